@@ -0,0 +1,649 @@
+package hermes
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/themedef/go-hermes/internal/persistence"
+	"github.com/themedef/go-hermes/internal/types"
+)
+
+const (
+	defaultAppendRewriteMinSize    = 64 << 20
+	defaultAppendRewritePercentage = 100
+	appendRewriteCheckInterval     = time.Second
+)
+
+const (
+	FsyncAlways   = persistence.FsyncAlways
+	FsyncEverySec = persistence.FsyncEverySec
+	FsyncNo       = persistence.FsyncNo
+)
+
+const (
+	opSet        = "SET"
+	opIncrBy     = "INCRBY"
+	opLPush      = "LPUSH"
+	opRPush      = "RPUSH"
+	opLPop       = "LPOP"
+	opRPop       = "RPOP"
+	opLMove      = "LMOVE"
+	opLTrim      = "LTRIM"
+	opLSet       = "LSET"
+	opLInsert    = "LINSERT"
+	opLRem       = "LREM"
+	opHSet       = "HSET"
+	opHMSet      = "HMSET"
+	opHDel       = "HDEL"
+	opHExpireAt  = "HPEXPIREAT"
+	opSAdd       = "SADD"
+	opSRem       = "SREM"
+	opZAdd       = "ZADD"
+	opZRem       = "ZREM"
+	opXAdd       = "XADD"
+	opXTrim      = "XTRIM"
+	opXGroup     = "XGROUP"
+	opXReadGroup = "XREADGROUP"
+	opXAck       = "XACK"
+	opXClaim     = "XCLAIM"
+	opExpireAt   = "EXPIREAT"
+	opDel        = "DEL"
+	opRename     = "RENAME"
+	opDropAll    = "DROPALL"
+	opRestore    = "RESTORE"
+)
+
+// now is the clock expirations are evaluated against. While the append-only log is
+// replayed at startup it reports the time of the record being applied, so every
+// operation sees exactly the keys that were live when it originally ran.
+func (db *DB) now() time.Time {
+	if nanos := db.replayClock.Load(); nanos != 0 {
+		return time.Unix(0, nanos)
+	}
+	return time.Now()
+}
+
+// isExpired reports whether the key holding e has expired, which a hash does
+// once all of its fields have.
+func (db *DB) isExpired(e types.Entry) bool {
+	if len(e.FieldExpirations) > 0 && allFieldsExpired(e, db.now()) {
+		return true
+	}
+	if e.Expiration.IsZero() {
+		return false
+	}
+	return db.now().After(e.Expiration)
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// checkLoggable rejects values the append-only log cannot encode. Writes call it
+// before changing anything, since a mutation whose record fails to encode would
+// be applied but lost on restart. Without a log any value can be stored.
+func (db *DB) checkLoggable(op, key string, values ...interface{}) error {
+	if db.aof == nil {
+		return nil
+	}
+	for _, v := range values {
+		if err := persistence.CheckValue(v); err != nil {
+			db.logger.Error(op+" failed: value cannot be logged", "key", key, "error", err)
+			return persistenceError(err)
+		}
+	}
+	return nil
+}
+
+// appendLog records a mutation that has already been applied. Callers hold the lock
+// of every shard the mutation touched, so records for a key are logged in the same
+// order the mutations became visible. Values were checked with checkLoggable, so
+// only writing the record can fail here.
+func (db *DB) appendLog(op string, args ...interface{}) {
+	if db.aof == nil || db.replayClock.Load() != 0 {
+		return
+	}
+	rec := persistence.Record{Time: time.Now(), Op: op, Args: args}
+	if err := db.aof.Append(rec); err != nil {
+		db.logger.Error("failed to append to log", "op", op, "error", err)
+	}
+}
+
+// replayAppendLog applies the records of r. It only runs while the store is being
+// opened, before anything else can read or write it, which is what makes the
+// store-wide replay clock and the skipped appendLog calls safe.
+func (db *DB) replayAppendLog(r io.Reader) (persistence.ReplayStats, int, error) {
+	defer db.replayClock.Store(0)
+
+	failed := 0
+	stats, err := persistence.ReplayAppendLog(r, func(rec persistence.Record) error {
+		db.replayClock.Store(rec.Time.UnixNano())
+		if err := db.applyRecord(rec); err != nil {
+			db.logger.Warn("failed to replay record", "op", rec.Op, "error", err)
+			failed++
+		}
+		return nil
+	})
+	if errors.Is(err, persistence.ErrCorrupted) {
+		err = fmt.Errorf("%w: %v", ErrInvalidAppendLog, err)
+	}
+	return stats, failed, err
+}
+
+type recordArgs struct {
+	args []interface{}
+	err  error
+}
+
+func (a *recordArgs) value(i int) interface{} {
+	if i >= len(a.args) {
+		if a.err == nil {
+			a.err = fmt.Errorf("%w: missing argument %d", ErrInvalidAppendLog, i)
+		}
+		return nil
+	}
+	return a.args[i]
+}
+
+func (a *recordArgs) str(i int) string {
+	s, ok := a.value(i).(string)
+	if !ok && a.err == nil {
+		a.err = fmt.Errorf("%w: argument %d is not a string", ErrInvalidAppendLog, i)
+	}
+	return s
+}
+
+func (a *recordArgs) int64(i int) int64 {
+	n, ok := a.value(i).(int64)
+	if !ok && a.err == nil {
+		a.err = fmt.Errorf("%w: argument %d is not an int64", ErrInvalidAppendLog, i)
+	}
+	return n
+}
+
+func (a *recordArgs) float64(i int) float64 {
+	f, ok := a.value(i).(float64)
+	if !ok && a.err == nil {
+		a.err = fmt.Errorf("%w: argument %d is not a float64", ErrInvalidAppendLog, i)
+	}
+	return f
+}
+
+func (a *recordArgs) listSide(i int) types.ListSide {
+	switch s := a.str(i); s {
+	case "LEFT":
+		return types.ListLeft
+	case "RIGHT":
+		return types.ListRight
+	default:
+		if a.err == nil {
+			a.err = fmt.Errorf("%w: argument %d is not a list side", ErrInvalidAppendLog, i)
+		}
+		return types.ListLeft
+	}
+}
+
+func (a *recordArgs) strs(i int) []string {
+	var out []string
+	for ; i < len(a.args); i++ {
+		out = append(out, a.str(i))
+	}
+	return out
+}
+
+func (a *recordArgs) rest(i int) []interface{} {
+	if i >= len(a.args) {
+		return nil
+	}
+	return a.args[i:]
+}
+
+func (db *DB) applyRecord(rec persistence.Record) error {
+	ctx := withEventOrigin(context.Background(), types.OriginReplay)
+	if rec.Op == opDropAll {
+		return db.DropAll(ctx)
+	}
+
+	a := &recordArgs{args: rec.Args}
+	key := a.str(0)
+	if a.err != nil {
+		return a.err
+	}
+
+	var err error
+	switch rec.Op {
+	case opSet:
+		value, at := a.value(1), a.int64(2)
+		if a.err != nil {
+			return a.err
+		}
+		if err = db.Set(ctx, key, value, 0); err == nil {
+			err = db.setExpiration(key, at)
+		}
+	case opIncrBy:
+		delta := a.int64(1)
+		if a.err != nil {
+			return a.err
+		}
+		_, err = db.IncrBy(ctx, key, delta)
+	case opLPush:
+		_, err = db.LPush(ctx, key, a.rest(1)...)
+	case opRPush:
+		_, err = db.RPush(ctx, key, a.rest(1)...)
+	case opLPop:
+		_, err = db.LPop(ctx, key)
+	case opRPop:
+		_, err = db.RPop(ctx, key)
+	case opLMove:
+		destination, from, to := a.str(1), a.listSide(2), a.listSide(3)
+		if a.err != nil {
+			return a.err
+		}
+		_, _, err = db.lmove(ctx, key, destination, from, to)
+	case opLTrim:
+		start, stop := a.int64(1), a.int64(2)
+		if a.err != nil {
+			return a.err
+		}
+		err = db.LTrim(ctx, key, int(start), int(stop))
+	case opLSet:
+		index, value := a.int64(1), a.value(2)
+		if a.err != nil {
+			return a.err
+		}
+		err = db.LSet(ctx, key, int(index), value)
+	case opLInsert:
+		where, pivot, value := a.str(1), a.value(2), a.value(3)
+		if a.err == nil && where != "BEFORE" && where != "AFTER" {
+			a.err = fmt.Errorf("%w: argument 1 is not BEFORE or AFTER", ErrInvalidAppendLog)
+		}
+		if a.err != nil {
+			return a.err
+		}
+		_, err = db.LInsert(ctx, key, where == "BEFORE", pivot, value)
+	case opLRem:
+		count, value := a.int64(1), a.value(2)
+		if a.err != nil {
+			return a.err
+		}
+		_, err = db.LRem(ctx, key, int(count), value)
+	case opHSet:
+		field, value, at := a.str(1), a.value(2), a.int64(3)
+		if a.err != nil {
+			return a.err
+		}
+		if err = db.HSet(ctx, key, field, value, 0); err == nil {
+			err = db.setExpiration(key, at)
+		}
+	case opHMSet:
+		fields := make(map[string]interface{})
+		for i := 1; i < len(rec.Args); i += 2 {
+			fields[a.str(i)] = a.value(i + 1)
+		}
+		if a.err != nil {
+			return a.err
+		}
+		_, err = db.HMSet(ctx, key, fields)
+	case opHDel:
+		field := a.str(1)
+		if a.err != nil {
+			return a.err
+		}
+		err = db.HDel(ctx, key, field)
+	case opHExpireAt:
+		at, fields := a.int64(1), a.strs(2)
+		if a.err != nil {
+			return a.err
+		}
+		err = db.setFieldExpiration(key, at, fields)
+	case opSAdd:
+		err = db.SAdd(ctx, key, a.rest(1)...)
+	case opSRem:
+		err = db.SRem(ctx, key, a.rest(1)...)
+	case opZAdd:
+		var members []types.ZMember
+		for i := 1; i < len(rec.Args); i += 2 {
+			members = append(members, types.ZMember{Member: a.str(i), Score: a.float64(i + 1)})
+		}
+		if a.err != nil {
+			return a.err
+		}
+		_, err = db.ZAdd(ctx, key, types.ZAddOptions{}, members...)
+	case opZRem:
+		var members []string
+		for i := 1; i < len(rec.Args); i++ {
+			members = append(members, a.str(i))
+		}
+		if a.err != nil {
+			return a.err
+		}
+		_, err = db.ZRem(ctx, key, members...)
+	case opXAdd:
+		id := a.str(1)
+		fields := make(map[string]interface{})
+		for i := 2; i < len(rec.Args); i += 2 {
+			fields[a.str(i)] = a.value(i + 1)
+		}
+		if a.err != nil {
+			return a.err
+		}
+		_, err = db.XAdd(ctx, key, id, fields)
+	case opXTrim:
+		maxLen := a.int64(1)
+		if a.err != nil {
+			return a.err
+		}
+		_, err = db.XTrim(ctx, key, int(maxLen))
+	case opXGroup:
+		action, group := a.str(1), a.str(2)
+		if a.err != nil {
+			return a.err
+		}
+		switch action {
+		case "CREATE":
+			id, mkStream := a.str(3), a.int64(4)
+			if a.err != nil {
+				return a.err
+			}
+			err = db.XGroupCreate(ctx, key, group, id, mkStream != 0)
+		case "DESTROY":
+			_, err = db.XGroupDestroy(ctx, key, group)
+		default:
+			return fmt.Errorf("%w: unknown XGROUP action %q", ErrInvalidAppendLog, action)
+		}
+	case opXReadGroup:
+		group, consumer, count, noAck := a.str(1), a.str(2), a.int64(3), a.int64(4)
+		if a.err != nil {
+			return a.err
+		}
+		opts := types.XReadOptions{Count: int(count), NoAck: noAck != 0}
+		_, err = db.XReadGroup(ctx, group, consumer, opts, []string{key}, []string{">"})
+	case opXAck:
+		group := a.str(1)
+		ids := a.strs(2)
+		if a.err != nil {
+			return a.err
+		}
+		_, err = db.XAck(ctx, key, group, ids...)
+	case opXClaim:
+		group, consumer := a.str(1), a.str(2)
+		ids := a.strs(3)
+		if a.err != nil {
+			return a.err
+		}
+		// The log lists only IDs that were claimed or dropped, so claiming them
+		// regardless of idle time reproduces the original outcome.
+		_, err = db.XClaim(ctx, key, group, consumer, 0, ids...)
+	case opExpireAt:
+		at := a.int64(1)
+		if a.err != nil {
+			return a.err
+		}
+		err = db.setExpiration(key, at)
+	case opDel:
+		err = db.Delete(ctx, key)
+	case opRename:
+		newKey := a.str(1)
+		if a.err != nil {
+			return a.err
+		}
+		err = db.Rename(ctx, key, newKey)
+	case opRestore:
+		entry, ok := a.value(1).(types.Entry)
+		if !ok {
+			return fmt.Errorf("%w: RESTORE without an entry", ErrInvalidAppendLog)
+		}
+		err = db.RestoreRawEntry(ctx, key, entry)
+	default:
+		return fmt.Errorf("%w: unknown operation %q", ErrInvalidAppendLog, rec.Op)
+	}
+	return err
+}
+
+func (db *DB) setExpiration(key string, at int64) error {
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.lockShard(context.Background(), sh)()
+
+	entry, exists := sh.data[key]
+	if !exists || db.isExpired(entry) {
+		return ErrKeyNotFound
+	}
+	if at == 0 {
+		entry.Expiration = time.Time{}
+	} else {
+		entry.Expiration = time.Unix(0, at)
+	}
+	sh.data[key] = entry
+	return nil
+}
+
+// setFieldExpiration sets the expiration of fields of the hash at key, or
+// removes it when at is zero. Fields the hash does not have are skipped.
+func (db *DB) setFieldExpiration(key string, at int64, fields []string) error {
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.lockShard(context.Background(), sh)()
+
+	hash, entry, err := db.hashForWrite(sh, key, "HPExpireAt", false)
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		if _, ok := hash[field]; !ok {
+			continue
+		}
+		if at == 0 {
+			entry.ClearFieldExpiration(field)
+			continue
+		}
+		entry.SetFieldExpiration(field, time.Unix(0, at))
+	}
+	sh.data[key] = entry
+	return nil
+}
+
+// restorePersistedState rebuilds the dataset on startup. An existing append-only log
+// is authoritative because it already contains everything the snapshot does; the
+// snapshot is only used to build the base of a new log.
+func (db *DB) restorePersistedState() error {
+	path := db.config.AppendLogFile
+	if path != "" {
+		info, err := os.Stat(path)
+		switch {
+		case err == nil:
+			if info.Size() > 0 {
+				if err := db.replayAppendLogFile(path, info.Size()); err != nil {
+					return err
+				}
+			}
+			return db.openAppendLog()
+		case !os.IsNotExist(err):
+			return err
+		}
+	}
+
+	if db.config.SnapshotFile != "" {
+		if err := db.loadSnapshotFile(db.config.SnapshotFile); err != nil {
+			return err
+		}
+	}
+	if path != "" {
+		if err := db.createAppendLog(path); err != nil {
+			return err
+		}
+		return db.openAppendLog()
+	}
+	return nil
+}
+
+func (db *DB) replayAppendLogFile(path string, size int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	stats, failed, err := db.replayAppendLog(f)
+	_ = f.Close()
+	if err != nil {
+		return err
+	}
+
+	if stats.ValidSize < size {
+		db.logger.Warn("truncating incomplete tail of append-only log",
+			"file", path,
+			"size", size,
+			"validSize", stats.ValidSize)
+		if err := os.Truncate(path, stats.ValidSize); err != nil {
+			return err
+		}
+	}
+
+	applied, skipped := stats.Applied-failed, stats.Skipped+failed
+	if skipped > 0 {
+		db.logger.Warn("append-only log replayed with skipped records", "file", path, "applied", applied, "skipped", skipped)
+	} else {
+		db.logger.Info("append-only log replayed", "file", path, "applied", applied)
+	}
+	return nil
+}
+
+func (db *DB) openAppendLog() error {
+	aof, err := persistence.OpenAppendLog(db.config.AppendLogFile, db.config.AppendFsync)
+	if err != nil {
+		return err
+	}
+	db.aof = aof
+	return nil
+}
+
+// createAppendLog writes the current dataset as the base of a new log. The file only
+// appears under its final name once it is complete, so a crash never leaves a
+// partial log that would shadow the snapshot on the next start.
+func (db *DB) createAppendLog(path string) error {
+	tmp, err := persistence.CreateTemp(path)
+	if err != nil {
+		return err
+	}
+	err = db.writeLogBase(context.Background(), tmp, db.captureShards(nil), time.Now())
+	if err == nil {
+		err = persistence.InstallFile(tmp, path)
+	}
+	_ = tmp.Close()
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// writeLogBase writes one RESTORE record per captured key. Replaying them recreates
+// the dataset as it was at the capture time.
+func (db *DB) writeLogBase(ctx context.Context, w io.Writer, captured []map[string]types.Entry, at time.Time) error {
+	bw := bufio.NewWriter(w)
+	for _, data := range captured {
+		select {
+		case <-ctx.Done():
+			return ErrContextCanceled
+		default:
+		}
+
+		for key, entry := range data {
+			rec := persistence.Record{Time: at, Op: opRestore, Args: []interface{}{key, entry}}
+			if err := persistence.EncodeRecord(bw, rec); err != nil {
+				return persistenceError(err)
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+func (db *DB) RewriteAppendLog(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("RewriteAppendLog operation canceled")
+		return ErrContextCanceled
+	default:
+	}
+
+	if db.aof == nil {
+		db.logger.Warn("RewriteAppendLog called without an append-only log")
+		return ErrAppendLogDisabled
+	}
+
+	var (
+		at       time.Time
+		beginErr error
+	)
+	captured := db.captureShards(func() {
+		at = time.Now()
+		beginErr = db.aof.BeginRewrite()
+	})
+	if beginErr != nil {
+		if errors.Is(beginErr, persistence.ErrRewriteInProgress) {
+			db.logger.Warn("RewriteAppendLog skipped: rewrite already in progress")
+			return ErrRewriteInProgress
+		}
+		db.logger.Error("RewriteAppendLog failed to start", "error", beginErr)
+		return beginErr
+	}
+
+	sizeBefore := db.aof.Size()
+	tmp, err := persistence.CreateTemp(db.aof.Path())
+	if err != nil {
+		db.aof.AbortRewrite()
+		db.logger.Error("RewriteAppendLog failed to create temporary file", "error", err)
+		return err
+	}
+	if err := db.writeLogBase(ctx, tmp, captured, at); err != nil {
+		db.aof.AbortRewrite()
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		db.logger.Error("RewriteAppendLog failed to write base", "error", err)
+		return err
+	}
+	if err := db.aof.FinishRewrite(tmp); err != nil {
+		db.logger.Error("RewriteAppendLog failed to install rewritten log", "error", err)
+		return err
+	}
+
+	db.logger.Info("RewriteAppendLog operation successful", "sizeBefore", sizeBefore, "sizeAfter", db.aof.Size())
+	return nil
+}
+
+func (db *DB) shouldRewriteAppendLog() bool {
+	if db.config.AppendRewritePercentage < 0 {
+		return false
+	}
+	size, base := db.aof.Size(), db.aof.BaseSize()
+	if size < db.config.AppendRewriteMinSize {
+		return false
+	}
+	if base == 0 {
+		return true
+	}
+	return (size-base)*100/base >= int64(db.config.AppendRewritePercentage)
+}
+
+func (db *DB) autoRewriteAppendLog(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !db.shouldRewriteAppendLog() {
+				continue
+			}
+			if err := db.RewriteAppendLog(db.cleanupCtx); err != nil && !IsContextCanceled(err) && !IsRewriteInProgress(err) {
+				db.logger.Error("automatic append-only log rewrite failed", "error", err)
+			}
+		case <-db.cleanupCtx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package hermes
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/themedef/go-hermes/internal/contracts"
+	"github.com/themedef/go-hermes/internal/persistence"
+)
+
+func openAOFStore(t *testing.T, path string, policy persistence.FsyncPolicy) contracts.StoreHandler {
+	t.Helper()
+	db := NewStore(Config{ShardCount: 4, AppendLogFile: path, AppendFsync: policy})
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+// TestAppendLogReplay checks that every logged mutation is rebuilt on restart.
+func TestAppendLogReplay(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "hermes.aof")
+
+	for _, policy := range []persistence.FsyncPolicy{FsyncAlways, FsyncEverySec, FsyncNo} {
+		t.Run(string(policy), func(t *testing.T) {
+			_ = os.Remove(path)
+			db := openAOFStore(t, path, policy)
+
+			// Scenario 1: strings, counters and expirations
+			_ = db.Set(ctx, "str", "v1", 0)
+			_, _ = db.GetSet(ctx, "str", "v2", 0)
+			_, _ = db.IncrBy(ctx, "counter", 10)
+			_, _ = db.Decr(ctx, "counter")
+			_ = db.Set(ctx, "ttl", "x", 3600)
+			_ = db.Set(ctx, "persisted", "y", 3600)
+			_, _ = db.Persist(ctx, "persisted")
+
+			// Scenario 2: lists, hashes and sets
+			_, _ = db.RPush(ctx, "list", "a", "b", "c", "d")
+			_, _ = db.LPush(ctx, "list", "z")
+			_, _ = db.RPop(ctx, "list")
+			_ = db.LTrim(ctx, "list", 0, 2)
+			_, _ = db.BLMove(ctx, "list", "moved", ListLeft, ListRight)
+			_ = db.LSet(ctx, "list", -1, "B")
+			_, _ = db.LInsert(ctx, "list", true, "a", "x")
+			_, _ = db.RPush(ctx, "list", "a")
+			_, _ = db.LRem(ctx, "list", -1, "a")
+			_, _ = db.RPopLPush(ctx, "list", "moved")
+			_ = db.HSet(ctx, "hash", "f1", "v1", 0)
+			_ = db.HSet(ctx, "hash", "f2", int64(2), 0)
+			_ = db.HDel(ctx, "hash", "f1")
+			_, _ = db.HMSet(ctx, "hash", map[string]interface{}{"f3": "3", "f4": "x"})
+			_, _ = db.HIncrBy(ctx, "hash", "f3", 4)
+			_, _ = db.HIncrByFloat(ctx, "hash", "f5", 0.25)
+			_, _ = db.HSetNX(ctx, "hash", "f4", "y")
+			_, _ = db.HMSet(ctx, "session", map[string]interface{}{"tok1": "a", "tok2": "b", "n": int64(1)})
+			_, _ = db.HExpire(ctx, "session", 3600, "tok1", "tok2", "n")
+			_, _ = db.HPersist(ctx, "session", "tok2")
+			_, _ = db.HIncrBy(ctx, "session", "n", 1)
+			_ = db.HSet(ctx, "session", "tok1", "a2", 0)
+			_ = db.SAdd(ctx, "set", "a", "b", "c")
+			_ = db.SRem(ctx, "set", "b")
+
+			// Scenario 3: key management
+			_ = db.Set(ctx, "old", "renamed", 0)
+			_ = db.Rename(ctx, "old", "new")
+			_ = db.Set(ctx, "gone", "x", 0)
+			_ = db.Delete(ctx, "gone")
+			_ = db.Close()
+
+			db = openAOFStore(t, path, policy)
+
+			if val, _ := db.Get(ctx, "str"); val != "v2" {
+				t.Errorf("Expected str=v2, got %v", val)
+			}
+			if val, _ := db.Get(ctx, "counter"); val != int64(9) {
+				t.Errorf("Expected counter=9, got %v", val)
+			}
+			if _, ttl, _ := db.GetWithDetails(ctx, "ttl"); ttl <= 3500 {
+				t.Errorf("Expected TTL close to 3600, got %d", ttl)
+			}
+			if _, ttl, _ := db.GetWithDetails(ctx, "persisted"); ttl != -1 {
+				t.Errorf("Expected persisted key without TTL, got %d", ttl)
+			}
+			if list, _ := db.LRange(ctx, "list", 0, -1); !reflect.DeepEqual(list, []interface{}{"x", "a"}) {
+				t.Errorf("Unexpected list after replay: %v", list)
+			}
+			if moved, _ := db.LRange(ctx, "moved", 0, -1); !reflect.DeepEqual(moved, []interface{}{"B", "z"}) {
+				t.Errorf("Unexpected moved list after replay: %v", moved)
+			}
+			if hash, _ := db.HGetAll(ctx, "hash"); !reflect.DeepEqual(hash, map[string]interface{}{"f2": int64(2), "f3": int64(7), "f4": "x", "f5": 0.25}) {
+				t.Errorf("Unexpected hash after replay: %v", hash)
+			}
+			if ttls, _ := db.HTTL(ctx, "session", "tok1", "tok2", "n"); len(ttls) != 3 || ttls[0] != -1 || ttls[1] != -1 || ttls[2] <= 3500 {
+				t.Errorf("Unexpected field TTLs after replay: %v", ttls)
+			}
+			if card, _ := db.SCard(ctx, "set"); card != 2 {
+				t.Errorf("Expected set cardinality 2, got %d", card)
+			}
+			if val, _ := db.Get(ctx, "new"); val != "renamed" {
+				t.Errorf("Expected renamed key, got %v", val)
+			}
+			if exists, _ := db.Exists(ctx, "old"); exists {
+				t.Error("Renamed key should not exist under its old name")
+			}
+			if exists, _ := db.Exists(ctx, "gone"); exists {
+				t.Error("Deleted key should stay deleted after replay")
+			}
+		})
+	}
+}
+
+// TestAppendLogDropAll checks that DropAll is replayed in order with surrounding writes.
+func TestAppendLogDropAll(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "hermes.aof")
+
+	db := openAOFStore(t, path, FsyncAlways)
+	_ = db.Set(ctx, "before", "x", 0)
+	_ = db.DropAll(ctx)
+	_ = db.Set(ctx, "after", "y", 0)
+	_ = db.Close()
+
+	db = openAOFStore(t, path, FsyncAlways)
+	if exists, _ := db.Exists(ctx, "before"); exists {
+		t.Error("Key written before DropAll should not survive replay")
+	}
+	if val, _ := db.Get(ctx, "after"); val != "y" {
+		t.Errorf("Expected after=y, got %v", val)
+	}
+}
+
+// TestAppendLogExpiredKeys checks that keys which expired before a restart are not brought back.
+func TestAppendLogExpiredKeys(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "hermes.aof")
+
+	db := openAOFStore(t, path, FsyncAlways)
+	_ = db.Set(ctx, "counter", int64(5), 1)
+	_, _ = db.Incr(ctx, "counter")
+	_, _ = db.RPush(ctx, "list", "a")
+	_, _ = db.Expire(ctx, "list", 1)
+	_, _ = db.RPush(ctx, "list", "b")
+	_ = db.Close()
+
+	time.Sleep(1100 * time.Millisecond)
+
+	db = openAOFStore(t, path, FsyncAlways)
+	if exists, _ := db.Exists(ctx, "counter"); exists {
+		t.Error("Expired counter must not be resurrected by a replayed Incr")
+	}
+	if exists, _ := db.Exists(ctx, "list"); exists {
+		t.Error("Expired list must not be resurrected by a replayed RPush")
+	}
+}
+
+// TestAppendLogUnsupportedValue checks that a value the log cannot encode is
+// rejected before the write is applied.
+func TestAppendLogUnsupportedValue(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "hermes.aof")
+	type point struct{ X, Y int }
+
+	db := openAOFStore(t, path, FsyncAlways)
+	_, _ = db.RPush(ctx, "list", "a")
+
+	// Scenario 1: the write fails and nothing changes
+	if err := db.Set(ctx, "str", point{1, 2}, 0); !IsUnsupportedValue(err) {
+		t.Errorf("Expected ErrUnsupportedValue from Set, got %v", err)
+	}
+	if exists, _ := db.Exists(ctx, "str"); exists {
+		t.Error("Rejected Set must not create the key")
+	}
+	if _, err := db.RPush(ctx, "list", "b", []interface{}{point{}}); !IsUnsupportedValue(err) {
+		t.Errorf("Expected ErrUnsupportedValue from RPush, got %v", err)
+	}
+	if _, err := db.HMSet(ctx, "hash", map[string]interface{}{"f": point{}}); !IsUnsupportedValue(err) {
+		t.Errorf("Expected ErrUnsupportedValue from HMSet, got %v", err)
+	}
+	if n, _ := db.LLen(ctx, "list"); n != 1 {
+		t.Errorf("Rejected RPush must leave the list alone, got length %d", n)
+	}
+
+	// Scenario 2: what was accepted survives a restart
+	_ = db.Close()
+	db = openAOFStore(t, path, FsyncAlways)
+	if got, err := db.LRange(ctx, "list", 0, -1); err != nil || !reflect.DeepEqual(got, []interface{}{"a"}) {
+		t.Errorf("Unexpected list after restart %v (err=%v)", got, err)
+	}
+
+	// Scenario 3: without a log any value can be stored
+	mem := withTestStore(t)
+	if err := mem.Set(ctx, "str", point{1, 2}, 0); err != nil {
+		t.Errorf("Set without a log failed: %v", err)
+	}
+}
+
+// TestAppendLogTruncatedTail checks that a torn final record is skipped and the log stays writable.
+func TestAppendLogTruncatedTail(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "hermes.aof")
+
+	db := openAOFStore(t, path, FsyncAlways)
+	_ = db.Set(ctx, "k1", "v1", 0)
+	_ = db.Set(ctx, "k2", "v2", 0)
+	_ = db.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	torn := data[:len(data)-3]
+	if err := os.WriteFile(path, torn, 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	// Scenario 1: replay reports the skipped record
+	probe := withTestStore(t).(*DB)
+	stats, failed, err := probe.replayAppendLog(bytes.NewReader(torn))
+	if applied, skipped := stats.Applied-failed, stats.Skipped+failed; err != nil || applied != 1 || skipped != 1 {
+		t.Errorf("Expected 1 applied and 1 skipped, got %d/%d (err=%v)", applied, skipped, err)
+	}
+
+	// Scenario 2: startup keeps the intact prefix and appends after it
+	db = openAOFStore(t, path, FsyncAlways)
+	if val, _ := db.Get(ctx, "k1"); val != "v1" {
+		t.Errorf("Expected k1=v1, got %v", val)
+	}
+	if exists, _ := db.Exists(ctx, "k2"); exists {
+		t.Error("Torn record should not be applied")
+	}
+	_ = db.Set(ctx, "k3", "v3", 0)
+	_ = db.Close()
+
+	db = openAOFStore(t, path, FsyncAlways)
+	if val, _ := db.Get(ctx, "k3"); val != "v3" {
+		t.Errorf("Expected k3=v3 after second restart, got %v", val)
+	}
+}
+
+// TestAppendLogSeedsFromSnapshot checks that a new log starts from the snapshot and replaces it afterwards.
+func TestAppendLogSeedsFromSnapshot(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	snapshot := filepath.Join(dir, "dump.hdb")
+	path := filepath.Join(dir, "hermes.aof")
+
+	src := withTestStore(t)
+	_ = src.Set(ctx, "base", "from-snapshot", 0)
+	f, err := os.Create(snapshot)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := src.SaveSnapshot(ctx, f); err != nil {
+		t.Fatalf("SaveSnapshot failed: %v", err)
+	}
+	_ = f.Close()
+
+	db := NewStore(Config{SnapshotFile: snapshot, AppendLogFile: path, AppendFsync: FsyncAlways})
+	_ = db.Set(ctx, "extra", "from-log", 0)
+	_ = db.Close()
+	_ = os.Remove(snapshot)
+
+	db = NewStore(Config{SnapshotFile: snapshot, AppendLogFile: path})
+	defer db.Close()
+	if val, _ := db.Get(ctx, "base"); val != "from-snapshot" {
+		t.Errorf("Expected snapshot data to be seeded into the log, got %v", val)
+	}
+	if val, _ := db.Get(ctx, "extra"); val != "from-log" {
+		t.Errorf("Expected logged write, got %v", val)
+	}
+}
+
+// TestAppendLogRewrite checks that a rewrite compacts the log without losing data.
+func TestAppendLogRewrite(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "hermes.aof")
+
+	db := openAOFStore(t, path, FsyncNo)
+	for i := 0; i < 1000; i++ {
+		_, _ = db.Incr(ctx, "counter")
+	}
+	_ = db.HSet(ctx, "hash", "f", "v", 3600)
+	_ = db.HSet(ctx, "hash", "token", "t", 0)
+	_, _ = db.HExpire(ctx, "hash", 3600, "token")
+	_ = db.Set(ctx, "deleted", "x", 0)
+	_ = db.Delete(ctx, "deleted")
+
+	before, _ := os.Stat(path)
+	if err := db.RewriteAppendLog(ctx); err != nil {
+		t.Fatalf("RewriteAppendLog failed: %v", err)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size()/10 {
+		t.Errorf("Expected rewrite to compact the log, size %d -> %d", before.Size(), after.Size())
+	}
+
+	_, _ = db.Incr(ctx, "counter")
+	_ = db.Close()
+
+	db = openAOFStore(t, path, FsyncNo)
+	if val, _ := db.Get(ctx, "counter"); val != int64(1001) {
+		t.Errorf("Expected counter=1001, got %v", val)
+	}
+	if _, ttl, _ := db.GetWithDetails(ctx, "hash"); ttl <= 3500 {
+		t.Errorf("Expected hash TTL to survive the rewrite, got %d", ttl)
+	}
+	if ttls, _ := db.HTTL(ctx, "hash", "token"); len(ttls) != 1 || ttls[0] <= 3500 {
+		t.Errorf("Expected field TTL to survive the rewrite, got %v", ttls)
+	}
+	if exists, _ := db.Exists(ctx, "deleted"); exists {
+		t.Error("Deleted key should not reappear after rewrite")
+	}
+
+	plain := withTestStore(t)
+	if err := plain.RewriteAppendLog(ctx); !IsAppendLogDisabled(err) {
+		t.Errorf("Expected ErrAppendLogDisabled without a log, got %v", err)
+	}
+}
+
+// TestAppendLogRewriteConcurrentWrites checks that writes made during a rewrite end up in the new log.
+func TestAppendLogRewriteConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "hermes.aof")
+
+	db := openAOFStore(t, path, FsyncNo)
+	for i := 0; i < 500; i++ {
+		_ = db.Set(ctx, fmt.Sprintf("key-%d", i), i, 0)
+	}
+
+	stop := make(chan struct{})
+	started := make(chan struct{})
+	done := make(chan int64)
+	go func() {
+		var n int64
+		for {
+			select {
+			case <-stop:
+				done <- n
+				return
+			default:
+			}
+			if _, err := db.Incr(ctx, "counter"); err == nil {
+				n++
+				if n == 1 {
+					close(started)
+				}
+			}
+		}
+	}()
+	<-started
+
+	for i := 0; i < 3; i++ {
+		if err := db.RewriteAppendLog(ctx); err != nil {
+			t.Errorf("RewriteAppendLog failed: %v", err)
+		}
+	}
+	close(stop)
+	total := <-done
+	_ = db.Close()
+
+	db = openAOFStore(t, path, FsyncNo)
+	if val, _ := db.Get(ctx, "counter"); val != total {
+		t.Errorf("Expected counter=%d after restart, got %v", total, val)
+	}
+	if val, _ := db.Get(ctx, "key-499"); val != 499 {
+		t.Errorf("Expected key-499=499, got %v", val)
+	}
+}
+
+// TestAppendLogAutoRewrite checks that the size thresholds in Config trigger a background rewrite.
+func TestAppendLogAutoRewrite(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "hermes.aof")
+
+	db := NewStore(Config{
+		AppendLogFile:           path,
+		AppendFsync:             FsyncNo,
+		AppendRewriteMinSize:    1024,
+		AppendRewritePercentage: 50,
+	})
+	defer db.Close()
+
+	for i := 0; i < 1000; i++ {
+		_, _ = db.Incr(ctx, "counter")
+	}
+	grown, _ := os.Stat(path)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if info, err := os.Stat(path); err == nil && info.Size() < grown.Size()/10 {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Errorf("Expected automatic rewrite to shrink the log below %d bytes", grown.Size()/10)
+}
@@ -0,0 +1,174 @@
+package hermes
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// blockRegistry lets blocking reads such as XREAD BLOCK and BLPOP sleep until
+// one of their keys is written. Like watchRegistry, it costs a single atomic
+// load per write while nobody is blocked.
+type blockRegistry struct {
+	mu      sync.Mutex
+	waiters map[string][]*blockWaiter
+	count   atomic.Int64
+}
+
+// blockWaiter is one blocked call. The waiters of a key queue in arrival order.
+// A write wakes every reader, but only the first consumer in line: consumers
+// take what they read away, and serving them one at a time keeps them fair.
+type blockWaiter struct {
+	keys     []string
+	ch       chan struct{}
+	consumer bool
+}
+
+func (w *blockWaiter) signal() {
+	select {
+	case w.ch <- struct{}{}:
+	default:
+	}
+}
+
+// waitKeys registers interest in writes to keys. The waiter's channel receives
+// a value after such writes, coalescing bursts; stop unregisters it.
+func (db *DB) waitKeys(keys []string, consumer bool) (*blockWaiter, func()) {
+	w := &blockWaiter{keys: keys, ch: make(chan struct{}, 1), consumer: consumer}
+
+	db.blocked.mu.Lock()
+	if db.blocked.waiters == nil {
+		db.blocked.waiters = make(map[string][]*blockWaiter)
+	}
+	for _, key := range keys {
+		db.blocked.waiters[key] = append(db.blocked.waiters[key], w)
+	}
+	db.blocked.count.Add(1)
+	db.blocked.mu.Unlock()
+
+	stop := func() {
+		db.blocked.mu.Lock()
+		defer db.blocked.mu.Unlock()
+		for _, key := range keys {
+			queue := db.blocked.waiters[key]
+			wasFirst := w.consumer && firstConsumer(queue) == w
+			for i, other := range queue {
+				if other == w {
+					queue = append(queue[:i:i], queue[i+1:]...)
+					break
+				}
+			}
+			if len(queue) == 0 {
+				delete(db.blocked.waiters, key)
+				continue
+			}
+			db.blocked.waiters[key] = queue
+			// The next consumer in line may find what w left behind.
+			if next := firstConsumer(queue); wasFirst && next != nil {
+				next.signal()
+			}
+		}
+		db.blocked.count.Add(-1)
+	}
+	return w, stop
+}
+
+func firstConsumer(queue []*blockWaiter) *blockWaiter {
+	for _, w := range queue {
+		if w.consumer {
+			return w
+		}
+	}
+	return nil
+}
+
+// inLine reports whether w is the first consumer waiting on key, and so the
+// one allowed to take from it.
+func (db *DB) inLine(w *blockWaiter, key string) bool {
+	db.blocked.mu.Lock()
+	defer db.blocked.mu.Unlock()
+	return firstConsumer(db.blocked.waiters[key]) == w
+}
+
+// wake signals the readers blocked on the keys written by op.
+func (db *DB) wake(op string, args []interface{}) {
+	if db.blocked.count.Load() == 0 {
+		return
+	}
+
+	db.blocked.mu.Lock()
+	defer db.blocked.mu.Unlock()
+
+	n := 1
+	if op == opRename || op == opLMove {
+		n = 2
+	}
+	for i := 0; i < n && i < len(args); i++ {
+		key, ok := args[i].(string)
+		if !ok {
+			continue
+		}
+		first := true
+		for _, w := range db.blocked.waiters[key] {
+			if w.consumer {
+				if !first {
+					continue
+				}
+				first = false
+			}
+			w.signal()
+		}
+	}
+}
+
+// block calls try until it reports done, sleeping between attempts until one
+// of keys is written. It gives up after timeout, or only when ctx is done if
+// timeout is zero. Giving up on the timeout is not an error: the caller sees
+// that try never reported done.
+func (db *DB) block(ctx context.Context, keys []string, timeout time.Duration, try func() (bool, error)) error {
+	w, stop := db.waitKeys(keys, false)
+	defer stop()
+	return db.wait(ctx, w, timeout, try)
+}
+
+// blockInLine is block for consumers, which wait in line: try is passed the
+// waiter so it can skip the keys where it is not first, see inLine.
+func (db *DB) blockInLine(ctx context.Context, keys []string, timeout time.Duration, try func(w *blockWaiter) (bool, error)) error {
+	w, stop := db.waitKeys(keys, true)
+	defer stop()
+	return db.wait(ctx, w, timeout, func() (bool, error) { return try(w) })
+}
+
+// wait runs the loop of block. The waiter is registered before the first
+// attempt, so a write landing between a failed attempt and the wait is not
+// missed.
+func (db *DB) wait(ctx context.Context, w *blockWaiter, timeout time.Duration, try func() (bool, error)) error {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		if done, err := try(); done || err != nil {
+			return err
+		}
+		select {
+		case <-w.ch:
+		case <-expired:
+			return nil
+		case <-ctx.Done():
+			db.logger.Warn("blocking operation canceled", "keys", w.keys)
+			return ErrContextCanceled
+		}
+	}
+}
+
+// canBlock reports whether a blocking command running with ctx may wait. It
+// may not while a transaction holds shard locks, as in Redis MULTI.
+func canBlock(ctx context.Context) bool {
+	held, _ := ctx.Value(heldShardsKey{}).(*heldShards)
+	return held == nil
+}
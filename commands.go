@@ -4,678 +4,1071 @@ import (
 	"context"
 	"fmt"
 	"github.com/themedef/go-hermes/internal/contracts"
+	"github.com/themedef/go-hermes/internal/resp"
 	"github.com/themedef/go-hermes/internal/types"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
 )
 
 type CommandAPI struct {
-	db contracts.StoreHandler
+	db      contracts.StoreHandler
+	session commandSession
+	subs    subscriberSession
 }
 
 func NewCommandAPI(db contracts.StoreHandler) contracts.CommandsHandler {
 	return &CommandAPI{db: db}
 }
 
+// replyFormat turns the reply or error of one command queued inside MULTI into
+// its slot of the EXEC reply.
+type replyFormat func(cmd string, reply interface{}, err error) interface{}
+
+// Execute runs a command and renders its reply as text meant to be read by a
+// person. Commands are parsed and run exactly as for Do; only the reply differs.
 func (c *CommandAPI) Execute(ctx context.Context, parts []string) (string, error) {
 	if len(parts) == 0 {
 		return "", nil
 	}
 	cmd := strings.ToUpper(parts[0])
+	reply, err := c.dispatch(ctx, cmd, parts, textSlot)
+	if err != nil {
+		return "", err
+	}
+	return textReply(cmd, reply), nil
+}
+
+// dispatch runs one command of the session: the transaction commands, the
+// queueing of commands after MULTI, and everything else through run.
+func (c *CommandAPI) dispatch(ctx context.Context, cmd string, parts []string, format replyFormat) (interface{}, error) {
+	if isSessionCommand(cmd) {
+		return c.runSession(ctx, cmd, parts[1:], format)
+	}
+	if c.enqueue(parts) {
+		return resp.SimpleString("QUEUED"), nil
+	}
+	return c.run(ctx, cmd, parts[1:])
+}
 
+// run parses and runs one command. Replies follow Redis semantics and use the
+// types Do documents; failures are store errors or resp.Error values.
+func (c *CommandAPI) run(ctx context.Context, cmd string, args []string) (interface{}, error) {
 	switch cmd {
 
-	case "SET":
-		if len(parts) < 3 {
-			return "", fmt.Errorf("Usage: SET key value [ttlSeconds]")
+	case "PING":
+		if len(args) > 1 {
+			return nil, respWrongArgs(cmd)
 		}
-		key := parts[1]
-		value := parts[2]
-		ttl := 0
-		if len(parts) >= 4 {
-			tmp, err := strconv.Atoi(parts[3])
-			if err != nil {
-				return "", fmt.Errorf("invalid TTL: %v", parts[3])
-			}
-			ttl = tmp
-		}
-		if err := c.db.Set(ctx, key, value, ttl); err != nil {
-			return "", err
+		if len(args) == 1 {
+			return args[0], nil
 		}
-		return "OK", nil
+		return resp.SimpleString("PONG"), nil
 
-	case "GET":
-		if len(parts) < 2 {
-			return "", fmt.Errorf("Usage: GET key")
+	case "ECHO":
+		if len(args) != 1 {
+			return nil, respWrongArgs(cmd)
 		}
-		key := parts[1]
-		val, err := c.db.Get(ctx, key)
-		if err != nil {
-			if IsKeyNotFound(err) || IsKeyExpired(err) {
-				return "(nil)", nil
-			}
-			return "", err
+		return args[0], nil
+
+	case "HELP":
+		return helpText, nil
+
+	case "QUIT", "EXIT":
+		return resp.SimpleString("OK"), nil
+
+	case "UNWATCH":
+		if len(args) != 0 {
+			return nil, respWrongArgs(cmd)
 		}
-		return fmt.Sprintf("\"%v\"", val), nil
+		c.unwatchKeys()
+		return resp.SimpleString("OK"), nil
+
+	case "PUBLISH", "PUBSUB", "SUBSCRIBE", "UNSUBSCRIBE", "PSUBSCRIBE", "PUNSUBSCRIBE":
+		return c.runPubSub(cmd, args)
+
+	case "LINDEX", "LSET", "LINSERT", "LREM", "LPOS", "LMOVE", "RPOPLPUSH", "BLPOP", "BRPOP", "BLMOVE":
+		return c.runList(ctx, cmd, args)
+
+	case "HMSET", "HMGET", "HSETNX", "HINCRBY", "HINCRBYFLOAT", "HKEYS", "HVALS", "HSTRLEN",
+		"HEXPIRE", "HTTL", "HPERSIST":
+		return c.runHash(ctx, cmd, args)
 
-	case "SETNX":
-		if len(parts) < 3 {
-			return "", fmt.Errorf("Usage: SETNX key value [ttlSeconds]")
+	case "XADD", "XLEN", "XRANGE", "XREVRANGE", "XTRIM", "XREAD", "XREADGROUP",
+		"XGROUP", "XACK", "XPENDING", "XCLAIM":
+		return c.runStream(ctx, cmd, args)
+
+	case "SET":
+		if len(args) < 2 {
+			return nil, respWrongArgs(cmd)
 		}
-		key := parts[1]
-		value := parts[2]
+		key, value, opts := args[0], args[1], args[2:]
 		ttl := 0
-		if len(parts) >= 4 {
-			tmp, err := strconv.Atoi(parts[3])
-			if err != nil {
-				return "", fmt.Errorf("invalid TTL: %v", parts[3])
+		// SET key value seconds, the form Execute has always accepted.
+		if len(opts) == 1 {
+			if n, err := strconv.Atoi(opts[0]); err == nil {
+				ttl, opts = n, nil
 			}
-			ttl = tmp
 		}
-		ok, err := c.db.SetNX(ctx, key, value, ttl)
-		if err != nil {
-			return "", err
+		var nx, xx bool
+		for i := 0; i < len(opts); i++ {
+			switch strings.ToUpper(opts[i]) {
+			case "NX":
+				nx = true
+			case "XX":
+				xx = true
+			case "EX", "PX":
+				if i+1 >= len(opts) {
+					return nil, respErrSyntax
+				}
+				n, err := strconv.Atoi(opts[i+1])
+				if err != nil || n <= 0 {
+					return nil, respInvalidExpire(cmd)
+				}
+				if strings.ToUpper(opts[i]) == "PX" {
+					n = (n + 999) / 1000
+				}
+				ttl = n
+				i++
+			default:
+				return nil, respErrSyntax
+			}
 		}
-		if ok {
-			return "1", nil
+		switch {
+		case nx && xx:
+			return nil, respErrSyntax
+		case nx:
+			ok, err := c.db.SetNX(ctx, key, value, ttl)
+			if err != nil && !IsKeyExists(err) {
+				return nil, err
+			}
+			if !ok {
+				return nil, nil
+			}
+		case xx:
+			ok, err := c.db.SetXX(ctx, key, value, ttl)
+			if err != nil && !IsKeyNotFound(err) {
+				return nil, err
+			}
+			if !ok {
+				return nil, nil
+			}
+		default:
+			if err := c.db.Set(ctx, key, value, ttl); err != nil {
+				return nil, err
+			}
 		}
-		return "0", nil
+		return resp.SimpleString("OK"), nil
 
-	case "SETXX":
-		if len(parts) < 3 {
-			return "", fmt.Errorf("Usage: SETXX key value [ttlSeconds]")
-		}
-		key := parts[1]
-		value := parts[2]
-		ttl := 0
-		if len(parts) >= 4 {
-			tmp, err := strconv.Atoi(parts[3])
-			if err != nil {
-				return "", fmt.Errorf("invalid TTL: %v", parts[3])
-			}
-			ttl = tmp
+	case "SETNX", "SETXX":
+		if len(args) != 2 && len(args) != 3 {
+			return nil, respWrongArgs(cmd)
 		}
-		ok, err := c.db.SetXX(ctx, key, value, ttl)
+		ttl, err := optionalTTL(cmd, args, 2)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		if ok {
-			return "1", nil
+		var ok bool
+		if cmd == "SETNX" {
+			ok, err = c.db.SetNX(ctx, args[0], args[1], ttl)
+		} else {
+			ok, err = c.db.SetXX(ctx, args[0], args[1], ttl)
 		}
-		return "0", nil
+		if err != nil && !IsKeyExists(err) && !IsKeyNotFound(err) {
+			return nil, err
+		}
+		return respBool(ok), nil
 
-	case "SETCAS":
-		if len(parts) < 4 {
-			return "", fmt.Errorf("Usage: SETCAS key old_value new_value [ttlSeconds]")
+	case "SETEX":
+		if len(args) != 3 {
+			return nil, respWrongArgs(cmd)
 		}
-		key := parts[1]
-		oldValue := parts[2]
-		newValue := parts[3]
-		ttl := 0
-		if len(parts) >= 5 {
-			tmp, err := strconv.Atoi(parts[4])
-			if err != nil {
-				return "", fmt.Errorf("invalid TTL: %v", parts[4])
-			}
-			ttl = tmp
+		ttl, err := strconv.Atoi(args[1])
+		if err != nil || ttl <= 0 {
+			return nil, respInvalidExpire(cmd)
 		}
-		err := c.db.SetCAS(ctx, key, oldValue, newValue, ttl)
-		if err != nil {
-			return "", err
+		if err := c.db.Set(ctx, args[0], args[2], ttl); err != nil {
+			return nil, err
 		}
-		return "OK", nil
+		return resp.SimpleString("OK"), nil
 
-	case "GETSET":
-		if len(parts) < 3 {
-			return "", fmt.Errorf("Usage: GETSET key new_value [ttlSeconds]")
-		}
-		key := parts[1]
-		newValue := parts[2]
-		ttl := 0
-		if len(parts) >= 4 {
-			tmp, err := strconv.Atoi(parts[3])
-			if err != nil {
-				return "", fmt.Errorf("invalid TTL: %v", parts[3])
-			}
-			ttl = tmp
+	case "SETCAS":
+		if len(args) != 3 && len(args) != 4 {
+			return nil, respWrongArgs(cmd)
 		}
-		oldVal, err := c.db.GetSet(ctx, key, newValue, ttl)
+		ttl, err := optionalTTL(cmd, args, 3)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-		return fmt.Sprintf("%v", oldVal), nil
+		if err := c.db.SetCAS(ctx, args[0], args[1], args[2], ttl); err != nil {
+			return nil, err
+		}
+		return resp.SimpleString("OK"), nil
 
-	case "INCR":
-		if len(parts) < 2 {
-			return "", fmt.Errorf("Usage: INCR key")
+	case "GET":
+		if len(args) != 1 {
+			return nil, respWrongArgs(cmd)
 		}
-		key := parts[1]
-		newVal, err := c.db.Incr(ctx, key)
+		entry, err := c.db.GetRawEntry(ctx, args[0])
 		if err != nil {
-			if IsInvalidValueType(err) {
-				return "(error) value is not an integer", nil
+			if IsKeyNotFound(err) {
+				return nil, nil
 			}
-			return "", err
+			return nil, err
+		}
+		if entry.Type != types.String {
+			return nil, respErrWrongType
 		}
-		return fmt.Sprintf("%d", newVal), nil
+		return respBulk(entry.Value), nil
 
-	case "DECR":
-		if len(parts) < 2 {
-			return "", fmt.Errorf("Usage: DECR key")
+	case "GETSET":
+		if len(args) != 2 && len(args) != 3 {
+			return nil, respWrongArgs(cmd)
 		}
-		key := parts[1]
-		newVal, err := c.db.Decr(ctx, key)
+		ttl, err := optionalTTL(cmd, args, 2)
 		if err != nil {
-			if IsInvalidValueType(err) {
-				return "(error) value is not an integer", nil
-			}
-			return "", err
-		}
-		return fmt.Sprintf("%d", newVal), nil
-
-	case "INCRBY":
-		if len(parts) < 3 {
-			return "", fmt.Errorf("Usage: INCRBY key increment")
+			return nil, err
 		}
-		key := parts[1]
-		inc, err := strconv.ParseInt(parts[2], 10, 64)
+		old, err := c.db.GetSet(ctx, args[0], args[1], ttl)
 		if err != nil {
-			return "", fmt.Errorf("invalid increment: %v", parts[2])
+			return nil, err
+		}
+		return respBulk(old), nil
+
+	case "GETWITHDETAILS":
+		if len(args) != 1 {
+			return nil, respWrongArgs(cmd)
 		}
-		newVal, err := c.db.IncrBy(ctx, key, inc)
+		val, ttl, err := c.db.GetWithDetails(ctx, args[0])
 		if err != nil {
-			if IsInvalidValueType(err) {
-				return "(error) value is not an integer", nil
+			if IsKeyNotFound(err) || IsKeyExpired(err) {
+				return nil, nil
 			}
-			return "", err
+			return nil, err
 		}
-		return fmt.Sprintf("%d", newVal), nil
+		return []interface{}{respBulk(val), int64(ttl)}, nil
 
-	case "DECRBY":
-		if len(parts) < 3 {
-			return "", fmt.Errorf("Usage: DECRBY key decrement")
+	case "MGET":
+		if len(args) < 1 {
+			return nil, respWrongArgs(cmd)
+		}
+		out := make([]interface{}, len(args))
+		for i, key := range args {
+			entry, err := c.db.GetRawEntry(ctx, key)
+			if err == nil && entry.Type == types.String {
+				out[i] = respBulk(entry.Value)
+			}
 		}
-		key := parts[1]
-		dec, err := strconv.ParseInt(parts[2], 10, 64)
-		if err != nil {
-			return "", fmt.Errorf("invalid decrement: %v", parts[2])
+		return out, nil
+
+	case "MSET":
+		if len(args) < 2 || len(args)%2 != 0 {
+			return nil, respWrongArgs(cmd)
 		}
-		newVal, err := c.db.DecrBy(ctx, key, dec)
-		if err != nil {
-			if IsInvalidValueType(err) {
-				return "(error) value is not an integer", nil
+		for i := 0; i < len(args); i += 2 {
+			if err := c.db.Set(ctx, args[i], args[i+1], 0); err != nil {
+				return nil, err
 			}
-			return "", err
 		}
-		return fmt.Sprintf("%d", newVal), nil
+		return resp.SimpleString("OK"), nil
 
-	case "LPUSH":
-		if len(parts) < 3 {
-			return "", fmt.Errorf("Usage: LPUSH key value")
+	case "INCR", "DECR":
+		if len(args) != 1 {
+			return nil, respWrongArgs(cmd)
 		}
-		key := parts[1]
-		value := parts[2]
-		if err := c.db.LPush(ctx, key, value); err != nil {
-			return "", err
+		if cmd == "INCR" {
+			return c.db.Incr(ctx, args[0])
 		}
-		return "OK", nil
+		return c.db.Decr(ctx, args[0])
 
-	case "RPUSH":
-		if len(parts) < 3 {
-			return "", fmt.Errorf("Usage: RPUSH key value")
+	case "INCRBY", "DECRBY":
+		if len(args) != 2 {
+			return nil, respWrongArgs(cmd)
+		}
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return nil, respErrNotInt
 		}
-		key := parts[1]
-		value := parts[2]
-		if err := c.db.RPush(ctx, key, value); err != nil {
-			return "", err
+		if cmd == "INCRBY" {
+			return c.db.IncrBy(ctx, args[0], n)
 		}
-		return "OK", nil
+		return c.db.DecrBy(ctx, args[0], n)
 
-	case "LPOP":
-		if len(parts) < 2 {
-			return "", fmt.Errorf("Usage: LPOP key")
+	case "LPUSH", "RPUSH":
+		if len(args) < 2 {
+			return nil, respWrongArgs(cmd)
+		}
+		values := make([]interface{}, len(args)-1)
+		for i, v := range args[1:] {
+			values[i] = v
+		}
+		var (
+			n   int
+			err error
+		)
+		if cmd == "LPUSH" {
+			n, err = c.db.LPush(ctx, args[0], values...)
+		} else {
+			n, err = c.db.RPush(ctx, args[0], values...)
 		}
-		key := parts[1]
-		val, err := c.db.LPop(ctx, key)
 		if err != nil {
-			if IsKeyNotFound(err) || IsEmptyList(err) {
-				return "(nil)", nil
-			}
-			return "", err
+			return nil, err
 		}
-		return fmt.Sprintf("%v", val), nil
+		return int64(n), nil
 
-	case "RPOP":
-		if len(parts) < 2 {
-			return "", fmt.Errorf("Usage: RPOP key")
+	case "LPOP", "RPOP":
+		if len(args) != 1 {
+			return nil, respWrongArgs(cmd)
+		}
+		var (
+			val interface{}
+			err error
+		)
+		if cmd == "LPOP" {
+			val, err = c.db.LPop(ctx, args[0])
+		} else {
+			val, err = c.db.RPop(ctx, args[0])
 		}
-		key := parts[1]
-		val, err := c.db.RPop(ctx, key)
 		if err != nil {
 			if IsKeyNotFound(err) || IsEmptyList(err) {
-				return "(nil)", nil
+				return nil, nil
 			}
-			return "", err
+			return nil, err
 		}
-		return fmt.Sprintf("%v", val), nil
+		return respBulk(val), nil
 
 	case "LLEN":
-		if len(parts) < 2 {
-			return "", fmt.Errorf("Usage: LLEN key")
+		if len(args) != 1 {
+			return nil, respWrongArgs(cmd)
 		}
-		key := parts[1]
-		length, err := c.db.LLen(ctx, key)
+		n, err := c.db.LLen(ctx, args[0])
 		if err != nil {
-			return "", err
+			if IsKeyNotFound(err) {
+				return int64(0), nil
+			}
+			return nil, err
 		}
-		return strconv.Itoa(length), nil
+		return int64(n), nil
 
 	case "LRANGE":
-		if len(parts) < 4 {
-			return "", fmt.Errorf("Usage: LRANGE key start end")
+		if len(args) != 3 {
+			return nil, respWrongArgs(cmd)
 		}
-		key := parts[1]
-		start, err := strconv.Atoi(parts[2])
-		if err != nil {
-			return "", fmt.Errorf("invalid start: %v", parts[2])
+		start, err1 := strconv.Atoi(args[1])
+		stop, err2 := strconv.Atoi(args[2])
+		if err1 != nil || err2 != nil {
+			return nil, respErrNotInt
 		}
-		end, err := strconv.Atoi(parts[3])
+		values, err := c.db.LRange(ctx, args[0], start, stop)
 		if err != nil {
-			return "", fmt.Errorf("invalid end: %v", parts[3])
+			if IsKeyNotFound(err) {
+				return []interface{}{}, nil
+			}
+			return nil, err
 		}
-		result, err := c.db.LRange(ctx, key, start, end)
-		if err != nil {
-			return "", err
+		return respBulks(values), nil
+
+	case "LTRIM":
+		if len(args) != 3 {
+			return nil, respWrongArgs(cmd)
 		}
-		if len(result) == 0 {
-			return "(empty list)", nil
+		start, err1 := strconv.Atoi(args[1])
+		stop, err2 := strconv.Atoi(args[2])
+		if err1 != nil || err2 != nil {
+			return nil, respErrNotInt
 		}
-		var elems []string
-		for _, v := range result {
-			elems = append(elems, fmt.Sprintf("%v", v))
+		if err := c.db.LTrim(ctx, args[0], start, stop); err != nil && !IsKeyNotFound(err) {
+			return nil, err
 		}
-		return fmt.Sprintf("[%s]", strings.Join(elems, ", ")), nil
+		return resp.SimpleString("OK"), nil
 
 	case "HSET":
-		if len(parts) < 4 {
-			return "", fmt.Errorf("Usage: HSET key field value [ttl]")
-		}
-		key := parts[1]
-		field := parts[2]
-		value := parts[3]
-		ttl := 0
-		if len(parts) >= 5 {
-			tmp, err := strconv.Atoi(parts[4])
+		// HSET key field value seconds, the form Execute has always accepted,
+		// also sets the expiration of the key.
+		if len(args) == 4 {
+			ttl, err := optionalTTL(cmd, args, 3)
 			if err != nil {
-				return "", fmt.Errorf("invalid TTL: %v", parts[4])
+				return nil, err
+			}
+			exists, err := c.db.HExists(ctx, args[0], args[1])
+			if err != nil && !IsKeyNotFound(err) {
+				return nil, err
+			}
+			if err := c.db.HSet(ctx, args[0], args[1], args[2], ttl); err != nil {
+				return nil, err
 			}
-			ttl = tmp
+			return respBool(!exists), nil
 		}
-		if err := c.db.HSet(ctx, key, field, value, ttl); err != nil {
-			return "", err
+		if len(args) < 3 || len(args)%2 != 1 {
+			return nil, respWrongArgs(cmd)
 		}
-		return "1", nil
+		added, err := c.db.HMSet(ctx, args[0], parseHashFields(args[1:]))
+		if err != nil {
+			return nil, err
+		}
+		return int64(added), nil
 
 	case "HGET":
-		if len(parts) < 3 {
-			return "", fmt.Errorf("Usage: HGET key field")
+		if len(args) != 2 {
+			return nil, respWrongArgs(cmd)
 		}
-		key := parts[1]
-		field := parts[2]
-		val, err := c.db.HGet(ctx, key, field)
+		val, err := c.db.HGet(ctx, args[0], args[1])
 		if err != nil {
 			if IsKeyNotFound(err) {
-				return "(nil)", nil
+				return nil, nil
 			}
-			return "", err
+			return nil, err
 		}
-		return fmt.Sprintf("%v", val), nil
+		return respBulk(val), nil
 
 	case "HDEL":
-		if len(parts) < 3 {
-			return "", fmt.Errorf("Usage: HDEL key field")
+		if len(args) < 2 {
+			return nil, respWrongArgs(cmd)
 		}
-		key := parts[1]
-		field := parts[2]
-		if err := c.db.HDel(ctx, key, field); err != nil {
-			if IsKeyNotFound(err) {
-				return "0", nil
+		removed := int64(0)
+		for _, field := range args[1:] {
+			exists, err := c.db.HExists(ctx, args[0], field)
+			if err != nil {
+				if IsKeyNotFound(err) {
+					break
+				}
+				return nil, err
 			}
-			return "", err
+			if !exists {
+				continue
+			}
+			if err := c.db.HDel(ctx, args[0], field); err != nil && !IsKeyNotFound(err) {
+				return nil, err
+			}
+			removed++
 		}
-		return "1", nil
+		return removed, nil
 
 	case "HGETALL":
-		if len(parts) < 2 {
-			return "", fmt.Errorf("Usage: HGETALL key")
+		if len(args) != 1 {
+			return nil, respWrongArgs(cmd)
 		}
-		key := parts[1]
-		hash, err := c.db.HGetAll(ctx, key)
+		hash, err := c.db.HGetAll(ctx, args[0])
 		if err != nil {
 			if IsKeyNotFound(err) {
-				return "(empty list or set)", nil
+				return map[string]interface{}{}, nil
 			}
-			return "", err
+			return nil, err
 		}
-		if len(hash) == 0 {
-			return "(empty list or set)", nil
-		}
-		var result []string
+		out := make(map[string]interface{}, len(hash))
 		for k, v := range hash {
-			result = append(result, fmt.Sprintf("%q: %q", k, v))
+			out[k] = respBulk(v)
 		}
-		return strings.Join(result, "\n"), nil
+		return out, nil
 
 	case "HEXISTS":
-		if len(parts) < 3 {
-			return "", fmt.Errorf("Usage: HEXISTS key field")
+		if len(args) != 2 {
+			return nil, respWrongArgs(cmd)
+		}
+		exists, err := c.db.HExists(ctx, args[0], args[1])
+		if err != nil && !IsKeyNotFound(err) {
+			return nil, err
+		}
+		return respBool(exists), nil
+
+	case "HLEN":
+		if len(args) != 1 {
+			return nil, respWrongArgs(cmd)
 		}
-		key := parts[1]
-		field := parts[2]
-		exists, err := c.db.HExists(ctx, key, field)
+		n, err := c.db.HLen(ctx, args[0])
 		if err != nil {
-			return "", err
+			if IsKeyNotFound(err) {
+				return int64(0), nil
+			}
+			return nil, err
+		}
+		return int64(n), nil
+
+	case "SADD", "SREM":
+		if len(args) < 2 {
+			return nil, respWrongArgs(cmd)
+		}
+		changed := int64(0)
+		members := make([]interface{}, 0, len(args)-1)
+		seen := make(map[string]struct{}, len(args)-1)
+		for _, m := range args[1:] {
+			if _, dup := seen[m]; dup {
+				continue
+			}
+			seen[m] = struct{}{}
+			members = append(members, m)
+
+			isMember, err := c.db.SIsMember(ctx, args[0], m)
+			if err != nil && !IsKeyNotFound(err) {
+				return nil, err
+			}
+			if isMember == (cmd == "SREM") {
+				changed++
+			}
 		}
-		if exists {
-			return "1", nil
+		var err error
+		if cmd == "SADD" {
+			err = c.db.SAdd(ctx, args[0], members...)
+		} else {
+			err = c.db.SRem(ctx, args[0], members...)
 		}
-		return "0", nil
+		if err != nil && !IsKeyNotFound(err) {
+			return nil, err
+		}
+		return changed, nil
 
-	case "HLEN":
-		if len(parts) < 2 {
-			return "", fmt.Errorf("Usage: HLEN key")
+	case "SISMEMBER":
+		if len(args) != 2 {
+			return nil, respWrongArgs(cmd)
+		}
+		found, err := c.db.SIsMember(ctx, args[0], args[1])
+		if err != nil && !IsKeyNotFound(err) {
+			return nil, err
 		}
-		key := parts[1]
-		length, err := c.db.HLen(ctx, key)
+		return respBool(found), nil
+
+	case "SCARD":
+		if len(args) != 1 {
+			return nil, respWrongArgs(cmd)
+		}
+		n, err := c.db.SCard(ctx, args[0])
 		if err != nil {
-			return "", err
+			if IsKeyNotFound(err) {
+				return int64(0), nil
+			}
+			return nil, err
 		}
-		return strconv.Itoa(length), nil
+		return int64(n), nil
 
-	case "SADD":
+	case "SMEMBERS":
+		if len(args) != 1 {
+			return nil, respWrongArgs(cmd)
+		}
+		members, err := c.db.SMembers(ctx, args[0])
+		if err != nil {
+			if IsKeyNotFound(err) {
+				return resp.Set{}, nil
+			}
+			return nil, err
+		}
+		return resp.Set(respBulks(members)), nil
 
-		if len(parts) < 3 {
-			return "", fmt.Errorf("Usage: SADD key member1 [member2 ...]")
+	case "ZADD":
+		key, opts, incr, members, err := parseZAddArgs(args)
+		if err != nil {
+			if len(args) < 3 {
+				return nil, respWrongArgs(cmd)
+			}
+			return nil, err
 		}
-		key := parts[1]
-		members := parts[2:]
-		var memInterfaces []interface{}
-		for _, m := range members {
-			memInterfaces = append(memInterfaces, m)
+		if incr {
+			score, err := c.db.ZIncrBy(ctx, key, members[0].Member, members[0].Score, opts)
+			if err != nil {
+				if IsConditionNotMet(err) {
+					return nil, nil
+				}
+				return nil, err
+			}
+			return formatScore(score), nil
 		}
-		if err := c.db.SAdd(ctx, key, memInterfaces...); err != nil {
-			return "", err
+		n, err := c.db.ZAdd(ctx, key, opts, members...)
+		if err != nil {
+			return nil, err
 		}
+		return int64(n), nil
 
-		return fmt.Sprintf("%d", len(members)), nil
+	case "ZINCRBY":
+		if len(args) != 3 {
+			return nil, respWrongArgs(cmd)
+		}
+		increment, err := parseScore(args[1])
+		if err != nil {
+			return nil, err
+		}
+		score, err := c.db.ZIncrBy(ctx, args[0], args[2], increment, types.ZAddOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return formatScore(score), nil
 
-	case "SREM":
-		if len(parts) < 3 {
-			return "", fmt.Errorf("Usage: SREM key member1 [member2 ...]")
+	case "ZREM":
+		if len(args) < 2 {
+			return nil, respWrongArgs(cmd)
 		}
-		key := parts[1]
-		members := parts[2:]
-		var memInterfaces []interface{}
-		for _, m := range members {
-			memInterfaces = append(memInterfaces, m)
+		n, err := c.db.ZRem(ctx, args[0], args[1:]...)
+		if err != nil && !IsKeyNotFound(err) {
+			return nil, err
+		}
+		return int64(n), nil
+
+	case "ZSCORE":
+		if len(args) != 2 {
+			return nil, respWrongArgs(cmd)
 		}
-		if err := c.db.SRem(ctx, key, memInterfaces...); err != nil {
+		score, err := c.db.ZScore(ctx, args[0], args[1])
+		if err != nil {
 			if IsKeyNotFound(err) {
-				return "0", nil
+				return nil, nil
 			}
-			return "", err
+			return nil, err
 		}
-		return fmt.Sprintf("%d", len(members)), nil
+		return formatScore(score), nil
 
-	case "SISMEMBER":
-		if len(parts) < 3 {
-			return "", fmt.Errorf("Usage: SISMEMBER key member")
+	case "ZRANK", "ZREVRANK":
+		if len(args) != 2 {
+			return nil, respWrongArgs(cmd)
+		}
+		var (
+			rank int
+			err  error
+		)
+		if cmd == "ZRANK" {
+			rank, err = c.db.ZRank(ctx, args[0], args[1])
+		} else {
+			rank, err = c.db.ZRevRank(ctx, args[0], args[1])
 		}
-		key := parts[1]
-		member := parts[2]
-		found, err := c.db.SIsMember(ctx, key, member)
 		if err != nil {
 			if IsKeyNotFound(err) {
-				return "0", nil
+				return nil, nil
 			}
-			return "", err
+			return nil, err
+		}
+		return int64(rank), nil
+
+	case "ZRANGE", "ZREVRANGE", "ZRANGEBYSCORE", "ZREVRANGEBYSCORE", "ZRANGEBYLEX", "ZREVRANGEBYLEX":
+		if len(args) < 3 {
+			return nil, respWrongArgs(cmd)
 		}
-		if found {
-			return "1", nil
+		q, err := parseZRangeArgs(cmd, args)
+		if err != nil {
+			return nil, err
+		}
+		members, err := c.zrange(ctx, q)
+		if err != nil {
+			return nil, err
 		}
-		return "0", nil
+		return respZMembers(members, q.withScores), nil
 
-	case "SCARD":
-		if len(parts) < 2 {
-			return "", fmt.Errorf("Usage: SCARD key")
+	case "ZPOPMIN", "ZPOPMAX":
+		if len(args) < 1 || len(args) > 2 {
+			return nil, respWrongArgs(cmd)
+		}
+		count := 1
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 0 {
+				return nil, resp.Error("ERR value is out of range, must be positive")
+			}
+			count = n
+		}
+		var (
+			members []types.ZMember
+			err     error
+		)
+		if cmd == "ZPOPMIN" {
+			members, err = c.db.ZPopMin(ctx, args[0], count)
+		} else {
+			members, err = c.db.ZPopMax(ctx, args[0], count)
 		}
-		key := parts[1]
-		count, err := c.db.SCard(ctx, key)
+		if err != nil && !IsKeyNotFound(err) {
+			return nil, err
+		}
+		return respZMembers(members, true), nil
+
+	case "ZCARD":
+		if len(args) != 1 {
+			return nil, respWrongArgs(cmd)
+		}
+		n, err := c.db.ZCard(ctx, args[0])
 		if err != nil {
 			if IsKeyNotFound(err) {
-				return "0", nil
+				return int64(0), nil
 			}
-			return "", err
+			return nil, err
 		}
-		return strconv.Itoa(count), nil
+		return int64(n), nil
 
-	case "SMEMBERS":
-		if len(parts) < 2 {
-			return "", fmt.Errorf("Usage: SMEMBERS key")
+	case "ZCOUNT":
+		if len(args) != 3 {
+			return nil, respWrongArgs(cmd)
 		}
-		key := parts[1]
-		members, err := c.db.SMembers(ctx, key)
+		min, err1 := types.ParseScoreBound(args[1])
+		max, err2 := types.ParseScoreBound(args[2])
+		if err1 != nil || err2 != nil {
+			return nil, resp.Error("ERR min or max is not a float")
+		}
+		n, err := c.db.ZCount(ctx, args[0], min, max)
 		if err != nil {
 			if IsKeyNotFound(err) {
-				return "(empty set)", nil
+				return int64(0), nil
 			}
-			return "", err
+			return nil, err
 		}
-		if len(members) == 0 {
-			return "(empty set)", nil
+		return int64(n), nil
+
+	case "DEL":
+		if len(args) < 1 {
+			return nil, respWrongArgs(cmd)
+		}
+		removed := int64(0)
+		for _, key := range args {
+			if err := c.db.Delete(ctx, key); err == nil {
+				removed++
+			} else if !IsKeyNotFound(err) {
+				return nil, err
+			}
 		}
-		var out []string
-		for _, m := range members {
-			out = append(out, fmt.Sprintf("%v", m))
+		return removed, nil
+
+	case "EXISTS":
+		if len(args) < 1 {
+			return nil, respWrongArgs(cmd)
 		}
-		return fmt.Sprintf("[%s]", strings.Join(out, " ")), nil
+		count := int64(0)
+		for _, key := range args {
+			exists, err := c.db.Exists(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			if exists {
+				count++
+			}
+		}
+		return count, nil
 
 	case "EXPIRE":
-		if len(parts) < 3 {
-			return "", fmt.Errorf("Usage: EXPIRE key seconds")
+		if len(args) != 2 {
+			return nil, respWrongArgs(cmd)
 		}
-		key := parts[1]
-		ttl, err := strconv.Atoi(parts[2])
+		ttl, err := strconv.Atoi(args[1])
 		if err != nil {
-			return "", fmt.Errorf("invalid TTL: %v", parts[2])
+			return nil, respErrNotInt
 		}
-		ok, err := c.db.Expire(ctx, key, ttl)
-		if err != nil {
-			return "", err
+		if ttl <= 0 {
+			return nil, respInvalidExpire(cmd)
 		}
-		if !ok {
-			return "false", nil
+		ok, err := c.db.Expire(ctx, args[0], ttl)
+		if err != nil && !IsKeyNotFound(err) {
+			return nil, err
 		}
-		return "OK", nil
+		return respBool(ok), nil
 
 	case "PERSIST":
-		if len(parts) < 2 {
-			return "", fmt.Errorf("Usage: PERSIST key")
-		}
-		key := parts[1]
-		ok, err := c.db.Persist(ctx, key)
-		if err != nil {
-			return "", err
+		if len(args) != 1 {
+			return nil, respWrongArgs(cmd)
 		}
-		if !ok {
-			return "false", nil
+		ok, err := c.db.Persist(ctx, args[0])
+		if err != nil && !IsKeyNotFound(err) {
+			return nil, err
 		}
-		return "OK", nil
+		return respBool(ok), nil
 
 	case "TTL":
-		if len(parts) < 2 {
-			return "", fmt.Errorf("Usage: TTL key")
+		if len(args) != 1 {
+			return nil, respWrongArgs(cmd)
 		}
-		key := parts[1]
-		_, ttl, err := c.db.GetWithDetails(ctx, key)
+		_, ttl, err := c.db.GetWithDetails(ctx, args[0])
 		if err != nil {
 			if IsKeyNotFound(err) || IsKeyExpired(err) {
-				return "-2", nil
+				return int64(-2), nil
 			}
-			return "", err
+			return nil, err
 		}
-		if ttl == -1 {
-			return "-1", nil
-		}
-		return strconv.Itoa(ttl), nil
+		return int64(ttl), nil
 
 	case "TYPE":
-		if len(parts) < 2 {
-			return "", fmt.Errorf("Usage: TYPE key")
+		if len(args) != 1 {
+			return nil, respWrongArgs(cmd)
 		}
-		key := parts[1]
-		dtype, err := c.db.Type(ctx, key)
+		dtype, err := c.db.Type(ctx, args[0])
 		if err != nil {
 			if IsKeyNotFound(err) {
-				return "(nil)", nil
+				return resp.SimpleString("none"), nil
 			}
-			return "", err
-		}
-		dt, ok := dtype.(types.DataType)
-		if !ok {
-			return "", fmt.Errorf("unexpected type returned")
+			return nil, err
 		}
-		var typeStr string
-		switch dt {
+		switch dtype {
 		case types.String:
-			typeStr = "string"
+			return resp.SimpleString("string"), nil
 		case types.List:
-			typeStr = "list"
+			return resp.SimpleString("list"), nil
 		case types.Hash:
-			typeStr = "hash"
+			return resp.SimpleString("hash"), nil
 		case types.Set:
-			typeStr = "set"
+			return resp.SimpleString("set"), nil
+		case types.ZSet:
+			return resp.SimpleString("zset"), nil
+		case types.Stream:
+			return resp.SimpleString("stream"), nil
 		default:
-			typeStr = "unknown"
-		}
-		return typeStr, nil
-
-	case "GETWITHDETAILS":
-		if len(parts) < 2 {
-			return "", fmt.Errorf("Usage: GETWITHDETAILS key")
-		}
-		key := parts[1]
-		val, ttl, err := c.db.GetWithDetails(ctx, key)
-		if err != nil {
-			if IsKeyNotFound(err) || IsKeyExpired(err) {
-				return "(nil)", nil
-			}
-			return "", err
+			return resp.SimpleString("unknown"), nil
 		}
-		return fmt.Sprintf("Value: %v, TTL: %d", val, ttl), nil
 
 	case "RENAME":
-		if len(parts) < 3 {
-			return "", fmt.Errorf("Usage: RENAME old_key new_key")
+		if len(args) != 2 {
+			return nil, respWrongArgs(cmd)
 		}
-		oldKey := parts[1]
-		newKey := parts[2]
-		if err := c.db.Rename(ctx, oldKey, newKey); err != nil {
-			if IsKeyNotFound(err) {
-				return "(nil)", nil
-			} else if IsKeyExists(err) {
-				return "(error) new key exists", nil
+		if err := c.db.Rename(ctx, args[0], args[1]); err != nil {
+			if IsKeyExists(err) {
+				return nil, resp.Error("ERR target key name is busy")
 			}
-			return "", err
+			return nil, err
 		}
-		return "OK", nil
+		return resp.SimpleString("OK"), nil
 
 	case "FIND":
-		if len(parts) < 2 {
-			return "", fmt.Errorf("Usage: FIND value")
+		if len(args) != 1 {
+			return nil, respWrongArgs(cmd)
 		}
-		value := parts[1]
-		keys, err := c.db.FindByValue(ctx, value)
-		if err != nil {
-			if IsKeyNotFound(err) {
-				return "Keys: []", nil
-			}
-			return "", err
+		keys, err := c.db.FindByValue(ctx, args[0])
+		if err != nil && !IsKeyNotFound(err) {
+			return nil, err
 		}
-		if len(keys) == 0 {
-			return "Keys: []", nil
+		out := make([]interface{}, len(keys))
+		for i, key := range keys {
+			out[i] = key
 		}
-		return fmt.Sprintf("Keys: [%s]", strings.Join(keys, ",")), nil
+		return out, nil
 
-	case "EXISTS":
-		if len(parts) < 2 {
-			return "", fmt.Errorf("Usage: EXISTS key")
-		}
-		key := parts[1]
-		exists, err := c.db.Exists(ctx, key)
-		if err != nil {
-			return "", err
-		}
-		if exists {
-			return "1", nil
+	case "FLUSHALL", "FLUSHDB", "DROPALL":
+		if err := c.db.DropAll(ctx); err != nil {
+			return nil, err
 		}
-		return "0", nil
+		return resp.SimpleString("OK"), nil
 
-	case "DEL":
-		if len(parts) < 2 {
-			return "", fmt.Errorf("Usage: DEL key")
-		}
-		key := parts[1]
-		err := c.db.Delete(ctx, key)
-		if err != nil {
-			if IsKeyNotFound(err) {
-				return "false", nil
+	default:
+		return nil, errUnknownCommand(cmd)
+	}
+}
+
+// optionalTTL parses the ttl in seconds that may follow the values of SETNX,
+// SETXX, SETCAS, GETSET and HSET at args[i].
+func optionalTTL(cmd string, args []string, i int) (int, error) {
+	if len(args) <= i {
+		return 0, nil
+	}
+	ttl, err := strconv.Atoi(args[i])
+	if err != nil {
+		return 0, respInvalidExpire(cmd)
+	}
+	return ttl, nil
+}
+
+// textSlot is the replyFormat of Execute.
+func textSlot(cmd string, reply interface{}, err error) interface{} {
+	if err != nil {
+		return err
+	}
+	return textReply(cmd, reply)
+}
+
+// textReply renders the reply of cmd for Execute. Commands that Execute
+// supported before Do keep the wording they always had; everything else is
+// rendered by formatText.
+func textReply(cmd string, reply interface{}) string {
+	switch v := reply.(type) {
+	case string:
+		if cmd == "GET" {
+			return fmt.Sprintf("\"%s\"", v)
+		}
+	case int64:
+		switch cmd {
+		case "DEL":
+			return strconv.FormatBool(v > 0)
+		case "EXPIRE", "PERSIST":
+			if v > 0 {
+				return "OK"
+			}
+			return "false"
+		case "LPUSH", "RPUSH":
+			return "OK"
+		}
+	case resp.SimpleString:
+		switch {
+		case cmd == "TYPE" && v == "none":
+			return "(nil)"
+		case (cmd == "QUIT" || cmd == "EXIT") && v == "OK":
+			return "Bye!"
+		}
+	case []interface{}:
+		switch cmd {
+		case "FIND":
+			keys := make([]string, len(v))
+			for i, key := range v {
+				keys[i] = formatText(key)
+			}
+			return fmt.Sprintf("Keys: [%s]", strings.Join(keys, ","))
+		case "GETWITHDETAILS":
+			return fmt.Sprintf("Value: %v, TTL: %v", v[0], v[1])
+		case "BLPOP", "BRPOP":
+			return fmt.Sprintf("%v: %v", v[0], v[1])
+		case "SUBSCRIBE", "UNSUBSCRIBE", "PSUBSCRIBE", "PUNSUBSCRIBE":
+			return formatLines(v, formatText)
+		case "XRANGE", "XREVRANGE", "XCLAIM":
+			return formatStreamEntries(v)
+		case "XREAD", "XREADGROUP":
+			return formatLines(v, func(r interface{}) string {
+				read := r.([]interface{})
+				return fmt.Sprintf("%v:\n%s", read[0], formatStreamEntries(read[1].([]interface{})))
+			})
+		case "XPENDING":
+			return formatPending(v)
+		case "EXEC":
+			if len(v) == 0 {
+				return "(empty array)"
 			}
-			return "", err
+			lines := make([]string, len(v))
+			for i, r := range v {
+				lines[i] = fmt.Sprintf("%d) %s", i+1, formatText(r))
+			}
+			return strings.Join(lines, "\n")
 		}
-		return "true", nil
+	}
+	return formatText(reply)
+}
 
-	case "DROPALL":
-		if err := c.db.DropAll(ctx); err != nil {
-			return "", err
-		}
-		return "OK", nil
+// formatText renders a reply of run: nil as (nil), arrays and sets in
+// brackets, maps one "field": "value" pair per line and errors as (error).
+func formatText(reply interface{}) string {
+	switch v := reply.(type) {
+	case nil:
+		return "(nil)"
+	case string:
+		return v
+	case resp.SimpleString:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case error:
+		return "(error) " + v.Error()
+	case resp.Push:
+		return formatJoined(v, " ")
+	case resp.Set:
+		if len(v) == 0 {
+			return "(empty set)"
+		}
+		return "[" + formatJoined(v, " ") + "]"
+	case []interface{}:
+		if len(v) == 0 {
+			return "(empty list)"
+		}
+		return "[" + formatJoined(v, ", ") + "]"
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return "(empty list or set)"
+		}
+		fields := make([]string, 0, len(v))
+		for f := range v {
+			fields = append(fields, f)
+		}
+		sort.Strings(fields)
+		lines := make([]string, len(fields))
+		for i, f := range fields {
+			lines[i] = fmt.Sprintf("%q: %q", f, formatText(v[f]))
+		}
+		return strings.Join(lines, "\n")
+	}
+	return fmt.Sprint(reply)
+}
 
-	case "EXEC":
-		return "EXEC not implemented", nil
-	case "DISCARD":
-		return "DISCARD executed", nil
-	case "HELP":
-		return `
+func formatJoined(values []interface{}, sep string) string {
+	elems := make([]string, len(values))
+	for i, v := range values {
+		elems[i] = formatText(v)
+	}
+	return strings.Join(elems, sep)
+}
+
+func formatLines(values []interface{}, format func(interface{}) string) string {
+	lines := make([]string, len(values))
+	for i, v := range values {
+		lines[i] = format(v)
+	}
+	return strings.Join(lines, "\n")
+}
+
+const helpText = `
 Available Commands:
-  SET key value [ttl]
+  SET key value [ttl | EX seconds | PX milliseconds] [NX|XX]
+  SETEX key seconds value
   GET key
   SETNX key value [ttl]
   SETXX key value [ttl]
   SETCAS key old_value new_value [ttl]
   GETSET key new_value [ttl]
+  MGET key [key ...]
+  MSET key value [key value ...]
   INCR key
   DECR key
   INCRBY key increment
   DECRBY key decrement
-  LPUSH key value
-  RPUSH key value
+  LPUSH key value [value ...]
+  RPUSH key value [value ...]
   LPOP key
   RPOP key
   LLEN key
   LRANGE key start end
-  HSET key field value [ttl]
+  LTRIM key start stop
+  LINDEX key index
+  LSET key index element
+  LINSERT key BEFORE|AFTER pivot element
+  LREM key count element
+  LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+  LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+  RPOPLPUSH source destination
+  BLPOP key [key ...] timeout
+  BRPOP key [key ...] timeout
+  BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+  HSET key field value [ttl] | HSET key field value [field value ...]
   HGET key field
-  HDEL key field
+  HDEL key field [field ...]
   HGETALL key
   HEXISTS key field
   HLEN key
+  HMSET key field value [field value ...]
+  HMGET key field [field ...]
+  HSETNX key field value
+  HINCRBY key field increment
+  HINCRBYFLOAT key field increment
+  HKEYS key
+  HVALS key
+  HSTRLEN key field
+  HEXPIRE key seconds FIELDS numfields field [field ...]
+  HTTL key FIELDS numfields field [field ...]
+  HPERSIST key FIELDS numfields field [field ...]
   SADD key member [member2 ...]
   SREM key member [member2 ...]
   SISMEMBER key member
   SCARD key
   SMEMBERS key
-  EXISTS key
+  ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+  ZINCRBY key increment member
+  ZREM key member [member2 ...]
+  ZSCORE key member
+  ZRANK key member
+  ZREVRANK key member
+  ZRANGE key start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+  ZREVRANGE key start stop [WITHSCORES]
+  ZRANGEBYSCORE key min max [WITHSCORES] [LIMIT offset count]
+  ZREVRANGEBYSCORE key max min [WITHSCORES] [LIMIT offset count]
+  ZRANGEBYLEX key min max [LIMIT offset count]
+  ZPOPMIN key [count]
+  ZPOPMAX key [count]
+  ZCARD key
+  ZCOUNT key min max
+  XADD key id|* field value [field value ...]
+  XLEN key
+  XRANGE key start end [COUNT count]
+  XREVRANGE key end start [COUNT count]
+  XTRIM key MAXLEN [=|~] count
+  XREAD [COUNT count] [BLOCK ms] STREAMS key [key ...] id [id ...]
+  XGROUP CREATE key group id|$ [MKSTREAM] | DESTROY key group
+  XREADGROUP GROUP group consumer [COUNT count] [BLOCK ms] [NOACK] STREAMS key [key ...] id [id ...]
+  XACK key group id [id ...]
+  XPENDING key group [start end count [consumer]]
+  XCLAIM key group consumer min-idle-ms id [id ...]
+  EXISTS key [key ...]
   EXPIRE key seconds
   PERSIST key
   TTL key
@@ -683,18 +1076,214 @@ Available Commands:
   GETWITHDETAILS key
   RENAME old_key new_key
   FIND value
-  DEL key
-  DROPALL
+  DEL key [key ...]
+  DROPALL / FLUSHALL / FLUSHDB
+  PUBLISH channel message
+  SUBSCRIBE channel [channel ...]
+  UNSUBSCRIBE [channel ...]
+  PSUBSCRIBE pattern [pattern ...]
+  PUNSUBSCRIBE [pattern ...]
+  PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT
+  WATCH key [key ...]
+  UNWATCH
+  MULTI
   EXEC
   DISCARD
+  PING [message]
+  ECHO message
   HELP
   QUIT / EXIT
-`, nil
+`
 
-	case "QUIT", "EXIT":
-		return "Bye!", nil
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+func parseScore(s string) (float64, error) {
+	score, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, fmt.Errorf("value is not a valid float")
+	}
+	return score, nil
+}
 
+// parseZAddArgs parses "key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]".
+func parseZAddArgs(args []string) (key string, opts types.ZAddOptions, incr bool, members []types.ZMember, err error) {
+	if len(args) < 3 {
+		return "", opts, false, nil, fmt.Errorf("Usage: ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]")
+	}
+	key = args[0]
+	i := 1
+flags:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			opts.NX = true
+		case "XX":
+			opts.XX = true
+		case "GT":
+			opts.GT = true
+		case "LT":
+			opts.LT = true
+		case "CH":
+			opts.CH = true
+		case "INCR":
+			incr = true
+		default:
+			break flags
+		}
+	}
+	rest := args[i:]
+	if len(rest) == 0 || len(rest)%2 != 0 {
+		return "", opts, false, nil, fmt.Errorf("syntax error")
+	}
+	if incr && len(rest) != 2 {
+		return "", opts, false, nil, fmt.Errorf("INCR option supports a single increment-element pair")
+	}
+	if validateZAddOptions(opts) != nil {
+		return "", opts, false, nil, fmt.Errorf("GT, LT, and/or NX options at the same time are not compatible")
+	}
+	for j := 0; j < len(rest); j += 2 {
+		score, err := parseScore(rest[j])
+		if err != nil {
+			return "", opts, false, nil, err
+		}
+		members = append(members, types.ZMember{Member: rest[j+1], Score: score})
+	}
+	return key, opts, incr, members, nil
+}
+
+// zrangeQuery is a normalized ZRANGE, ZREVRANGE, ZRANGEBYSCORE, ZREVRANGEBYSCORE
+// or ZRANGEBYLEX request.
+type zrangeQuery struct {
+	key        string
+	by         string
+	start      int
+	stop       int
+	minScore   types.ScoreBound
+	maxScore   types.ScoreBound
+	minLex     types.LexBound
+	maxLex     types.LexBound
+	opts       types.ZRangeOptions
+	withScores bool
+	empty      bool
+}
+
+func parseZRangeArgs(cmd string, args []string) (zrangeQuery, error) {
+	q := zrangeQuery{by: "rank"}
+	if len(args) < 3 {
+		return q, fmt.Errorf("Usage: %s key start stop [options]", cmd)
+	}
+	q.key = args[0]
+	switch cmd {
+	case "ZREVRANGE":
+		q.opts.Rev = true
+	case "ZRANGEBYSCORE":
+		q.by = "score"
+	case "ZREVRANGEBYSCORE":
+		q.by, q.opts.Rev = "score", true
+	case "ZRANGEBYLEX":
+		q.by = "lex"
+	case "ZREVRANGEBYLEX":
+		q.by, q.opts.Rev = "lex", true
+	}
+
+	limited := false
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "WITHSCORES":
+			q.withScores = true
+		case "BYSCORE":
+			q.by = "score"
+		case "BYLEX":
+			q.by = "lex"
+		case "REV":
+			q.opts.Rev = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return q, fmt.Errorf("syntax error")
+			}
+			offset, err1 := strconv.Atoi(args[i+1])
+			count, err2 := strconv.Atoi(args[i+2])
+			if err1 != nil || err2 != nil {
+				return q, fmt.Errorf("value is not an integer or out of range")
+			}
+			limited = true
+			q.opts.Offset, q.opts.Count = offset, count
+			i += 2
+		default:
+			return q, fmt.Errorf("syntax error")
+		}
+	}
+
+	if limited {
+		if q.by == "rank" {
+			return q, fmt.Errorf("syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+		}
+		if q.opts.Count == 0 || q.opts.Offset < 0 {
+			q.empty = true
+		} else if q.opts.Count < 0 {
+			q.opts.Count = 0
+		}
+	}
+	if q.by == "lex" && q.withScores {
+		return q, fmt.Errorf("syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+
+	// Reverse score and lex ranges take the upper bound first.
+	lo, hi := args[1], args[2]
+	if q.opts.Rev && q.by != "rank" {
+		lo, hi = hi, lo
+	}
+
+	var err1, err2 error
+	switch q.by {
+	case "rank":
+		q.start, err1 = strconv.Atoi(lo)
+		q.stop, err2 = strconv.Atoi(hi)
+		if err1 != nil || err2 != nil {
+			return q, fmt.Errorf("value is not an integer or out of range")
+		}
+	case "score":
+		q.minScore, err1 = types.ParseScoreBound(lo)
+		q.maxScore, err2 = types.ParseScoreBound(hi)
+		if err1 != nil || err2 != nil {
+			return q, fmt.Errorf("min or max is not a float")
+		}
+	case "lex":
+		q.minLex, err1 = types.ParseLexBound(lo)
+		q.maxLex, err2 = types.ParseLexBound(hi)
+		if err1 != nil || err2 != nil {
+			return q, fmt.Errorf("min or max not valid string range item")
+		}
+	}
+	return q, nil
+}
+
+func (c *CommandAPI) zrange(ctx context.Context, q zrangeQuery) ([]types.ZMember, error) {
+	if q.empty {
+		return []types.ZMember{}, nil
+	}
+	var (
+		members []types.ZMember
+		err     error
+	)
+	switch q.by {
+	case "score":
+		members, err = c.db.ZRangeByScore(ctx, q.key, q.minScore, q.maxScore, q.opts)
+	case "lex":
+		members, err = c.db.ZRangeByLex(ctx, q.key, q.minLex, q.maxLex, q.opts)
 	default:
-		return "", fmt.Errorf("unknown command: %s", cmd)
+		members, err = c.db.ZRange(ctx, q.key, q.start, q.stop, q.opts.Rev)
+	}
+	if IsKeyNotFound(err) {
+		return []types.ZMember{}, nil
 	}
+	return members, err
 }
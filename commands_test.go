@@ -3,7 +3,11 @@ package hermes
 import (
 	"context"
 	"github.com/themedef/go-hermes/internal/contracts"
+	"github.com/themedef/go-hermes/internal/resp"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func helperCreateAPI() (contracts.CommandsHandler, context.Context) {
@@ -141,6 +145,184 @@ func TestCommandAPIListOps(t *testing.T) {
 	}
 }
 
+// TestCommandAPIExecuteAndDo checks that Execute and Do accept the same
+// commands and differ only in how the reply is rendered.
+func TestCommandAPIExecuteAndDo(t *testing.T) {
+	text, ctx := helperCreateAPI()
+	typed, _ := helperCreateAPI()
+
+	// Scenario 1: the same commands on two stores
+	steps := []struct {
+		parts []string
+		text  string
+		reply interface{}
+	}{
+		{[]string{"SET", "a", "1", "EX", "100"}, "OK", resp.SimpleString("OK")},
+		{[]string{"SET", "b", "2", "100"}, "OK", resp.SimpleString("OK")},
+		{[]string{"GET", "a"}, "\"1\"", "1"},
+		{[]string{"RPUSH", "list", "x", "y"}, "OK", int64(2)},
+		{[]string{"DEL", "a", "b"}, "true", int64(2)},
+		{[]string{"TYPE", "a"}, "(nil)", resp.SimpleString("none")},
+		{[]string{"LRANGE", "list", "0", "-1"}, "[x, y]", []interface{}{"x", "y"}},
+	}
+	for _, s := range steps {
+		got, err := text.Execute(ctx, s.parts)
+		if err != nil || got != s.text {
+			t.Errorf("Execute %v: got=%q err=%v, want=%q", s.parts, got, err, s.text)
+		}
+		reply, err := typed.Do(ctx, s.parts)
+		if err != nil || !reflect.DeepEqual(reply, s.reply) {
+			t.Errorf("Do %v: got=%#v err=%v, want=%#v", s.parts, reply, err, s.reply)
+		}
+	}
+
+	// Scenario 2: both report the same argument errors
+	for _, parts := range [][]string{{"GET"}, {"SET", "k", "v", "EX"}, {"NOPE"}} {
+		_, textErr := text.Execute(ctx, parts)
+		_, respErr := typed.Do(ctx, parts)
+		if textErr == nil || respErr == nil || textErr.Error() != respErr.Error() {
+			t.Errorf("%v: Execute err=%v, Do err=%v", parts, textErr, respErr)
+		}
+	}
+}
+
+func TestCommandAPIListEdits(t *testing.T) {
+	api, ctx := helperCreateAPI()
+	_, _ = api.Execute(ctx, []string{"RPUSH", "list", "a"})
+	_, _ = api.Execute(ctx, []string{"RPUSH", "list", "b"})
+	_, _ = api.Execute(ctx, []string{"RPUSH", "list", "a"})
+
+	// Scenario 1: Each command's reply
+	steps := []struct {
+		parts []string
+		want  string
+	}{
+		{[]string{"LINDEX", "list", "-1"}, "a"},
+		{[]string{"LINDEX", "list", "5"}, "(nil)"},
+		{[]string{"LSET", "list", "1", "B"}, "OK"},
+		{[]string{"LINSERT", "list", "BEFORE", "B", "x"}, "4"},
+		{[]string{"LINSERT", "list", "AFTER", "nope", "x"}, "-1"},
+		{[]string{"LINSERT", "missing", "AFTER", "a", "x"}, "0"},
+		{[]string{"LPOS", "list", "a"}, "0"},
+		{[]string{"LPOS", "list", "a", "RANK", "-1", "COUNT", "0"}, "[3, 0]"},
+		{[]string{"LPOS", "list", "z"}, "(nil)"},
+		{[]string{"LREM", "list", "-1", "a"}, "1"},
+		{[]string{"LMOVE", "list", "other", "LEFT", "RIGHT"}, "a"},
+		{[]string{"RPOPLPUSH", "list", "other"}, "B"},
+		{[]string{"RPOPLPUSH", "missing", "other"}, "(nil)"},
+		{[]string{"LRANGE", "other", "0", "-1"}, "[B, a]"},
+	}
+	for _, step := range steps {
+		got, err := api.Execute(ctx, step.parts)
+		if err != nil || got != step.want {
+			t.Errorf("%v: got=%q err=%v, want=%q", step.parts, got, err, step.want)
+		}
+	}
+
+	// Scenario 2: Invalid arguments
+	for _, parts := range [][]string{
+		{"LSET", "list", "9", "x"},
+		{"LINSERT", "list", "NEAR", "x", "y"},
+		{"LPOS", "list", "x", "RANK", "0"},
+		{"LPOS", "list", "x", "COUNT"},
+		{"LMOVE", "list", "other", "LEFT"},
+	} {
+		if _, err := api.Execute(ctx, parts); err == nil {
+			t.Errorf("%v: expected an error", parts)
+		}
+	}
+}
+
+func TestCommandAPIHashCommands(t *testing.T) {
+	api, ctx := helperCreateAPI()
+
+	// Scenario 1: Each command's reply
+	steps := []struct {
+		parts []string
+		want  string
+	}{
+		{[]string{"HMSET", "user", "name", "Ann", "visits", "1"}, "OK"},
+		{[]string{"HMGET", "user", "name", "nope"}, "[Ann, (nil)]"},
+		{[]string{"HMGET", "missing", "name"}, "[(nil)]"},
+		{[]string{"HSETNX", "user", "name", "Bo"}, "0"},
+		{[]string{"HSETNX", "user", "city", "Oslo"}, "1"},
+		{[]string{"HINCRBY", "user", "visits", "4"}, "5"},
+		{[]string{"HINCRBYFLOAT", "user", "score", "2.5"}, "2.5"},
+		{[]string{"HKEYS", "user"}, "[city, name, score, visits]"},
+		{[]string{"HVALS", "user"}, "[Oslo, Ann, 2.5, 5]"},
+		{[]string{"HSTRLEN", "user", "city"}, "4"},
+		{[]string{"HKEYS", "missing"}, "(empty list)"},
+		{[]string{"HEXPIRE", "user", "100", "FIELDS", "2", "city", "nope"}, "[1, -2]"},
+		{[]string{"HPERSIST", "user", "FIELDS", "2", "city", "name"}, "[1, -1]"},
+		{[]string{"HTTL", "missing", "FIELDS", "1", "city"}, "[-2]"},
+	}
+	for _, step := range steps {
+		got, err := api.Execute(ctx, step.parts)
+		if err != nil || got != step.want {
+			t.Errorf("%v: got=%q err=%v, want=%q", step.parts, got, err, step.want)
+		}
+	}
+
+	// Scenario 2: Invalid arguments and values
+	for _, parts := range [][]string{
+		{"HMSET", "user", "name"},
+		{"HINCRBY", "user", "visits", "1.5"},
+		{"HINCRBY", "user", "name", "1"},
+		{"HINCRBYFLOAT", "user", "score", "nan"},
+		{"HEXPIRE", "user", "0", "FIELDS", "1", "city"},
+		{"HTTL", "user", "FIELDS", "2", "city"},
+		{"HPERSIST", "user", "city", "1", "name"},
+	} {
+		if _, err := api.Execute(ctx, parts); err == nil {
+			t.Errorf("%v: expected an error", parts)
+		}
+	}
+}
+
+// TestCommandAPIBlockingList checks BLPOP, BRPOP and BLMOVE, including their
+// timeout and their behavior inside MULTI.
+func TestCommandAPIBlockingList(t *testing.T) {
+	api, ctx := helperCreateAPI()
+	_, _ = api.Execute(ctx, []string{"RPUSH", "jobs", "j1"})
+	_, _ = api.Execute(ctx, []string{"RPUSH", "jobs", "j2"})
+
+	// Scenario 1: Elements are popped and moved right away
+	steps := []struct {
+		parts []string
+		want  string
+	}{
+		{[]string{"BLPOP", "none", "jobs", "0"}, "jobs: j1"},
+		{[]string{"BLMOVE", "jobs", "done", "LEFT", "RIGHT", "0"}, "j2"},
+		{[]string{"BRPOP", "done", "1.5"}, "done: j2"},
+		{[]string{"BRPOP", "jobs", "0.05"}, "(nil)"},
+	}
+	for _, step := range steps {
+		got, err := api.Execute(ctx, step.parts)
+		if err != nil || got != step.want {
+			t.Errorf("%v: got=%q err=%v, want=%q", step.parts, got, err, step.want)
+		}
+	}
+
+	// Scenario 2: Invalid arguments
+	if _, err := api.Execute(ctx, []string{"BLPOP", "jobs", "-1"}); err == nil {
+		t.Error("Expected an error for a negative timeout")
+	}
+	if _, err := api.Execute(ctx, []string{"BLMOVE", "jobs", "done", "UP", "LEFT", "0"}); err == nil {
+		t.Error("Expected an error for an invalid side")
+	}
+
+	// Scenario 3: Inside MULTI a blocking pop does not wait
+	for _, parts := range [][]string{{"MULTI"}, {"BLPOP", "jobs", "0"}} {
+		if _, err := api.Execute(ctx, parts); err != nil {
+			t.Fatalf("%v failed: %v", parts, err)
+		}
+	}
+	got, err := api.Execute(ctx, []string{"EXEC"})
+	if err != nil || !strings.Contains(got, "(nil)") {
+		t.Errorf("EXEC got=%q err=%v", got, err)
+	}
+}
+
 func TestCommandAPIExpireFind(t *testing.T) {
 	api, ctx := helperCreateAPI()
 
@@ -191,3 +373,259 @@ func TestCommandAPIUnknownQuit(t *testing.T) {
 		t.Errorf("Got=%q, want=%q", got, "Bye!")
 	}
 }
+
+func TestCommandAPISortedSet(t *testing.T) {
+	api, ctx := helperCreateAPI()
+
+	steps := []struct {
+		parts []string
+		want  string
+	}{
+		{[]string{"ZADD", "board", "10", "alice", "20", "bob", "15", "carol"}, "3"},
+		{[]string{"ZADD", "board", "XX", "CH", "25", "alice", "1", "dave"}, "1"},
+		{[]string{"ZADD", "board", "INCR", "5", "bob"}, "25"},
+		{[]string{"ZADD", "board", "NX", "INCR", "5", "bob"}, "(nil)"},
+		{[]string{"ZSCORE", "board", "alice"}, "25"},
+		{[]string{"ZRANK", "board", "carol"}, "0"},
+		{[]string{"ZREVRANK", "board", "carol"}, "2"},
+		{[]string{"ZRANGE", "board", "0", "-1", "WITHSCORES"}, "[carol, 15, alice, 25, bob, 25]"},
+		{[]string{"ZRANGE", "board", "+inf", "(15", "BYSCORE", "REV", "LIMIT", "0", "1"}, "[bob]"},
+		{[]string{"ZRANGEBYSCORE", "board", "-inf", "20"}, "[carol]"},
+		{[]string{"ZCOUNT", "board", "15", "25"}, "3"},
+		{[]string{"ZPOPMAX", "board"}, "[bob, 25]"},
+		{[]string{"ZREM", "board", "carol", "nobody"}, "1"},
+		{[]string{"ZCARD", "board"}, "1"},
+		{[]string{"TYPE", "board"}, "zset"},
+	}
+	for _, s := range steps {
+		got, err := api.Execute(ctx, s.parts)
+		if err != nil {
+			t.Fatalf("%v error: %v", s.parts, err)
+		}
+		if got != s.want {
+			t.Errorf("%v: Got=%q, want=%q", s.parts, got, s.want)
+		}
+	}
+
+	if _, err := api.Execute(ctx, []string{"ZADD", "board", "NX", "XX", "1", "x"}); err == nil {
+		t.Error("Expected error for NX together with XX")
+	}
+	if _, err := api.Execute(ctx, []string{"ZRANGE", "board", "0", "1", "LIMIT", "0", "1"}); err == nil {
+		t.Error("Expected error for LIMIT without BYSCORE or BYLEX")
+	}
+}
+
+// TestCommandAPIMultiExec checks MULTI/EXEC/DISCARD and WATCH through Execute.
+func TestCommandAPIMultiExec(t *testing.T) {
+	api, ctx := helperCreateAPI()
+
+	// Scenario 1: queued commands run on EXEC
+	steps := []struct {
+		parts []string
+		want  string
+	}{
+		{[]string{"MULTI"}, "OK"},
+		{[]string{"SET", "a", "1"}, "QUEUED"},
+		{[]string{"INCR", "n"}, "QUEUED"},
+		{[]string{"GET", "a"}, "QUEUED"},
+		{[]string{"EXEC"}, "1) OK\n2) 1\n3) \"1\""},
+		{[]string{"MULTI"}, "OK"},
+		{[]string{"SET", "a", "9"}, "QUEUED"},
+		{[]string{"DISCARD"}, "OK"},
+		{[]string{"GET", "a"}, "\"1\""},
+	}
+	for _, s := range steps {
+		got, err := api.Execute(ctx, s.parts)
+		if err != nil {
+			t.Fatalf("%v: unexpected error %v", s.parts, err)
+		}
+		if got != s.want {
+			t.Errorf("%v: expected %q, got %q", s.parts, s.want, got)
+		}
+	}
+
+	// Scenario 2: misuse is reported
+	if _, err := api.Execute(ctx, []string{"EXEC"}); err == nil {
+		t.Error("Expected error for EXEC without MULTI")
+	}
+	if _, err := api.Execute(ctx, []string{"DISCARD"}); err == nil {
+		t.Error("Expected error for DISCARD without MULTI")
+	}
+	api.Execute(ctx, []string{"MULTI"})
+	if _, err := api.Execute(ctx, []string{"MULTI"}); err == nil {
+		t.Error("Expected error for nested MULTI")
+	}
+	if _, err := api.Execute(ctx, []string{"WATCH", "a"}); err == nil {
+		t.Error("Expected error for WATCH inside MULTI")
+	}
+	api.Execute(ctx, []string{"DISCARD"})
+
+	// Scenario 3: EXEC returns (nil) when a watched key was changed by another client
+	other := NewCommandAPI(api.(*CommandAPI).db)
+	for _, parts := range [][]string{{"WATCH", "a"}, {"MULTI"}, {"SET", "a", "mine"}} {
+		if _, err := api.Execute(ctx, parts); err != nil {
+			t.Fatalf("%v: unexpected error %v", parts, err)
+		}
+	}
+	if _, err := other.Execute(ctx, []string{"SET", "a", "theirs"}); err != nil {
+		t.Fatalf("SET from other session failed: %v", err)
+	}
+	if got, _ := api.Execute(ctx, []string{"EXEC"}); got != "(nil)" {
+		t.Errorf("Expected (nil) from aborted EXEC, got %q", got)
+	}
+	if got, _ := api.Execute(ctx, []string{"GET", "a"}); got != "\"theirs\"" {
+		t.Errorf("Expected \"theirs\", got %q", got)
+	}
+}
+
+// TestCommandAPIStream checks the stream commands through Execute.
+func TestCommandAPIStream(t *testing.T) {
+	api, ctx := helperCreateAPI()
+
+	steps := []struct {
+		parts []string
+		want  string
+	}{
+		{[]string{"XADD", "jobs", "1-1", "task", "build"}, "1-1"},
+		{[]string{"XADD", "jobs", "1-*", "task", "test"}, "1-2"},
+		{[]string{"XADD", "jobs", "2-0", "task", "deploy", "env", "prod"}, "2-0"},
+		{[]string{"XLEN", "jobs"}, "3"},
+		{[]string{"XRANGE", "jobs", "-", "+", "COUNT", "2"}, "1-1 task=build\n1-2 task=test"},
+		{[]string{"XREVRANGE", "jobs", "+", "(1-2"}, "2-0 env=prod task=deploy"},
+		{[]string{"XREAD", "STREAMS", "jobs", "1-2"}, "jobs:\n2-0 env=prod task=deploy"},
+		{[]string{"XGROUP", "CREATE", "jobs", "ci", "0"}, "OK"},
+		{[]string{"XREADGROUP", "GROUP", "ci", "w1", "COUNT", "1", "STREAMS", "jobs", ">"}, "jobs:\n1-1 task=build"},
+		{[]string{"XREADGROUP", "GROUP", "ci", "w2", "STREAMS", "jobs", ">"}, "jobs:\n1-2 task=test\n2-0 env=prod task=deploy"},
+		{[]string{"XPENDING", "jobs", "ci"}, "3 pending, 1-1 .. 2-0\nw1: 1\nw2: 2"},
+		{[]string{"XACK", "jobs", "ci", "1-2"}, "1"},
+		{[]string{"XCLAIM", "jobs", "ci", "w1", "0", "2-0"}, "2-0 env=prod task=deploy"},
+		{[]string{"XTRIM", "jobs", "MAXLEN", "~", "1"}, "2"},
+		{[]string{"XREAD", "STREAMS", "jobs", "$"}, "(nil)"},
+		{[]string{"XGROUP", "DESTROY", "jobs", "ci"}, "1"},
+		{[]string{"TYPE", "jobs"}, "stream"},
+		{[]string{"XLEN", "missing"}, "0"},
+	}
+	for _, s := range steps {
+		got, err := api.Execute(ctx, s.parts)
+		if err != nil {
+			t.Fatalf("%v error: %v", s.parts, err)
+		}
+		if got != s.want {
+			t.Errorf("%v: Got=%q, want=%q", s.parts, got, s.want)
+		}
+	}
+
+	if _, err := api.Execute(ctx, []string{"XADD", "jobs", "1-0", "task", "old"}); !IsStreamIDTooSmall(err) {
+		t.Errorf("Expected ErrStreamIDTooSmall, got %v", err)
+	}
+	if _, err := api.Execute(ctx, []string{"XREAD", "STREAMS", "jobs"}); err == nil {
+		t.Error("Expected error for an unbalanced STREAMS list")
+	}
+	if _, err := api.Execute(ctx, []string{"XREADGROUP", "GROUP", "ci", "w1", "STREAMS", "jobs", ">"}); !IsGroupNotFound(err) {
+		t.Errorf("Expected ErrGroupNotFound, got %v", err)
+	}
+
+	// A blocking read inside MULTI does not wait, as in Redis.
+	api.Execute(ctx, []string{"MULTI"})
+	api.Execute(ctx, []string{"XREAD", "BLOCK", "0", "STREAMS", "jobs", "$"})
+	if got, err := api.Execute(ctx, []string{"EXEC"}); err != nil || !strings.Contains(got, "(nil)") {
+		t.Errorf("EXEC with XREAD BLOCK: Got=%q (err=%v)", got, err)
+	}
+}
+
+// TestCommandAPIPubSub checks PUBLISH, PUBSUB and session subscriptions.
+func TestCommandAPIPubSub(t *testing.T) {
+	api, ctx := helperCreateAPI()
+	other := NewCommandAPI(api.(*CommandAPI).db)
+
+	run := func(c contracts.CommandsHandler, parts ...string) string {
+		t.Helper()
+		got, err := c.Execute(ctx, parts)
+		if err != nil {
+			t.Fatalf("%v: unexpected error %v", parts, err)
+		}
+		return got
+	}
+
+	// Scenario 1: publishing without subscribers reaches nobody
+	if got := run(other, "PUBLISH", "news", "hi"); got != "0" {
+		t.Errorf("Expected 0 receivers, got %q", got)
+	}
+
+	// Scenario 2: subscriptions are confirmed with the session's count
+	if got := run(api, "SUBSCRIBE", "news", "sport"); got != "subscribe news 1\nsubscribe sport 2" {
+		t.Errorf("Unexpected SUBSCRIBE reply %q", got)
+	}
+	if got := run(api, "PSUBSCRIBE", "n*"); got != "psubscribe n* 3" {
+		t.Errorf("Unexpected PSUBSCRIBE reply %q", got)
+	}
+
+	// Scenario 3: a message reaches the channel and the pattern subscription
+	if got := run(other, "PUBLISH", "news", "hello"); got != "2" {
+		t.Errorf("Expected 2 receivers, got %q", got)
+	}
+	received := map[PubSubMessage]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-api.Messages():
+			received[msg] = true
+		case <-time.After(time.Second):
+			t.Fatal("Timeout waiting for message")
+		}
+	}
+	if !received[PubSubMessage{Channel: "news", Message: "hello"}] ||
+		!received[PubSubMessage{Pattern: "n*", Channel: "news", Message: "hello"}] {
+		t.Errorf("Unexpected messages %v", received)
+	}
+
+	// Scenario 4: introspection
+	if got := run(other, "PUBSUB", "CHANNELS", "n*"); got != "[news]" {
+		t.Errorf("Unexpected PUBSUB CHANNELS reply %q", got)
+	}
+	if got := run(other, "PUBSUB", "NUMSUB", "news", "none"); got != "[news, 1, none, 0]" {
+		t.Errorf("Unexpected PUBSUB NUMSUB reply %q", got)
+	}
+	if got := run(other, "PUBSUB", "NUMPAT"); got != "1" {
+		t.Errorf("Unexpected PUBSUB NUMPAT reply %q", got)
+	}
+
+	// Scenario 5: unsubscribing without arguments drops every channel
+	if got := run(api, "UNSUBSCRIBE"); got != "unsubscribe news 2\nunsubscribe sport 1" {
+		t.Errorf("Unexpected UNSUBSCRIBE reply %q", got)
+	}
+	run(api, "PUNSUBSCRIBE", "n*")
+	if got := run(other, "PUBLISH", "news", "bye"); got != "0" {
+		t.Errorf("Expected 0 receivers after unsubscribing, got %q", got)
+	}
+
+	// Scenario 6: messages a session cannot take are dropped by its small
+	// store subscription, where they are counted
+	const published = 2000
+	db := NewStore(Config{})
+	slow := NewCommandAPI(db).(*CommandAPI)
+	run(slow, "SUBSCRIBE", "firehose")
+	for i := 0; i < published; i++ {
+		db.Publish("firehose", "m")
+	}
+	stats := db.SubscriptionStats()
+	if len(stats) != 1 || stats[0].Capacity != sessionSubscriptionBuffer ||
+		stats[0].Delivered+stats[0].Dropped != published ||
+		stats[0].Dropped < published-sessionMessageBuffer-sessionSubscriptionBuffer-1 {
+		t.Errorf("Expected the drops to be counted, got %+v", stats)
+	}
+
+	// Scenario 7: closing the session ends its subscriptions
+	closed := make(chan struct{})
+	go func() {
+		slow.closeSession()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("closeSession blocked on a full session")
+	}
+	if stats := db.SubscriptionStats(); len(stats) != 0 {
+		t.Errorf("Expected no subscriptions after closing the session, got %+v", stats)
+	}
+}
@@ -1,21 +1,42 @@
 package hermes
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/themedef/go-hermes/internal/types"
+)
 
 var (
-	ErrKeyNotFound          = errors.New("key not found")
-	ErrKeyExpired           = errors.New("key expired")
-	ErrKeyExists            = errors.New("key already exists")
-	ErrInvalidType          = errors.New("invalid data type")
-	ErrValueMismatch        = errors.New("value mismatch")
-	ErrInvalidValueType     = errors.New("invalid value type")
-	ErrContextCanceled      = errors.New("operation canceled")
-	ErrInvalidTTL           = errors.New("invalid TTL value")
-	ErrEmptyList            = errors.New("list is empty")
-	ErrEmptyValues          = errors.New("empty value")
-	ErrInvalidKey           = errors.New("invalid key")
-	ErrTransactionNotActive = errors.New("transaction is not active")
-	ErrTransactionFailed    = errors.New("transaction failed")
+	ErrKeyNotFound           = errors.New("key not found")
+	ErrKeyExpired            = errors.New("key expired")
+	ErrKeyExists             = errors.New("key already exists")
+	ErrInvalidType           = errors.New("invalid data type")
+	ErrValueMismatch         = errors.New("value mismatch")
+	ErrInvalidValueType      = errors.New("invalid value type")
+	ErrContextCanceled       = errors.New("operation canceled")
+	ErrInvalidTTL            = errors.New("invalid TTL value")
+	ErrEmptyList             = errors.New("list is empty")
+	ErrIndexOutOfRange       = errors.New("index out of range")
+	ErrEmptyValues           = errors.New("empty value")
+	ErrInvalidKey            = errors.New("invalid key")
+	ErrTransactionNotActive  = errors.New("transaction is not active")
+	ErrTransactionFailed     = errors.New("transaction failed")
+	ErrInvalidSnapshot       = errors.New("invalid snapshot")
+	ErrUnsupportedValue      = errors.New("unsupported value type")
+	ErrInvalidAppendLog      = errors.New("invalid append-only log")
+	ErrAppendLogDisabled     = errors.New("append-only log is not enabled")
+	ErrRewriteInProgress     = errors.New("append-only log rewrite already in progress")
+	ErrInvalidScore          = errors.New("score is not a valid float")
+	ErrInvalidOptions        = errors.New("incompatible options")
+	ErrConditionNotMet       = errors.New("condition not met")
+	ErrTransactionConflict   = errors.New("transaction aborted: watched key changed")
+	ErrTransactionReadOnly   = errors.New("write in a read-only transaction")
+	ErrSavepointNotFound     = errors.New("savepoint not found")
+	ErrNestedTransactionOpen = errors.New("nested transaction still open")
+	ErrInvalidStreamID       = types.ErrInvalidStreamID
+	ErrStreamIDTooSmall      = errors.New("stream ID is not greater than the last one")
+	ErrGroupExists           = errors.New("consumer group already exists")
+	ErrGroupNotFound         = errors.New("consumer group not found")
 )
 
 func IsKeyNotFound(err error) bool {
@@ -54,6 +75,10 @@ func IsEmptyList(err error) bool {
 	return errors.Is(err, ErrEmptyList)
 }
 
+func IsIndexOutOfRange(err error) bool {
+	return errors.Is(err, ErrIndexOutOfRange)
+}
+
 func IsInvalidKey(err error) bool {
 	return errors.Is(err, ErrInvalidKey)
 }
@@ -65,3 +90,67 @@ func IsTransactionNotActive(err error) bool {
 func IsTransactionFailed(err error) bool {
 	return errors.Is(err, ErrTransactionFailed)
 }
+
+func IsInvalidSnapshot(err error) bool {
+	return errors.Is(err, ErrInvalidSnapshot)
+}
+
+func IsUnsupportedValue(err error) bool {
+	return errors.Is(err, ErrUnsupportedValue)
+}
+
+func IsInvalidAppendLog(err error) bool {
+	return errors.Is(err, ErrInvalidAppendLog)
+}
+
+func IsAppendLogDisabled(err error) bool {
+	return errors.Is(err, ErrAppendLogDisabled)
+}
+
+func IsRewriteInProgress(err error) bool {
+	return errors.Is(err, ErrRewriteInProgress)
+}
+
+func IsInvalidScore(err error) bool {
+	return errors.Is(err, ErrInvalidScore)
+}
+
+func IsInvalidOptions(err error) bool {
+	return errors.Is(err, ErrInvalidOptions)
+}
+
+func IsConditionNotMet(err error) bool {
+	return errors.Is(err, ErrConditionNotMet)
+}
+
+func IsTransactionConflict(err error) bool {
+	return errors.Is(err, ErrTransactionConflict)
+}
+
+func IsTransactionReadOnly(err error) bool {
+	return errors.Is(err, ErrTransactionReadOnly)
+}
+
+func IsSavepointNotFound(err error) bool {
+	return errors.Is(err, ErrSavepointNotFound)
+}
+
+func IsNestedTransactionOpen(err error) bool {
+	return errors.Is(err, ErrNestedTransactionOpen)
+}
+
+func IsInvalidStreamID(err error) bool {
+	return errors.Is(err, ErrInvalidStreamID)
+}
+
+func IsStreamIDTooSmall(err error) bool {
+	return errors.Is(err, ErrStreamIDTooSmall)
+}
+
+func IsGroupExists(err error) bool {
+	return errors.Is(err, ErrGroupExists)
+}
+
+func IsGroupNotFound(err error) bool {
+	return errors.Is(err, ErrGroupNotFound)
+}
@@ -0,0 +1,653 @@
+package hermes
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/themedef/go-hermes/internal/types"
+)
+
+// hashForRead returns the hash stored at key, leaving out the fields that have
+// expired, so the caller must not modify it. A hash whose fields have all
+// expired is ErrKeyNotFound. The caller holds the shard lock.
+func (db *DB) hashForRead(sh *shard, key, op string) (map[string]interface{}, error) {
+	entry, exists := sh.data[key]
+	if !exists || db.isExpired(entry) {
+		db.logger.Warn(op+" failed: key not found or expired", "key", key)
+		return nil, ErrKeyNotFound
+	}
+	hash, err := db.hashValue(entry, key, op)
+	if err != nil {
+		return nil, err
+	}
+	if db.fieldsMayHaveExpired(entry) {
+		if hash = db.liveFields(entry, hash); len(hash) == 0 {
+			db.logger.Warn(op+" failed: every field has expired", "key", key)
+			return nil, ErrKeyNotFound
+		}
+	}
+	return hash, nil
+}
+
+// hashForWrite is hashForRead for mutations: an expired key is dropped, the
+// expired fields are removed and, when create is set, a missing key yields a
+// new empty hash that is not yet stored.
+func (db *DB) hashForWrite(sh *shard, key, op string, create bool) (map[string]interface{}, types.Entry, error) {
+	entry, exists := sh.data[key]
+	if exists && db.isExpired(entry) {
+		db.removeExpired(sh, key, entry)
+		exists = false
+		db.logger.Info(op+" removed expired key", "key", key)
+	}
+	if exists && db.fieldsMayHaveExpired(entry) {
+		if entry, exists = db.removeExpiredFields(sh, key, entry); !exists {
+			db.logger.Info(op+" removed hash whose fields have all expired", "key", key)
+		}
+	}
+	if !exists {
+		if !create {
+			db.logger.Warn(op+" failed: key not found or expired", "key", key)
+			return nil, types.Entry{}, ErrKeyNotFound
+		}
+		hash := make(map[string]interface{})
+		return hash, types.Entry{Value: hash, Type: types.Hash}, nil
+	}
+	hash, err := db.hashValue(entry, key, op)
+	return hash, entry, err
+}
+
+// hashValue returns the hash held by entry, the entry of key.
+func (db *DB) hashValue(entry types.Entry, key, op string) (map[string]interface{}, error) {
+	if entry.Type != types.Hash {
+		db.logger.Error(op+" failed: existing key is not a hash", "key", key)
+		return nil, ErrInvalidType
+	}
+	hash, ok := entry.Value.(map[string]interface{})
+	if !ok {
+		db.logger.Error(op+" failed: stored value is not a valid hash", "key", key)
+		return nil, ErrInvalidType
+	}
+	return hash, nil
+}
+
+// fieldExpired reports whether field of the hash in entry has expired.
+func (db *DB) fieldExpired(entry types.Entry, field string) bool {
+	at, ok := entry.FieldExpirations[field]
+	return ok && db.now().After(at)
+}
+
+// fieldsMayHaveExpired reports whether a field of the hash in entry may have
+// expired, that is whether its earliest field expiration has passed.
+func (db *DB) fieldsMayHaveExpired(entry types.Entry) bool {
+	return len(entry.FieldExpirations) > 0 && db.now().After(entry.FirstFieldExpiration())
+}
+
+// allFieldsExpired reports whether every field of the hash in e has expired
+// at now. The fields are only scanned once the earliest of them has expired.
+func allFieldsExpired(e types.Entry, now time.Time) bool {
+	hash, ok := e.Value.(map[string]interface{})
+	if !ok || len(e.FieldExpirations) < len(hash) || !now.After(e.FirstFieldExpiration()) {
+		return false
+	}
+	for _, at := range e.FieldExpirations {
+		if !now.After(at) {
+			return false
+		}
+	}
+	return true
+}
+
+// liveFields returns hash, the value of entry, without its expired fields. It
+// only copies hash when a field has expired.
+func (db *DB) liveFields(entry types.Entry, hash map[string]interface{}) map[string]interface{} {
+	now := db.now()
+	expired := 0
+	for _, at := range entry.FieldExpirations {
+		if now.After(at) {
+			expired++
+		}
+	}
+	if expired == 0 {
+		return hash
+	}
+	live := make(map[string]interface{}, len(hash)-expired)
+	for field, value := range hash {
+		if at, ok := entry.FieldExpirations[field]; !ok || !now.After(at) {
+			live[field] = value
+		}
+	}
+	return live
+}
+
+// removeExpiredFields deletes the expired fields of the hash stored at key and
+// announces each of them, deleting the key when no field is left. Watches of
+// the key are invalidated. It returns
+// the updated entry and whether the key still exists. The caller holds the
+// shard's write lock.
+func (db *DB) removeExpiredFields(sh *shard, key string, entry types.Entry) (types.Entry, bool) {
+	hash, ok := entry.Value.(map[string]interface{})
+	if !ok {
+		return entry, true
+	}
+
+	now := db.now()
+	var removed []string
+	for field, at := range entry.FieldExpirations {
+		if !now.After(at) {
+			continue
+		}
+		old := hash[field]
+		delete(hash, field)
+		removed = append(removed, field)
+		db.notify(context.Background(), types.Event{Op: types.EventHExpired, Key: key, Field: field, Type: types.Hash, OldValue: old, Origin: types.OriginExpiry})
+	}
+	if len(removed) == 0 {
+		return entry, true
+	}
+	entry.ClearFieldExpiration(removed...)
+	// Expired fields are removed without a logged write, but watchers of the
+	// key must still see the change.
+	db.touch(opHDel, []interface{}{key})
+	if len(hash) == 0 {
+		delete(sh.data, key)
+		db.notify(context.Background(), types.Event{Op: types.EventDel, Key: key, Type: types.Hash, Origin: types.OriginExpiry})
+		return types.Entry{}, false
+	}
+	sh.data[key] = entry
+	return entry, true
+}
+
+// sortedFields returns the fields of hash in ascending order, which is the
+// order HKeys, HVals and the append-only log use.
+func sortedFields(hash map[string]interface{}) []string {
+	fields := make([]string, 0, len(hash))
+	for field := range hash {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// afterKeptFieldWrite records that field of the hash at key was set to value
+// without losing its expiration. An HSET record clears the expiration when
+// replayed, so it is recorded again after it.
+func (db *DB) afterKeptFieldWrite(key, field string, value interface{}, entry types.Entry) {
+	db.afterWrite(opHSet, key, field, value, unixNano(entry.Expiration))
+	if at, ok := entry.FieldExpirations[field]; ok {
+		db.afterWrite(opHExpireAt, key, unixNano(at), field)
+	}
+}
+
+// HMSet sets several fields of the hash at key at once, creating the hash if
+// needed, and returns how many of the fields are new. The key's expiration is
+// left unchanged; the fields set lose theirs, as with HSet.
+func (db *DB) HMSet(ctx context.Context, key string, fields map[string]interface{}) (int, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("HMSet operation canceled", "key", key)
+		return 0, ErrContextCanceled
+	default:
+	}
+
+	if len(fields) == 0 {
+		db.logger.Warn("HMSet called with no fields", "key", key)
+		return 0, ErrEmptyValues
+	}
+
+	if err := db.checkLoggable("HMSet", key, fields); err != nil {
+		return 0, err
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.lockShard(ctx, sh)()
+
+	hash, entry, err := db.hashForWrite(sh, key, "HMSet", true)
+	if err != nil {
+		return 0, err
+	}
+
+	names := sortedFields(fields)
+	args := make([]interface{}, 0, 1+2*len(names))
+	args = append(args, key)
+	oldValues := make([]interface{}, len(names))
+	added := 0
+	for i, field := range names {
+		old, exists := hash[field]
+		if !exists {
+			added++
+		}
+		oldValues[i] = old
+		hash[field] = fields[field]
+		entry.ClearFieldExpiration(field)
+		args = append(args, field, fields[field])
+	}
+	sh.data[key] = entry
+
+	db.afterWrite(opHMSet, args...)
+	for i, field := range names {
+		db.notify(ctx, types.Event{Op: types.EventHSet, Key: key, Field: field, Type: types.Hash, OldValue: oldValues[i], NewValue: fields[field], TTL: ttlLeft(entry.Expiration)})
+	}
+	db.logger.Info("HMSet operation successful", "key", key, "fields", len(names), "added", added)
+	return added, nil
+}
+
+// HMGet returns the values of fields in the hash at key, nil for the fields
+// the hash does not have.
+func (db *DB) HMGet(ctx context.Context, key string, fields ...string) ([]interface{}, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("HMGet operation canceled", "key", key)
+		return nil, ErrContextCanceled
+	default:
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.rlockShard(ctx, sh)()
+
+	hash, err := db.hashForRead(sh, key, "HMGet")
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(fields))
+	for i, field := range fields {
+		values[i] = hash[field]
+	}
+
+	db.logger.Info("HMGet operation successful", "key", key, "fields", len(fields))
+	return values, nil
+}
+
+// HSetNX sets field in the hash at key only if the field does not exist yet,
+// creating the hash if needed, and reports whether it did.
+func (db *DB) HSetNX(ctx context.Context, key, field string, value interface{}) (bool, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("HSetNX operation canceled", "key", key)
+		return false, ErrContextCanceled
+	default:
+	}
+
+	if err := db.checkLoggable("HSetNX", key, value); err != nil {
+		return false, err
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.lockShard(ctx, sh)()
+
+	hash, entry, err := db.hashForWrite(sh, key, "HSetNX", true)
+	if err != nil {
+		return false, err
+	}
+	if _, exists := hash[field]; exists {
+		db.logger.Info("HSetNX skipped: field already exists", "key", key, "field", field)
+		return false, nil
+	}
+
+	hash[field] = value
+	sh.data[key] = entry
+	db.afterWrite(opHSet, key, field, value, unixNano(entry.Expiration))
+	db.notify(ctx, types.Event{Op: types.EventHSet, Key: key, Field: field, Type: types.Hash, NewValue: value, TTL: ttlLeft(entry.Expiration)})
+	db.logger.Info("HSetNX operation successful", "key", key, "field", field, "value", value)
+	return true, nil
+}
+
+// HIncrBy adds increment to the integer in field of the hash at key and returns
+// the result, creating the hash and the field, from 0, if needed. The field
+// must hold an int64, a string in base 10 as written by HSET over the wire, or
+// a whole float64 as decoded from JSON; anything else, or a result that
+// overflows, is ErrInvalidValueType. The result is stored as an int64 and the
+// field keeps its expiration.
+func (db *DB) HIncrBy(ctx context.Context, key, field string, increment int64) (int64, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("HIncrBy operation canceled", "key", key)
+		return 0, ErrContextCanceled
+	default:
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.lockShard(ctx, sh)()
+
+	hash, entry, err := db.hashForWrite(sh, key, "HIncrBy", true)
+	if err != nil {
+		return 0, err
+	}
+
+	var current int64
+	switch v := hash[field].(type) {
+	case nil:
+	case int64:
+		current = v
+	case float64:
+		if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			db.logger.Error("HIncrBy failed: field is not an integer", "key", key, "field", field)
+			return 0, ErrInvalidValueType
+		}
+		current = int64(v)
+	case string:
+		if current, err = strconv.ParseInt(v, 10, 64); err != nil {
+			db.logger.Error("HIncrBy failed: field is not an integer", "key", key, "field", field)
+			return 0, ErrInvalidValueType
+		}
+	default:
+		db.logger.Error("HIncrBy failed: field is not an integer", "key", key, "field", field)
+		return 0, ErrInvalidValueType
+	}
+	if (increment > 0 && current > math.MaxInt64-increment) || (increment < 0 && current < math.MinInt64-increment) {
+		db.logger.Error("HIncrBy failed: increment would overflow", "key", key, "field", field)
+		return 0, ErrInvalidValueType
+	}
+
+	old := hash[field]
+	result := current + increment
+	hash[field] = result
+	sh.data[key] = entry
+	db.afterKeptFieldWrite(key, field, result, entry)
+	db.notify(ctx, types.Event{Op: types.EventHIncrBy, Key: key, Field: field, Type: types.Hash, OldValue: old, NewValue: result, TTL: ttlLeft(entry.Expiration)})
+	db.logger.Info("HIncrBy operation successful", "key", key, "field", field, "newValue", result)
+	return result, nil
+}
+
+// HIncrByFloat is HIncrBy for floats. The field must hold a float64, an int64
+// or a string holding a number; the result must be finite and is stored as a
+// float64.
+func (db *DB) HIncrByFloat(ctx context.Context, key, field string, increment float64) (float64, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("HIncrByFloat operation canceled", "key", key)
+		return 0, ErrContextCanceled
+	default:
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.lockShard(ctx, sh)()
+
+	hash, entry, err := db.hashForWrite(sh, key, "HIncrByFloat", true)
+	if err != nil {
+		return 0, err
+	}
+
+	var current float64
+	switch v := hash[field].(type) {
+	case nil:
+	case float64:
+		current = v
+	case int64:
+		current = float64(v)
+	case string:
+		if current, err = strconv.ParseFloat(v, 64); err != nil {
+			db.logger.Error("HIncrByFloat failed: field is not a float", "key", key, "field", field)
+			return 0, ErrInvalidValueType
+		}
+	default:
+		db.logger.Error("HIncrByFloat failed: field is not a float", "key", key, "field", field)
+		return 0, ErrInvalidValueType
+	}
+	result := current + increment
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		db.logger.Error("HIncrByFloat failed: increment would produce NaN or Infinity", "key", key, "field", field)
+		return 0, ErrInvalidValueType
+	}
+
+	old := hash[field]
+	hash[field] = result
+	sh.data[key] = entry
+	db.afterKeptFieldWrite(key, field, result, entry)
+	db.notify(ctx, types.Event{Op: types.EventHIncrByFloat, Key: key, Field: field, Type: types.Hash, OldValue: old, NewValue: result, TTL: ttlLeft(entry.Expiration)})
+	db.logger.Info("HIncrByFloat operation successful", "key", key, "field", field, "newValue", result)
+	return result, nil
+}
+
+// HKeys returns the fields of the hash at key in ascending order.
+func (db *DB) HKeys(ctx context.Context, key string) ([]string, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("HKeys operation canceled", "key", key)
+		return nil, ErrContextCanceled
+	default:
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.rlockShard(ctx, sh)()
+
+	hash, err := db.hashForRead(sh, key, "HKeys")
+	if err != nil {
+		return nil, err
+	}
+	fields := sortedFields(hash)
+
+	db.logger.Info("HKeys operation successful", "key", key, "fieldsCount", len(fields))
+	return fields, nil
+}
+
+// HVals returns the values of the hash at key, in the order HKeys returns
+// their fields.
+func (db *DB) HVals(ctx context.Context, key string) ([]interface{}, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("HVals operation canceled", "key", key)
+		return nil, ErrContextCanceled
+	default:
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.rlockShard(ctx, sh)()
+
+	hash, err := db.hashForRead(sh, key, "HVals")
+	if err != nil {
+		return nil, err
+	}
+	fields := sortedFields(hash)
+	values := make([]interface{}, len(fields))
+	for i, field := range fields {
+		values[i] = hash[field]
+	}
+
+	db.logger.Info("HVals operation successful", "key", key, "fieldsCount", len(values))
+	return values, nil
+}
+
+// HStrLen returns the length of the value in field of the hash at key, as HGET
+// replies with it, or 0 if the hash does not have the field.
+func (db *DB) HStrLen(ctx context.Context, key, field string) (int, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("HStrLen operation canceled", "key", key)
+		return 0, ErrContextCanceled
+	default:
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.rlockShard(ctx, sh)()
+
+	hash, err := db.hashForRead(sh, key, "HStrLen")
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	switch v := hash[field].(type) {
+	case nil:
+	case string:
+		n = len(v)
+	case []byte:
+		n = len(v)
+	default:
+		n = len(fmt.Sprint(v))
+	}
+
+	db.logger.Info("HStrLen operation successful", "key", key, "field", field, "length", n)
+	return n, nil
+}
+
+// HExpire sets the expiration of fields of the hash at key to ttl seconds from
+// now. Expired fields are removed lazily, as expired keys are, and the hash is
+// removed with its last field. The result holds, for each field, 1 if its
+// expiration was set or -2 if the hash has no such field, which is every
+// field when the key does not exist. ttl must be positive; HPersist removes an
+// expiration.
+func (db *DB) HExpire(ctx context.Context, key string, ttl int, fields ...string) ([]int, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("HExpire operation canceled", "key", key)
+		return nil, ErrContextCanceled
+	default:
+	}
+
+	if ttl <= 0 {
+		db.logger.Error("invalid TTL value in HExpire", "key", key, "ttl", ttl)
+		return nil, ErrInvalidTTL
+	}
+	if len(fields) == 0 {
+		db.logger.Warn("HExpire called with no fields", "key", key)
+		return nil, ErrEmptyValues
+	}
+	expiration, err := ttlSecondsToTime(ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.lockShard(ctx, sh)()
+
+	hash, entry, err := db.hashForWrite(sh, key, "HExpire", false)
+	if err == ErrKeyNotFound {
+		return missingFields(len(fields)), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	results := make([]int, len(fields))
+	var set []interface{}
+	for i, field := range fields {
+		if _, ok := hash[field]; !ok {
+			results[i] = -2
+			continue
+		}
+		entry.SetFieldExpiration(field, expiration)
+		results[i] = 1
+		set = append(set, field)
+	}
+	if len(set) == 0 {
+		return results, nil
+	}
+
+	sh.data[key] = entry
+	db.afterWrite(opHExpireAt, append([]interface{}{key, unixNano(expiration)}, set...)...)
+	for _, field := range set {
+		db.notify(ctx, types.Event{Op: types.EventHExpire, Key: key, Field: field.(string), Type: types.Hash, NewValue: expiration, TTL: ttlLeft(entry.Expiration)})
+	}
+	db.logger.Info("HExpire operation successful", "key", key, "fields", len(set), "ttl", ttl)
+	return results, nil
+}
+
+// HTTL returns, for each of fields of the hash at key, the seconds it has left,
+// -1 if it does not expire or -2 if the hash has no such field or the key does
+// not exist.
+func (db *DB) HTTL(ctx context.Context, key string, fields ...string) ([]int, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("HTTL operation canceled", "key", key)
+		return nil, ErrContextCanceled
+	default:
+	}
+
+	if len(fields) == 0 {
+		db.logger.Warn("HTTL called with no fields", "key", key)
+		return nil, ErrEmptyValues
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.rlockShard(ctx, sh)()
+
+	hash, err := db.hashForRead(sh, key, "HTTL")
+	if err == ErrKeyNotFound {
+		return missingFields(len(fields)), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	expirations := sh.data[key].FieldExpirations
+	now := db.now()
+	results := make([]int, len(fields))
+	for i, field := range fields {
+		if _, ok := hash[field]; !ok {
+			results[i] = -2
+		} else if at, ok := expirations[field]; ok {
+			// Round up as Redis does, so HEXPIRE 10 reads back as 10.
+			results[i] = int((at.Sub(now) + time.Second - 1) / time.Second)
+		} else {
+			results[i] = -1
+		}
+	}
+	db.logger.Info("HTTL operation successful", "key", key, "fields", len(fields))
+	return results, nil
+}
+
+// HPersist removes the expiration of fields of the hash at key. The result
+// holds, for each field, 1 if its expiration was removed, -1 if it had none or
+// -2 if the hash has no such field or the key does not exist.
+func (db *DB) HPersist(ctx context.Context, key string, fields ...string) ([]int, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("HPersist operation canceled", "key", key)
+		return nil, ErrContextCanceled
+	default:
+	}
+
+	if len(fields) == 0 {
+		db.logger.Warn("HPersist called with no fields", "key", key)
+		return nil, ErrEmptyValues
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.lockShard(ctx, sh)()
+
+	hash, entry, err := db.hashForWrite(sh, key, "HPersist", false)
+	if err == ErrKeyNotFound {
+		return missingFields(len(fields)), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	results := make([]int, len(fields))
+	var persisted []interface{}
+	for i, field := range fields {
+		if _, ok := hash[field]; !ok {
+			results[i] = -2
+			continue
+		}
+		if _, ok := entry.FieldExpirations[field]; !ok {
+			results[i] = -1
+			continue
+		}
+		entry.ClearFieldExpiration(field)
+		results[i] = 1
+		persisted = append(persisted, field)
+	}
+	if len(persisted) == 0 {
+		return results, nil
+	}
+
+	sh.data[key] = entry
+	db.afterWrite(opHExpireAt, append([]interface{}{key, int64(0)}, persisted...)...)
+	for _, field := range persisted {
+		db.notify(ctx, types.Event{Op: types.EventHPersist, Key: key, Field: field.(string), Type: types.Hash, TTL: ttlLeft(entry.Expiration)})
+	}
+	db.logger.Info("HPersist operation successful", "key", key, "fields", len(persisted))
+	return results, nil
+}
+
+// missingFields is the result of HExpire, HTTL and HPersist for a key that
+// does not exist: -2 for each of n fields.
+func missingFields(n int) []int {
+	results := make([]int, n)
+	for i := range results {
+		results[i] = -2
+	}
+	return results
+}
@@ -0,0 +1,200 @@
+package hermes
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/themedef/go-hermes/internal/resp"
+)
+
+// parseHashFields parses the field value pairs of HSET and HMSET.
+func parseHashFields(args []string) map[string]interface{} {
+	fields := make(map[string]interface{}, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		fields[args[i]] = args[i+1]
+	}
+	return fields
+}
+
+func parseHashIncrement(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("value is not a valid float")
+	}
+	return f, nil
+}
+
+func (c *CommandAPI) hmget(ctx context.Context, args []string) ([]interface{}, error) {
+	values, err := c.db.HMGet(ctx, args[0], args[1:]...)
+	if IsKeyNotFound(err) {
+		return make([]interface{}, len(args)-1), nil
+	}
+	return values, err
+}
+
+func (c *CommandAPI) hstrlen(ctx context.Context, args []string) (int, error) {
+	n, err := c.db.HStrLen(ctx, args[0], args[1])
+	if IsKeyNotFound(err) {
+		return 0, nil
+	}
+	return n, err
+}
+
+// parseFieldsArg parses the FIELDS numfields field [field ...] arguments of
+// HEXPIRE, HTTL and HPERSIST.
+func parseFieldsArg(args []string) ([]string, error) {
+	if len(args) < 3 || !strings.EqualFold(args[0], "FIELDS") {
+		return nil, fmt.Errorf("mandatory argument FIELDS is missing or not at the right position")
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 1 || n != len(args)-2 {
+		return nil, fmt.Errorf("the numfields parameter must match the number of arguments")
+	}
+	return args[2:], nil
+}
+
+// hashFieldTTL runs HEXPIRE (with a ttl), HTTL or HPERSIST on args, the
+// arguments following the key and the ttl.
+func (c *CommandAPI) hashFieldTTL(ctx context.Context, cmd, key string, ttl int, args []string) ([]int, error) {
+	fields, err := parseFieldsArg(args)
+	if err != nil {
+		return nil, err
+	}
+	switch cmd {
+	case "HEXPIRE":
+		return c.db.HExpire(ctx, key, ttl, fields...)
+	case "HTTL":
+		return c.db.HTTL(ctx, key, fields...)
+	default:
+		return c.db.HPersist(ctx, key, fields...)
+	}
+}
+
+// runHash handles the hash commands beyond HSET, HGET, HDEL, HGETALL, HEXISTS
+// and HLEN.
+func (c *CommandAPI) runHash(ctx context.Context, cmd string, args []string) (interface{}, error) {
+	switch cmd {
+	case "HMSET":
+		if len(args) < 3 || len(args)%2 != 1 {
+			return nil, respWrongArgs(cmd)
+		}
+		if _, err := c.db.HMSet(ctx, args[0], parseHashFields(args[1:])); err != nil {
+			return nil, err
+		}
+		return resp.SimpleString("OK"), nil
+
+	case "HMGET":
+		if len(args) < 2 {
+			return nil, respWrongArgs(cmd)
+		}
+		values, err := c.hmget(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+		return respBulks(values), nil
+
+	case "HSETNX":
+		if len(args) != 3 {
+			return nil, respWrongArgs(cmd)
+		}
+		ok, err := c.db.HSetNX(ctx, args[0], args[1], args[2])
+		if err != nil {
+			return nil, err
+		}
+		return respBool(ok), nil
+
+	case "HINCRBY":
+		if len(args) != 3 {
+			return nil, respWrongArgs(cmd)
+		}
+		inc, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return nil, respErrNotInt
+		}
+		n, err := c.db.HIncrBy(ctx, args[0], args[1], inc)
+		if IsInvalidValueType(err) {
+			return nil, resp.Error("ERR hash value is not an integer or the increment would overflow")
+		}
+		if err != nil {
+			return nil, err
+		}
+		return n, nil
+
+	case "HINCRBYFLOAT":
+		if len(args) != 3 {
+			return nil, respWrongArgs(cmd)
+		}
+		inc, err := parseHashIncrement(args[2])
+		if err != nil {
+			return nil, err
+		}
+		f, err := c.db.HIncrByFloat(ctx, args[0], args[1], inc)
+		if IsInvalidValueType(err) {
+			return nil, resp.Error("ERR hash value is not a float or the increment would produce NaN or Infinity")
+		}
+		if err != nil {
+			return nil, err
+		}
+		return formatScore(f), nil
+
+	case "HKEYS":
+		if len(args) != 1 {
+			return nil, respWrongArgs(cmd)
+		}
+		fields, err := c.db.HKeys(ctx, args[0])
+		if err != nil && !IsKeyNotFound(err) {
+			return nil, err
+		}
+		out := make([]interface{}, len(fields))
+		for i, field := range fields {
+			out[i] = field
+		}
+		return out, nil
+
+	case "HVALS":
+		if len(args) != 1 {
+			return nil, respWrongArgs(cmd)
+		}
+		values, err := c.db.HVals(ctx, args[0])
+		if err != nil && !IsKeyNotFound(err) {
+			return nil, err
+		}
+		return respBulks(values), nil
+
+	case "HSTRLEN":
+		if len(args) != 2 {
+			return nil, respWrongArgs(cmd)
+		}
+		n, err := c.hstrlen(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+		return int64(n), nil
+
+	case "HEXPIRE", "HTTL", "HPERSIST":
+		if len(args) < 4 || (cmd == "HEXPIRE" && len(args) < 5) {
+			return nil, respWrongArgs(cmd)
+		}
+		ttl, rest := 0, args[1:]
+		if cmd == "HEXPIRE" {
+			var err error
+			if ttl, err = strconv.Atoi(args[1]); err != nil {
+				return nil, respErrNotInt
+			}
+			rest = args[2:]
+		}
+		results, err := c.hashFieldTTL(ctx, cmd, args[0], ttl, rest)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]interface{}, len(results))
+		for i, r := range results {
+			out[i] = int64(r)
+		}
+		return out, nil
+	}
+	return nil, errUnknownCommand(cmd)
+}
@@ -0,0 +1,262 @@
+package hermes
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/themedef/go-hermes/internal/types"
+)
+
+// TestStoreHMSetHMGet checks setting and reading several fields at once.
+func TestStoreHMSetHMGet(t *testing.T) {
+	db := withTestStore(t)
+	ctx := context.Background()
+
+	// Scenario 1: New and existing fields
+	if added, err := db.HMSet(ctx, "user", map[string]interface{}{"name": "Ann", "age": int64(30)}); err != nil || added != 2 {
+		t.Fatalf("HMSet = %d (err=%v)", added, err)
+	}
+	if added, err := db.HMSet(ctx, "user", map[string]interface{}{"name": "Bo", "city": "Oslo"}); err != nil || added != 1 {
+		t.Fatalf("HMSet with an existing field = %d (err=%v)", added, err)
+	}
+	values, err := db.HMGet(ctx, "user", "name", "missing", "city")
+	if err != nil || !reflect.DeepEqual(values, []interface{}{"Bo", nil, "Oslo"}) {
+		t.Errorf("HMGet = %v (err=%v)", values, err)
+	}
+
+	// Scenario 2: Errors
+	if _, err := db.HMSet(ctx, "user", nil); err != ErrEmptyValues {
+		t.Errorf("Expected ErrEmptyValues, got %v", err)
+	}
+	if _, err := db.HMGet(ctx, "missing", "name"); !IsKeyNotFound(err) {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+	_ = db.Set(ctx, "str", "x", 0)
+	if _, err := db.HMSet(ctx, "str", map[string]interface{}{"f": "v"}); !IsInvalidType(err) {
+		t.Errorf("Expected ErrInvalidType, got %v", err)
+	}
+}
+
+// TestStoreHSetNX checks that HSetNX only creates fields.
+func TestStoreHSetNX(t *testing.T) {
+	db := withTestStore(t)
+	ctx := context.Background()
+
+	if ok, err := db.HSetNX(ctx, "h", "f", "v1"); err != nil || !ok {
+		t.Fatalf("HSetNX on a new hash = %v (err=%v)", ok, err)
+	}
+	if ok, err := db.HSetNX(ctx, "h", "f", "v2"); err != nil || ok {
+		t.Fatalf("HSetNX on an existing field = %v (err=%v)", ok, err)
+	}
+	if val, _ := db.HGet(ctx, "h", "f"); val != "v1" {
+		t.Errorf("Expected v1, got %v", val)
+	}
+}
+
+// TestStoreHIncrBy checks integer and float field increments, including
+// values written as strings and invalid values.
+func TestStoreHIncrBy(t *testing.T) {
+	db := withTestStore(t)
+	ctx := context.Background()
+
+	// Scenario 1: Counting from a missing field and from a string
+	if n, err := db.HIncrBy(ctx, "stats", "visits", 5); err != nil || n != 5 {
+		t.Errorf("HIncrBy on a missing field = %d (err=%v)", n, err)
+	}
+	_ = db.HSet(ctx, "stats", "wire", "10", 0)
+	if n, err := db.HIncrBy(ctx, "stats", "wire", -3); err != nil || n != 7 {
+		t.Errorf("HIncrBy on a string = %d (err=%v)", n, err)
+	}
+	if val, _ := db.HGet(ctx, "stats", "wire"); val != int64(7) {
+		t.Errorf("Expected int64(7) stored, got %#v", val)
+	}
+
+	// Scenario 2: Floats
+	if f, err := db.HIncrByFloat(ctx, "stats", "ratio", 0.5); err != nil || f != 0.5 {
+		t.Errorf("HIncrByFloat on a missing field = %v (err=%v)", f, err)
+	}
+	if f, err := db.HIncrByFloat(ctx, "stats", "visits", 1.5); err != nil || f != 6.5 {
+		t.Errorf("HIncrByFloat on an integer = %v (err=%v)", f, err)
+	}
+
+	// Scenario 3: Invalid values and overflow
+	_ = db.HSet(ctx, "stats", "name", "abc", 0)
+	if _, err := db.HIncrBy(ctx, "stats", "name", 1); !IsInvalidValueType(err) {
+		t.Errorf("Expected ErrInvalidValueType, got %v", err)
+	}
+	if _, err := db.HIncrBy(ctx, "stats", "ratio", 1); !IsInvalidValueType(err) {
+		t.Errorf("Expected ErrInvalidValueType for a fractional value, got %v", err)
+	}
+	_ = db.HSet(ctx, "stats", "big", int64(math.MaxInt64), 0)
+	if _, err := db.HIncrBy(ctx, "stats", "big", 1); !IsInvalidValueType(err) {
+		t.Errorf("Expected ErrInvalidValueType on overflow, got %v", err)
+	}
+	if _, err := db.HIncrByFloat(ctx, "stats", "ratio", math.Inf(1)); !IsInvalidValueType(err) {
+		t.Errorf("Expected ErrInvalidValueType for an infinite result, got %v", err)
+	}
+}
+
+// TestStoreHKeysHValsHStrLen checks listing a hash and measuring its values.
+func TestStoreHKeysHValsHStrLen(t *testing.T) {
+	db := withTestStore(t)
+	ctx := context.Background()
+	_, _ = db.HMSet(ctx, "h", map[string]interface{}{"b": "two", "a": "one", "c": int64(300)})
+
+	if fields, err := db.HKeys(ctx, "h"); err != nil || !reflect.DeepEqual(fields, []string{"a", "b", "c"}) {
+		t.Errorf("HKeys = %v (err=%v)", fields, err)
+	}
+	if values, err := db.HVals(ctx, "h"); err != nil || !reflect.DeepEqual(values, []interface{}{"one", "two", int64(300)}) {
+		t.Errorf("HVals = %v (err=%v)", values, err)
+	}
+	for field, want := range map[string]int{"a": 3, "c": 3, "missing": 0} {
+		if n, err := db.HStrLen(ctx, "h", field); err != nil || n != want {
+			t.Errorf("HStrLen %s = %d (err=%v), want %d", field, n, err, want)
+		}
+	}
+	if _, err := db.HKeys(ctx, "missing"); !IsKeyNotFound(err) {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+// TestStoreHashFieldTTL checks HExpire, HTTL and HPersist and how writes treat
+// field expirations.
+func TestStoreHashFieldTTL(t *testing.T) {
+	db := withTestStore(t)
+	ctx := context.Background()
+
+	_, _ = db.HMSet(ctx, "session", map[string]interface{}{"a": "1", "b": "2", "n": int64(0)})
+
+	// Scenario 1: Per-field results
+	if res, err := db.HExpire(ctx, "session", 100, "a", "missing"); err != nil || !reflect.DeepEqual(res, []int{1, -2}) {
+		t.Fatalf("HExpire = %v (err=%v)", res, err)
+	}
+	ttls, err := db.HTTL(ctx, "session", "a", "b", "missing")
+	if err != nil || !reflect.DeepEqual(ttls, []int{100, -1, -2}) {
+		t.Errorf("HTTL = %v (err=%v)", ttls, err)
+	}
+	if _, ttl, _ := db.GetWithDetails(ctx, "session"); ttl != -1 {
+		t.Errorf("HExpire should not set the key TTL, got %d", ttl)
+	}
+	if res, err := db.HPersist(ctx, "session", "a", "b", "missing"); err != nil || !reflect.DeepEqual(res, []int{1, -1, -2}) {
+		t.Errorf("HPersist = %v (err=%v)", res, err)
+	}
+
+	// Scenario 2: HSet clears the expiration, HIncrBy keeps it
+	_, _ = db.HExpire(ctx, "session", 100, "a", "n")
+	_ = db.HSet(ctx, "session", "a", "3", 0)
+	_, _ = db.HIncrBy(ctx, "session", "n", 1)
+	if ttls, _ := db.HTTL(ctx, "session", "a", "n"); len(ttls) != 2 || ttls[0] != -1 || ttls[1] <= 0 {
+		t.Errorf("Expected [-1 >0] after HSet and HIncrBy, got %v", ttls)
+	}
+
+	// Scenario 3: Errors
+	if _, err := db.HExpire(ctx, "session", 0, "a"); !IsInvalidTTL(err) {
+		t.Errorf("Expected ErrInvalidTTL, got %v", err)
+	}
+	if _, err := db.HTTL(ctx, "session"); err != ErrEmptyValues {
+		t.Errorf("Expected ErrEmptyValues, got %v", err)
+	}
+
+	// Scenario 4: A missing key has none of the fields
+	if res, err := db.HExpire(ctx, "missing", 100, "a", "b"); err != nil || !reflect.DeepEqual(res, []int{-2, -2}) {
+		t.Errorf("HExpire on a missing key = %v (err=%v)", res, err)
+	}
+	if res, err := db.HTTL(ctx, "missing", "a"); err != nil || !reflect.DeepEqual(res, []int{-2}) {
+		t.Errorf("HTTL on a missing key = %v (err=%v)", res, err)
+	}
+	if res, err := db.HPersist(ctx, "missing", "a"); err != nil || !reflect.DeepEqual(res, []int{-2}) {
+		t.Errorf("HPersist on a missing key = %v (err=%v)", res, err)
+	}
+}
+
+// TestStoreHashFieldExpiry checks that expired fields are hidden from reads,
+// removed by the next write, and take the key with them when none is left.
+func TestStoreHashFieldExpiry(t *testing.T) {
+	db := withTestStore(t)
+	ctx := context.Background()
+
+	past, future := time.Now().Add(-time.Second), time.Now().Add(time.Hour)
+	_ = db.RestoreRawEntry(ctx, "tokens", types.Entry{
+		Value:            map[string]interface{}{"old": "x", "new": "y", "keep": "z"},
+		Type:             types.Hash,
+		FieldExpirations: map[string]time.Time{"old": past, "new": future},
+	})
+
+	// Scenario 1: Reads skip the expired field
+	if hash, err := db.HGetAll(ctx, "tokens"); err != nil || !reflect.DeepEqual(hash, map[string]interface{}{"new": "y", "keep": "z"}) {
+		t.Errorf("HGetAll = %v (err=%v)", hash, err)
+	}
+	if n, _ := db.HLen(ctx, "tokens"); n != 2 {
+		t.Errorf("Expected HLen 2, got %d", n)
+	}
+	if ok, _ := db.HExists(ctx, "tokens", "old"); ok {
+		t.Error("Expired field should not exist")
+	}
+	if _, err := db.HGet(ctx, "tokens", "old"); !IsKeyNotFound(err) {
+		t.Errorf("Expected ErrKeyNotFound for an expired field, got %v", err)
+	}
+
+	// Scenario 2: A write removes it
+	_ = db.HSet(ctx, "tokens", "keep", "w", 0)
+	raw, _ := db.GetRawEntry(ctx, "tokens")
+	if _, ok := raw.Value.(map[string]interface{})["old"]; ok {
+		t.Error("Expired field should be removed by a write")
+	}
+	if !reflect.DeepEqual(raw.FieldExpirations, map[string]time.Time{"new": future}) {
+		t.Errorf("Unexpected field expirations %v", raw.FieldExpirations)
+	}
+
+	// Scenario 3: A hash whose fields have all expired is gone
+	_ = db.RestoreRawEntry(ctx, "gone", types.Entry{
+		Value:            map[string]interface{}{"f": "v"},
+		Type:             types.Hash,
+		FieldExpirations: map[string]time.Time{"f": past},
+	})
+	if exists, _ := db.Exists(ctx, "gone"); exists {
+		t.Error("Hash with only expired fields should not exist")
+	}
+	if ok, err := db.HSetNX(ctx, "gone", "f", "new"); err != nil || !ok {
+		t.Errorf("HSetNX on an expired field = %v (err=%v)", ok, err)
+	}
+}
+
+// TestStoreHashFieldCleanup checks that the background cleanup removes
+// expired fields of hashes nobody accesses, invalidating watches of them.
+func TestStoreHashFieldCleanup(t *testing.T) {
+	db := NewStore(Config{CleanupInterval: 10 * time.Millisecond})
+	defer db.Close()
+	ctx := context.Background()
+
+	for _, key := range []string{"a", "b", "c"} {
+		_ = db.Set(ctx, key, "v", 0)
+	}
+	_ = db.RestoreRawEntry(ctx, "h", types.Entry{
+		Value:            map[string]interface{}{"old": "x", "keep": "y"},
+		Type:             types.Hash,
+		FieldExpirations: map[string]time.Time{"old": time.Now().Add(50 * time.Millisecond)},
+	})
+	tx := db.Transaction()
+	if err := tx.Watch(ctx, "h"); err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		raw, _ := db.GetRawEntry(ctx, "h")
+		if _, ok := raw.Value.(map[string]interface{})["old"]; !ok {
+			if raw.FieldExpirations != nil {
+				t.Errorf("Expected no field expirations left, got %v", raw.FieldExpirations)
+			}
+			// The removal invalidates watches of the hash
+			if _, err := tx.Commit(); !IsTransactionConflict(err) {
+				t.Errorf("Expected ErrTransactionConflict, got %v", err)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Expired field was not removed by the cleanup")
+}
@@ -1,7 +1,13 @@
 package contracts
 
-import "context"
+import (
+	"context"
+
+	"github.com/themedef/go-hermes/internal/types"
+)
 
 type CommandsHandler interface {
 	Execute(ctx context.Context, parts []string) (string, error)
+	Do(ctx context.Context, parts []string) (interface{}, error)
+	Messages() <-chan types.PubSubMessage
 }
@@ -1,10 +1,33 @@
 package contracts
 
+import (
+	"context"
+
+	"github.com/themedef/go-hermes/internal/types"
+)
+
 type PubSubHandler interface {
-	Subscribe(key string) chan string
+	Subscribe(key string, opts ...types.SubscribeOptions) chan string
+	SubscribeContext(ctx context.Context, key string, opts ...types.SubscribeOptions) <-chan string
 	Unsubscribe(key string, ch chan string)
-	Publish(key, message string)
+	SubscribeEvents(key string, opts ...types.SubscribeOptions) chan types.Event
+	SubscribeEventsContext(ctx context.Context, key string, opts ...types.SubscribeOptions) <-chan types.Event
+	UnsubscribeEvents(key string, ch chan types.Event)
+	PSubscribe(pattern string, opts ...types.SubscribeOptions) chan types.PatternMessage
+	PUnsubscribe(pattern string, ch chan types.PatternMessage)
+	PSubscribeEvents(pattern string, opts ...types.SubscribeOptions) chan types.Event
+	PUnsubscribeEvents(pattern string, ch chan types.Event)
+	Publish(key, message string) int
+	PublishEvent(ev types.Event) int
+	NumSub(keys ...string) map[string]int
+	NumPat() int
+	Stats() []types.SubscriptionStats
+	SetRetention(key string, size int)
+	History(key string, after uint64) []types.Event
+	HasSubscribers() bool
 	ListSubscribers() []string
+	ListPatterns() []string
 	UnsubscribeAllForKey(key string)
+	UnsubscribeAllForPattern(pattern string)
 	Close()
 }
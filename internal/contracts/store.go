@@ -3,6 +3,8 @@ package contracts
 import (
 	"context"
 	"github.com/themedef/go-hermes/internal/types"
+	"io"
+	"time"
 )
 
 type StoreHandler interface {
@@ -16,13 +18,23 @@ type StoreHandler interface {
 	Decr(ctx context.Context, key string) (int64, error)
 	IncrBy(ctx context.Context, key string, increment int64) (int64, error)
 	DecrBy(ctx context.Context, key string, decrement int64) (int64, error)
-	LPush(ctx context.Context, key string, values ...interface{}) error
-	RPush(ctx context.Context, key string, values ...interface{}) error
+	LPush(ctx context.Context, key string, values ...interface{}) (int, error)
+	RPush(ctx context.Context, key string, values ...interface{}) (int, error)
 	LPop(ctx context.Context, key string) (interface{}, error)
 	RPop(ctx context.Context, key string) (interface{}, error)
+	BLPop(ctx context.Context, keys ...string) (string, interface{}, error)
+	BRPop(ctx context.Context, keys ...string) (string, interface{}, error)
+	BLMove(ctx context.Context, source, destination string, from, to types.ListSide) (interface{}, error)
 	LLen(ctx context.Context, key string) (int, error)
 	LRange(ctx context.Context, key string, start, end int) ([]interface{}, error)
 	LTrim(ctx context.Context, key string, start, stop int) error
+	LIndex(ctx context.Context, key string, index int) (interface{}, error)
+	LSet(ctx context.Context, key string, index int, value interface{}) error
+	LInsert(ctx context.Context, key string, before bool, pivot, value interface{}) (int, error)
+	LRem(ctx context.Context, key string, count int, value interface{}) (int, error)
+	LPos(ctx context.Context, key string, value interface{}, opts types.LPosOptions) ([]int, error)
+	LMove(ctx context.Context, source, destination string, from, to types.ListSide) (interface{}, error)
+	RPopLPush(ctx context.Context, source, destination string) (interface{}, error)
 
 	HSet(ctx context.Context, key string, field string, value interface{}, ttl int) error
 	HGet(ctx context.Context, key string, field string) (interface{}, error)
@@ -30,6 +42,17 @@ type StoreHandler interface {
 	HGetAll(ctx context.Context, key string) (map[string]interface{}, error)
 	HExists(ctx context.Context, key string, field string) (bool, error)
 	HLen(ctx context.Context, key string) (int, error)
+	HMSet(ctx context.Context, key string, fields map[string]interface{}) (int, error)
+	HMGet(ctx context.Context, key string, fields ...string) ([]interface{}, error)
+	HSetNX(ctx context.Context, key, field string, value interface{}) (bool, error)
+	HIncrBy(ctx context.Context, key, field string, increment int64) (int64, error)
+	HIncrByFloat(ctx context.Context, key, field string, increment float64) (float64, error)
+	HKeys(ctx context.Context, key string) ([]string, error)
+	HVals(ctx context.Context, key string) ([]interface{}, error)
+	HStrLen(ctx context.Context, key, field string) (int, error)
+	HExpire(ctx context.Context, key string, ttl int, fields ...string) ([]int, error)
+	HTTL(ctx context.Context, key string, fields ...string) ([]int, error)
+	HPersist(ctx context.Context, key string, fields ...string) ([]int, error)
 
 	SAdd(ctx context.Context, key string, members ...interface{}) error
 	SRem(ctx context.Context, key string, members ...interface{}) error
@@ -37,6 +60,33 @@ type StoreHandler interface {
 	SIsMember(ctx context.Context, key string, member interface{}) (bool, error)
 	SCard(ctx context.Context, key string) (int, error)
 
+	ZAdd(ctx context.Context, key string, opts types.ZAddOptions, members ...types.ZMember) (int, error)
+	ZIncrBy(ctx context.Context, key string, member string, increment float64, opts types.ZAddOptions) (float64, error)
+	ZRem(ctx context.Context, key string, members ...string) (int, error)
+	ZScore(ctx context.Context, key string, member string) (float64, error)
+	ZRank(ctx context.Context, key string, member string) (int, error)
+	ZRevRank(ctx context.Context, key string, member string) (int, error)
+	ZRange(ctx context.Context, key string, start, stop int, rev bool) ([]types.ZMember, error)
+	ZRangeByScore(ctx context.Context, key string, min, max types.ScoreBound, opts types.ZRangeOptions) ([]types.ZMember, error)
+	ZRangeByLex(ctx context.Context, key string, min, max types.LexBound, opts types.ZRangeOptions) ([]types.ZMember, error)
+	ZPopMin(ctx context.Context, key string, count int) ([]types.ZMember, error)
+	ZPopMax(ctx context.Context, key string, count int) ([]types.ZMember, error)
+	ZCard(ctx context.Context, key string) (int, error)
+	ZCount(ctx context.Context, key string, min, max types.ScoreBound) (int, error)
+
+	XAdd(ctx context.Context, key, id string, fields map[string]interface{}) (types.StreamID, error)
+	XLen(ctx context.Context, key string) (int, error)
+	XRange(ctx context.Context, key, start, end string, count int) ([]types.StreamEntry, error)
+	XRevRange(ctx context.Context, key, end, start string, count int) ([]types.StreamEntry, error)
+	XTrim(ctx context.Context, key string, maxLen int) (int, error)
+	XRead(ctx context.Context, opts types.XReadOptions, keys, ids []string) ([]types.StreamRead, error)
+	XGroupCreate(ctx context.Context, key, group, id string, mkStream bool) error
+	XGroupDestroy(ctx context.Context, key, group string) (bool, error)
+	XReadGroup(ctx context.Context, group, consumer string, opts types.XReadOptions, keys, ids []string) ([]types.StreamRead, error)
+	XAck(ctx context.Context, key, group string, ids ...string) (int, error)
+	XPending(ctx context.Context, key, group, consumer string) ([]types.StreamPending, error)
+	XClaim(ctx context.Context, key, group, consumer string, minIdle time.Duration, ids ...string) ([]types.StreamEntry, error)
+
 	Exists(ctx context.Context, key string) (bool, error)
 	Expire(ctx context.Context, key string, ttl int) (bool, error)
 	Persist(ctx context.Context, key string) (bool, error)
@@ -48,13 +98,34 @@ type StoreHandler interface {
 	DropAll(ctx context.Context) error
 	GetRawEntry(ctx context.Context, key string) (types.Entry, error)
 	RestoreRawEntry(ctx context.Context, key string, e types.Entry) error
+	SaveSnapshot(ctx context.Context, w io.Writer) error
+	LoadSnapshot(ctx context.Context, r io.Reader) error
+	RewriteAppendLog(ctx context.Context) error
 
-	Subscribe(key string) chan string
+	Subscribe(key string, opts ...types.SubscribeOptions) chan string
+	SubscribeContext(ctx context.Context, key string, opts ...types.SubscribeOptions) <-chan string
 	Unsubscribe(key string, ch chan string)
+	SubscribeEvents(key string, opts ...types.SubscribeOptions) chan types.Event
+	SubscribeEventsContext(ctx context.Context, key string, opts ...types.SubscribeOptions) <-chan types.Event
+	UnsubscribeEvents(key string, ch chan types.Event)
 	ListSubscriptions() []string
 	CloseAllSubscriptionsForKey(key string)
+	PSubscribe(pattern string, opts ...types.SubscribeOptions) chan types.PatternMessage
+	PUnsubscribe(pattern string, ch chan types.PatternMessage)
+	PSubscribeEvents(pattern string, opts ...types.SubscribeOptions) chan types.Event
+	PUnsubscribeEvents(pattern string, ch chan types.Event)
+	ListPatternSubscriptions() []string
+	CloseAllSubscriptionsForPattern(pattern string)
+	Publish(channel, message string) int
+	NumSub(channels ...string) map[string]int
+	NumPat() int
+	SubscriptionStats() []types.SubscriptionStats
+	SetChannelRetention(channel string, size int)
+	ChannelHistory(channel string, after uint64) []types.Event
 	Logger() LoggerHandler
 	Commands() CommandsHandler
 	Transaction() TransactionHandler
+	Update(ctx context.Context, fn func(tx TransactionHandler) error) error
+	View(ctx context.Context, fn func(tx TransactionHandler) error) error
 	Close() error
 }
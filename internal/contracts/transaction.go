@@ -1,10 +1,19 @@
 package contracts
 
-import "context"
+import (
+	"context"
+
+	"github.com/themedef/go-hermes/internal/types"
+)
 
 type TransactionHandler interface {
-	Commit() error
+	Commit() ([]types.TxResult, error)
 	Rollback() error
+	Watch(ctx context.Context, keys ...string) error
+	Unwatch() error
+	Savepoint(name string) error
+	RollbackTo(name string) error
+	Begin() (TransactionHandler, error)
 	Set(ctx context.Context, key string, value interface{}, ttl int) error
 	SetNX(ctx context.Context, key string, value interface{}, ttl int) error
 	SetXX(ctx context.Context, key string, value interface{}, ttl int) error
@@ -22,6 +31,13 @@ type TransactionHandler interface {
 	LLen(ctx context.Context, key string) (int, error)
 	LRange(ctx context.Context, key string, start, end int) ([]interface{}, error)
 	LTrim(ctx context.Context, key string, start, stop int) error
+	LIndex(ctx context.Context, key string, index int) (interface{}, error)
+	LSet(ctx context.Context, key string, index int, value interface{}) error
+	LInsert(ctx context.Context, key string, before bool, pivot, value interface{}) (int, error)
+	LRem(ctx context.Context, key string, count int, value interface{}) (int, error)
+	LPos(ctx context.Context, key string, value interface{}, opts types.LPosOptions) ([]int, error)
+	LMove(ctx context.Context, source, destination string, from, to types.ListSide) (interface{}, error)
+	RPopLPush(ctx context.Context, source, destination string) (interface{}, error)
 
 	HSet(ctx context.Context, key, field string, value interface{}, ttl int) error
 	HGet(ctx context.Context, key, field string) (interface{}, error)
@@ -29,12 +45,38 @@ type TransactionHandler interface {
 	HGetAll(ctx context.Context, key string) (map[string]interface{}, error)
 	HExists(ctx context.Context, key, field string) (bool, error)
 	HLen(ctx context.Context, key string) (int, error)
+	HMSet(ctx context.Context, key string, fields map[string]interface{}) (int, error)
+	HMGet(ctx context.Context, key string, fields ...string) ([]interface{}, error)
+	HSetNX(ctx context.Context, key, field string, value interface{}) (bool, error)
+	HIncrBy(ctx context.Context, key, field string, increment int64) (int64, error)
+	HIncrByFloat(ctx context.Context, key, field string, increment float64) (float64, error)
+	HKeys(ctx context.Context, key string) ([]string, error)
+	HVals(ctx context.Context, key string) ([]interface{}, error)
+	HStrLen(ctx context.Context, key, field string) (int, error)
+	HExpire(ctx context.Context, key string, ttl int, fields ...string) ([]int, error)
+	HTTL(ctx context.Context, key string, fields ...string) ([]int, error)
+	HPersist(ctx context.Context, key string, fields ...string) ([]int, error)
 
 	SAdd(ctx context.Context, key string, members ...interface{}) error
 	SRem(ctx context.Context, key string, members ...interface{}) error
 	SMembers(ctx context.Context, key string) ([]interface{}, error)
 	SIsMember(ctx context.Context, key string, member interface{}) (bool, error)
 	SCard(ctx context.Context, key string) (int, error)
+
+	ZAdd(ctx context.Context, key string, opts types.ZAddOptions, members ...types.ZMember) error
+	ZIncrBy(ctx context.Context, key string, member string, increment float64, opts types.ZAddOptions) error
+	ZRem(ctx context.Context, key string, members ...string) error
+	ZPopMin(ctx context.Context, key string, count int) ([]types.ZMember, error)
+	ZPopMax(ctx context.Context, key string, count int) ([]types.ZMember, error)
+	ZScore(ctx context.Context, key string, member string) (float64, error)
+	ZRank(ctx context.Context, key string, member string) (int, error)
+	ZRevRank(ctx context.Context, key string, member string) (int, error)
+	ZRange(ctx context.Context, key string, start, stop int, rev bool) ([]types.ZMember, error)
+	ZRangeByScore(ctx context.Context, key string, min, max types.ScoreBound, opts types.ZRangeOptions) ([]types.ZMember, error)
+	ZRangeByLex(ctx context.Context, key string, min, max types.LexBound, opts types.ZRangeOptions) ([]types.ZMember, error)
+	ZCard(ctx context.Context, key string) (int, error)
+	ZCount(ctx context.Context, key string, min, max types.ScoreBound) (int, error)
+
 	Exists(ctx context.Context, key string) (bool, error)
 	Expire(ctx context.Context, key string, ttl int) error
 	Persist(ctx context.Context, key string) error
@@ -0,0 +1,411 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type FsyncPolicy string
+
+const (
+	FsyncAlways   FsyncPolicy = "always"
+	FsyncEverySec FsyncPolicy = "everysec"
+	FsyncNo       FsyncPolicy = "no"
+)
+
+const (
+	recordHeaderSize = 8
+	maxRecordSize    = 512 << 20
+	// payloadChunk is the largest payload read into a buffer sized from the
+	// header alone; longer ones grow with the bytes actually read.
+	payloadChunk = 64 << 10
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+var ErrRewriteInProgress = errors.New("log rewrite already in progress")
+
+// Record is a single logged mutation. Time is the wall clock at which the
+// mutation was applied; replay evaluates expirations against it.
+type Record struct {
+	Time time.Time
+	Op   string
+	Args []interface{}
+}
+
+type ReplayStats struct {
+	Applied   int
+	Skipped   int
+	ValidSize int64
+}
+
+// AppendLog frames every record as: payload length (uint32 LE), CRC-32C of the
+// payload (uint32 LE), payload.
+type AppendLog struct {
+	mu     sync.Mutex
+	file   *os.File
+	path   string
+	policy FsyncPolicy
+	size   int64
+	dirty  bool
+	buf    bytes.Buffer
+	closed bool
+
+	// baseSize is the file size after the last rewrite (or at open); growth
+	// thresholds for automatic rewrites are measured against it.
+	baseSize   int64
+	rewriting  bool
+	rewriteBuf bytes.Buffer
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func ParseFsyncPolicy(policy FsyncPolicy) (FsyncPolicy, error) {
+	switch policy {
+	case "":
+		return FsyncEverySec, nil
+	case FsyncAlways, FsyncEverySec, FsyncNo:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown fsync policy %q", policy)
+	}
+}
+
+func OpenAppendLog(path string, policy FsyncPolicy) (*AppendLog, error) {
+	policy, err := ParseFsyncPolicy(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	l := &AppendLog{
+		file:     file,
+		path:     path,
+		policy:   policy,
+		size:     info.Size(),
+		baseSize: info.Size(),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if policy == FsyncEverySec {
+		go l.syncEverySecond()
+	} else {
+		close(l.done)
+	}
+	return l, nil
+}
+
+func EncodeRecord(w io.Writer, rec Record) error {
+	var payload bytes.Buffer
+	enc := newEncoder(&payload)
+	if err := enc.writeTime(rec.Time); err != nil {
+		return err
+	}
+	if err := enc.writeString(rec.Op); err != nil {
+		return err
+	}
+	if err := enc.writeValues(rec.Args); err != nil {
+		return err
+	}
+
+	var header [recordHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[:4], uint32(payload.Len()))
+	binary.LittleEndian.PutUint32(header[4:], crc32.Checksum(payload.Bytes(), castagnoli))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload.Bytes())
+	return err
+}
+
+func (l *AppendLog) Append(rec Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return os.ErrClosed
+	}
+
+	l.buf.Reset()
+	if err := EncodeRecord(&l.buf, rec); err != nil {
+		return err
+	}
+	n, err := l.file.Write(l.buf.Bytes())
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if l.rewriting {
+		l.rewriteBuf.Write(l.buf.Bytes())
+	}
+
+	if l.policy == FsyncAlways {
+		return l.file.Sync()
+	}
+	l.dirty = true
+	return nil
+}
+
+func (l *AppendLog) Size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.size
+}
+
+func (l *AppendLog) BaseSize() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.baseSize
+}
+
+func (l *AppendLog) Path() string {
+	return l.path
+}
+
+func (l *AppendLog) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.syncLocked()
+}
+
+func (l *AppendLog) syncLocked() error {
+	if l.closed || !l.dirty {
+		return nil
+	}
+	l.dirty = false
+	return l.file.Sync()
+}
+
+func (l *AppendLog) syncEverySecond() {
+	defer close(l.done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.mu.Lock()
+			if l.policy == FsyncEverySec {
+				_ = l.syncLocked()
+			}
+			l.mu.Unlock()
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+func (l *AppendLog) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	close(l.stop)
+	l.mu.Unlock()
+	<-l.done
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var err error
+	if l.policy != FsyncNo {
+		err = l.file.Sync()
+	}
+	l.closed = true
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// BeginRewrite starts collecting every record appended from now on. The caller must
+// make sure no record can be appended between capturing the dataset it is about to
+// write and this call.
+func (l *AppendLog) BeginRewrite() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return os.ErrClosed
+	}
+	if l.rewriting {
+		return ErrRewriteInProgress
+	}
+	l.rewriting = true
+	l.rewriteBuf.Reset()
+	return nil
+}
+
+func (l *AppendLog) AbortRewrite() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rewriting = false
+	l.rewriteBuf.Reset()
+}
+
+// FinishRewrite appends the records collected since BeginRewrite to tmp, atomically
+// replaces the log with it and continues appending to tmp. Appends are blocked only
+// while the collected tail is copied. On failure tmp is closed and removed.
+func (l *AppendLog) FinishRewrite(tmp *os.File) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	defer func() {
+		l.rewriting = false
+		l.rewriteBuf.Reset()
+	}()
+
+	err := func() error {
+		if !l.rewriting {
+			return errors.New("no log rewrite in progress")
+		}
+		if l.closed {
+			return os.ErrClosed
+		}
+		if _, err := tmp.Write(l.rewriteBuf.Bytes()); err != nil {
+			return err
+		}
+		return InstallFile(tmp, l.path)
+	}()
+	if err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	size, _ := tmp.Seek(0, io.SeekCurrent)
+	old := l.file
+	l.file = tmp
+	l.size = size
+	l.baseSize = size
+	l.dirty = false
+	_ = old.Close()
+	return nil
+}
+
+// CreateTemp creates a file next to path so that it can later be renamed over it.
+func CreateTemp(path string) (*os.File, error) {
+	return os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+}
+
+// InstallFile flushes f to disk and atomically renames it to path. f stays open.
+func InstallFile(f *os.File, path string) error {
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		_ = dir.Sync()
+		_ = dir.Close()
+	}
+	return nil
+}
+
+// ReplayAppendLog decodes records in order and hands them to fn. A damaged record
+// whose frame is intact is skipped; an incomplete record at the end of the stream
+// (a torn write) is skipped and ends the replay. ValidSize is the length of the
+// prefix that ends on a record boundary and can safely be appended to.
+func ReplayAppendLog(r io.Reader, fn func(Record) error) (ReplayStats, error) {
+	var stats ReplayStats
+	br := bufio.NewReader(r)
+	var header [recordHeaderSize]byte
+
+	for {
+		n, err := io.ReadFull(br, header[:])
+		if err == io.EOF {
+			return stats, nil
+		}
+		if err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) && n > 0 {
+				stats.Skipped++
+				return stats, nil
+			}
+			return stats, err
+		}
+
+		size := binary.LittleEndian.Uint32(header[:4])
+		if size > maxRecordSize {
+			stats.Skipped++
+			return stats, fmt.Errorf("%w: record of %d bytes at offset %d", ErrCorrupted, size, stats.ValidSize)
+		}
+		payload, err := readPayload(br, size)
+		if err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) || err == io.EOF {
+				stats.Skipped++
+				return stats, nil
+			}
+			return stats, err
+		}
+		stats.ValidSize += int64(recordHeaderSize) + int64(size)
+
+		if crc32.Checksum(payload, castagnoli) != binary.LittleEndian.Uint32(header[4:]) {
+			stats.Skipped++
+			continue
+		}
+		rec, err := decodeRecord(payload)
+		if err != nil {
+			stats.Skipped++
+			continue
+		}
+		if err := fn(rec); err != nil {
+			return stats, err
+		}
+		stats.Applied++
+	}
+}
+
+// readPayload reads the size bytes of a record payload from r. The length comes
+// from a header that has not been checked yet, so a damaged one must not make
+// a short log allocate up to maxRecordSize: beyond payloadChunk, the buffer
+// only grows as the bytes arrive.
+func readPayload(r io.Reader, size uint32) ([]byte, error) {
+	if size <= payloadChunk {
+		payload := make([]byte, size)
+		_, err := io.ReadFull(r, payload)
+		return payload, err
+	}
+	var buf bytes.Buffer
+	buf.Grow(payloadChunk)
+	if _, err := io.CopyN(&buf, r, int64(size)); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeRecord(payload []byte) (Record, error) {
+	dec := newDecoder(bytes.NewReader(payload))
+	t, err := dec.readTime()
+	if err != nil {
+		return Record{}, err
+	}
+	op, err := dec.readString()
+	if err != nil {
+		return Record{}, err
+	}
+	args, err := dec.readValues()
+	if err != nil {
+		return Record{}, err
+	}
+	return Record{Time: t, Op: op, Args: args}, nil
+}
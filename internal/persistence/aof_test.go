@@ -0,0 +1,103 @@
+package persistence
+
+import (
+	"bytes"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func encodeRecords(t *testing.T, recs ...Record) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, rec := range recs {
+		if err := EncodeRecord(&buf, rec); err != nil {
+			t.Fatalf("EncodeRecord failed: %v", err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// TestReplayAppendLog checks record decoding, damaged records and torn tails.
+func TestReplayAppendLog(t *testing.T) {
+	now := time.Now()
+	first := encodeRecords(t, Record{Time: now, Op: "SET", Args: []interface{}{"k", "v", int64(0)}})
+	second := encodeRecords(t, Record{Time: now, Op: "DEL", Args: []interface{}{"k"}})
+	data := append(append([]byte(nil), first...), second...)
+
+	// Scenario 1: intact log
+	var ops []string
+	stats, err := ReplayAppendLog(bytes.NewReader(data), func(rec Record) error {
+		ops = append(ops, rec.Op)
+		return nil
+	})
+	if err != nil || stats.Applied != 2 || stats.Skipped != 0 || stats.ValidSize != int64(len(data)) {
+		t.Fatalf("Unexpected stats %+v (err=%v)", stats, err)
+	}
+	if len(ops) != 2 || ops[0] != "SET" || ops[1] != "DEL" {
+		t.Errorf("Unexpected replay order: %v", ops)
+	}
+
+	// Scenario 2: torn final record
+	stats, err = ReplayAppendLog(bytes.NewReader(data[:len(data)-1]), func(Record) error { return nil })
+	if err != nil || stats.Applied != 1 || stats.Skipped != 1 || stats.ValidSize != int64(len(first)) {
+		t.Errorf("Unexpected stats for torn tail %+v (err=%v)", stats, err)
+	}
+
+	// Scenario 3: torn header
+	stats, err = ReplayAppendLog(bytes.NewReader(data[:len(first)+3]), func(Record) error { return nil })
+	if err != nil || stats.Applied != 1 || stats.Skipped != 1 {
+		t.Errorf("Unexpected stats for torn header %+v (err=%v)", stats, err)
+	}
+
+	// Scenario 4: checksum mismatch in an intact frame
+	damaged := append([]byte(nil), data...)
+	damaged[recordHeaderSize+1] ^= 0xFF
+	stats, err = ReplayAppendLog(bytes.NewReader(damaged), func(Record) error { return nil })
+	if err != nil || stats.Applied != 1 || stats.Skipped != 1 || stats.ValidSize != int64(len(data)) {
+		t.Errorf("Unexpected stats for damaged record %+v (err=%v)", stats, err)
+	}
+
+	// Scenario 5: absurd length prefix
+	garbage := []byte{0xFF, 0xFF, 0xFF, 0xFF, 0, 0, 0, 0}
+	if _, err := ReplayAppendLog(bytes.NewReader(garbage), func(Record) error { return nil }); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("Expected ErrCorrupted for oversized record, got %v", err)
+	}
+
+	// Scenario 6: a damaged length within the limit on a short log is a torn
+	// tail and does not allocate the claimed size
+	claimed := append([]byte{0, 0, 0, 0x10, 0, 0, 0, 0}, first...)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	stats, err = ReplayAppendLog(bytes.NewReader(claimed), func(Record) error { return nil })
+	runtime.ReadMemStats(&after)
+	if err != nil || stats.Applied != 0 || stats.Skipped != 1 || stats.ValidSize != 0 {
+		t.Errorf("Unexpected stats for damaged length %+v (err=%v)", stats, err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("Replaying %d bytes allocated %d bytes", len(claimed), allocated)
+	}
+
+	// Scenario 7: a record larger than a chunk
+	large := encodeRecords(t, Record{Time: now, Op: "SET", Args: []interface{}{"k", string(make([]byte, 3*payloadChunk)), int64(0)}})
+	stats, err = ReplayAppendLog(bytes.NewReader(large), func(Record) error { return nil })
+	if err != nil || stats.Applied != 1 || stats.ValidSize != int64(len(large)) {
+		t.Errorf("Unexpected stats for large record %+v (err=%v)", stats, err)
+	}
+}
+
+// TestParseFsyncPolicy checks the accepted fsync policies and the default.
+func TestParseFsyncPolicy(t *testing.T) {
+	if p, err := ParseFsyncPolicy(""); err != nil || p != FsyncEverySec {
+		t.Errorf("Expected default everysec, got %q (err=%v)", p, err)
+	}
+	for _, p := range []FsyncPolicy{FsyncAlways, FsyncEverySec, FsyncNo} {
+		if got, err := ParseFsyncPolicy(p); err != nil || got != p {
+			t.Errorf("Expected %q to be accepted, got %q (err=%v)", p, got, err)
+		}
+	}
+	if _, err := ParseFsyncPolicy("sometimes"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
@@ -0,0 +1,734 @@
+package persistence
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/themedef/go-hermes/internal/types"
+)
+
+var (
+	ErrUnsupportedValue = errors.New("unsupported value type")
+	ErrCorrupted        = errors.New("corrupted data")
+)
+
+const (
+	tagNil byte = iota
+	tagString
+	tagBool
+	tagInt
+	tagInt32
+	tagInt64
+	tagUint
+	tagUint32
+	tagUint64
+	tagFloat32
+	tagFloat64
+	tagBytes
+	tagSlice
+	tagMap
+	tagEntry
+	// tagEntryFieldTTLs is an entry followed by the expirations of its hash
+	// fields. Entries without them keep using tagEntry.
+	tagEntryFieldTTLs
+)
+
+// maxCollectionLen guards against absurd allocations when a length prefix is damaged.
+const maxCollectionLen = 1 << 31
+
+type encoder struct {
+	w   io.Writer
+	buf [binary.MaxVarintLen64]byte
+}
+
+func newEncoder(w io.Writer) *encoder {
+	return &encoder{w: w}
+}
+
+func (e *encoder) writeByte(b byte) error {
+	e.buf[0] = b
+	_, err := e.w.Write(e.buf[:1])
+	return err
+}
+
+func (e *encoder) writeUvarint(v uint64) error {
+	n := binary.PutUvarint(e.buf[:], v)
+	_, err := e.w.Write(e.buf[:n])
+	return err
+}
+
+func (e *encoder) writeVarint(v int64) error {
+	n := binary.PutVarint(e.buf[:], v)
+	_, err := e.w.Write(e.buf[:n])
+	return err
+}
+
+func (e *encoder) writeUint64(v uint64) error {
+	binary.LittleEndian.PutUint64(e.buf[:8], v)
+	_, err := e.w.Write(e.buf[:8])
+	return err
+}
+
+func (e *encoder) writeBytes(b []byte) error {
+	if err := e.writeUvarint(uint64(len(b))); err != nil {
+		return err
+	}
+	_, err := e.w.Write(b)
+	return err
+}
+
+func (e *encoder) writeString(s string) error {
+	if err := e.writeUvarint(uint64(len(s))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(e.w, s)
+	return err
+}
+
+func (e *encoder) writeTime(t time.Time) error {
+	if t.IsZero() {
+		return e.writeVarint(0)
+	}
+	return e.writeVarint(t.UnixNano())
+}
+
+func (e *encoder) writeValue(v interface{}) error {
+	switch val := v.(type) {
+	case nil:
+		return e.writeByte(tagNil)
+	case string:
+		if err := e.writeByte(tagString); err != nil {
+			return err
+		}
+		return e.writeString(val)
+	case bool:
+		if err := e.writeByte(tagBool); err != nil {
+			return err
+		}
+		if val {
+			return e.writeByte(1)
+		}
+		return e.writeByte(0)
+	case int:
+		if err := e.writeByte(tagInt); err != nil {
+			return err
+		}
+		return e.writeVarint(int64(val))
+	case int32:
+		if err := e.writeByte(tagInt32); err != nil {
+			return err
+		}
+		return e.writeVarint(int64(val))
+	case int64:
+		if err := e.writeByte(tagInt64); err != nil {
+			return err
+		}
+		return e.writeVarint(val)
+	case uint:
+		if err := e.writeByte(tagUint); err != nil {
+			return err
+		}
+		return e.writeUvarint(uint64(val))
+	case uint32:
+		if err := e.writeByte(tagUint32); err != nil {
+			return err
+		}
+		return e.writeUvarint(uint64(val))
+	case uint64:
+		if err := e.writeByte(tagUint64); err != nil {
+			return err
+		}
+		return e.writeUvarint(val)
+	case float32:
+		if err := e.writeByte(tagFloat32); err != nil {
+			return err
+		}
+		return e.writeUint64(uint64(math.Float32bits(val)))
+	case float64:
+		if err := e.writeByte(tagFloat64); err != nil {
+			return err
+		}
+		return e.writeUint64(math.Float64bits(val))
+	case []byte:
+		if err := e.writeByte(tagBytes); err != nil {
+			return err
+		}
+		return e.writeBytes(val)
+	case []interface{}:
+		if err := e.writeByte(tagSlice); err != nil {
+			return err
+		}
+		return e.writeValues(val)
+	case map[string]interface{}:
+		if err := e.writeByte(tagMap); err != nil {
+			return err
+		}
+		return e.writeStringMap(val)
+	case types.Entry:
+		if len(val.FieldExpirations) > 0 {
+			if err := e.writeByte(tagEntryFieldTTLs); err != nil {
+				return err
+			}
+			return e.writeEntryFieldTTLs(val)
+		}
+		if err := e.writeByte(tagEntry); err != nil {
+			return err
+		}
+		return e.writeEntry(val)
+	default:
+		return fmt.Errorf("%w: %T", ErrUnsupportedValue, v)
+	}
+}
+
+// CheckValue returns ErrUnsupportedValue when v cannot be encoded in a record
+// or a snapshot, so that a write can be rejected before it is applied.
+func CheckValue(v interface{}) error {
+	switch val := v.(type) {
+	case nil, string, bool, int, int32, int64, uint, uint32, uint64, float32, float64, []byte:
+		return nil
+	case []interface{}:
+		for _, item := range val {
+			if err := CheckValue(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		for _, item := range val {
+			if err := CheckValue(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return newEncoder(io.Discard).writeValue(v)
+}
+
+func (e *encoder) writeValues(values []interface{}) error {
+	if err := e.writeUvarint(uint64(len(values))); err != nil {
+		return err
+	}
+	for _, v := range values {
+		if err := e.writeValue(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) writeStringMap(m map[string]interface{}) error {
+	if err := e.writeUvarint(uint64(len(m))); err != nil {
+		return err
+	}
+	for k, v := range m {
+		if err := e.writeString(k); err != nil {
+			return err
+		}
+		if err := e.writeValue(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) writeEntry(entry types.Entry) error {
+	if err := e.writeByte(byte(entry.Type)); err != nil {
+		return err
+	}
+	if err := e.writeTime(entry.Expiration); err != nil {
+		return err
+	}
+
+	switch entry.Type {
+	case types.String:
+		return e.writeValue(entry.Value)
+	case types.List:
+		list, ok := entry.Value.(*types.Deque)
+		if !ok {
+			return fmt.Errorf("%w: list holds %T", ErrUnsupportedValue, entry.Value)
+		}
+		return e.writeValues(list.Values())
+	case types.Hash:
+		hash, ok := entry.Value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%w: hash holds %T", ErrUnsupportedValue, entry.Value)
+		}
+		return e.writeStringMap(hash)
+	case types.Set:
+		set, ok := entry.Value.(map[interface{}]struct{})
+		if !ok {
+			return fmt.Errorf("%w: set holds %T", ErrUnsupportedValue, entry.Value)
+		}
+		if err := e.writeUvarint(uint64(len(set))); err != nil {
+			return err
+		}
+		for m := range set {
+			if err := e.writeValue(m); err != nil {
+				return err
+			}
+		}
+		return nil
+	case types.ZSet:
+		zset, ok := entry.Value.(*types.SortedSet)
+		if !ok {
+			return fmt.Errorf("%w: sorted set holds %T", ErrUnsupportedValue, entry.Value)
+		}
+		if err := e.writeUvarint(uint64(zset.Len())); err != nil {
+			return err
+		}
+		for _, m := range zset.Members() {
+			if err := e.writeString(m.Member); err != nil {
+				return err
+			}
+			if err := e.writeUint64(math.Float64bits(m.Score)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case types.Stream:
+		stream, ok := entry.Value.(*types.StreamLog)
+		if !ok {
+			return fmt.Errorf("%w: stream holds %T", ErrUnsupportedValue, entry.Value)
+		}
+		return e.writeStream(stream)
+	default:
+		return fmt.Errorf("%w: data type %d", ErrUnsupportedValue, entry.Type)
+	}
+}
+
+// writeEntryFieldTTLs writes a hash entry followed by the expirations of its
+// fields.
+func (e *encoder) writeEntryFieldTTLs(entry types.Entry) error {
+	if entry.Type != types.Hash {
+		return fmt.Errorf("%w: field expirations on data type %d", ErrUnsupportedValue, entry.Type)
+	}
+	if err := e.writeEntry(entry); err != nil {
+		return err
+	}
+	if err := e.writeUvarint(uint64(len(entry.FieldExpirations))); err != nil {
+		return err
+	}
+	for field, at := range entry.FieldExpirations {
+		if err := e.writeString(field); err != nil {
+			return err
+		}
+		if err := e.writeTime(at); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) writeStreamID(id types.StreamID) error {
+	if err := e.writeUvarint(id.Ms); err != nil {
+		return err
+	}
+	return e.writeUvarint(id.Seq)
+}
+
+// writeStream writes the last ID, the entries and then every consumer group
+// with its pending entries.
+func (e *encoder) writeStream(stream *types.StreamLog) error {
+	if err := e.writeStreamID(stream.LastID()); err != nil {
+		return err
+	}
+	entries := stream.Entries()
+	if err := e.writeUvarint(uint64(len(entries))); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := e.writeStreamID(entry.ID); err != nil {
+			return err
+		}
+		if err := e.writeStringMap(entry.Fields); err != nil {
+			return err
+		}
+	}
+
+	groups := stream.Groups()
+	if err := e.writeUvarint(uint64(len(groups))); err != nil {
+		return err
+	}
+	for _, g := range groups {
+		if err := e.writeString(g.Name); err != nil {
+			return err
+		}
+		if err := e.writeStreamID(g.LastDelivered); err != nil {
+			return err
+		}
+		pending := g.PendingList("")
+		if err := e.writeUvarint(uint64(len(pending))); err != nil {
+			return err
+		}
+		for _, p := range pending {
+			if err := e.writeStreamID(p.ID); err != nil {
+				return err
+			}
+			if err := e.writeString(p.Consumer); err != nil {
+				return err
+			}
+			if err := e.writeTime(p.DeliveredAt); err != nil {
+				return err
+			}
+			if err := e.writeUvarint(uint64(p.Deliveries)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+type decoder struct {
+	r   byteReader
+	buf [8]byte
+}
+
+func newDecoder(r byteReader) *decoder {
+	return &decoder{r: r}
+}
+
+func (d *decoder) readByte() (byte, error) {
+	return d.r.ReadByte()
+}
+
+func (d *decoder) readUvarint() (uint64, error) {
+	return binary.ReadUvarint(d.r)
+}
+
+func (d *decoder) readVarint() (int64, error) {
+	return binary.ReadVarint(d.r)
+}
+
+func (d *decoder) readUint64() (uint64, error) {
+	if _, err := io.ReadFull(d.r, d.buf[:8]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(d.buf[:8]), nil
+}
+
+func (d *decoder) readLen() (int, error) {
+	n, err := d.readUvarint()
+	if err != nil {
+		return 0, err
+	}
+	if n > maxCollectionLen {
+		return 0, fmt.Errorf("%w: length %d out of range", ErrCorrupted, n)
+	}
+	return int(n), nil
+}
+
+func (d *decoder) readBytes() ([]byte, error) {
+	n, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(io.LimitReader(d.r, int64(n)))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return b, nil
+}
+
+func (d *decoder) readString() (string, error) {
+	b, err := d.readBytes()
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (d *decoder) readTime() (time.Time, error) {
+	nanos, err := d.readVarint()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if nanos == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(0, nanos), nil
+}
+
+func (d *decoder) readValue() (interface{}, error) {
+	tag, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tag {
+	case tagNil:
+		return nil, nil
+	case tagString:
+		return d.readString()
+	case tagBool:
+		b, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+		return b == 1, nil
+	case tagInt:
+		v, err := d.readVarint()
+		return int(v), err
+	case tagInt32:
+		v, err := d.readVarint()
+		return int32(v), err
+	case tagInt64:
+		return d.readVarint()
+	case tagUint:
+		v, err := d.readUvarint()
+		return uint(v), err
+	case tagUint32:
+		v, err := d.readUvarint()
+		return uint32(v), err
+	case tagUint64:
+		return d.readUvarint()
+	case tagFloat32:
+		v, err := d.readUint64()
+		return math.Float32frombits(uint32(v)), err
+	case tagFloat64:
+		v, err := d.readUint64()
+		return math.Float64frombits(v), err
+	case tagBytes:
+		return d.readBytes()
+	case tagSlice:
+		return d.readValues()
+	case tagMap:
+		return d.readStringMap()
+	case tagEntry:
+		return d.readEntry()
+	case tagEntryFieldTTLs:
+		return d.readEntryFieldTTLs()
+	default:
+		return nil, fmt.Errorf("%w: unknown value tag %d", ErrCorrupted, tag)
+	}
+}
+
+func (d *decoder) readValues() ([]interface{}, error) {
+	n, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, 0, min(n, 1024))
+	for i := 0; i < n; i++ {
+		v, err := d.readValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func (d *decoder) readStringMap() (map[string]interface{}, error) {
+	n, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]interface{}, min(n, 1024))
+	for i := 0; i < n; i++ {
+		k, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.readValue()
+		if err != nil {
+			return nil, err
+		}
+		m[k] = v
+	}
+	return m, nil
+}
+
+func (d *decoder) readEntry() (types.Entry, error) {
+	typ, err := d.readByte()
+	if err != nil {
+		return types.Entry{}, err
+	}
+	expiration, err := d.readTime()
+	if err != nil {
+		return types.Entry{}, err
+	}
+
+	entry := types.Entry{Type: types.DataType(typ), Expiration: expiration}
+	switch entry.Type {
+	case types.String:
+		entry.Value, err = d.readValue()
+	case types.List:
+		var values []interface{}
+		values, err = d.readValues()
+		entry.Value = types.NewDeque(values...)
+	case types.Hash:
+		entry.Value, err = d.readStringMap()
+	case types.Set:
+		var n int
+		n, err = d.readLen()
+		if err != nil {
+			break
+		}
+		set := make(map[interface{}]struct{}, min(n, 1024))
+		for i := 0; i < n; i++ {
+			var m interface{}
+			m, err = d.readValue()
+			if err != nil {
+				break
+			}
+			switch m.(type) {
+			case []byte, []interface{}, map[string]interface{}:
+				err = fmt.Errorf("%w: unhashable set member %T", ErrCorrupted, m)
+			}
+			if err != nil {
+				break
+			}
+			set[m] = struct{}{}
+		}
+		entry.Value = set
+	case types.ZSet:
+		var n int
+		n, err = d.readLen()
+		if err != nil {
+			break
+		}
+		zset := types.NewSortedSet()
+		for i := 0; i < n; i++ {
+			var member string
+			var bits uint64
+			if member, err = d.readString(); err != nil {
+				break
+			}
+			if bits, err = d.readUint64(); err != nil {
+				break
+			}
+			score := math.Float64frombits(bits)
+			if math.IsNaN(score) {
+				err = fmt.Errorf("%w: NaN score for sorted set member", ErrCorrupted)
+				break
+			}
+			zset.Add(member, score)
+		}
+		entry.Value = zset
+	case types.Stream:
+		entry.Value, err = d.readStream()
+	default:
+		return types.Entry{}, fmt.Errorf("%w: unknown data type %d", ErrCorrupted, typ)
+	}
+	if err != nil {
+		return types.Entry{}, err
+	}
+	return entry, nil
+}
+
+func (d *decoder) readEntryFieldTTLs() (types.Entry, error) {
+	entry, err := d.readEntry()
+	if err != nil {
+		return types.Entry{}, err
+	}
+	if entry.Type != types.Hash {
+		return types.Entry{}, fmt.Errorf("%w: field expirations on data type %d", ErrCorrupted, entry.Type)
+	}
+	n, err := d.readLen()
+	if err != nil {
+		return types.Entry{}, err
+	}
+	for i := 0; i < n; i++ {
+		field, err := d.readString()
+		if err != nil {
+			return types.Entry{}, err
+		}
+		at, err := d.readTime()
+		if err != nil {
+			return types.Entry{}, err
+		}
+		entry.SetFieldExpiration(field, at)
+	}
+	return entry, nil
+}
+
+func (d *decoder) readStreamID() (types.StreamID, error) {
+	ms, err := d.readUvarint()
+	if err != nil {
+		return types.StreamID{}, err
+	}
+	seq, err := d.readUvarint()
+	if err != nil {
+		return types.StreamID{}, err
+	}
+	return types.StreamID{Ms: ms, Seq: seq}, nil
+}
+
+func (d *decoder) readStream() (*types.StreamLog, error) {
+	last, err := d.readStreamID()
+	if err != nil {
+		return nil, err
+	}
+	n, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+	stream := types.NewStreamLog()
+	for i := 0; i < n; i++ {
+		var entry types.StreamEntry
+		if entry.ID, err = d.readStreamID(); err != nil {
+			return nil, err
+		}
+		if entry.Fields, err = d.readStringMap(); err != nil {
+			return nil, err
+		}
+		if !stream.Append(entry) {
+			return nil, fmt.Errorf("%w: stream entry %s out of order", ErrCorrupted, entry.ID)
+		}
+	}
+	stream.SetLastID(last)
+
+	groups, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < groups; i++ {
+		name, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		lastDelivered, err := d.readStreamID()
+		if err != nil {
+			return nil, err
+		}
+		g := types.NewStreamGroup(name, lastDelivered)
+		pending, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+		for j := 0; j < pending; j++ {
+			var p types.StreamPending
+			if p.ID, err = d.readStreamID(); err != nil {
+				return nil, err
+			}
+			if p.Consumer, err = d.readString(); err != nil {
+				return nil, err
+			}
+			if p.DeliveredAt, err = d.readTime(); err != nil {
+				return nil, err
+			}
+			deliveries, err := d.readUvarint()
+			if err != nil {
+				return nil, err
+			}
+			p.Deliveries = int(deliveries)
+			g.Pending[p.ID] = &p
+		}
+		if !stream.AddGroup(g) {
+			return nil, fmt.Errorf("%w: duplicate consumer group %q", ErrCorrupted, name)
+		}
+	}
+	return stream, nil
+}
@@ -0,0 +1,180 @@
+package persistence
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+
+	"github.com/themedef/go-hermes/internal/types"
+)
+
+const (
+	snapshotMagic   = "HERMESDB"
+	SnapshotVersion = 1
+
+	opEntry          byte = 0x01
+	opEntryFieldTTLs byte = 0x02
+	opEOF            byte = 0xFF
+)
+
+var (
+	ErrChecksumMismatch   = errors.New("checksum mismatch")
+	ErrUnsupportedVersion = errors.New("unsupported snapshot version")
+)
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// Snapshot layout: magic, version, a sequence of (opEntry, key, entry) records,
+// opEOF, and finally a little-endian CRC-64 of every preceding byte. Hashes
+// whose fields expire are written as (opEntryFieldTTLs, key, entry, field
+// expirations) instead, so snapshots without them read as before.
+type SnapshotWriter struct {
+	bw   *bufio.Writer
+	hash hash.Hash64
+	enc  *encoder
+	done bool
+}
+
+func NewSnapshotWriter(w io.Writer) (*SnapshotWriter, error) {
+	bw := bufio.NewWriter(w)
+	h := crc64.New(crcTable)
+	sw := &SnapshotWriter{
+		bw:   bw,
+		hash: h,
+		enc:  newEncoder(io.MultiWriter(bw, h)),
+	}
+
+	if _, err := io.WriteString(sw.enc.w, snapshotMagic); err != nil {
+		return nil, err
+	}
+	if err := sw.enc.writeUvarint(SnapshotVersion); err != nil {
+		return nil, err
+	}
+	return sw, nil
+}
+
+func (sw *SnapshotWriter) WriteEntry(key string, entry types.Entry) error {
+	if sw.done {
+		return errors.New("snapshot writer already closed")
+	}
+	op := opEntry
+	if len(entry.FieldExpirations) > 0 {
+		op = opEntryFieldTTLs
+	}
+	if err := sw.enc.writeByte(op); err != nil {
+		return err
+	}
+	if err := sw.enc.writeString(key); err != nil {
+		return err
+	}
+	if op == opEntryFieldTTLs {
+		return sw.enc.writeEntryFieldTTLs(entry)
+	}
+	return sw.enc.writeEntry(entry)
+}
+
+// Close terminates the snapshot and flushes it. The underlying writer is left open.
+func (sw *SnapshotWriter) Close() error {
+	if sw.done {
+		return nil
+	}
+	sw.done = true
+	if err := sw.enc.writeByte(opEOF); err != nil {
+		return err
+	}
+	var sum [8]byte
+	binary.LittleEndian.PutUint64(sum[:], sw.hash.Sum64())
+	if _, err := sw.bw.Write(sum[:]); err != nil {
+		return err
+	}
+	return sw.bw.Flush()
+}
+
+// ReadSnapshot decodes every entry and hands it to fn. Entries are streamed before the
+// trailing checksum is verified, so callers must discard them if an error is returned.
+func ReadSnapshot(r io.Reader, fn func(key string, entry types.Entry) error) error {
+	br := bufio.NewReader(r)
+	hr := &hashingReader{r: br, hash: crc64.New(crcTable)}
+	dec := newDecoder(hr)
+
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(hr, magic); err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupted, err)
+	}
+	if string(magic) != snapshotMagic {
+		return fmt.Errorf("%w: bad magic %q", ErrCorrupted, magic)
+	}
+	version, err := dec.readUvarint()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupted, err)
+	}
+	if version != SnapshotVersion {
+		return fmt.Errorf("%w: %w %d", ErrCorrupted, ErrUnsupportedVersion, version)
+	}
+
+	for {
+		op, err := dec.readByte()
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrCorrupted, err)
+		}
+		if op == opEOF {
+			break
+		}
+		if op != opEntry && op != opEntryFieldTTLs {
+			return fmt.Errorf("%w: unknown record type 0x%02x", ErrCorrupted, op)
+		}
+
+		key, err := dec.readString()
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrCorrupted, err)
+		}
+		var entry types.Entry
+		if op == opEntryFieldTTLs {
+			entry, err = dec.readEntryFieldTTLs()
+		} else {
+			entry, err = dec.readEntry()
+		}
+		if err != nil {
+			if errors.Is(err, ErrCorrupted) {
+				return err
+			}
+			return fmt.Errorf("%w: %v", ErrCorrupted, err)
+		}
+		if err := fn(key, entry); err != nil {
+			return err
+		}
+	}
+
+	expected := hr.hash.Sum64()
+	var sum [8]byte
+	if _, err := io.ReadFull(br, sum[:]); err != nil {
+		return fmt.Errorf("%w: missing checksum: %v", ErrCorrupted, err)
+	}
+	if binary.LittleEndian.Uint64(sum[:]) != expected {
+		return fmt.Errorf("%w: %w", ErrCorrupted, ErrChecksumMismatch)
+	}
+	return nil
+}
+
+type hashingReader struct {
+	r    *bufio.Reader
+	hash hash.Hash64
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	_, _ = h.hash.Write(p[:n])
+	return n, err
+}
+
+func (h *hashingReader) ReadByte() (byte, error) {
+	b, err := h.r.ReadByte()
+	if err == nil {
+		_, _ = h.hash.Write([]byte{b})
+	}
+	return b, err
+}
@@ -0,0 +1,73 @@
+package pubsub
+
+import (
+	"sync"
+
+	"github.com/themedef/go-hermes/internal/types"
+)
+
+// history keeps the latest events published to one channel in a ring buffer
+// and numbers every event with an increasing ID. The counter survives resizing
+// and disabling, so IDs never repeat for a channel.
+//
+// mu also serializes publishing to the channel, so subscribers receive events
+// in ID order and a subscription resuming from an ID sees every later event
+// exactly once.
+type history struct {
+	mu   sync.Mutex
+	buf  []types.Event
+	head int
+	n    int
+	last uint64
+}
+
+func (h *history) enabled() bool {
+	return len(h.buf) > 0
+}
+
+// append numbers ev, stores it and returns it with its ID. The caller holds mu.
+func (h *history) append(ev types.Event) types.Event {
+	h.last++
+	ev.ID = h.last
+	if h.n < len(h.buf) {
+		h.buf[(h.head+h.n)%len(h.buf)] = ev
+		h.n++
+	} else {
+		h.buf[h.head] = ev
+		h.head = (h.head + 1) % len(h.buf)
+	}
+	return ev
+}
+
+// since returns the stored events with an ID above id, at most limit of them,
+// keeping the newest. The caller holds mu.
+func (h *history) since(id uint64, limit int) []types.Event {
+	if h.n == 0 || id >= h.last || limit <= 0 {
+		return nil
+	}
+	count := h.n
+	if missed := h.last - id; missed < uint64(count) {
+		count = int(missed)
+	}
+	if count > limit {
+		count = limit
+	}
+	out := make([]types.Event, count)
+	for i := range out {
+		out[i] = h.buf[(h.head+h.n-count+i)%len(h.buf)]
+	}
+	return out
+}
+
+// resize changes the capacity to size, keeping the newest events. The caller
+// holds mu.
+func (h *history) resize(size int) {
+	if size < 0 {
+		size = 0
+	}
+	kept := h.since(0, size)
+	h.buf = make([]types.Event, size)
+	copy(h.buf, kept)
+	h.head = 0
+	h.n = len(kept)
+}
@@ -0,0 +1,366 @@
+package pubsub
+
+// patternIndex stores glob patterns in a trie so that a key can be matched
+// against all of them in one pass. Patterns sharing a prefix, such as
+// "user:*:cart" and "user:*:orders", share trie nodes, and matching walks the
+// trie like an NFA: the cost depends on the key length and the number of live
+// states, not on the number of patterns.
+//
+// The syntax follows Redis PSUBSCRIBE: '*' matches any run of bytes, '?' one
+// byte, "[abc]", "[^abc]" and "[a-z]" a byte from a class, and '\' escapes
+// the next byte.
+type patternIndex struct {
+	root *patternNode
+	size int
+}
+
+type patternNode struct {
+	literal map[byte]*patternNode
+	any     *patternNode
+	star    *patternNode
+	classes map[string]*classEdge
+	// loop is set on the node a '*' leads to: it consumes any byte and stays.
+	loop bool
+	// subs holds the subscriptions of the patterns ending at this node. Spellings
+	// such as "a*" and "a**" end at the same node, so they are keyed by pattern.
+	subs map[string]map[interface{}]*subscription
+}
+
+type classEdge struct {
+	class byteClass
+	next  *patternNode
+}
+
+// byteClass is a compiled "[...]" expression.
+type byteClass struct {
+	negate bool
+	ranges [][2]byte
+}
+
+func (c byteClass) matches(b byte) bool {
+	for _, r := range c.ranges {
+		if b >= r[0] && b <= r[1] {
+			return !c.negate
+		}
+	}
+	return c.negate
+}
+
+type tokenKind int
+
+const (
+	tokenLiteral tokenKind = iota
+	tokenAny
+	tokenStar
+	tokenClass
+)
+
+type patternToken struct {
+	kind  tokenKind
+	char  byte
+	class byteClass
+	raw   string
+}
+
+// parsePattern splits a glob into tokens. Consecutive '*' collapse into one, an
+// unterminated class is taken literally and a trailing '\' matches itself.
+func parsePattern(pattern string) []patternToken {
+	tokens := make([]patternToken, 0, len(pattern))
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if n := len(tokens); n > 0 && tokens[n-1].kind == tokenStar {
+				continue
+			}
+			tokens = append(tokens, patternToken{kind: tokenStar})
+		case '?':
+			tokens = append(tokens, patternToken{kind: tokenAny})
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			tokens = append(tokens, patternToken{kind: tokenLiteral, char: pattern[i]})
+		case '[':
+			class, end, ok := parseClass(pattern, i)
+			if !ok {
+				tokens = append(tokens, patternToken{kind: tokenLiteral, char: c})
+				continue
+			}
+			tokens = append(tokens, patternToken{kind: tokenClass, class: class, raw: pattern[i : end+1]})
+			i = end
+		default:
+			tokens = append(tokens, patternToken{kind: tokenLiteral, char: c})
+		}
+	}
+	return tokens
+}
+
+// parseClass compiles the class starting at pattern[start] == '[' and returns
+// the index of its closing ']'.
+func parseClass(pattern string, start int) (byteClass, int, bool) {
+	var class byteClass
+	i := start + 1
+	if i < len(pattern) && pattern[i] == '^' {
+		class.negate = true
+		i++
+	}
+	for ; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == ']':
+			return class, i, true
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			class.ranges = append(class.ranges, [2]byte{pattern[i], pattern[i]})
+		case i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']':
+			lo, hi := c, pattern[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			class.ranges = append(class.ranges, [2]byte{lo, hi})
+			i += 2
+		default:
+			class.ranges = append(class.ranges, [2]byte{c, c})
+		}
+	}
+	return byteClass{}, 0, false
+}
+
+func newPatternIndex() *patternIndex {
+	return &patternIndex{root: &patternNode{}}
+}
+
+// add registers sub for pattern.
+func (idx *patternIndex) add(pattern string, sub *subscription) {
+	n := idx.root
+	for _, tok := range parsePattern(pattern) {
+		n = n.child(tok)
+	}
+	if n.subs == nil {
+		n.subs = make(map[string]map[interface{}]*subscription)
+	}
+	if n.subs[pattern] == nil {
+		n.subs[pattern] = make(map[interface{}]*subscription)
+	}
+	if _, ok := n.subs[pattern][sub.id]; !ok {
+		n.subs[pattern][sub.id] = sub
+		idx.size++
+	}
+}
+
+// remove unregisters the subscription id from pattern and prunes nodes left
+// without patterns.
+func (idx *patternIndex) remove(pattern string, id interface{}) (*subscription, bool) {
+	tokens := parsePattern(pattern)
+	path := make([]*patternNode, 0, len(tokens)+1)
+	n := idx.root
+	path = append(path, n)
+	for _, tok := range tokens {
+		if n = n.lookup(tok); n == nil {
+			return nil, false
+		}
+		path = append(path, n)
+	}
+	sub, ok := n.subs[pattern][id]
+	if !ok {
+		return nil, false
+	}
+	delete(n.subs[pattern], id)
+	idx.size--
+	if len(n.subs[pattern]) == 0 {
+		delete(n.subs, pattern)
+	}
+	for i := len(tokens) - 1; i >= 0; i-- {
+		if !path[i+1].empty() {
+			break
+		}
+		path[i].unlink(tokens[i])
+	}
+	return sub, true
+}
+
+// removePattern drops every subscription of pattern and returns them.
+func (idx *patternIndex) removePattern(pattern string) []*subscription {
+	n := idx.root
+	for _, tok := range parsePattern(pattern) {
+		if n = n.lookup(tok); n == nil {
+			return nil
+		}
+	}
+	subs := make([]*subscription, 0, len(n.subs[pattern]))
+	for _, sub := range n.subs[pattern] {
+		subs = append(subs, sub)
+	}
+	for _, sub := range subs {
+		idx.remove(pattern, sub.id)
+	}
+	return subs
+}
+
+// match calls fn for every subscription whose pattern matches key.
+func (idx *patternIndex) match(key string, fn func(pattern string, sub *subscription)) {
+	if idx.size == 0 {
+		return
+	}
+	current := make(map[*patternNode]struct{})
+	addState(current, idx.root)
+	next := make(map[*patternNode]struct{})
+	for i := 0; i < len(key) && len(current) > 0; i++ {
+		b := key[i]
+		for n := range current {
+			if n.loop {
+				addState(next, n)
+			}
+			if m := n.literal[b]; m != nil {
+				addState(next, m)
+			}
+			if n.any != nil {
+				addState(next, n.any)
+			}
+			for _, e := range n.classes {
+				if e.class.matches(b) {
+					addState(next, e.next)
+				}
+			}
+		}
+		current, next = next, current
+		for n := range next {
+			delete(next, n)
+		}
+	}
+	for n := range current {
+		for pattern, subs := range n.subs {
+			for _, sub := range subs {
+				fn(pattern, sub)
+			}
+		}
+	}
+}
+
+// patterns returns every pattern with at least one subscription.
+func (idx *patternIndex) patterns() []string {
+	var out []string
+	idx.root.walk(func(n *patternNode) {
+		for pattern := range n.subs {
+			out = append(out, pattern)
+		}
+	})
+	return out
+}
+
+// each calls fn for every registered subscription.
+func (idx *patternIndex) each(fn func(pattern string, sub *subscription)) {
+	idx.root.walk(func(n *patternNode) {
+		for pattern, subs := range n.subs {
+			for _, sub := range subs {
+				fn(pattern, sub)
+			}
+		}
+	})
+}
+
+// drain returns every registered subscription and empties the index.
+func (idx *patternIndex) drain() []*subscription {
+	var out []*subscription
+	idx.each(func(_ string, sub *subscription) {
+		out = append(out, sub)
+	})
+	idx.root = &patternNode{}
+	idx.size = 0
+	return out
+}
+
+// addState adds n and, since '*' may match nothing, the '*' nodes reachable
+// from it without consuming a byte.
+func addState(states map[*patternNode]struct{}, n *patternNode) {
+	for n != nil {
+		if _, ok := states[n]; ok {
+			return
+		}
+		states[n] = struct{}{}
+		n = n.star
+	}
+}
+
+func (n *patternNode) child(tok patternToken) *patternNode {
+	if m := n.lookup(tok); m != nil {
+		return m
+	}
+	m := &patternNode{}
+	switch tok.kind {
+	case tokenLiteral:
+		if n.literal == nil {
+			n.literal = make(map[byte]*patternNode)
+		}
+		n.literal[tok.char] = m
+	case tokenAny:
+		n.any = m
+	case tokenStar:
+		m.loop = true
+		n.star = m
+	case tokenClass:
+		if n.classes == nil {
+			n.classes = make(map[string]*classEdge)
+		}
+		n.classes[tok.raw] = &classEdge{class: tok.class, next: m}
+	}
+	return m
+}
+
+func (n *patternNode) lookup(tok patternToken) *patternNode {
+	switch tok.kind {
+	case tokenLiteral:
+		return n.literal[tok.char]
+	case tokenAny:
+		return n.any
+	case tokenStar:
+		return n.star
+	case tokenClass:
+		if e := n.classes[tok.raw]; e != nil {
+			return e.next
+		}
+	}
+	return nil
+}
+
+func (n *patternNode) unlink(tok patternToken) {
+	switch tok.kind {
+	case tokenLiteral:
+		delete(n.literal, tok.char)
+	case tokenAny:
+		n.any = nil
+	case tokenStar:
+		n.star = nil
+	case tokenClass:
+		delete(n.classes, tok.raw)
+	}
+}
+
+func (n *patternNode) empty() bool {
+	return len(n.subs) == 0 && len(n.literal) == 0 && n.any == nil && n.star == nil && len(n.classes) == 0
+}
+
+func (n *patternNode) walk(fn func(n *patternNode)) {
+	fn(n)
+	for _, m := range n.literal {
+		m.walk(fn)
+	}
+	if n.any != nil {
+		n.any.walk(fn)
+	}
+	if n.star != nil {
+		n.star.walk(fn)
+	}
+	for _, e := range n.classes {
+		e.next.walk(fn)
+	}
+}
+
+// Match reports whether key matches the glob pattern.
+func Match(pattern, key string) bool {
+	idx := newPatternIndex()
+	idx.add(pattern, &subscription{id: pattern})
+	matched := false
+	idx.match(key, func(string, *subscription) { matched = true })
+	return matched
+}
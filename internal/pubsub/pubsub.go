@@ -1,9 +1,14 @@
 package pubsub
 
 import (
+	"context"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/themedef/go-hermes/internal/contracts"
+	"github.com/themedef/go-hermes/internal/types"
 )
 
 type Config struct {
@@ -12,8 +17,16 @@ type Config struct {
 
 type PubSub struct {
 	mu          sync.RWMutex
-	subscribers map[string]map[chan string]struct{}
+	subscribers map[string]map[interface{}]*subscription
+	patterns    *patternIndex
 	bufferSize  int
+	// histories holds the channels with retention; retained counts those with a
+	// non-zero size.
+	histories map[string]*history
+	retained  atomic.Int64
+	// active mirrors whether any subscription or retained channel exists, so
+	// publishers can skip building events without taking mu.
+	active atomic.Bool
 }
 
 func NewPubSub(config Config) contracts.PubSubHandler {
@@ -22,11 +35,80 @@ func NewPubSub(config Config) contracts.PubSubHandler {
 		bs = config.BufferSize
 	}
 	return &PubSub{
-		subscribers: make(map[string]map[chan string]struct{}),
+		subscribers: make(map[string]map[interface{}]*subscription),
+		patterns:    newPatternIndex(),
 		bufferSize:  bs,
+		histories:   make(map[string]*history),
 	}
 }
 
+// HasSubscribers reports whether a published event would be seen by anyone:
+// a key or pattern subscription, or a channel keeping history.
+func (ps *PubSub) HasSubscribers() bool {
+	return ps.active.Load()
+}
+
+// updateActive refreshes active. The caller holds mu.
+func (ps *PubSub) updateActive() {
+	ps.active.Store(len(ps.subscribers) > 0 || ps.patterns.size > 0 || ps.retained.Load() > 0)
+}
+
+// SetRetention makes key keep its last size events, numbered with increasing
+// IDs, for History and for subscriptions resuming with
+// SubscribeOptions.LastID. Resizing keeps the newest events; a size of zero or
+// less drops the history and stops numbering, but IDs continue from where they
+// were if retention is enabled again.
+func (ps *PubSub) SetRetention(key string, size int) {
+	ps.mu.Lock()
+	h := ps.histories[key]
+	if h == nil {
+		if size <= 0 {
+			ps.mu.Unlock()
+			return
+		}
+		h = &history{}
+		ps.histories[key] = h
+	}
+	ps.mu.Unlock()
+
+	h.mu.Lock()
+	was := h.enabled()
+	h.resize(size)
+	now := h.enabled()
+	h.mu.Unlock()
+
+	if was != now {
+		if now {
+			ps.retained.Add(1)
+		} else {
+			ps.retained.Add(-1)
+		}
+		ps.mu.Lock()
+		ps.updateActive()
+		ps.mu.Unlock()
+	}
+}
+
+// History returns the retained events of key with an ID above after, oldest
+// first.
+func (ps *PubSub) History(key string, after uint64) []types.Event {
+	h := ps.history(key)
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.since(after, h.n)
+}
+
+// history returns the history of key, nil if retention was never enabled for
+// it.
+func (ps *PubSub) history(key string) *history {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return ps.histories[key]
+}
+
 func (ps *PubSub) ListSubscribers() []string {
 	ps.mu.RLock()
 	defer ps.mu.RUnlock()
@@ -38,52 +120,310 @@ func (ps *PubSub) ListSubscribers() []string {
 	return keys
 }
 
-func (ps *PubSub) Subscribe(key string) chan string {
+// Subscribe delivers the events of key as strings, in the format of
+// types.Event.String. opts selects the buffer size, what happens when the
+// subscriber falls behind (by default new messages are dropped) and where to
+// resume a retained channel.
+func (ps *PubSub) Subscribe(key string, opts ...types.SubscribeOptions) chan string {
+	opt := ps.options(opts)
+	ch := make(chan string, opt.BufferSize)
+	ps.add(key, newSubscription(key, false, ch, opt, func(d *delivery, _ string) string {
+		return d.String()
+	}), opt)
+	return ch
+}
+
+// SubscribeContext is Subscribe for a subscription that ends with ctx: when ctx
+// is done the subscription is removed and the channel closed.
+func (ps *PubSub) SubscribeContext(ctx context.Context, key string, opts ...types.SubscribeOptions) <-chan string {
+	ch := ps.Subscribe(key, opts...)
+	ps.bind(ctx, key, ch)
+	return ch
+}
+
+// SubscribeEvents delivers the events of key as types.Event values.
+func (ps *PubSub) SubscribeEvents(key string, opts ...types.SubscribeOptions) chan types.Event {
+	opt := ps.options(opts)
+	ch := make(chan types.Event, opt.BufferSize)
+	ps.add(key, newSubscription(key, false, ch, opt, func(d *delivery, _ string) types.Event {
+		return d.event
+	}), opt)
+	return ch
+}
+
+// SubscribeEventsContext is SubscribeEvents for a subscription that ends with
+// ctx.
+func (ps *PubSub) SubscribeEventsContext(ctx context.Context, key string, opts ...types.SubscribeOptions) <-chan types.Event {
+	ch := ps.SubscribeEvents(key, opts...)
+	ps.bind(ctx, key, ch)
+	return ch
+}
+
+// bind removes the subscription id of key once ctx is done. It returns early
+// if the subscription is closed some other way.
+func (ps *PubSub) bind(ctx context.Context, key string, id interface{}) {
+	ps.mu.RLock()
+	sub := ps.subscribers[key][id]
+	ps.mu.RUnlock()
+	if sub == nil {
+		return
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			ps.remove(key, id)
+		case <-sub.done:
+		}
+	}()
+}
+
+func (ps *PubSub) Unsubscribe(key string, ch chan string) {
+	ps.remove(key, ch)
+}
+
+func (ps *PubSub) UnsubscribeEvents(key string, ch chan types.Event) {
+	ps.remove(key, ch)
+}
+
+// add registers sub for key. It first queues opt.InitialMessage and, if
+// opt.LastID is set, the retained events after it, as many as fit the buffer.
+func (ps *PubSub) add(key string, sub *subscription, opt types.SubscribeOptions) {
+	if opt.InitialMessage != "" {
+		sub.send(&delivery{event: types.Event{
+			Op:        types.EventMessage,
+			Key:       key,
+			NewValue:  opt.InitialMessage,
+			Timestamp: time.Now(),
+			Origin:    types.OriginPublish,
+		}}, "")
+	}
+	if opt.LastID > 0 {
+		if h := ps.history(key); h != nil {
+			// Holding the history lock keeps publishers out until the
+			// subscription is registered, so no event is missed or repeated.
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			buffered, capacity := sub.buffer()
+			for _, ev := range h.since(opt.LastID, capacity-buffered) {
+				sub.deliver(&delivery{event: ev}, "")
+			}
+		}
+	}
+
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
 
-	ch := make(chan string, ps.bufferSize)
 	if ps.subscribers[key] == nil {
-		ps.subscribers[key] = make(map[chan string]struct{})
+		ps.subscribers[key] = make(map[interface{}]*subscription)
+	}
+	ps.subscribers[key][sub.id] = sub
+	ps.updateActive()
+}
+
+func (ps *PubSub) remove(key string, id interface{}) {
+	ps.mu.Lock()
+	subscribers := ps.subscribers[key]
+	sub, found := subscribers[id]
+	if found {
+		delete(subscribers, id)
+		if len(subscribers) == 0 {
+			delete(ps.subscribers, key)
+		}
+		ps.updateActive()
 	}
-	ps.subscribers[key][ch] = struct{}{}
+	ps.mu.Unlock()
+
+	if found {
+		sub.shut()
+	}
+}
+
+// PSubscribe subscribes to every key matching the glob pattern. Each message
+// carries the pattern and the key it was published to.
+func (ps *PubSub) PSubscribe(pattern string, opts ...types.SubscribeOptions) chan types.PatternMessage {
+	opt := ps.options(opts)
+	ch := make(chan types.PatternMessage, opt.BufferSize)
+	ps.padd(pattern, newSubscription(pattern, true, ch, opt, func(d *delivery, pattern string) types.PatternMessage {
+		return types.PatternMessage{Pattern: pattern, Key: d.event.Key, Message: d.String()}
+	}))
 	return ch
 }
 
-func (ps *PubSub) Unsubscribe(key string, ch chan string) {
+// PSubscribeEvents delivers the events of every key matching the glob pattern,
+// with Event.Pattern set to pattern.
+func (ps *PubSub) PSubscribeEvents(pattern string, opts ...types.SubscribeOptions) chan types.Event {
+	opt := ps.options(opts)
+	ch := make(chan types.Event, opt.BufferSize)
+	ps.padd(pattern, newSubscription(pattern, true, ch, opt, func(d *delivery, pattern string) types.Event {
+		ev := d.event
+		ev.Pattern = pattern
+		return ev
+	}))
+	return ch
+}
+
+func (ps *PubSub) PUnsubscribe(pattern string, ch chan types.PatternMessage) {
+	ps.premove(pattern, ch)
+}
+
+func (ps *PubSub) PUnsubscribeEvents(pattern string, ch chan types.Event) {
+	ps.premove(pattern, ch)
+}
+
+func (ps *PubSub) padd(pattern string, sub *subscription) {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
 
-	if subscribers, exists := ps.subscribers[key]; exists {
-		if _, found := subscribers[ch]; found {
-			delete(subscribers, ch)
-			if len(subscribers) == 0 {
-				delete(ps.subscribers, key)
-			}
-		}
+	ps.patterns.add(pattern, sub)
+	ps.updateActive()
+}
+
+func (ps *PubSub) premove(pattern string, id interface{}) {
+	ps.mu.Lock()
+	sub, removed := ps.patterns.remove(pattern, id)
+	ps.updateActive()
+	ps.mu.Unlock()
+
+	if removed {
+		sub.shut()
+	}
+}
+
+func (ps *PubSub) UnsubscribeAllForPattern(pattern string) {
+	ps.mu.Lock()
+	subs := ps.patterns.removePattern(pattern)
+	ps.updateActive()
+	ps.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.shut()
 	}
-	close(ch)
 }
 
-func (ps *PubSub) Publish(key, message string) {
+// NumSub returns the number of subscriptions to each of keys, not counting
+// pattern subscriptions.
+func (ps *PubSub) NumSub(keys ...string) map[string]int {
 	ps.mu.RLock()
-	subscribers, exists := ps.subscribers[key]
-	if !exists {
-		ps.mu.RUnlock()
-		return
+	defer ps.mu.RUnlock()
+
+	counts := make(map[string]int, len(keys))
+	for _, key := range keys {
+		counts[key] = len(ps.subscribers[key])
 	}
-	localChans := make([]chan string, 0, len(subscribers))
-	for ch := range subscribers {
-		localChans = append(localChans, ch)
+	return counts
+}
+
+// NumPat returns the number of distinct patterns with at least one
+// subscription.
+func (ps *PubSub) NumPat() int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	return len(ps.patterns.patterns())
+}
+
+func (ps *PubSub) ListPatterns() []string {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	return ps.patterns.patterns()
+}
+
+// Publish sends an application message to the subscribers of key and returns
+// how many subscriptions received it.
+func (ps *PubSub) Publish(key, message string) int {
+	return ps.PublishEvent(types.Event{
+		Op:       types.EventMessage,
+		Key:      key,
+		NewValue: message,
+		Origin:   types.OriginPublish,
+	})
+}
+
+// PublishEvent sends ev to the subscribers of ev.Key and of every pattern
+// matching it, and returns how many received it. A subscriber whose buffer is
+// full is handled by its slow consumer policy; if the event is dropped it is
+// not counted, and a PolicyDisconnect subscription is closed.
+func (ps *PubSub) PublishEvent(ev types.Event) int {
+	if !ps.HasSubscribers() {
+		return 0
+	}
+
+	type target struct {
+		sub     *subscription
+		pattern string
+	}
+
+	if ev.Timestamp.IsZero() {
+		ev.Timestamp = time.Now()
+	}
+	if ps.retained.Load() > 0 {
+		if h := ps.history(ev.Key); h != nil {
+			// Numbering and delivery happen under the history lock, so
+			// subscribers see a retained channel's events in ID order.
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			if h.enabled() {
+				ev = h.append(ev)
+			}
+		}
 	}
+
+	ps.mu.RLock()
+	subscribers := ps.subscribers[ev.Key]
+	targets := make([]target, 0, len(subscribers))
+	for _, sub := range subscribers {
+		targets = append(targets, target{sub: sub})
+	}
+	ps.patterns.match(ev.Key, func(pattern string, sub *subscription) {
+		targets = append(targets, target{sub: sub, pattern: pattern})
+	})
 	ps.mu.RUnlock()
 
-	for _, ch := range localChans {
-		select {
-		case ch <- message:
-		default:
+	if len(targets) == 0 {
+		return 0
+	}
+	d := &delivery{event: ev}
+	received := 0
+	var slow []*subscription
+	for _, t := range targets {
+		if t.sub.deliver(d, t.pattern) {
+			received++
+		} else if t.sub.policy == types.PolicyDisconnect {
+			slow = append(slow, t.sub)
+		}
+	}
+	for _, sub := range slow {
+		if sub.pattern {
+			ps.premove(sub.key, sub.id)
+		} else {
+			ps.remove(sub.key, sub.id)
 		}
 	}
+	return received
+}
+
+// Stats returns the policy, buffer use and delivery counters of every
+// subscription, key subscriptions first, each group ordered by channel.
+func (ps *PubSub) Stats() []types.SubscriptionStats {
+	ps.mu.RLock()
+	var keys, patterns []types.SubscriptionStats
+	for _, subs := range ps.subscribers {
+		for _, sub := range subs {
+			keys = append(keys, sub.stats())
+		}
+	}
+	ps.patterns.each(func(_ string, sub *subscription) {
+		patterns = append(patterns, sub.stats())
+	})
+	ps.mu.RUnlock()
+
+	byChannel := func(stats []types.SubscriptionStats) {
+		sort.SliceStable(stats, func(i, j int) bool { return stats[i].Channel < stats[j].Channel })
+	}
+	byChannel(keys)
+	byChannel(patterns)
+	return append(keys, patterns...)
 }
 
 func (ps *PubSub) UnsubscribeAllForKey(key string) {
@@ -91,30 +431,29 @@ func (ps *PubSub) UnsubscribeAllForKey(key string) {
 	subscribers, exists := ps.subscribers[key]
 	if exists {
 		delete(ps.subscribers, key)
+		ps.updateActive()
 	}
 	ps.mu.Unlock()
 
-	if !exists {
-		return
-	}
-	localChans := make([]chan string, 0, len(subscribers))
-	for ch := range subscribers {
-		localChans = append(localChans, ch)
-	}
-	for _, ch := range localChans {
-		close(ch)
+	for _, sub := range subscribers {
+		sub.shut()
 	}
 }
 
 func (ps *PubSub) Close() {
 	ps.mu.Lock()
 	subsCopy := ps.subscribers
-	ps.subscribers = make(map[string]map[chan string]struct{})
+	ps.subscribers = make(map[string]map[interface{}]*subscription)
+	patternSubs := ps.patterns.drain()
+	ps.updateActive()
 	ps.mu.Unlock()
 
 	for _, subs := range subsCopy {
-		for ch := range subs {
-			close(ch)
+		for _, sub := range subs {
+			sub.shut()
 		}
 	}
+	for _, sub := range patternSubs {
+		sub.shut()
+	}
 }
@@ -1,9 +1,13 @@
 package pubsub
 
 import (
+	"context"
+	"fmt"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/themedef/go-hermes/internal/types"
 )
 
 func TestPubSubBasic(t *testing.T) {
@@ -98,3 +102,416 @@ func TestPubSubBufferOverflow(t *testing.T) {
 		t.Errorf("Expected message in buffer, but got nothing")
 	}
 }
+
+func TestPatternMatching(t *testing.T) {
+	cases := []struct {
+		pattern string
+		key     string
+		match   bool
+	}{
+		{"session:*", "session:42", true},
+		{"session:*", "session:", true},
+		{"session:*", "sessions", false},
+		{"user:*:cart", "user:7:cart", true},
+		{"user:*:cart", "user:7:orders", false},
+		{"user:*:cart", "user:a:b:cart", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"a**b", "ab", true},
+		{"*", "", true},
+		{"[abc", "[abc", true},
+		{"exact", "exact", true},
+		{"exact", "exactly", false},
+	}
+	for _, c := range cases {
+		idx := newPatternIndex()
+		idx.add(c.pattern, &subscription{id: c.pattern})
+		matched := false
+		idx.match(c.key, func(pattern string, _ *subscription) {
+			matched = pattern == c.pattern
+		})
+		if matched != c.match {
+			t.Errorf("pattern %q key %q: expected match=%v", c.pattern, c.key, c.match)
+		}
+	}
+}
+
+func TestPSubscribe(t *testing.T) {
+	ps := NewPubSub(Config{})
+
+	sessions := ps.PSubscribe("session:*")
+	carts := ps.PSubscribe("user:*:cart")
+	exact := ps.Subscribe("session:1")
+
+	ps.Publish("session:1", "login")
+	ps.Publish("user:9:cart", "add")
+	ps.Publish("user:9:orders", "ignored")
+
+	if msg := <-sessions; msg != (types.PatternMessage{Pattern: "session:*", Key: "session:1", Message: "login"}) {
+		t.Errorf("Unexpected pattern message %+v", msg)
+	}
+	if msg := <-carts; msg != (types.PatternMessage{Pattern: "user:*:cart", Key: "user:9:cart", Message: "add"}) {
+		t.Errorf("Unexpected pattern message %+v", msg)
+	}
+	if msg := <-exact; msg != "login" {
+		t.Errorf("Expected exact subscriber to get 'login', got %q", msg)
+	}
+	select {
+	case msg := <-carts:
+		t.Errorf("Unexpected message %+v", msg)
+	default:
+	}
+
+	ps.PUnsubscribe("session:*", sessions)
+	if _, open := <-sessions; open {
+		t.Error("Channel should be closed after PUnsubscribe")
+	}
+	if patterns := ps.ListPatterns(); len(patterns) != 1 || patterns[0] != "user:*:cart" {
+		t.Errorf("Expected [user:*:cart], got %v", patterns)
+	}
+
+	ps.Close()
+	if _, open := <-carts; open {
+		t.Error("Channel should be closed after PubSub Close()")
+	}
+}
+
+func TestPSubscribeManyPatterns(t *testing.T) {
+	ps := NewPubSub(Config{BufferSize: 1})
+
+	chans := make([]chan types.PatternMessage, 5000)
+	for i := range chans {
+		chans[i] = ps.PSubscribe(fmt.Sprintf("tenant:%d:*", i))
+	}
+	all := ps.PSubscribe("tenant:*")
+
+	ps.Publish("tenant:1234:orders", "created")
+
+	for i, ch := range chans {
+		select {
+		case msg := <-ch:
+			if i != 1234 {
+				t.Fatalf("pattern %d unexpectedly received %+v", i, msg)
+			}
+		default:
+			if i == 1234 {
+				t.Fatal("tenant:1234:* did not receive the message")
+			}
+		}
+	}
+	if msg := <-all; msg.Pattern != "tenant:*" || msg.Key != "tenant:1234:orders" {
+		t.Errorf("Unexpected message %+v", msg)
+	}
+
+	for i, ch := range chans {
+		ps.PUnsubscribe(fmt.Sprintf("tenant:%d:*", i), ch)
+	}
+	if patterns := ps.ListPatterns(); len(patterns) != 1 {
+		t.Errorf("Expected only tenant:* left, got %d patterns", len(patterns))
+	}
+}
+
+func TestSubscribeEvents(t *testing.T) {
+	ps := NewPubSub(Config{})
+
+	events := ps.SubscribeEvents("user")
+	legacy := ps.Subscribe("user")
+
+	ps.PublishEvent(types.Event{Op: types.EventGetSet, Key: "user", OldValue: "ann", NewValue: "bob"})
+	ps.Publish("user", "hello")
+
+	ev := <-events
+	if ev.Op != types.EventGetSet || ev.OldValue != "ann" || ev.NewValue != "bob" || ev.Timestamp.IsZero() {
+		t.Errorf("Unexpected event %+v", ev)
+	}
+	if msg := <-legacy; msg != "GETSET: ann -> bob" {
+		t.Errorf("Expected 'GETSET: ann -> bob', got %q", msg)
+	}
+
+	ev = <-events
+	if ev.Op != types.EventMessage || ev.NewValue != "hello" || ev.Origin != types.OriginPublish {
+		t.Errorf("Unexpected message event %+v", ev)
+	}
+	if msg := <-legacy; msg != "hello" {
+		t.Errorf("Expected 'hello', got %q", msg)
+	}
+
+	ps.UnsubscribeEvents("user", events)
+	ps.UnsubscribeEvents("user", events)
+	if _, open := <-events; open {
+		t.Error("Channel should be closed after UnsubscribeEvents")
+	}
+}
+
+func TestHasSubscribers(t *testing.T) {
+	ps := NewPubSub(Config{BufferSize: 1})
+	if ps.HasSubscribers() {
+		t.Fatal("Expected no subscribers on a new PubSub")
+	}
+
+	ch := ps.Subscribe("a")
+	pch := ps.PSubscribe("b*")
+	if !ps.HasSubscribers() {
+		t.Fatal("Expected subscribers after Subscribe")
+	}
+
+	ps.Unsubscribe("a", ch)
+	if !ps.HasSubscribers() {
+		t.Fatal("Expected the pattern subscription to remain")
+	}
+	ps.PUnsubscribe("b*", pch)
+	if ps.HasSubscribers() {
+		t.Error("Expected no subscribers after unsubscribing everything")
+	}
+
+	ps.SubscribeEvents("c")
+	ps.Close()
+	if ps.HasSubscribers() {
+		t.Error("Expected no subscribers after Close")
+	}
+}
+
+func TestPublishReceivers(t *testing.T) {
+	ps := NewPubSub(Config{BufferSize: 1})
+
+	if n := ps.Publish("news", "nobody"); n != 0 {
+		t.Errorf("Expected 0 receivers, got %d", n)
+	}
+
+	a := ps.Subscribe("news")
+	ps.Subscribe("news")
+	ps.PSubscribe("n*")
+	ps.PSubscribe("n*")
+	ps.PSubscribe("x*")
+
+	if n := ps.Publish("news", "hello"); n != 4 {
+		t.Errorf("Expected 4 receivers, got %d", n)
+	}
+	// Every buffer is full now, so nobody takes the next message.
+	if n := ps.Publish("news", "dropped"); n != 0 {
+		t.Errorf("Expected 0 receivers with full buffers, got %d", n)
+	}
+	<-a
+	if n := ps.Publish("news", "again"); n != 1 {
+		t.Errorf("Expected 1 receiver, got %d", n)
+	}
+
+	counts := ps.NumSub("news", "other")
+	if counts["news"] != 2 || counts["other"] != 0 {
+		t.Errorf("Unexpected NumSub %v", counts)
+	}
+	if n := ps.NumPat(); n != 2 {
+		t.Errorf("Expected 2 patterns, got %d", n)
+	}
+	if !Match("n*", "news") || Match("x*", "news") {
+		t.Error("Unexpected Match result")
+	}
+}
+
+func TestSlowConsumerPolicies(t *testing.T) {
+	ps := NewPubSub(Config{BufferSize: 2})
+
+	newest := ps.Subscribe("k")
+	oldest := ps.Subscribe("k", types.SubscribeOptions{Policy: types.PolicyDropOldest})
+	block := ps.Subscribe("k", types.SubscribeOptions{Policy: types.PolicyBlock, BlockTimeout: 10 * time.Millisecond})
+	disconnect := ps.PSubscribe("k*", types.SubscribeOptions{Policy: types.PolicyDisconnect})
+
+	for i := 1; i <= 3; i++ {
+		ps.Publish("k", fmt.Sprintf("m%d", i))
+	}
+
+	expect := func(name string, ch chan string, want ...string) {
+		t.Helper()
+		for _, w := range want {
+			if got := <-ch; got != w {
+				t.Errorf("%s: expected %q, got %q", name, w, got)
+			}
+		}
+	}
+	expect("drop-newest", newest, "m1", "m2")
+	expect("drop-oldest", oldest, "m2", "m3")
+	expect("block", block, "m1", "m2")
+
+	// The disconnected subscription is closed after its buffered messages.
+	var got []string
+	for msg := range disconnect {
+		got = append(got, msg.Message)
+	}
+	if len(got) != 2 || got[0] != "m1" || got[1] != "m2" {
+		t.Errorf("disconnect: unexpected messages %v", got)
+	}
+	if n := ps.NumPat(); n != 0 {
+		t.Errorf("Expected the disconnected pattern to be removed, got %d patterns", n)
+	}
+
+	// A blocked publisher completes once the reader makes room.
+	slow := ps.Subscribe("slow", types.SubscribeOptions{Policy: types.PolicyBlock, BlockTimeout: time.Second})
+	ps.Publish("slow", "a")
+	ps.Publish("slow", "b")
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-slow
+	}()
+	if n := ps.Publish("slow", "c"); n != 1 {
+		t.Errorf("Expected the blocked publish to be received, got %d receivers", n)
+	}
+}
+
+func TestSubscriptionStats(t *testing.T) {
+	ps := NewPubSub(Config{BufferSize: 2})
+
+	ps.Subscribe("b")
+	a := ps.SubscribeEvents("a", types.SubscribeOptions{Policy: types.PolicyDropOldest})
+	ps.PSubscribe("*")
+
+	for i := 0; i < 3; i++ {
+		ps.Publish("a", "x")
+	}
+	ps.Publish("b", "y")
+	<-a
+
+	stats := ps.Stats()
+	if len(stats) != 3 {
+		t.Fatalf("Expected 3 subscriptions, got %d", len(stats))
+	}
+	want := []types.SubscriptionStats{
+		{Channel: "a", Policy: types.PolicyDropOldest, Buffered: 1, Capacity: 2, Delivered: 3, Dropped: 1},
+		{Channel: "b", Buffered: 1, Capacity: 2, Delivered: 1},
+		{Channel: "*", Pattern: true, Buffered: 2, Capacity: 2, Delivered: 2, Dropped: 2},
+	}
+	for i, w := range want {
+		if stats[i] != w {
+			t.Errorf("Stats[%d]: expected %+v, got %+v", i, w, stats[i])
+		}
+	}
+
+	// Closed subscriptions disappear from the stats.
+	ps.UnsubscribeEvents("a", a)
+	if stats := ps.Stats(); len(stats) != 2 {
+		t.Errorf("Expected 2 subscriptions after unsubscribe, got %d", len(stats))
+	}
+}
+
+func TestSubscribeContext(t *testing.T) {
+	ps := NewPubSub(Config{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := ps.SubscribeContext(ctx, "k", types.SubscribeOptions{BufferSize: 2, InitialMessage: "hello"})
+	if c := cap(ch); c != 2 {
+		t.Errorf("Expected buffer size 2, got %d", c)
+	}
+	ps.Publish("k", "m1")
+	ps.Publish("k", "m2")
+	if stats := ps.Stats(); len(stats) != 1 || stats[0].Delivered != 1 || stats[0].Dropped != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if msg := <-ch; msg != "hello" {
+		t.Errorf("Expected the initial message first, got %q", msg)
+	}
+	if msg := <-ch; msg != "m1" {
+		t.Errorf("Expected 'm1', got %q", msg)
+	}
+
+	cancel()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Error("Expected channel to be closed after cancel")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Channel not closed after cancel")
+	}
+	if ps.HasSubscribers() {
+		t.Error("Expected no subscribers after cancel")
+	}
+
+	// A subscription closed by other means still closes its channel once.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	ch = ps.SubscribeContext(ctx, "k")
+	if c := cap(ch); c != 10000 {
+		t.Errorf("Expected the default buffer size, got %d", c)
+	}
+	ps.UnsubscribeAllForKey("k")
+	if _, ok := <-ch; ok {
+		t.Error("Expected channel to be closed")
+	}
+}
+
+func TestRetention(t *testing.T) {
+	ps := NewPubSub(Config{})
+
+	// Scenario 1: without retention nothing is kept or numbered.
+	ps.Publish("orders", "lost")
+	if h := ps.History("orders", 0); len(h) != 0 {
+		t.Errorf("Expected no history, got %v", h)
+	}
+
+	// Scenario 2: the ring buffer keeps the newest messages with increasing IDs,
+	// even with no subscriber around.
+	ps.SetRetention("orders", 3)
+	if !ps.HasSubscribers() {
+		t.Error("Expected a retained channel to count as a subscriber")
+	}
+	for i := 1; i <= 5; i++ {
+		ps.Publish("orders", fmt.Sprintf("m%d", i))
+	}
+	messages := func(events []types.Event) string {
+		out := ""
+		for _, ev := range events {
+			out += fmt.Sprintf("%d:%v ", ev.ID, ev.NewValue)
+		}
+		return out
+	}
+	if got := messages(ps.History("orders", 0)); got != "3:m3 4:m4 5:m5 " {
+		t.Errorf("Unexpected history %q", got)
+	}
+	if got := messages(ps.History("orders", 4)); got != "5:m5 " {
+		t.Errorf("Unexpected history after 4 %q", got)
+	}
+
+	// Scenario 3: a subscriber resuming from an ID gets what it missed, then
+	// live messages, all numbered.
+	ch := ps.SubscribeEvents("orders", types.SubscribeOptions{LastID: 3})
+	ps.Publish("orders", "m6")
+	for _, want := range []uint64{4, 5, 6} {
+		if ev := <-ch; ev.ID != want {
+			t.Errorf("Expected event %d, got %d", want, ev.ID)
+		}
+	}
+	ps.UnsubscribeEvents("orders", ch)
+
+	// Scenario 4: replay is limited to the free buffer space, newest first.
+	small := ps.Subscribe("orders", types.SubscribeOptions{LastID: 1, BufferSize: 2, InitialMessage: "hi"})
+	if got := []string{<-small, <-small}; got[0] != "hi" || got[1] != "m6" {
+		t.Errorf("Unexpected replay %v", got)
+	}
+	ps.Unsubscribe("orders", small)
+
+	// Scenario 5: shrinking keeps the newest; disabling drops the history but
+	// IDs continue when it is enabled again.
+	ps.SetRetention("orders", 1)
+	if got := messages(ps.History("orders", 0)); got != "6:m6 " {
+		t.Errorf("Unexpected history after resize %q", got)
+	}
+	ps.SetRetention("orders", 0)
+	if ps.HasSubscribers() {
+		t.Error("Expected no subscribers after disabling retention")
+	}
+	if h := ps.History("orders", 0); len(h) != 0 {
+		t.Errorf("Expected no history after disabling, got %v", h)
+	}
+	ps.SetRetention("orders", 2)
+	ps.Publish("orders", "m7")
+	if got := messages(ps.History("orders", 0)); got != "7:m7 " {
+		t.Errorf("Unexpected history after re-enabling %q", got)
+	}
+}
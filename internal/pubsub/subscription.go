@@ -0,0 +1,164 @@
+package pubsub
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/themedef/go-hermes/internal/types"
+)
+
+// defaultBlockTimeout is how long PolicyBlock waits when
+// SubscribeOptions.BlockTimeout is zero.
+const defaultBlockTimeout = 100 * time.Millisecond
+
+// subscription is one subscriber channel. id is the channel handed to the
+// caller, which identifies the subscription on unsubscribe. key is the
+// subscribed key, or the glob when pattern is set. send applies the slow
+// consumer policy and reports whether the delivery was accepted.
+type subscription struct {
+	id      interface{}
+	key     string
+	pattern bool
+	policy  types.SlowConsumerPolicy
+	send    func(d *delivery, pattern string) bool
+	close   func()
+	// buffer returns the number of queued messages and the channel capacity.
+	buffer func() (int, int)
+	// done is closed when the subscription is.
+	done chan struct{}
+
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+
+	// mu orders deliveries with closing the channel: Publish sends outside the
+	// PubSub lock, so it can race with Unsubscribe. It also makes the
+	// subscription's only sender the one holding it, which drop-oldest relies on.
+	mu     sync.Mutex
+	closed bool
+}
+
+// options returns the first of opts, or the zero options, with defaults
+// filled in.
+func (ps *PubSub) options(opts []types.SubscribeOptions) types.SubscribeOptions {
+	var opt types.SubscribeOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	if opt.BufferSize <= 0 {
+		opt.BufferSize = ps.bufferSize
+	}
+	if opt.BlockTimeout <= 0 {
+		opt.BlockTimeout = defaultBlockTimeout
+	}
+	return opt
+}
+
+// newSubscription builds a subscription delivering into ch the messages
+// produced by build, according to the policy in opt.
+func newSubscription[T any](key string, pattern bool, ch chan T, opt types.SubscribeOptions, build func(d *delivery, pattern string) T) *subscription {
+	sub := &subscription{
+		id:      ch,
+		key:     key,
+		pattern: pattern,
+		policy:  opt.Policy,
+		close:   func() { close(ch) },
+		buffer:  func() (int, int) { return len(ch), cap(ch) },
+		done:    make(chan struct{}),
+	}
+	sub.send = func(d *delivery, pattern string) bool {
+		return offer(sub, ch, build(d, pattern), opt)
+	}
+	return sub
+}
+
+// offer sends msg on ch, or applies the policy in opt when ch is full.
+// Messages evicted by PolicyDropOldest are counted as dropped on sub.
+func offer[T any](sub *subscription, ch chan T, msg T, opt types.SubscribeOptions) bool {
+	select {
+	case ch <- msg:
+		return true
+	default:
+	}
+
+	switch opt.Policy {
+	case types.PolicyDropOldest:
+		if cap(ch) == 0 {
+			return false
+		}
+		select {
+		case <-ch:
+			sub.dropped.Add(1)
+		default:
+		}
+		// The caller holds sub.mu, so no other sender can fill the slot freed
+		// above or by the reader.
+		ch <- msg
+		return true
+	case types.PolicyBlock:
+		timer := time.NewTimer(opt.BlockTimeout)
+		defer timer.Stop()
+		select {
+		case ch <- msg:
+			return true
+		case <-timer.C:
+			return false
+		}
+	}
+	return false
+}
+
+// deliver hands d to the subscriber and updates its counters. Deliveries to a
+// closed subscription are neither delivered nor dropped.
+func (s *subscription) deliver(d *delivery, pattern string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.send(d, pattern) {
+		s.delivered.Add(1)
+		return true
+	}
+	s.dropped.Add(1)
+	return false
+}
+
+func (s *subscription) shut() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		s.close()
+		close(s.done)
+	}
+}
+
+func (s *subscription) stats() types.SubscriptionStats {
+	buffered, capacity := s.buffer()
+	return types.SubscriptionStats{
+		Channel:   s.key,
+		Pattern:   s.pattern,
+		Policy:    s.policy,
+		Buffered:  buffered,
+		Capacity:  capacity,
+		Delivered: s.delivered.Load(),
+		Dropped:   s.dropped.Load(),
+	}
+}
+
+// delivery is an event on its way to the subscribers. Its string form is built
+// at most once, and only if a string subscriber needs it.
+type delivery struct {
+	event     types.Event
+	text      string
+	formatted bool
+}
+
+func (d *delivery) String() string {
+	if !d.formatted {
+		d.text = d.event.String()
+		d.formatted = true
+	}
+	return d.text
+}
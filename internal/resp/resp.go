@@ -0,0 +1,456 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	RESP2 = 2
+	RESP3 = 3
+)
+
+const (
+	maxBulkLen  = 512 << 20
+	maxArrayLen = 1 << 20
+	// maxInlineLen limits the lines of a request: inline commands and the
+	// headers of multibulk requests.
+	maxInlineLen = 64 << 10
+	// maxPrealloc limits what a request length prefix alone may allocate;
+	// longer arrays and bulk strings grow as their data arrives.
+	maxPrealloc = 1024
+)
+
+var ErrProtocol = errors.New("protocol error")
+
+// SimpleString is written as a status reply (+OK) instead of a bulk string.
+type SimpleString string
+
+// Error is an error reply read from the wire. Its text starts with the error code,
+// for example "WRONGTYPE Operation against a key holding the wrong kind of value".
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Set is written as a RESP3 set and as a plain array under RESP2.
+type Set []interface{}
+
+// Push is an out-of-band RESP3 push message. Under RESP2 it is written as an array.
+type Push []interface{}
+
+type Reader struct {
+	br *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{br: bufio.NewReader(r)}
+}
+
+// Buffered reports how many bytes of already received input are waiting to be
+// parsed. A server flushes its replies once it has drained a pipelined batch.
+func (r *Reader) Buffered() int {
+	return r.br.Buffered()
+}
+
+// ReadCommand reads one request, either a multibulk array of bulk strings or an
+// inline command line. Empty inline lines are skipped. Unlike ReadValue it
+// accepts no other types, so a client cannot make the server parse nested
+// aggregates, and it never allocates more than the data received calls for.
+func (r *Reader) ReadCommand() ([]string, error) {
+	for {
+		b, err := r.br.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		line, err := r.readLimitedLine(maxInlineLen)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] != '*' {
+			if parts := strings.Fields(line); len(parts) > 0 {
+				return parts, nil
+			}
+			continue
+		}
+
+		n, err := r.readLength(line[1:], maxArrayLen)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, fmt.Errorf("%w: expected array of bulk strings", ErrProtocol)
+		}
+		parts := make([]string, 0, min(n, maxPrealloc))
+		for i := 0; i < n; i++ {
+			header, err := r.readLimitedLine(maxInlineLen)
+			if err != nil {
+				return nil, err
+			}
+			if header == "" || header[0] != '$' {
+				return nil, fmt.Errorf("%w: expected bulk string", ErrProtocol)
+			}
+			size, err := r.readLength(header[1:], maxBulkLen)
+			if err != nil {
+				return nil, err
+			}
+			if size < 0 {
+				return nil, fmt.Errorf("%w: expected bulk string", ErrProtocol)
+			}
+			bulk, err := r.readBulk(size)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, string(bulk))
+		}
+		if len(parts) > 0 {
+			return parts, nil
+		}
+	}
+}
+
+// ReadValue reads any RESP2 or RESP3 value. Bulk strings are returned as string,
+// integers as int64, arrays, sets and pushes as []interface{}, maps as
+// map[string]interface{}, and error replies as Error.
+func (r *Reader) ReadValue() (interface{}, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, fmt.Errorf("%w: empty line", ErrProtocol)
+	}
+
+	body := line[1:]
+	switch line[0] {
+	case '+':
+		return SimpleString(body), nil
+	case '-':
+		return Error(body), nil
+	case ':':
+		n, err := strconv.ParseInt(body, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid integer %q", ErrProtocol, body)
+		}
+		return n, nil
+	case '_':
+		return nil, nil
+	case '#':
+		return body == "t", nil
+	case ',':
+		f, err := strconv.ParseFloat(body, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid double %q", ErrProtocol, body)
+		}
+		return f, nil
+	case '$', '=', '!':
+		n, err := r.readLength(body, maxBulkLen)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf, err := r.readBulk(n)
+		if err != nil {
+			return nil, err
+		}
+		if line[0] == '!' {
+			return Error(buf), nil
+		}
+		return string(buf), nil
+	case '*', '~', '>':
+		n, err := r.readLength(body, maxArrayLen)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = r.ReadValue(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	case '%':
+		n, err := r.readLength(body, maxArrayLen)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		m := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			k, err := r.ReadValue()
+			if err != nil {
+				return nil, err
+			}
+			v, err := r.ReadValue()
+			if err != nil {
+				return nil, err
+			}
+			m[fmt.Sprint(k)] = v
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown type byte %q", ErrProtocol, line[0])
+	}
+}
+
+func (r *Reader) readLength(s string, limit int) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < -1 || n > limit {
+		return 0, fmt.Errorf("%w: invalid length %q", ErrProtocol, s)
+	}
+	return n, nil
+}
+
+// readBulk reads the n bytes of a bulk string and the CRLF after them. Beyond
+// maxPrealloc the buffer grows with the data read rather than trusting n.
+func (r *Reader) readBulk(n int) ([]byte, error) {
+	var buf []byte
+	if n <= maxPrealloc {
+		buf = make([]byte, n+2)
+		if _, err := io.ReadFull(r.br, buf); err != nil {
+			return nil, err
+		}
+	} else {
+		var b bytes.Buffer
+		if _, err := io.CopyN(&b, r.br, int64(n)+2); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		buf = b.Bytes()
+	}
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return nil, fmt.Errorf("%w: bulk string not terminated by CRLF", ErrProtocol)
+	}
+	return buf[:n], nil
+}
+
+// readLimitedLine is readLine for untrusted input: a line longer than limit
+// is a protocol error.
+func (r *Reader) readLimitedLine(limit int) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.br.ReadSlice('\n')
+		if len(line)+len(chunk) > limit+2 {
+			return "", fmt.Errorf("%w: line too long", ErrProtocol)
+		}
+		line = append(line, chunk...)
+		switch err {
+		case nil:
+			return strings.TrimSuffix(string(line[:len(line)-1]), "\r"), nil
+		case bufio.ErrBufferFull:
+			continue
+		case io.EOF:
+			if len(line) > 0 {
+				return "", io.ErrUnexpectedEOF
+			}
+		}
+		return "", err
+	}
+}
+
+func (r *Reader) readLine() (string, error) {
+	line, err := r.br.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && line != "" {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimSuffix(line[:len(line)-1], "\r"), nil
+}
+
+type Writer struct {
+	bw *bufio.Writer
+	// Protocol selects how nulls, maps, sets, booleans and doubles are encoded.
+	Protocol int
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{bw: bufio.NewWriter(w), Protocol: RESP2}
+}
+
+func (w *Writer) Flush() error {
+	return w.bw.Flush()
+}
+
+func (w *Writer) WriteSimple(s string) error {
+	return w.writeLine('+', s)
+}
+
+// WriteError writes an error reply. Messages that do not start with an upper-case
+// error code are prefixed with ERR.
+func (w *Writer) WriteError(err error) error {
+	msg := strings.NewReplacer("\r", " ", "\n", " ").Replace(err.Error())
+	if !hasErrorCode(msg) {
+		msg = "ERR " + msg
+	}
+	return w.writeLine('-', msg)
+}
+
+func (w *Writer) WriteInt(n int64) error {
+	return w.writeLine(':', strconv.FormatInt(n, 10))
+}
+
+func (w *Writer) WriteBulk(s string) error {
+	if err := w.writeLine('$', strconv.Itoa(len(s))); err != nil {
+		return err
+	}
+	if _, err := w.bw.WriteString(s); err != nil {
+		return err
+	}
+	_, err := w.bw.WriteString("\r\n")
+	return err
+}
+
+func (w *Writer) WriteNull() error {
+	if w.Protocol >= RESP3 {
+		_, err := w.bw.WriteString("_\r\n")
+		return err
+	}
+	_, err := w.bw.WriteString("$-1\r\n")
+	return err
+}
+
+func (w *Writer) WriteArrayHeader(n int) error {
+	return w.writeLine('*', strconv.Itoa(n))
+}
+
+// WriteValue encodes a reply produced by a command: nil, SimpleString, error,
+// integers, string, []byte, bool, floats, []interface{}, Set, Push and
+// map[string]interface{}. Any other value is written as its fmt representation.
+func (w *Writer) WriteValue(v interface{}) error {
+	switch val := v.(type) {
+	case nil:
+		return w.WriteNull()
+	case SimpleString:
+		return w.WriteSimple(string(val))
+	case error:
+		return w.WriteError(val)
+	case int64:
+		return w.WriteInt(val)
+	case int:
+		return w.WriteInt(int64(val))
+	case string:
+		return w.WriteBulk(val)
+	case []byte:
+		return w.WriteBulk(string(val))
+	case bool:
+		if w.Protocol >= RESP3 {
+			if val {
+				return w.writeLine('#', "t")
+			}
+			return w.writeLine('#', "f")
+		}
+		if val {
+			return w.WriteInt(1)
+		}
+		return w.WriteInt(0)
+	case float64:
+		return w.writeDouble(val)
+	case float32:
+		return w.writeDouble(float64(val))
+	case []interface{}:
+		return w.writeAggregate('*', val)
+	case Set:
+		if w.Protocol >= RESP3 {
+			return w.writeAggregate('~', val)
+		}
+		return w.writeAggregate('*', val)
+	case Push:
+		if w.Protocol >= RESP3 {
+			return w.writeAggregate('>', val)
+		}
+		return w.writeAggregate('*', val)
+	case map[string]interface{}:
+		return w.writeMap(val)
+	default:
+		return w.WriteBulk(fmt.Sprint(val))
+	}
+}
+
+func (w *Writer) writeDouble(f float64) error {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if w.Protocol < RESP3 {
+		return w.WriteBulk(s)
+	}
+	switch {
+	case math.IsInf(f, 1):
+		s = "inf"
+	case math.IsInf(f, -1):
+		s = "-inf"
+	case math.IsNaN(f):
+		s = "nan"
+	}
+	return w.writeLine(',', s)
+}
+
+func (w *Writer) writeAggregate(prefix byte, items []interface{}) error {
+	if err := w.writeLine(prefix, strconv.Itoa(len(items))); err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := w.WriteValue(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMap writes keys in sorted order so replies are deterministic. Under RESP2 a
+// map is flattened into an array of alternating keys and values.
+func (w *Writer) writeMap(m map[string]interface{}) error {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	if w.Protocol >= RESP3 {
+		if err := w.writeLine('%', strconv.Itoa(len(m))); err != nil {
+			return err
+		}
+	} else if err := w.WriteArrayHeader(len(m) * 2); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := w.WriteBulk(k); err != nil {
+			return err
+		}
+		if err := w.WriteValue(m[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Writer) writeLine(prefix byte, s string) error {
+	if err := w.bw.WriteByte(prefix); err != nil {
+		return err
+	}
+	if _, err := w.bw.WriteString(s); err != nil {
+		return err
+	}
+	_, err := w.bw.WriteString("\r\n")
+	return err
+}
+
+func hasErrorCode(msg string) bool {
+	code, _, found := strings.Cut(msg, " ")
+	if !found || code == "" {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,152 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestReadCommand checks multibulk, inline and pipelined requests.
+func TestReadCommand(t *testing.T) {
+	input := "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$5\r\nhello\r\n" +
+		"\r\n" +
+		"PING  there\r\n" +
+		"*2\r\n$3\r\nGET\r\n$1\r\nk\r\n"
+	r := NewReader(strings.NewReader(input))
+
+	want := [][]string{{"SET", "k", "hello"}, {"PING", "there"}, {"GET", "k"}}
+	for i, w := range want {
+		got, err := r.ReadCommand()
+		if err != nil {
+			t.Fatalf("Command %d: unexpected error %v", i, err)
+		}
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("Command %d: got %q, want %q", i, got, w)
+		}
+	}
+
+	// Scenario: a bulk string that is not terminated is a protocol error
+	r = NewReader(strings.NewReader("*1\r\n$3\r\nGETX\r\n"))
+	if _, err := r.ReadCommand(); !errors.Is(err, ErrProtocol) {
+		t.Errorf("Expected ErrProtocol, got %v", err)
+	}
+
+	// Scenario: a non-string element is rejected
+	r = NewReader(strings.NewReader("*1\r\n:1\r\n"))
+	if _, err := r.ReadCommand(); !errors.Is(err, ErrProtocol) {
+		t.Errorf("Expected ErrProtocol, got %v", err)
+	}
+
+	// Scenario: nested aggregates and non-array requests are rejected
+	for _, in := range []string{
+		"*1\r\n*1\r\n$1\r\nx\r\n",
+		"*1\r\n%1\r\n$1\r\nk\r\n$1\r\nv\r\n",
+		"*1\r\n$-1\r\n",
+		"*-1\r\n",
+	} {
+		r = NewReader(strings.NewReader(in))
+		if _, err := r.ReadCommand(); !errors.Is(err, ErrProtocol) {
+			t.Errorf("%q: expected ErrProtocol, got %v", in, err)
+		}
+	}
+
+	// Scenario: a huge length prefix alone allocates nothing up front
+	header := strings.Repeat("*1048576\r\n", 20)
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	before := stats.TotalAlloc
+	NewReader(strings.NewReader(header)).ReadCommand()
+	runtime.ReadMemStats(&stats)
+	if grown := stats.TotalAlloc - before; grown > 1<<20 {
+		t.Errorf("Reading array headers allocated %d bytes", grown)
+	}
+
+	// Scenario: an inline line over the limit is a protocol error
+	r = NewReader(strings.NewReader(strings.Repeat("a", maxInlineLen+1) + "\r\n"))
+	if _, err := r.ReadCommand(); !errors.Is(err, ErrProtocol) {
+		t.Errorf("Expected ErrProtocol for long inline line, got %v", err)
+	}
+	r = NewReader(strings.NewReader("PING " + strings.Repeat("a", maxInlineLen-5) + "\r\n"))
+	if got, err := r.ReadCommand(); err != nil || len(got) != 2 {
+		t.Errorf("Expected inline line at the limit to parse, got %d parts, %v", len(got), err)
+	}
+
+	// Scenario: a bulk string longer than the preallocation still reads whole
+	big := strings.Repeat("v", 3*maxPrealloc)
+	r = NewReader(strings.NewReader(fmt.Sprintf("*2\r\n$3\r\nGET\r\n$%d\r\n%s\r\n", len(big), big)))
+	if got, err := r.ReadCommand(); err != nil || got[1] != big {
+		t.Errorf("Expected long bulk string to round-trip, got err %v", err)
+	}
+}
+
+// TestWriteValue checks reply encoding under RESP2 and RESP3.
+func TestWriteValue(t *testing.T) {
+	cases := []struct {
+		value interface{}
+		resp2 string
+		resp3 string
+	}{
+		{nil, "$-1\r\n", "_\r\n"},
+		{SimpleString("OK"), "+OK\r\n", "+OK\r\n"},
+		{Error("WRONGTYPE bad"), "-WRONGTYPE bad\r\n", "-WRONGTYPE bad\r\n"},
+		{errors.New("boom"), "-ERR boom\r\n", "-ERR boom\r\n"},
+		{int64(42), ":42\r\n", ":42\r\n"},
+		{"hi", "$2\r\nhi\r\n", "$2\r\nhi\r\n"},
+		{true, ":1\r\n", "#t\r\n"},
+		{1.5, "$3\r\n1.5\r\n", ",1.5\r\n"},
+		{[]interface{}{"a", int64(1)}, "*2\r\n$1\r\na\r\n:1\r\n", "*2\r\n$1\r\na\r\n:1\r\n"},
+		{Set{"a"}, "*1\r\n$1\r\na\r\n", "~1\r\n$1\r\na\r\n"},
+		{map[string]interface{}{"b": "2", "a": "1"},
+			"*4\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n",
+			"%2\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n"},
+	}
+
+	for _, tc := range cases {
+		for _, proto := range []int{RESP2, RESP3} {
+			var buf bytes.Buffer
+			w := NewWriter(&buf)
+			w.Protocol = proto
+			if err := w.WriteValue(tc.value); err != nil {
+				t.Fatalf("WriteValue(%#v) failed: %v", tc.value, err)
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatalf("Flush failed: %v", err)
+			}
+			want := tc.resp2
+			if proto == RESP3 {
+				want = tc.resp3
+			}
+			if buf.String() != want {
+				t.Errorf("RESP%d %#v: got %q, want %q", proto, tc.value, buf.String(), want)
+			}
+		}
+	}
+}
+
+// TestReadValueRoundTrip checks that written replies parse back.
+func TestReadValueRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Protocol = RESP3
+	_ = w.WriteValue(map[string]interface{}{"proto": int64(3), "modules": []interface{}{}})
+	_ = w.WriteValue(Set{"x"})
+	_ = w.Flush()
+
+	r := NewReader(&buf)
+	v, err := r.ReadValue()
+	if err != nil {
+		t.Fatalf("ReadValue failed: %v", err)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok || m["proto"] != int64(3) {
+		t.Errorf("Unexpected map %#v", v)
+	}
+	v, err = r.ReadValue()
+	if err != nil || !reflect.DeepEqual(v, []interface{}{"x"}) {
+		t.Errorf("Unexpected set %#v (err=%v)", v, err)
+	}
+}
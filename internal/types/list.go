@@ -0,0 +1,233 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ListSide selects an end of a list for commands such as BLMOVE.
+type ListSide int
+
+const (
+	ListLeft ListSide = iota
+	ListRight
+)
+
+func (s ListSide) String() string {
+	if s == ListRight {
+		return "RIGHT"
+	}
+	return "LEFT"
+}
+
+// LPosOptions controls LPos. Rank selects the match to start from: 2 skips the
+// first match, and a negative rank searches from the tail, -1 being the last
+// match; 0 is the same as 1. Count limits the matches returned, <= 0 returning
+// all of them. MaxLen limits the elements compared, <= 0 comparing the whole
+// list.
+type LPosOptions struct {
+	Rank   int
+	Count  int
+	MaxLen int
+}
+
+const minDequeCap = 8
+
+// Deque is the value of a list: a ring buffer whose capacity is a power of two.
+// It doubles when full and halves when three quarters empty, so pushes and pops
+// at either end are amortised O(1), indexing is O(1), and the memory of popped
+// elements is released.
+type Deque struct {
+	buf  []interface{}
+	head int
+	n    int
+}
+
+// NewDeque returns a deque holding values, first to last.
+func NewDeque(values ...interface{}) *Deque {
+	d := &Deque{}
+	if len(values) > 0 {
+		d.buf = make([]interface{}, dequeCap(len(values)))
+		d.n = copy(d.buf, values)
+	}
+	return d
+}
+
+// dequeCap returns the smallest valid capacity that holds n elements.
+func dequeCap(n int) int {
+	c := minDequeCap
+	for c < n {
+		c <<= 1
+	}
+	return c
+}
+
+func (d *Deque) Len() int {
+	return d.n
+}
+
+// slot returns the buffer position of the i-th element.
+func (d *Deque) slot(i int) int {
+	return (d.head + i) & (len(d.buf) - 1)
+}
+
+func (d *Deque) resize(capacity int) {
+	buf := make([]interface{}, capacity)
+	d.copyTo(buf, 0, d.n)
+	d.buf, d.head = buf, 0
+}
+
+// copyTo copies the elements from index start, n of them, into dst.
+func (d *Deque) copyTo(dst []interface{}, start, n int) {
+	if n == 0 {
+		return
+	}
+	first := d.slot(start)
+	copied := copy(dst[:n], d.buf[first:])
+	copy(dst[copied:n], d.buf)
+}
+
+func (d *Deque) grow() {
+	if d.n == len(d.buf) {
+		d.resize(dequeCap(d.n + 1))
+	}
+}
+
+func (d *Deque) shrink() {
+	if len(d.buf) > minDequeCap && d.n <= len(d.buf)/4 {
+		d.resize(dequeCap(d.n * 2))
+	}
+}
+
+func (d *Deque) PushFront(v interface{}) {
+	d.grow()
+	d.head = (d.head - 1) & (len(d.buf) - 1)
+	d.buf[d.head] = v
+	d.n++
+}
+
+func (d *Deque) PushBack(v interface{}) {
+	d.grow()
+	d.buf[d.slot(d.n)] = v
+	d.n++
+}
+
+// PopFront removes and returns the first element; ok is false if d is empty.
+func (d *Deque) PopFront() (v interface{}, ok bool) {
+	if d.n == 0 {
+		return nil, false
+	}
+	v, d.buf[d.head] = d.buf[d.head], nil
+	d.head = d.slot(1)
+	d.n--
+	d.shrink()
+	return v, true
+}
+
+// PopBack removes and returns the last element; ok is false if d is empty.
+func (d *Deque) PopBack() (v interface{}, ok bool) {
+	if d.n == 0 {
+		return nil, false
+	}
+	last := d.slot(d.n - 1)
+	v, d.buf[last] = d.buf[last], nil
+	d.n--
+	d.shrink()
+	return v, true
+}
+
+// Index returns the i-th element, 0 <= i < Len().
+func (d *Deque) Index(i int) interface{} {
+	return d.buf[d.slot(i)]
+}
+
+// Set replaces the i-th element, 0 <= i < Len().
+func (d *Deque) Set(i int, v interface{}) {
+	d.buf[d.slot(i)] = v
+}
+
+// Insert inserts v so that it becomes the i-th element, 0 <= i <= Len(). It
+// shifts the elements on the shorter side of i.
+func (d *Deque) Insert(i int, v interface{}) {
+	d.grow()
+	if i < d.n/2 {
+		d.head = (d.head - 1) & (len(d.buf) - 1)
+		for j := 0; j < i; j++ {
+			d.buf[d.slot(j)] = d.buf[d.slot(j+1)]
+		}
+	} else {
+		for j := d.n; j > i; j-- {
+			d.buf[d.slot(j)] = d.buf[d.slot(j-1)]
+		}
+	}
+	d.buf[d.slot(i)] = v
+	d.n++
+}
+
+// Filter keeps the elements for which keep, called with each index and element
+// first to last, returns true, and returns how many it dropped.
+func (d *Deque) Filter(keep func(i int, v interface{}) bool) int {
+	kept := 0
+	for i := 0; i < d.n; i++ {
+		v := d.buf[d.slot(i)]
+		if keep(i, v) {
+			d.buf[d.slot(kept)] = v
+			kept++
+		}
+	}
+	for i := kept; i < d.n; i++ {
+		d.buf[d.slot(i)] = nil
+	}
+	dropped := d.n - kept
+	d.n = kept
+	d.shrink()
+	return dropped
+}
+
+// Range returns a copy of the elements from start to stop inclusive, which the
+// caller has clamped to the deque.
+func (d *Deque) Range(start, stop int) []interface{} {
+	if start > stop {
+		return []interface{}{}
+	}
+	out := make([]interface{}, stop-start+1)
+	d.copyTo(out, start, len(out))
+	return out
+}
+
+// Values returns a copy of all the elements, first to last.
+func (d *Deque) Values() []interface{} {
+	return d.Range(0, d.n-1)
+}
+
+// Trim keeps the elements from start to stop inclusive, which the caller has
+// clamped to the deque, and drops the others.
+func (d *Deque) Trim(start, stop int) {
+	if start > stop {
+		*d = Deque{}
+		return
+	}
+	for i := 0; i < start; i++ {
+		d.buf[d.slot(i)] = nil
+	}
+	for i := stop + 1; i < d.n; i++ {
+		d.buf[d.slot(i)] = nil
+	}
+	d.head = d.slot(start)
+	d.n = stop - start + 1
+	d.shrink()
+}
+
+// Clone returns a copy of d that shares no memory with it.
+func (d *Deque) Clone() *Deque {
+	return NewDeque(d.Values()...)
+}
+
+// MarshalJSON encodes the deque as an array, first to last.
+func (d *Deque) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Values())
+}
+
+func (d *Deque) String() string {
+	return fmt.Sprint(d.Values())
+}
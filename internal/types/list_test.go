@@ -0,0 +1,126 @@
+package types
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// TestDequeAgainstModel checks the ring buffer against a plain slice under
+// random pushes, pops, trims, inserts, sets and filters, across growth,
+// wraparound and shrinking.
+func TestDequeAgainstModel(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	d := NewDeque(0, 1, 2)
+	model := []interface{}{0, 1, 2}
+
+	for i := 3; i < 20000; i++ {
+		switch op := rng.Intn(13); {
+		case op < 3:
+			d.PushFront(i)
+			model = append([]interface{}{i}, model...)
+		case op < 6:
+			d.PushBack(i)
+			model = append(model, i)
+		case op < 8:
+			v, ok := d.PopFront()
+			if ok != (len(model) > 0) {
+				t.Fatalf("PopFront ok = %v with %d elements", ok, len(model))
+			}
+			if ok {
+				if v != model[0] {
+					t.Fatalf("PopFront = %v, want %v", v, model[0])
+				}
+				model = model[1:]
+			}
+		case op < 9:
+			v, ok := d.PopBack()
+			if ok != (len(model) > 0) {
+				t.Fatalf("PopBack ok = %v with %d elements", ok, len(model))
+			}
+			if ok {
+				if v != model[len(model)-1] {
+					t.Fatalf("PopBack = %v, want %v", v, model[len(model)-1])
+				}
+				model = model[:len(model)-1]
+			}
+		case op == 9:
+			if len(model) > 2 {
+				start, stop := 1, len(model)-1-rng.Intn(2)
+				d.Trim(start, stop)
+				model = append([]interface{}(nil), model[start:stop+1]...)
+			}
+		case op == 10:
+			at := rng.Intn(len(model) + 1)
+			d.Insert(at, i)
+			model = append(model[:at], append([]interface{}{i}, model[at:]...)...)
+		case op == 11:
+			if len(model) > 0 {
+				at := rng.Intn(len(model))
+				d.Set(at, i)
+				model[at] = i
+			}
+		default:
+			drop := func(v interface{}) bool { return v.(int)%5 == 0 }
+			var kept []interface{}
+			for _, v := range model {
+				if !drop(v) {
+					kept = append(kept, v)
+				}
+			}
+			if n := d.Filter(func(_ int, v interface{}) bool { return !drop(v) }); n != len(model)-len(kept) {
+				t.Fatalf("Filter dropped %d, want %d", n, len(model)-len(kept))
+			}
+			model = kept
+		}
+		if d.Len() != len(model) {
+			t.Fatalf("Len = %d, want %d", d.Len(), len(model))
+		}
+	}
+	if got := d.Values(); !reflect.DeepEqual(got, model) && !(len(got) == 0 && len(model) == 0) {
+		t.Fatalf("Values = %v, want %v", got, model)
+	}
+}
+
+// TestDequeShrinks checks that popping releases the buffer and the popped
+// slots.
+func TestDequeShrinks(t *testing.T) {
+	d := NewDeque()
+	for i := 0; i < 1<<16; i++ {
+		d.PushBack(i)
+	}
+	for d.Len() > 10 {
+		d.PopFront()
+	}
+	if len(d.buf) > 64 {
+		t.Errorf("Buffer of %d slots kept for %d elements", len(d.buf), d.Len())
+	}
+	for i := d.Len(); i < len(d.buf); i++ {
+		if d.buf[d.slot(i)] != nil {
+			t.Fatalf("Free slot %d still references %v", i, d.buf[d.slot(i)])
+		}
+	}
+}
+
+// TestDequeWraparound checks that ranges and clones copy elements that wrap
+// around the end of the buffer.
+func TestDequeWraparound(t *testing.T) {
+	d := NewDeque()
+	for i := 0; i < 6; i++ {
+		d.PushBack(i)
+	}
+	d.PopFront()
+	d.PopFront()
+	d.PushBack(6)
+	d.PushBack(7)
+	d.PushBack(8)
+	want := []interface{}{2, 3, 4, 5, 6, 7, 8}
+	if got := d.Range(0, 6); !reflect.DeepEqual(got, want) {
+		t.Errorf("Range = %v, want %v", got, want)
+	}
+	c := d.Clone()
+	d.PopBack()
+	if got := c.Values(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Clone changed with the original: %v", got)
+	}
+}
@@ -0,0 +1,358 @@
+package types
+
+import (
+	"fmt"
+	"time"
+)
+
+// PatternMessage is a message delivered to a pattern subscription. Pattern is
+// the subscribed glob and Key the key the message was published to.
+type PatternMessage struct {
+	Pattern string
+	Key     string
+	Message string
+}
+
+// PubSubMessage is a message received by a command session subscribed with
+// SUBSCRIBE or PSUBSCRIBE. Pattern is set for pattern subscriptions.
+type PubSubMessage struct {
+	Pattern string
+	Channel string
+	Message string
+}
+
+// SlowConsumerPolicy decides what happens to a message for a subscriber whose
+// buffer is full.
+type SlowConsumerPolicy int
+
+const (
+	// PolicyDropNewest drops the message being published.
+	PolicyDropNewest SlowConsumerPolicy = iota
+	// PolicyDropOldest drops the oldest buffered message to make room.
+	PolicyDropOldest
+	// PolicyBlock makes the publisher wait up to SubscribeOptions.BlockTimeout
+	// for room and drops the message after that. Keyspace events are published
+	// once the writer has released its store locks.
+	PolicyBlock
+	// PolicyDisconnect drops the message and closes the subscription.
+	PolicyDisconnect
+)
+
+func (p SlowConsumerPolicy) String() string {
+	switch p {
+	case PolicyDropNewest:
+		return "drop-newest"
+	case PolicyDropOldest:
+		return "drop-oldest"
+	case PolicyBlock:
+		return "block"
+	case PolicyDisconnect:
+		return "disconnect"
+	}
+	return fmt.Sprintf("SlowConsumerPolicy(%d)", int(p))
+}
+
+// MarshalText encodes the policy by name, so it reads well in JSON.
+func (p SlowConsumerPolicy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText parses a policy name as written by MarshalText.
+func (p *SlowConsumerPolicy) UnmarshalText(text []byte) error {
+	for _, policy := range []SlowConsumerPolicy{PolicyDropNewest, PolicyDropOldest, PolicyBlock, PolicyDisconnect} {
+		if string(text) == policy.String() {
+			*p = policy
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown slow consumer policy %q", text)
+}
+
+// SubscribeOptions configures one subscription. The zero value keeps the
+// defaults: the store's buffer size, and dropping the newest message when the
+// buffer is full.
+type SubscribeOptions struct {
+	Policy SlowConsumerPolicy
+	// BlockTimeout bounds the wait of PolicyBlock; zero means 100ms.
+	BlockTimeout time.Duration
+	// BufferSize is the capacity of the subscriber's channel; zero means the
+	// store's PubSubBufferSize.
+	BufferSize int
+	// InitialMessage, if not empty, is queued before any event as an
+	// application message. Pattern subscriptions ignore it.
+	InitialMessage string
+	// LastID, if not zero, replays the events a retained channel kept after
+	// this ID before live ones, as many as fit the buffer. Pattern
+	// subscriptions ignore it.
+	LastID uint64
+}
+
+// SubscriptionStats describes one subscription. Channel is the subscribed key,
+// or the glob when Pattern is set. Buffered and Capacity describe the
+// subscriber's channel; Delivered and Dropped count messages since it was
+// created.
+type SubscriptionStats struct {
+	Channel   string             `json:"channel"`
+	Pattern   bool               `json:"pattern"`
+	Policy    SlowConsumerPolicy `json:"policy"`
+	Buffered  int                `json:"buffered"`
+	Capacity  int                `json:"capacity"`
+	Delivered uint64             `json:"delivered"`
+	Dropped   uint64             `json:"dropped"`
+}
+
+// EventOp names the operation behind an Event. The names follow Redis
+// keyspace notifications where one exists.
+type EventOp string
+
+const (
+	EventSet           EventOp = "set"
+	EventCAS           EventOp = "cas"
+	EventGetSet        EventOp = "getset"
+	EventIncrBy        EventOp = "incrby"
+	EventLPush         EventOp = "lpush"
+	EventRPush         EventOp = "rpush"
+	EventLPop          EventOp = "lpop"
+	EventRPop          EventOp = "rpop"
+	EventLTrim         EventOp = "ltrim"
+	EventLSet          EventOp = "lset"
+	EventLInsert       EventOp = "linsert"
+	EventLRem          EventOp = "lrem"
+	EventHSet          EventOp = "hset"
+	EventHDel          EventOp = "hdel"
+	EventHIncrBy       EventOp = "hincrby"
+	EventHIncrByFloat  EventOp = "hincrbyfloat"
+	EventHExpire       EventOp = "hexpire"
+	EventHPersist      EventOp = "hpersist"
+	EventHExpired      EventOp = "hexpired"
+	EventSAdd          EventOp = "sadd"
+	EventSRem          EventOp = "srem"
+	EventZAdd          EventOp = "zadd"
+	EventZIncrBy       EventOp = "zincr"
+	EventZRem          EventOp = "zrem"
+	EventZPopMin       EventOp = "zpopmin"
+	EventZPopMax       EventOp = "zpopmax"
+	EventXAdd          EventOp = "xadd"
+	EventXTrim         EventOp = "xtrim"
+	EventXGroupCreate  EventOp = "xgroup-create"
+	EventXGroupDestroy EventOp = "xgroup-destroy"
+	EventDel           EventOp = "del"
+	EventRenameFrom    EventOp = "rename_from"
+	EventRenameTo      EventOp = "rename_to"
+	EventExpire        EventOp = "expire"
+	EventPersist       EventOp = "persist"
+	EventRestore       EventOp = "restore"
+	EventFlushAll      EventOp = "flushall"
+	EventExpired       EventOp = "expired"
+	// EventMessage is an application message sent with Publish.
+	EventMessage EventOp = "message"
+)
+
+// EventClass is a set of event kinds, selected with Config.NotifyEvents in the
+// spirit of Redis notify-keyspace-events.
+type EventClass int
+
+const (
+	// EventClassGeneric covers del, rename_from, rename_to, expire, persist,
+	// restore and flushall.
+	EventClassGeneric EventClass = 1 << iota
+	EventClassString
+	EventClassList
+	EventClassHash
+	EventClassSet
+	EventClassZSet
+	EventClassExpired
+	EventClassStream
+
+	EventClassAll = EventClassGeneric | EventClassString | EventClassList | EventClassHash |
+		EventClassSet | EventClassZSet | EventClassExpired | EventClassStream
+	// EventClassNone disables keyspace events.
+	EventClassNone EventClass = -1
+)
+
+// Has reports whether c includes every class in other.
+func (c EventClass) Has(other EventClass) bool {
+	return c > 0 && c&other == other
+}
+
+// ParseEventClasses parses Redis notify-keyspace-events letters: g (generic),
+// $ (string), l (list), h (hash), s (set), z (sorted set), t (stream), x
+// (expired) and A (all of them). K and E select Redis channel kinds and are ignored. An empty
+// string selects nothing.
+func ParseEventClasses(flags string) (EventClass, error) {
+	var c EventClass
+	for _, f := range flags {
+		switch f {
+		case 'g':
+			c |= EventClassGeneric
+		case '$':
+			c |= EventClassString
+		case 'l':
+			c |= EventClassList
+		case 'h':
+			c |= EventClassHash
+		case 's':
+			c |= EventClassSet
+		case 'z':
+			c |= EventClassZSet
+		case 't':
+			c |= EventClassStream
+		case 'x':
+			c |= EventClassExpired
+		case 'A':
+			c |= EventClassAll
+		case 'K', 'E':
+		default:
+			return 0, fmt.Errorf("unknown event class %q", f)
+		}
+	}
+	if c == 0 {
+		return EventClassNone, nil
+	}
+	return c, nil
+}
+
+// Class returns the class an operation's events belong to. Application
+// messages belong to no class and are always delivered.
+func (op EventOp) Class() EventClass {
+	switch op {
+	case EventSet, EventCAS, EventGetSet, EventIncrBy:
+		return EventClassString
+	case EventLPush, EventRPush, EventLPop, EventRPop, EventLTrim, EventLSet, EventLInsert, EventLRem:
+		return EventClassList
+	case EventHSet, EventHDel, EventHIncrBy, EventHIncrByFloat, EventHExpire, EventHPersist, EventHExpired:
+		return EventClassHash
+	case EventSAdd, EventSRem:
+		return EventClassSet
+	case EventZAdd, EventZIncrBy, EventZRem, EventZPopMin, EventZPopMax:
+		return EventClassZSet
+	case EventXAdd, EventXTrim, EventXGroupCreate, EventXGroupDestroy:
+		return EventClassStream
+	case EventExpired:
+		return EventClassExpired
+	case EventMessage:
+		return 0
+	}
+	return EventClassGeneric
+}
+
+// EventOrigin tells what caused an Event.
+type EventOrigin string
+
+const (
+	OriginClient      EventOrigin = "client"
+	OriginTransaction EventOrigin = "transaction"
+	OriginExpiry      EventOrigin = "expiry"
+	OriginReplay      EventOrigin = "replay"
+	OriginPublish     EventOrigin = "publish"
+)
+
+// Event describes a change to a key. OldValue and NewValue are set when the
+// operation knows them: for pushes and adds NewValue holds the added values,
+// for pops and removals OldValue holds what was removed, and for application
+// messages NewValue is the message. Field names the hash field or sorted set
+// member an operation targets. TTL is the time the key has left, zero if it
+// does not expire. Pattern is set on deliveries to pattern subscriptions. ID
+// numbers the events of a channel with retention enabled and is zero
+// otherwise.
+type Event struct {
+	ID        uint64
+	Op        EventOp
+	Key       string
+	Field     string
+	Type      DataType
+	OldValue  interface{}
+	NewValue  interface{}
+	TTL       time.Duration
+	Timestamp time.Time
+	Origin    EventOrigin
+	Pattern   string
+}
+
+// String formats the event the way string subscriptions receive it.
+func (e Event) String() string {
+	switch e.Op {
+	case EventSet:
+		return fmt.Sprintf("SET: %v", e.NewValue)
+	case EventCAS:
+		return fmt.Sprintf("CAS: %v -> %v", e.OldValue, e.NewValue)
+	case EventGetSet:
+		return fmt.Sprintf("GETSET: %v -> %v", e.OldValue, e.NewValue)
+	case EventIncrBy:
+		return fmt.Sprintf("INCRBY: %v", e.NewValue)
+	case EventLPush:
+		return fmt.Sprintf("LPush: %v", e.NewValue)
+	case EventRPush:
+		return fmt.Sprintf("RPush: %v", e.NewValue)
+	case EventLPop:
+		return fmt.Sprintf("LPop: %v", e.OldValue)
+	case EventRPop:
+		return fmt.Sprintf("RPop: %v", e.OldValue)
+	case EventLTrim:
+		return "LTrim"
+	case EventLSet:
+		return fmt.Sprintf("LSet: %v -> %v", e.OldValue, e.NewValue)
+	case EventLInsert:
+		return fmt.Sprintf("LInsert: %v", e.NewValue)
+	case EventLRem:
+		return fmt.Sprintf("LRem: %v", e.OldValue)
+	case EventHSet:
+		return fmt.Sprintf("HSet: %s = %v", e.Field, e.NewValue)
+	case EventHDel:
+		return fmt.Sprintf("HDel: %s", e.Field)
+	case EventHIncrBy:
+		return fmt.Sprintf("HIncrBy: %s -> %v", e.Field, e.NewValue)
+	case EventHIncrByFloat:
+		return fmt.Sprintf("HIncrByFloat: %s -> %v", e.Field, e.NewValue)
+	case EventHExpire:
+		return fmt.Sprintf("HExpire: %s", e.Field)
+	case EventHPersist:
+		return fmt.Sprintf("HPersist: %s", e.Field)
+	case EventHExpired:
+		return fmt.Sprintf("HExpired: %s", e.Field)
+	case EventSAdd:
+		return fmt.Sprintf("SAdd: %v", e.NewValue)
+	case EventSRem:
+		return fmt.Sprintf("SRem: %v", e.OldValue)
+	case EventZAdd:
+		return fmt.Sprintf("ZAdd: %v", e.NewValue)
+	case EventZIncrBy:
+		return fmt.Sprintf("ZIncrBy: %s -> %v", e.Field, e.NewValue)
+	case EventZRem:
+		return fmt.Sprintf("ZRem: %v", e.OldValue)
+	case EventZPopMin:
+		return fmt.Sprintf("ZPopMin: %v", e.OldValue)
+	case EventZPopMax:
+		return fmt.Sprintf("ZPopMax: %v", e.OldValue)
+	case EventXAdd:
+		if entry, ok := e.NewValue.(StreamEntry); ok {
+			return fmt.Sprintf("XAdd: %s", entry.ID)
+		}
+		return fmt.Sprintf("XAdd: %v", e.NewValue)
+	case EventXTrim:
+		return fmt.Sprintf("XTrim: %v", e.OldValue)
+	case EventXGroupCreate:
+		return fmt.Sprintf("XGroupCreate: %s", e.Field)
+	case EventXGroupDestroy:
+		return fmt.Sprintf("XGroupDestroy: %s", e.Field)
+	case EventExpire:
+		return fmt.Sprintf("EXPIRE: %v", e.TTL)
+	case EventPersist:
+		return "PERSIST"
+	case EventRestore:
+		return "RESTORE"
+	case EventDel:
+		return "DELETE"
+	case EventRenameFrom:
+		return "RENAMED"
+	case EventRenameTo:
+		return "CREATED (via rename)"
+	case EventFlushAll:
+		return "FLUSH_ALL"
+	case EventExpired:
+		return "EXPIRED"
+	case EventMessage:
+		return fmt.Sprint(e.NewValue)
+	}
+	return string(e.Op)
+}
@@ -0,0 +1,407 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidStreamID = errors.New("invalid stream ID")
+
+// StreamID identifies a stream entry: the Unix time in milliseconds it was
+// added at and a sequence number for entries added within the same
+// millisecond. IDs of a stream only ever grow.
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+// MaxStreamID is the largest possible ID, the "+" of XRANGE.
+var MaxStreamID = StreamID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// MarshalText encodes the ID as "ms-seq", so it reads well in JSON.
+func (id StreamID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+func (id *StreamID) UnmarshalText(text []byte) error {
+	parsed, err := ParseStreamID(string(text))
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
+
+func (id StreamID) IsZero() bool {
+	return id.Ms == 0 && id.Seq == 0
+}
+
+func (id StreamID) Less(other StreamID) bool {
+	return id.Ms < other.Ms || (id.Ms == other.Ms && id.Seq < other.Seq)
+}
+
+// next returns the smallest ID greater than id.
+func (id StreamID) next() (StreamID, bool) {
+	switch {
+	case id.Seq < math.MaxUint64:
+		return StreamID{Ms: id.Ms, Seq: id.Seq + 1}, true
+	case id.Ms < math.MaxUint64:
+		return StreamID{Ms: id.Ms + 1}, true
+	}
+	return id, false
+}
+
+// prev returns the greatest ID smaller than id.
+func (id StreamID) prev() (StreamID, bool) {
+	switch {
+	case id.Seq > 0:
+		return StreamID{Ms: id.Ms, Seq: id.Seq - 1}, true
+	case id.Ms > 0:
+		return StreamID{Ms: id.Ms - 1, Seq: math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// ParseStreamID parses "ms-seq", or "ms" which stands for "ms-0".
+func ParseStreamID(s string) (StreamID, error) {
+	return parseStreamID(s, 0)
+}
+
+func parseStreamID(s string, defaultSeq uint64) (StreamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return StreamID{}, ErrInvalidStreamID
+	}
+	if !hasSeq {
+		return StreamID{Ms: ms, Seq: defaultSeq}, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return StreamID{}, ErrInvalidStreamID
+	}
+	return StreamID{Ms: ms, Seq: seq}, nil
+}
+
+// ParseStreamRange parses the bounds of XRANGE: "-" and "+" for the smallest
+// and largest ID, a "(" prefix for an exclusive bound, and a bare "ms" which
+// covers the whole millisecond. ok is false when the range is empty.
+func ParseStreamRange(start, end string) (from, to StreamID, ok bool, err error) {
+	from, ok, err = parseStreamBound(start, false)
+	if err != nil || !ok {
+		return
+	}
+	to, ok, err = parseStreamBound(end, true)
+	if err != nil || !ok {
+		return
+	}
+	return from, to, !to.Less(from), nil
+}
+
+func parseStreamBound(s string, end bool) (StreamID, bool, error) {
+	switch s {
+	case "-":
+		return StreamID{}, true, nil
+	case "+":
+		return MaxStreamID, true, nil
+	}
+	exclusive := strings.HasPrefix(s, "(")
+	if exclusive {
+		s = s[1:]
+	}
+	var defaultSeq uint64
+	if end {
+		defaultSeq = math.MaxUint64
+	}
+	id, err := parseStreamID(s, defaultSeq)
+	if err != nil || !exclusive {
+		return id, err == nil, err
+	}
+	if end {
+		id, ok := id.prev()
+		return id, ok, nil
+	}
+	id, ok := id.next()
+	return id, ok, nil
+}
+
+// StreamEntry is one stream record.
+type StreamEntry struct {
+	ID     StreamID               `json:"id"`
+	Fields map[string]interface{} `json:"fields"`
+}
+
+// StreamRead holds the entries XREAD and XREADGROUP return for one stream.
+type StreamRead struct {
+	Key     string        `json:"key"`
+	Entries []StreamEntry `json:"entries"`
+}
+
+// XReadOptions configures XRead and XReadGroup. Count limits the entries
+// returned per stream; zero means no limit. With Block a read that finds
+// nothing new waits for entries to arrive, up to Timeout or, when Timeout is
+// zero, until its context is done. NoAck makes XReadGroup deliver without
+// adding to the pending entries list.
+type XReadOptions struct {
+	Count   int
+	Block   bool
+	Timeout time.Duration
+	NoAck   bool
+}
+
+// StreamPending is an entry of a consumer group's pending entries list: a
+// message delivered to Consumer and not yet acknowledged.
+type StreamPending struct {
+	ID          StreamID  `json:"id"`
+	Consumer    string    `json:"consumer"`
+	DeliveredAt time.Time `json:"delivered_at"`
+	Deliveries  int       `json:"deliveries"`
+}
+
+// StreamGroup is a consumer group. LastDelivered is the ID of the newest
+// entry handed to any of its consumers; Pending holds what they have not
+// acknowledged yet.
+type StreamGroup struct {
+	Name          string
+	LastDelivered StreamID
+	Pending       map[StreamID]*StreamPending
+}
+
+func NewStreamGroup(name string, lastDelivered StreamID) *StreamGroup {
+	return &StreamGroup{Name: name, LastDelivered: lastDelivered, Pending: make(map[StreamID]*StreamPending)}
+}
+
+// Deliver records that id was handed to consumer at now.
+func (g *StreamGroup) Deliver(id StreamID, consumer string, now time.Time) {
+	p, ok := g.Pending[id]
+	if !ok {
+		p = &StreamPending{ID: id}
+		g.Pending[id] = p
+	}
+	p.Consumer = consumer
+	p.DeliveredAt = now
+	p.Deliveries++
+}
+
+// Ack removes id from the pending entries list and reports whether it was
+// there.
+func (g *StreamGroup) Ack(id StreamID) bool {
+	if _, ok := g.Pending[id]; !ok {
+		return false
+	}
+	delete(g.Pending, id)
+	return true
+}
+
+// PendingList returns the pending entries ordered by ID, limited to those of
+// consumer when it is not empty.
+func (g *StreamGroup) PendingList(consumer string) []StreamPending {
+	out := make([]StreamPending, 0, len(g.Pending))
+	for _, p := range g.Pending {
+		if consumer == "" || p.Consumer == consumer {
+			out = append(out, *p)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID.Less(out[j].ID) })
+	return out
+}
+
+func (g *StreamGroup) clone() *StreamGroup {
+	c := NewStreamGroup(g.Name, g.LastDelivered)
+	for id, p := range g.Pending {
+		cp := *p
+		c.Pending[id] = &cp
+	}
+	return c
+}
+
+// StreamLog is an append-only log of entries ordered by ID, with consumer
+// groups. Entries are never modified once added, so copies of a stream share
+// their field maps.
+type StreamLog struct {
+	entries []StreamEntry
+	last    StreamID
+	groups  map[string]*StreamGroup
+}
+
+func NewStreamLog() *StreamLog {
+	return &StreamLog{groups: make(map[string]*StreamGroup)}
+}
+
+func (s *StreamLog) Len() int {
+	return len(s.entries)
+}
+
+// LastID returns the ID of the newest entry ever added, which trimming does not
+// lower.
+func (s *StreamLog) LastID() StreamID {
+	return s.last
+}
+
+// SetLastID raises the last ID, for streams restored from a snapshot.
+func (s *StreamLog) SetLastID(id StreamID) {
+	if s.last.Less(id) {
+		s.last = id
+	}
+}
+
+// NextID returns the ID "*" stands for at now: the current millisecond, or the
+// last ID's if the clock is behind it, with the next free sequence number.
+func (s *StreamLog) NextID(now time.Time) (StreamID, bool) {
+	ms := uint64(now.UnixMilli())
+	if ms < s.last.Ms {
+		ms = s.last.Ms
+	}
+	return s.NextIDAt(ms)
+}
+
+// NextIDAt returns the ID "ms-*" stands for. ok is false if no ID with that
+// millisecond is greater than the last ID.
+func (s *StreamLog) NextIDAt(ms uint64) (StreamID, bool) {
+	switch {
+	case s.last.IsZero() && ms == 0:
+		return StreamID{Seq: 1}, true
+	case ms > s.last.Ms:
+		return StreamID{Ms: ms}, true
+	case ms == s.last.Ms:
+		return s.last.next()
+	}
+	return StreamID{}, false
+}
+
+// Append adds an entry. It reports false, and adds nothing, unless the ID is
+// greater than the last ID.
+func (s *StreamLog) Append(entry StreamEntry) bool {
+	if !s.last.Less(entry.ID) {
+		return false
+	}
+	s.entries = append(s.entries, entry)
+	s.last = entry.ID
+	return true
+}
+
+// Entries returns every entry, oldest first. The slice must not be modified.
+func (s *StreamLog) Entries() []StreamEntry {
+	return s.entries
+}
+
+// search returns the index of the first entry whose ID is not below id.
+func (s *StreamLog) search(id StreamID) int {
+	return sort.Search(len(s.entries), func(i int) bool { return !s.entries[i].ID.Less(id) })
+}
+
+// Get returns the entry with the given ID.
+func (s *StreamLog) Get(id StreamID) (StreamEntry, bool) {
+	i := s.search(id)
+	if i < len(s.entries) && s.entries[i].ID == id {
+		return s.entries[i], true
+	}
+	return StreamEntry{}, false
+}
+
+// Range returns the entries with an ID between from and to, inclusive, at most
+// count of them when count > 0. With rev the newest come first.
+func (s *StreamLog) Range(from, to StreamID, count int, rev bool) []StreamEntry {
+	lo := s.search(from)
+	hi := s.search(to)
+	if hi < len(s.entries) && s.entries[hi].ID == to {
+		hi++
+	}
+	if lo >= hi {
+		return []StreamEntry{}
+	}
+	n := hi - lo
+	if count > 0 && count < n {
+		n = count
+	}
+	out := make([]StreamEntry, n)
+	for i := range out {
+		if rev {
+			out[i] = s.entries[hi-1-i]
+		} else {
+			out[i] = s.entries[lo+i]
+		}
+	}
+	return out
+}
+
+// After returns up to count entries with an ID greater than id, oldest first.
+func (s *StreamLog) After(id StreamID, count int) []StreamEntry {
+	from, ok := id.next()
+	if !ok {
+		return []StreamEntry{}
+	}
+	return s.Range(from, MaxStreamID, count, false)
+}
+
+// Trim drops the oldest entries until at most maxLen remain and returns how
+// many it dropped.
+func (s *StreamLog) Trim(maxLen int) int {
+	if maxLen < 0 {
+		maxLen = 0
+	}
+	drop := len(s.entries) - maxLen
+	if drop <= 0 {
+		return 0
+	}
+	s.entries = append([]StreamEntry(nil), s.entries[drop:]...)
+	return drop
+}
+
+func (s *StreamLog) Group(name string) *StreamGroup {
+	return s.groups[name]
+}
+
+// Groups returns the consumer groups ordered by name.
+func (s *StreamLog) Groups() []*StreamGroup {
+	out := make([]*StreamGroup, 0, len(s.groups))
+	for _, g := range s.groups {
+		out = append(out, g)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// AddGroup adds g unless a group with its name exists, and reports whether it
+// did.
+func (s *StreamLog) AddGroup(g *StreamGroup) bool {
+	if _, ok := s.groups[g.Name]; ok {
+		return false
+	}
+	s.groups[g.Name] = g
+	return true
+}
+
+func (s *StreamLog) RemoveGroup(name string) bool {
+	if _, ok := s.groups[name]; !ok {
+		return false
+	}
+	delete(s.groups, name)
+	return true
+}
+
+// Clone returns a copy that shares no mutable state with s.
+func (s *StreamLog) Clone() *StreamLog {
+	c := &StreamLog{
+		entries: append([]StreamEntry(nil), s.entries...),
+		last:    s.last,
+		groups:  make(map[string]*StreamGroup, len(s.groups)),
+	}
+	for name, g := range s.groups {
+		c.groups[name] = g.clone()
+	}
+	return c
+}
+
+func (s *StreamLog) String() string {
+	return fmt.Sprintf("stream(%d entries, last %s)", len(s.entries), s.last)
+}
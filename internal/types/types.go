@@ -9,10 +9,117 @@ const (
 	List
 	Hash
 	Set
+	ZSet
+	Stream
 )
 
+// Entry is the value stored at a key. FieldExpirations holds, for a hash, the
+// expiration of each field that has one; it is nil otherwise. Change it through
+// SetFieldExpiration and ClearFieldExpiration, which keep track of the earliest
+// one so that checking a hash for expired fields does not have to scan them.
 type Entry struct {
-	Value      interface{}
-	Type       DataType
-	Expiration time.Time
+	Value            interface{}
+	Type             DataType
+	Expiration       time.Time
+	FieldExpirations map[string]time.Time
+
+	firstFieldExpiration time.Time
+}
+
+// SetFieldExpiration sets the expiration of field to at.
+func (e *Entry) SetFieldExpiration(field string, at time.Time) {
+	if e.FieldExpirations == nil {
+		e.FieldExpirations = make(map[string]time.Time)
+	}
+	old, had := e.FieldExpirations[field]
+	e.FieldExpirations[field] = at
+	switch {
+	case len(e.FieldExpirations) == 1:
+		e.firstFieldExpiration = at
+	case had && old.Equal(e.firstFieldExpiration):
+		e.findFirstFieldExpiration()
+	case at.Before(e.firstFieldExpiration):
+		e.firstFieldExpiration = at
+	}
+}
+
+// ClearFieldExpiration removes the expiration of each of fields that has one.
+// The map is dropped with its last expiration.
+func (e *Entry) ClearFieldExpiration(fields ...string) {
+	wasFirst := false
+	for _, field := range fields {
+		if old, had := e.FieldExpirations[field]; had {
+			wasFirst = wasFirst || old.Equal(e.firstFieldExpiration)
+			delete(e.FieldExpirations, field)
+		}
+	}
+	if len(e.FieldExpirations) == 0 {
+		e.FieldExpirations = nil
+		e.firstFieldExpiration = time.Time{}
+	} else if wasFirst {
+		e.findFirstFieldExpiration()
+	}
+}
+
+// FirstFieldExpiration returns the earliest of the field expirations, or the
+// zero time when there are none. It is also zero for an entry whose
+// FieldExpirations was filled in directly, so a zero result only means that no
+// field can be assumed to be live.
+func (e Entry) FirstFieldExpiration() time.Time {
+	return e.firstFieldExpiration
+}
+
+func (e *Entry) findFirstFieldExpiration() {
+	e.firstFieldExpiration = time.Time{}
+	for _, at := range e.FieldExpirations {
+		if e.firstFieldExpiration.IsZero() || at.Before(e.firstFieldExpiration) {
+			e.firstFieldExpiration = at
+		}
+	}
+}
+
+// Clone returns a copy of the entry whose aggregate value (list, hash, set, sorted set
+// or stream) and field expirations no longer share memory with the original.
+func (e Entry) Clone() Entry {
+	if e.FieldExpirations != nil {
+		expirations := make(map[string]time.Time, len(e.FieldExpirations))
+		for field, at := range e.FieldExpirations {
+			expirations[field] = at
+		}
+		e.FieldExpirations = expirations
+	}
+	switch v := e.Value.(type) {
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		copy(list, v)
+		e.Value = list
+	case map[string]interface{}:
+		hash := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			hash[k] = val
+		}
+		e.Value = hash
+	case map[interface{}]struct{}:
+		set := make(map[interface{}]struct{}, len(v))
+		for m := range v {
+			set[m] = struct{}{}
+		}
+		e.Value = set
+	case *Deque:
+		e.Value = v.Clone()
+	case *SortedSet:
+		e.Value = v.Clone()
+	case *StreamLog:
+		e.Value = v.Clone()
+	}
+	return e
+}
+
+// TxResult is the outcome of one operation applied by a transaction commit.
+// Value holds what the operation produced at commit time: the new counter for
+// increments, the popped element, the previous value for GetSet, and so on.
+type TxResult struct {
+	Op    string
+	Key   string
+	Value interface{}
 }
@@ -0,0 +1,42 @@
+package types
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestFirstFieldExpirationAgainstModel checks the tracked earliest field
+// expiration against a scan of the map under random sets, overwrites and
+// clears.
+func TestFirstFieldExpirationAgainstModel(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	base := time.Unix(1700000000, 0)
+	var e Entry
+
+	for i := 0; i < 20000; i++ {
+		field := strconv.Itoa(rng.Intn(16))
+		switch op := rng.Intn(5); {
+		case op < 3:
+			e.SetFieldExpiration(field, base.Add(time.Duration(rng.Intn(8))*time.Second))
+		case op < 4:
+			e.ClearFieldExpiration(field)
+		default:
+			e.ClearFieldExpiration(field, strconv.Itoa(rng.Intn(16)))
+		}
+
+		var want time.Time
+		for _, at := range e.FieldExpirations {
+			if want.IsZero() || at.Before(want) {
+				want = at
+			}
+		}
+		if got := e.FirstFieldExpiration(); !got.Equal(want) {
+			t.Fatalf("step %d: FirstFieldExpiration = %v, want %v", i, got, want)
+		}
+		if e.FieldExpirations != nil && len(e.FieldExpirations) == 0 {
+			t.Fatalf("step %d: empty field expirations map was kept", i)
+		}
+	}
+}
@@ -0,0 +1,459 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+const (
+	zskiplistMaxLevel = 32
+	zskiplistP        = 0.25
+)
+
+var ErrInvalidBound = errors.New("invalid range bound")
+
+// ZMember is a sorted set member together with its score.
+type ZMember struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// ZAddOptions mirrors the ZADD flags. NX only adds new members, XX only updates
+// existing ones, GT/LT only update when the new score is greater/less than the
+// current one, and CH makes ZAdd count changed members as well as added ones.
+type ZAddOptions struct {
+	NX bool
+	XX bool
+	GT bool
+	LT bool
+	CH bool
+}
+
+// ZRangeOptions controls score and lex range queries. Rev walks from the high end,
+// Offset skips matching members and Count limits the result; Count <= 0 returns
+// every remaining member.
+type ZRangeOptions struct {
+	Rev    bool
+	Offset int
+	Count  int
+}
+
+// ScoreBound is one end of a score range, e.g. "(1.5" is {1.5, true}.
+type ScoreBound struct {
+	Value     float64
+	Exclusive bool
+}
+
+// LexBound is one end of a lexicographic range. Inf is -1 for "-" and 1 for "+".
+type LexBound struct {
+	Value     string
+	Exclusive bool
+	Inf       int
+}
+
+// ParseScoreBound parses "1.5", "(1.5", "-inf" and "+inf".
+func ParseScoreBound(s string) (ScoreBound, error) {
+	var b ScoreBound
+	if strings.HasPrefix(s, "(") {
+		b.Exclusive = true
+		s = s[1:]
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) {
+		return ScoreBound{}, ErrInvalidBound
+	}
+	b.Value = v
+	return b, nil
+}
+
+// ParseLexBound parses "[a", "(a", "-" and "+".
+func ParseLexBound(s string) (LexBound, error) {
+	switch {
+	case s == "-":
+		return LexBound{Inf: -1}, nil
+	case s == "+":
+		return LexBound{Inf: 1}, nil
+	case strings.HasPrefix(s, "["):
+		return LexBound{Value: s[1:]}, nil
+	case strings.HasPrefix(s, "("):
+		return LexBound{Value: s[1:], Exclusive: true}, nil
+	default:
+		return LexBound{}, ErrInvalidBound
+	}
+}
+
+func (b ScoreBound) aboveMin(score float64) bool {
+	if b.Exclusive {
+		return score > b.Value
+	}
+	return score >= b.Value
+}
+
+func (b ScoreBound) belowMax(score float64) bool {
+	if b.Exclusive {
+		return score < b.Value
+	}
+	return score <= b.Value
+}
+
+func (b LexBound) aboveMin(member string) bool {
+	switch {
+	case b.Inf < 0:
+		return true
+	case b.Inf > 0:
+		return false
+	case b.Exclusive:
+		return member > b.Value
+	default:
+		return member >= b.Value
+	}
+}
+
+func (b LexBound) belowMax(member string) bool {
+	switch {
+	case b.Inf > 0:
+		return true
+	case b.Inf < 0:
+		return false
+	case b.Exclusive:
+		return member < b.Value
+	default:
+		return member <= b.Value
+	}
+}
+
+type zskipLevel struct {
+	forward *zskipNode
+	span    int
+}
+
+type zskipNode struct {
+	member   string
+	score    float64
+	backward *zskipNode
+	level    []zskipLevel
+}
+
+// SortedSet keeps unique string members ordered by score, then by member. A map
+// gives O(1) score lookups and a skiplist with spans gives O(log n) inserts,
+// deletes and rank queries.
+type SortedSet struct {
+	dict   map[string]float64
+	head   *zskipNode
+	tail   *zskipNode
+	level  int
+	length int
+}
+
+func NewSortedSet() *SortedSet {
+	return &SortedSet{
+		dict:  make(map[string]float64),
+		head:  &zskipNode{level: make([]zskipLevel, zskiplistMaxLevel)},
+		level: 1,
+	}
+}
+
+func zslLess(score float64, member string, otherScore float64, otherMember string) bool {
+	return score < otherScore || (score == otherScore && member < otherMember)
+}
+
+func zslRandomLevel() int {
+	level := 1
+	for level < zskiplistMaxLevel && rand.Float64() < zskiplistP {
+		level++
+	}
+	return level
+}
+
+func (z *SortedSet) Len() int {
+	return z.length
+}
+
+func (z *SortedSet) Score(member string) (float64, bool) {
+	score, ok := z.dict[member]
+	return score, ok
+}
+
+// Add inserts member or moves it to a new score. It reports whether the member
+// was newly added.
+func (z *SortedSet) Add(member string, score float64) bool {
+	if old, ok := z.dict[member]; ok {
+		if old != score {
+			z.delete(member, old)
+			z.insert(member, score)
+			z.dict[member] = score
+		}
+		return false
+	}
+	z.insert(member, score)
+	z.dict[member] = score
+	return true
+}
+
+func (z *SortedSet) Remove(member string) bool {
+	score, ok := z.dict[member]
+	if !ok {
+		return false
+	}
+	z.delete(member, score)
+	delete(z.dict, member)
+	return true
+}
+
+// Rank returns the 0-based position of member in ascending order.
+func (z *SortedSet) Rank(member string) (int, bool) {
+	score, ok := z.dict[member]
+	if !ok {
+		return 0, false
+	}
+	rank := 0
+	x := z.head
+	for i := z.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !zslLess(score, member, x.level[i].forward.score, x.level[i].forward.member) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != z.head && x.member == member {
+			return rank - 1, true
+		}
+	}
+	return 0, false
+}
+
+// Range returns members between the 0-based ranks start and stop, inclusive.
+// Negative indexes count from the end. With rev the ranks count from the highest
+// score.
+func (z *SortedSet) Range(start, stop int, rev bool) []ZMember {
+	if start < 0 {
+		start += z.length
+	}
+	if stop < 0 {
+		stop += z.length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= z.length {
+		stop = z.length - 1
+	}
+	if start > stop || start >= z.length {
+		return []ZMember{}
+	}
+
+	out := make([]ZMember, 0, stop-start+1)
+	if rev {
+		for x := z.byRank(z.length - start); x != nil && len(out) < cap(out); x = x.backward {
+			out = append(out, ZMember{Member: x.member, Score: x.score})
+		}
+		return out
+	}
+	for x := z.byRank(start + 1); x != nil && len(out) < cap(out); x = x.level[0].forward {
+		out = append(out, ZMember{Member: x.member, Score: x.score})
+	}
+	return out
+}
+
+// RangeByScore returns members with min <= score <= max, honoring exclusive
+// bounds and opts.
+func (z *SortedSet) RangeByScore(min, max ScoreBound, opts ZRangeOptions) []ZMember {
+	if opts.Rev {
+		x := z.lastMatching(func(n *zskipNode) bool { return max.belowMax(n.score) })
+		return collect(x, opts, true, func(n *zskipNode) bool { return min.aboveMin(n.score) })
+	}
+	x := z.firstMatching(func(n *zskipNode) bool { return min.aboveMin(n.score) })
+	return collect(x, opts, false, func(n *zskipNode) bool { return max.belowMax(n.score) })
+}
+
+// RangeByLex returns members between min and max by member name. It is only
+// meaningful when every member has the same score.
+func (z *SortedSet) RangeByLex(min, max LexBound, opts ZRangeOptions) []ZMember {
+	if opts.Rev {
+		x := z.lastMatching(func(n *zskipNode) bool { return max.belowMax(n.member) })
+		return collect(x, opts, true, func(n *zskipNode) bool { return min.aboveMin(n.member) })
+	}
+	x := z.firstMatching(func(n *zskipNode) bool { return min.aboveMin(n.member) })
+	return collect(x, opts, false, func(n *zskipNode) bool { return max.belowMax(n.member) })
+}
+
+// Count returns the number of members with a score between min and max.
+func (z *SortedSet) Count(min, max ScoreBound) int {
+	first := z.firstMatching(func(n *zskipNode) bool { return min.aboveMin(n.score) })
+	if first == nil || !max.belowMax(first.score) {
+		return 0
+	}
+	last := z.lastMatching(func(n *zskipNode) bool { return max.belowMax(n.score) })
+	firstRank, _ := z.Rank(first.member)
+	lastRank, _ := z.Rank(last.member)
+	return lastRank - firstRank + 1
+}
+
+// Members returns every member in ascending order.
+func (z *SortedSet) Members() []ZMember {
+	return z.Range(0, -1, false)
+}
+
+// MarshalJSON encodes the set as its members in ascending order.
+func (z *SortedSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(z.Members())
+}
+
+func (z *SortedSet) Clone() *SortedSet {
+	c := NewSortedSet()
+	for x := z.head.level[0].forward; x != nil; x = x.level[0].forward {
+		c.Add(x.member, x.score)
+	}
+	return c
+}
+
+func collect(x *zskipNode, opts ZRangeOptions, rev bool, inRange func(*zskipNode) bool) []ZMember {
+	out := []ZMember{}
+	skipped := 0
+	for ; x != nil && inRange(x); x = next(x, rev) {
+		if skipped < opts.Offset {
+			skipped++
+			continue
+		}
+		out = append(out, ZMember{Member: x.member, Score: x.score})
+		if opts.Count > 0 && len(out) == opts.Count {
+			break
+		}
+	}
+	return out
+}
+
+func next(x *zskipNode, rev bool) *zskipNode {
+	if rev {
+		return x.backward
+	}
+	return x.level[0].forward
+}
+
+// firstMatching returns the first node for which ok holds, assuming ok is false
+// for a prefix of the list and true afterwards.
+func (z *SortedSet) firstMatching(ok func(*zskipNode) bool) *zskipNode {
+	x := z.head
+	for i := z.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !ok(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+	return x.level[0].forward
+}
+
+// lastMatching returns the last node for which ok holds, assuming ok is true for
+// a prefix of the list and false afterwards.
+func (z *SortedSet) lastMatching(ok func(*zskipNode) bool) *zskipNode {
+	x := z.head
+	for i := z.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && ok(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+	if x == z.head {
+		return nil
+	}
+	return x
+}
+
+// byRank returns the node at the 1-based rank.
+func (z *SortedSet) byRank(rank int) *zskipNode {
+	traversed := 0
+	x := z.head
+	for i := z.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+func (z *SortedSet) insert(member string, score float64) {
+	var update [zskiplistMaxLevel]*zskipNode
+	var rank [zskiplistMaxLevel]int
+
+	x := z.head
+	for i := z.level - 1; i >= 0; i-- {
+		if i < z.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && zslLess(x.level[i].forward.score, x.level[i].forward.member, score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := zslRandomLevel()
+	if level > z.level {
+		for i := z.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = z.head
+			update[i].level[i].span = z.length
+		}
+		z.level = level
+	}
+
+	x = &zskipNode{member: member, score: score, level: make([]zskipLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < z.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != z.head {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		z.tail = x
+	}
+	z.length++
+}
+
+func (z *SortedSet) delete(member string, score float64) {
+	var update [zskiplistMaxLevel]*zskipNode
+
+	x := z.head
+	for i := z.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && zslLess(x.level[i].forward.score, x.level[i].forward.member, score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return
+	}
+
+	for i := 0; i < z.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		z.tail = x.backward
+	}
+	for z.level > 1 && z.head.level[z.level-1].forward == nil {
+		z.level--
+	}
+	z.length--
+}
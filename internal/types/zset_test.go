@@ -0,0 +1,114 @@
+package types
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func sortedModel(m map[string]float64) []ZMember {
+	out := make([]ZMember, 0, len(m))
+	for member, score := range m {
+		out = append(out, ZMember{Member: member, Score: score})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return zslLess(out[i].Score, out[i].Member, out[j].Score, out[j].Member)
+	})
+	return out
+}
+
+// TestSortedSetAgainstModel checks the skiplist against a sorted slice under random
+// inserts, score updates and removals.
+func TestSortedSetAgainstModel(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	z := NewSortedSet()
+	model := map[string]float64{}
+
+	for i := 0; i < 5000; i++ {
+		member := fmt.Sprintf("m%d", rng.Intn(300))
+		if rng.Intn(4) == 0 {
+			_, want := model[member]
+			if got := z.Remove(member); got != want {
+				t.Fatalf("Remove(%s) = %v, want %v", member, got, want)
+			}
+			delete(model, member)
+			continue
+		}
+		score := float64(rng.Intn(50))
+		_, existed := model[member]
+		if added := z.Add(member, score); added == existed {
+			t.Fatalf("Add(%s) reported added=%v for existing=%v", member, added, existed)
+		}
+		model[member] = score
+	}
+
+	want := sortedModel(model)
+	if z.Len() != len(want) {
+		t.Fatalf("Len = %d, want %d", z.Len(), len(want))
+	}
+	got := z.Members()
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Member %d = %+v, want %+v", i, got[i], want[i])
+		}
+		if rank, ok := z.Rank(want[i].Member); !ok || rank != i {
+			t.Fatalf("Rank(%s) = %d, want %d", want[i].Member, rank, i)
+		}
+	}
+
+	// Scenario: reverse index ranges mirror the forward order
+	rev := z.Range(0, 9, true)
+	for i, m := range rev {
+		if m != want[len(want)-1-i] {
+			t.Fatalf("Reverse member %d = %+v, want %+v", i, m, want[len(want)-1-i])
+		}
+	}
+
+	// Scenario: score ranges and counts agree with the model
+	min, max := ScoreBound{Value: 10}, ScoreBound{Value: 20, Exclusive: true}
+	var inRange []ZMember
+	for _, m := range want {
+		if m.Score >= 10 && m.Score < 20 {
+			inRange = append(inRange, m)
+		}
+	}
+	if n := z.Count(min, max); n != len(inRange) {
+		t.Errorf("Count = %d, want %d", n, len(inRange))
+	}
+	byScore := z.RangeByScore(min, max, ZRangeOptions{Offset: 2, Count: 5})
+	if len(byScore) != 5 || byScore[0] != inRange[2] {
+		t.Errorf("RangeByScore with limit = %+v", byScore)
+	}
+	revScore := z.RangeByScore(min, max, ZRangeOptions{Rev: true})
+	if len(revScore) != len(inRange) || revScore[0] != inRange[len(inRange)-1] {
+		t.Errorf("Reverse RangeByScore first = %+v", revScore[0])
+	}
+}
+
+// TestSortedSetRangeByLex checks lexicographic bounds on equal scores.
+func TestSortedSetRangeByLex(t *testing.T) {
+	z := NewSortedSet()
+	for _, m := range []string{"a", "b", "c", "d", "e"} {
+		z.Add(m, 0)
+	}
+
+	min, _ := ParseLexBound("(a")
+	max, _ := ParseLexBound("[d")
+	got := z.RangeByLex(min, max, ZRangeOptions{})
+	if len(got) != 3 || got[0].Member != "b" || got[2].Member != "d" {
+		t.Errorf("RangeByLex (a [d = %+v", got)
+	}
+
+	all := z.RangeByLex(LexBound{Inf: -1}, LexBound{Inf: 1}, ZRangeOptions{Rev: true, Count: 2})
+	if len(all) != 2 || all[0].Member != "e" || all[1].Member != "d" {
+		t.Errorf("Reverse RangeByLex - + LIMIT 0 2 = %+v", all)
+	}
+
+	if _, err := ParseLexBound("a"); err == nil {
+		t.Error("Expected an error for a lex bound without a prefix")
+	}
+	if _, err := ParseScoreBound("abc"); err == nil {
+		t.Error("Expected an error for a non-numeric score bound")
+	}
+}
@@ -0,0 +1,575 @@
+package hermes
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/themedef/go-hermes/internal/types"
+)
+
+type (
+	ListSide    = types.ListSide
+	LPosOptions = types.LPosOptions
+)
+
+const (
+	ListLeft  = types.ListLeft
+	ListRight = types.ListRight
+)
+
+// listForRead returns the list stored at key. The caller holds the shard lock.
+func (db *DB) listForRead(sh *shard, key, op string) (*types.Deque, error) {
+	entry, exists := sh.data[key]
+	if !exists || db.isExpired(entry) {
+		db.logger.Warn(op+" failed: key not found or expired", "key", key)
+		return nil, ErrKeyNotFound
+	}
+	if entry.Type != types.List {
+		db.logger.Error(op+" failed: existing key is not a list", "key", key)
+		return nil, ErrInvalidType
+	}
+	list, ok := entry.Value.(*types.Deque)
+	if !ok {
+		db.logger.Error(op+" failed: stored value is not a valid list", "key", key)
+		return nil, ErrInvalidType
+	}
+	return list, nil
+}
+
+// storedList returns e with a list given as a plain slice, the form
+// GetRawEntry hands out, converted to the deque lists are stored as.
+func storedList(e types.Entry) types.Entry {
+	if values, ok := e.Value.([]interface{}); ok && e.Type == types.List {
+		e.Value = types.NewDeque(values...)
+	}
+	return e
+}
+
+// listForWrite is listForRead for mutations: an expired key is dropped and,
+// when create is set, a missing key yields a new empty list that is not yet
+// stored.
+func (db *DB) listForWrite(sh *shard, key, op string, create bool) (*types.Deque, types.Entry, error) {
+	entry, exists := sh.data[key]
+	if exists && db.isExpired(entry) {
+		db.removeExpired(sh, key, entry)
+		exists = false
+		db.logger.Info(op+" removed expired key", "key", key)
+	}
+	if !exists {
+		if !create {
+			db.logger.Warn(op+" failed: key not found or expired", "key", key)
+			return nil, types.Entry{}, ErrKeyNotFound
+		}
+		list := types.NewDeque()
+		return list, types.Entry{Value: list, Type: types.List}, nil
+	}
+	list, err := db.listForRead(sh, key, op)
+	return list, entry, err
+}
+
+// listTake removes the element at one side of the list stored at key and
+// returns it with the list's entry, deleting the key when the list empties.
+// A missing list is ErrKeyNotFound. The caller holds the shard lock and
+// records the write.
+func (db *DB) listTake(sh *shard, key string, side types.ListSide) (val interface{}, entry types.Entry, emptied bool, err error) {
+	entry, exists := sh.data[key]
+	if !exists || db.isExpired(entry) {
+		return nil, entry, false, ErrKeyNotFound
+	}
+	list, err := db.listForRead(sh, key, "List pop")
+	if err != nil {
+		return nil, entry, false, err
+	}
+
+	var ok bool
+	if side == types.ListLeft {
+		val, ok = list.PopFront()
+	} else {
+		val, ok = list.PopBack()
+	}
+	if !ok {
+		return nil, entry, false, ErrKeyNotFound
+	}
+	if list.Len() == 0 {
+		delete(sh.data, key)
+		return val, entry, true, nil
+	}
+	return val, entry, false, nil
+}
+
+// listPut adds val at one side of the list stored at key, creating the list
+// if needed, and returns the list's entry. The caller holds the shard lock
+// and records the write.
+func (db *DB) listPut(sh *shard, key string, val interface{}, side types.ListSide) (types.Entry, error) {
+	list, entry, err := db.listForWrite(sh, key, "List push", true)
+	if err != nil {
+		return entry, err
+	}
+	if side == types.ListLeft {
+		list.PushFront(val)
+	} else {
+		list.PushBack(val)
+	}
+	sh.data[key] = entry
+	return entry, nil
+}
+
+func popEvent(side types.ListSide) types.EventOp {
+	if side == types.ListLeft {
+		return types.EventLPop
+	}
+	return types.EventRPop
+}
+
+func pushEvent(side types.ListSide) types.EventOp {
+	if side == types.ListLeft {
+		return types.EventLPush
+	}
+	return types.EventRPush
+}
+
+// popFrom pops an element from one side of the list at key and reports whether
+// there was one.
+func (db *DB) popFrom(ctx context.Context, key string, side types.ListSide) (interface{}, bool, error) {
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.lockShard(ctx, sh)()
+
+	val, entry, emptied, err := db.listTake(sh, key, side)
+	if IsKeyNotFound(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	op := opLPop
+	if side == types.ListRight {
+		op = opRPop
+	}
+	db.afterWrite(op, key)
+	db.notify(ctx, types.Event{Op: popEvent(side), Key: key, Type: types.List, OldValue: val, TTL: ttlLeft(entry.Expiration)})
+	if emptied {
+		db.notify(ctx, types.Event{Op: types.EventDel, Key: key, Type: types.List})
+	}
+	return val, true, nil
+}
+
+// lmove atomically pops an element from one side of the list at source and
+// pushes it to one side of the list at destination, and reports whether
+// source had an element to move. source and destination may be the same list.
+func (db *DB) lmove(ctx context.Context, source, destination string, from, to types.ListSide) (interface{}, bool, error) {
+	srcIndex, dstIndex := db.getShardIndex(source), db.getShardIndex(destination)
+	src, dst := db.shards[srcIndex], db.shards[dstIndex]
+	defer db.lockShards(ctx, []int{srcIndex, dstIndex})()
+
+	if entry, exists := dst.data[destination]; exists && !db.isExpired(entry) {
+		if _, err := db.listForRead(dst, destination, "List move"); err != nil {
+			return nil, false, err
+		}
+	}
+	val, srcEntry, emptied, err := db.listTake(src, source, from)
+	if IsKeyNotFound(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	dstEntry, err := db.listPut(dst, destination, val, to)
+	if err != nil {
+		return nil, false, err
+	}
+
+	db.afterWrite(opLMove, source, destination, from.String(), to.String())
+	db.notify(ctx, types.Event{Op: popEvent(from), Key: source, Type: types.List, OldValue: val, TTL: ttlLeft(srcEntry.Expiration)})
+	if emptied && source != destination {
+		db.notify(ctx, types.Event{Op: types.EventDel, Key: source, Type: types.List})
+	}
+	db.notify(ctx, types.Event{Op: pushEvent(to), Key: destination, Type: types.List, NewValue: []interface{}{val}, TTL: ttlLeft(dstEntry.Expiration)})
+	return val, true, nil
+}
+
+// BLPop pops the first element of the first non-empty list among keys and
+// returns it with the key it came from. While every list is empty it waits
+// for a push until ctx is done, which is ErrContextCanceled; callers waiting
+// on the same key are served in the order they started waiting. Inside a
+// transaction it does not wait and reports ErrKeyNotFound instead.
+func (db *DB) BLPop(ctx context.Context, keys ...string) (string, interface{}, error) {
+	return db.blockingPop(ctx, "BLPop", keys, types.ListLeft)
+}
+
+// BRPop is BLPop popping the last element of a list.
+func (db *DB) BRPop(ctx context.Context, keys ...string) (string, interface{}, error) {
+	return db.blockingPop(ctx, "BRPop", keys, types.ListRight)
+}
+
+func (db *DB) blockingPop(ctx context.Context, op string, keys []string, side types.ListSide) (string, interface{}, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn(op+" operation canceled", "keys", keys)
+		return "", nil, ErrContextCanceled
+	default:
+	}
+
+	if len(keys) == 0 {
+		db.logger.Warn(op + " called with no keys")
+		return "", nil, ErrEmptyValues
+	}
+
+	var (
+		key string
+		val interface{}
+	)
+	try := func(w *blockWaiter) (bool, error) {
+		for _, k := range keys {
+			if w != nil && !db.inLine(w, k) {
+				continue
+			}
+			v, ok, err := db.popFrom(ctx, k, side)
+			if err != nil || ok {
+				key, val = k, v
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+
+	var (
+		done bool
+		err  error
+	)
+	if canBlock(ctx) {
+		err = db.blockInLine(ctx, keys, 0, try)
+		done = err == nil
+	} else {
+		done, err = try(nil)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if !done {
+		db.logger.Info(op+" found no element", "keys", keys)
+		return "", nil, ErrKeyNotFound
+	}
+
+	db.logger.Info(op+" operation successful", "key", key, "poppedValue", val)
+	return key, val, nil
+}
+
+// BLMove atomically moves an element from one side of the list at source to
+// one side of the list at destination and returns it. While source is empty
+// it waits like BLPop.
+func (db *DB) BLMove(ctx context.Context, source, destination string, from, to ListSide) (interface{}, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("BLMove operation canceled", "source", source, "destination", destination)
+		return nil, ErrContextCanceled
+	default:
+	}
+
+	if source == "" || destination == "" {
+		db.logger.Error("BLMove failed: empty key", "source", source, "destination", destination)
+		return nil, ErrInvalidKey
+	}
+
+	var val interface{}
+	try := func(w *blockWaiter) (bool, error) {
+		if w != nil && !db.inLine(w, source) {
+			return false, nil
+		}
+		v, ok, err := db.lmove(ctx, source, destination, from, to)
+		val = v
+		return ok, err
+	}
+
+	var (
+		done bool
+		err  error
+	)
+	if canBlock(ctx) {
+		err = db.blockInLine(ctx, []string{source}, 0, try)
+		done = err == nil
+	} else {
+		done, err = try(nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !done {
+		db.logger.Info("BLMove found no element", "source", source)
+		return nil, ErrKeyNotFound
+	}
+
+	db.logger.Info("BLMove operation successful", "source", source, "destination", destination, "movedValue", val)
+	return val, nil
+}
+
+// listValuesEqual compares list elements for LINSERT, LREM and LPOS. Unlike ==
+// it does not panic on elements that are not comparable, such as slices.
+func listValuesEqual(a, b interface{}) bool {
+	if s, ok := a.(string); ok {
+		t, ok := b.(string)
+		return ok && s == t
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// listIndex resolves index, negative indexes counting from the tail, in a list
+// of length n and reports whether it is inside the list.
+func listIndex(index, n int) (int, bool) {
+	if index < 0 {
+		index += n
+	}
+	return index, index >= 0 && index < n
+}
+
+// LIndex returns the element at index. Negative indexes count from the tail,
+// -1 being the last element. An index outside the list is ErrIndexOutOfRange.
+func (db *DB) LIndex(ctx context.Context, key string, index int) (interface{}, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("LIndex operation canceled", "key", key)
+		return nil, ErrContextCanceled
+	default:
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.rlockShard(ctx, sh)()
+
+	list, err := db.listForRead(sh, key, "LIndex")
+	if err != nil {
+		return nil, err
+	}
+	i, ok := listIndex(index, list.Len())
+	if !ok {
+		db.logger.Warn("LIndex failed: index out of range", "key", key, "index", index, "length", list.Len())
+		return nil, ErrIndexOutOfRange
+	}
+
+	val := list.Index(i)
+	db.logger.Info("LIndex operation successful", "key", key, "index", index, "value", val)
+	return val, nil
+}
+
+// LSet replaces the element at index, counted as in LIndex.
+func (db *DB) LSet(ctx context.Context, key string, index int, value interface{}) error {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("LSet operation canceled", "key", key)
+		return ErrContextCanceled
+	default:
+	}
+
+	if err := db.checkLoggable("LSet", key, value); err != nil {
+		return err
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.lockShard(ctx, sh)()
+
+	list, entry, err := db.listForWrite(sh, key, "LSet", false)
+	if err != nil {
+		return err
+	}
+	i, ok := listIndex(index, list.Len())
+	if !ok {
+		db.logger.Warn("LSet failed: index out of range", "key", key, "index", index, "length", list.Len())
+		return ErrIndexOutOfRange
+	}
+
+	old := list.Index(i)
+	list.Set(i, value)
+	db.afterWrite(opLSet, key, int64(i), value)
+	db.notify(ctx, types.Event{Op: types.EventLSet, Key: key, Type: types.List, OldValue: old, NewValue: value, TTL: ttlLeft(entry.Expiration)})
+	db.logger.Info("LSet operation successful", "key", key, "index", i, "value", value)
+	return nil
+}
+
+// LInsert inserts value before or after the first element equal to pivot and
+// returns the new length of the list, or -1 if no element equals pivot.
+func (db *DB) LInsert(ctx context.Context, key string, before bool, pivot, value interface{}) (int, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("LInsert operation canceled", "key", key)
+		return 0, ErrContextCanceled
+	default:
+	}
+
+	if err := db.checkLoggable("LInsert", key, pivot, value); err != nil {
+		return 0, err
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.lockShard(ctx, sh)()
+
+	list, entry, err := db.listForWrite(sh, key, "LInsert", false)
+	if err != nil {
+		return 0, err
+	}
+	at := -1
+	for i := 0; i < list.Len(); i++ {
+		if listValuesEqual(list.Index(i), pivot) {
+			at = i
+			break
+		}
+	}
+	if at < 0 {
+		db.logger.Info("LInsert found no pivot", "key", key, "pivot", pivot)
+		return -1, nil
+	}
+
+	where := "BEFORE"
+	if !before {
+		where = "AFTER"
+		at++
+	}
+	list.Insert(at, value)
+	db.afterWrite(opLInsert, key, where, pivot, value)
+	db.notify(ctx, types.Event{Op: types.EventLInsert, Key: key, Type: types.List, NewValue: value, TTL: ttlLeft(entry.Expiration)})
+	db.logger.Info("LInsert operation successful", "key", key, "index", at, "value", value, "length", list.Len())
+	return list.Len(), nil
+}
+
+// LRem removes elements equal to value and returns how many it removed. With
+// count > 0 it removes the first count of them, with count < 0 the last -count,
+// and with count == 0 all of them. The key is deleted when the list empties.
+func (db *DB) LRem(ctx context.Context, key string, count int, value interface{}) (int, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("LRem operation canceled", "key", key)
+		return 0, ErrContextCanceled
+	default:
+	}
+
+	if err := db.checkLoggable("LRem", key, value); err != nil {
+		return 0, err
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.lockShard(ctx, sh)()
+
+	list, entry, err := db.listForWrite(sh, key, "LRem", false)
+	if err != nil {
+		return 0, err
+	}
+
+	// Matches are removed between the indexes from and to, which the count
+	// narrows down to the first or last matches.
+	from, to := 0, list.Len()
+	matched := 0
+	switch {
+	case count > 0:
+		for i := 0; i < list.Len() && matched < count; i++ {
+			if listValuesEqual(list.Index(i), value) {
+				matched++
+				to = i + 1
+			}
+		}
+	case count < 0:
+		for i := list.Len() - 1; i >= 0 && matched < -count; i-- {
+			if listValuesEqual(list.Index(i), value) {
+				matched++
+				from = i
+			}
+		}
+	}
+	removed := list.Filter(func(i int, v interface{}) bool {
+		return i < from || i >= to || !listValuesEqual(v, value)
+	})
+	if removed == 0 {
+		db.logger.Info("LRem found no element to remove", "key", key, "value", value)
+		return 0, nil
+	}
+	if list.Len() == 0 {
+		delete(sh.data, key)
+		db.logger.Info("LRem removed the key as the list is now empty", "key", key)
+	}
+
+	db.afterWrite(opLRem, key, int64(count), value)
+	db.notify(ctx, types.Event{Op: types.EventLRem, Key: key, Type: types.List, OldValue: value, TTL: ttlLeft(entry.Expiration)})
+	if list.Len() == 0 {
+		db.notify(ctx, types.Event{Op: types.EventDel, Key: key, Type: types.List})
+	}
+	db.logger.Info("LRem operation successful", "key", key, "value", value, "count", count, "removed", removed)
+	return removed, nil
+}
+
+// LPos returns the indexes of the elements equal to value, in the order they
+// are found; see LPosOptions. No match is an empty result.
+func (db *DB) LPos(ctx context.Context, key string, value interface{}, opts types.LPosOptions) ([]int, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("LPos operation canceled", "key", key)
+		return nil, ErrContextCanceled
+	default:
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.rlockShard(ctx, sh)()
+
+	list, err := db.listForRead(sh, key, "LPos")
+	if err != nil {
+		return nil, err
+	}
+
+	skip, step, i := opts.Rank-1, 1, 0
+	if opts.Rank == 0 {
+		skip = 0
+	} else if opts.Rank < 0 {
+		skip, step, i = -opts.Rank-1, -1, list.Len()-1
+	}
+	positions := []int{}
+	for compared := 0; i >= 0 && i < list.Len(); i, compared = i+step, compared+1 {
+		if opts.MaxLen > 0 && compared == opts.MaxLen {
+			break
+		}
+		if !listValuesEqual(list.Index(i), value) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		positions = append(positions, i)
+		if opts.Count > 0 && len(positions) == opts.Count {
+			break
+		}
+	}
+
+	db.logger.Info("LPos operation successful", "key", key, "value", value, "matches", len(positions))
+	return positions, nil
+}
+
+// LMove atomically moves an element from one side of the list at source to one
+// side of the list at destination and returns it. source and destination may
+// be the same list, and may live on different shards. An empty or missing
+// source is ErrKeyNotFound.
+func (db *DB) LMove(ctx context.Context, source, destination string, from, to ListSide) (interface{}, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("LMove operation canceled", "source", source, "destination", destination)
+		return nil, ErrContextCanceled
+	default:
+	}
+
+	if source == "" || destination == "" {
+		db.logger.Error("LMove failed: empty key", "source", source, "destination", destination)
+		return nil, ErrInvalidKey
+	}
+
+	val, ok, err := db.lmove(ctx, source, destination, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		db.logger.Warn("LMove failed: source list not found", "source", source)
+		return nil, ErrKeyNotFound
+	}
+
+	db.logger.Info("LMove operation successful", "source", source, "destination", destination, "movedValue", val)
+	return val, nil
+}
+
+// RPopLPush is LMove from the tail of source to the head of destination.
+func (db *DB) RPopLPush(ctx context.Context, source, destination string) (interface{}, error) {
+	return db.LMove(ctx, source, destination, types.ListRight, types.ListLeft)
+}
@@ -0,0 +1,311 @@
+package hermes
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/themedef/go-hermes/internal/resp"
+	"github.com/themedef/go-hermes/internal/types"
+)
+
+// parseBlockTimeout parses the timeout of BLPOP and friends: seconds as a
+// float, where 0 waits until the context is done.
+func parseBlockTimeout(s string) (time.Duration, error) {
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(secs) || math.IsInf(secs, 0) {
+		return 0, fmt.Errorf("timeout is not a float or out of range")
+	}
+	if secs < 0 {
+		return 0, fmt.Errorf("timeout is negative")
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+func parseListSide(s string) (types.ListSide, error) {
+	switch strings.ToUpper(s) {
+	case "LEFT":
+		return types.ListLeft, nil
+	case "RIGHT":
+		return types.ListRight, nil
+	}
+	return types.ListLeft, fmt.Errorf("syntax error")
+}
+
+// parseBLMoveArgs parses source destination LEFT|RIGHT LEFT|RIGHT timeout.
+func parseBLMoveArgs(args []string) (from, to types.ListSide, timeout time.Duration, err error) {
+	if len(args) != 5 {
+		return from, to, 0, fmt.Errorf("Usage: BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout")
+	}
+	if from, err = parseListSide(args[2]); err != nil {
+		return from, to, 0, err
+	}
+	if to, err = parseListSide(args[3]); err != nil {
+		return from, to, 0, err
+	}
+	timeout, err = parseBlockTimeout(args[4])
+	return from, to, timeout, err
+}
+
+// parseLInsertWhere parses the BEFORE|AFTER argument of LINSERT.
+func parseLInsertWhere(s string) (before bool, err error) {
+	switch strings.ToUpper(s) {
+	case "BEFORE":
+		return true, nil
+	case "AFTER":
+		return false, nil
+	}
+	return false, fmt.Errorf("syntax error")
+}
+
+// parseLPosArgs parses the options of LPOS key element [RANK rank]
+// [COUNT num-matches] [MAXLEN len]. Without COUNT only the first match is
+// wanted, and withCount is false.
+func parseLPosArgs(args []string) (opts types.LPosOptions, withCount bool, err error) {
+	opts.Count = 1
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			return opts, false, fmt.Errorf("syntax error")
+		}
+		n, err := strconv.Atoi(args[i+1])
+		if err != nil {
+			return opts, false, fmt.Errorf("value is not an integer or out of range")
+		}
+		switch strings.ToUpper(args[i]) {
+		case "RANK":
+			if n == 0 || n == math.MinInt {
+				return opts, false, fmt.Errorf("RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+			}
+			opts.Rank = n
+		case "COUNT":
+			if n < 0 {
+				return opts, false, fmt.Errorf("COUNT can't be negative")
+			}
+			opts.Count, withCount = n, true
+		case "MAXLEN":
+			if n < 0 {
+				return opts, false, fmt.Errorf("MAXLEN can't be negative")
+			}
+			opts.MaxLen = n
+		default:
+			return opts, false, fmt.Errorf("syntax error")
+		}
+	}
+	return opts, withCount, nil
+}
+
+func (c *CommandAPI) linsert(ctx context.Context, args []string) (int, error) {
+	before, err := parseLInsertWhere(args[1])
+	if err != nil {
+		return 0, err
+	}
+	length, err := c.db.LInsert(ctx, args[0], before, args[2], args[3])
+	if IsKeyNotFound(err) {
+		return 0, nil
+	}
+	return length, err
+}
+
+func (c *CommandAPI) lrem(ctx context.Context, args []string) (int, error) {
+	count, err := strconv.Atoi(args[1])
+	if err != nil {
+		return 0, fmt.Errorf("value is not an integer or out of range")
+	}
+	removed, err := c.db.LRem(ctx, args[0], count, args[2])
+	if IsKeyNotFound(err) {
+		return 0, nil
+	}
+	return removed, err
+}
+
+func (c *CommandAPI) lpos(ctx context.Context, args []string) (positions []int, withCount bool, err error) {
+	opts, withCount, err := parseLPosArgs(args[2:])
+	if err != nil {
+		return nil, false, err
+	}
+	positions, err = c.db.LPos(ctx, args[0], args[1], opts)
+	if IsKeyNotFound(err) {
+		return []int{}, withCount, nil
+	}
+	return positions, withCount, err
+}
+
+// lmove runs LMOVE or RPOPLPUSH and reports whether source had an element.
+func (c *CommandAPI) lmove(ctx context.Context, cmd string, args []string) (interface{}, bool, error) {
+	from, to := types.ListRight, types.ListLeft
+	if cmd == "LMOVE" {
+		var err error
+		if from, err = parseListSide(args[2]); err != nil {
+			return nil, false, err
+		}
+		if to, err = parseListSide(args[3]); err != nil {
+			return nil, false, err
+		}
+	}
+	val, err := c.db.LMove(ctx, args[0], args[1], from, to)
+	if IsKeyNotFound(err) {
+		return nil, false, nil
+	}
+	return val, err == nil, err
+}
+
+// blockingTimedOut calls fn with ctx bounded by timeout and reports whether fn
+// gave up waiting, which is not an error for the command: BLPOP and friends
+// reply nil then. Outside a transaction fn gives up when its own deadline
+// passes; inside one it never waits and finds nothing.
+func blockingTimedOut(ctx context.Context, timeout time.Duration, fn func(ctx context.Context) error) (bool, error) {
+	bctx, cancel := context.WithCancel(ctx)
+	if timeout > 0 {
+		bctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+
+	err := fn(bctx)
+	switch {
+	case IsContextCanceled(err) && ctx.Err() == nil, IsKeyNotFound(err):
+		return true, nil
+	case err != nil:
+		return false, err
+	}
+	return false, nil
+}
+
+func (c *CommandAPI) blockingPop(ctx context.Context, cmd string, args []string) (key string, val interface{}, timedOut bool, err error) {
+	timeout, err := parseBlockTimeout(args[len(args)-1])
+	if err != nil {
+		return "", nil, false, err
+	}
+	keys := args[:len(args)-1]
+	timedOut, err = blockingTimedOut(ctx, timeout, func(ctx context.Context) error {
+		var err error
+		if cmd == "BLPOP" {
+			key, val, err = c.db.BLPop(ctx, keys...)
+		} else {
+			key, val, err = c.db.BRPop(ctx, keys...)
+		}
+		return err
+	})
+	return key, val, timedOut, err
+}
+
+func (c *CommandAPI) blmove(ctx context.Context, args []string) (val interface{}, timedOut bool, err error) {
+	from, to, timeout, err := parseBLMoveArgs(args)
+	if err != nil {
+		return nil, false, err
+	}
+	timedOut, err = blockingTimedOut(ctx, timeout, func(ctx context.Context) error {
+		var err error
+		val, err = c.db.BLMove(ctx, args[0], args[1], from, to)
+		return err
+	})
+	return val, timedOut, err
+}
+
+// runList handles the list commands beyond push, pop and range.
+func (c *CommandAPI) runList(ctx context.Context, cmd string, args []string) (interface{}, error) {
+	switch cmd {
+	case "LINDEX":
+		if len(args) != 2 {
+			return nil, respWrongArgs(cmd)
+		}
+		index, err := strconv.Atoi(args[1])
+		if err != nil {
+			return nil, respErrNotInt
+		}
+		val, err := c.db.LIndex(ctx, args[0], index)
+		if err != nil {
+			if IsKeyNotFound(err) || IsIndexOutOfRange(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return respBulk(val), nil
+
+	case "LSET":
+		if len(args) != 3 {
+			return nil, respWrongArgs(cmd)
+		}
+		index, err := strconv.Atoi(args[1])
+		if err != nil {
+			return nil, respErrNotInt
+		}
+		if err := c.db.LSet(ctx, args[0], index, args[2]); err != nil {
+			return nil, err
+		}
+		return resp.SimpleString("OK"), nil
+
+	case "LINSERT":
+		if len(args) != 4 {
+			return nil, respWrongArgs(cmd)
+		}
+		length, err := c.linsert(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+		return int64(length), nil
+
+	case "LREM":
+		if len(args) != 3 {
+			return nil, respWrongArgs(cmd)
+		}
+		removed, err := c.lrem(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+		return int64(removed), nil
+
+	case "LPOS":
+		if len(args) < 2 {
+			return nil, respWrongArgs(cmd)
+		}
+		positions, withCount, err := c.lpos(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+		if !withCount {
+			if len(positions) == 0 {
+				return nil, nil
+			}
+			return int64(positions[0]), nil
+		}
+		out := make([]interface{}, len(positions))
+		for i, p := range positions {
+			out[i] = int64(p)
+		}
+		return out, nil
+
+	case "LMOVE", "RPOPLPUSH":
+		if (cmd == "LMOVE" && len(args) != 4) || (cmd == "RPOPLPUSH" && len(args) != 2) {
+			return nil, respWrongArgs(cmd)
+		}
+		val, ok, err := c.lmove(ctx, cmd, args)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return respBulk(val), nil
+
+	case "BLPOP", "BRPOP":
+		if len(args) < 2 {
+			return nil, respWrongArgs(cmd)
+		}
+		key, val, timedOut, err := c.blockingPop(ctx, cmd, args)
+		if err != nil || timedOut {
+			return nil, err
+		}
+		return []interface{}{key, respBulk(val)}, nil
+
+	case "BLMOVE":
+		if len(args) != 5 {
+			return nil, respWrongArgs(cmd)
+		}
+		val, timedOut, err := c.blmove(ctx, args)
+		if err != nil || timedOut {
+			return nil, err
+		}
+		return respBulk(val), nil
+	}
+	return nil, errUnknownCommand(cmd)
+}
@@ -0,0 +1,414 @@
+package hermes
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/themedef/go-hermes/internal/contracts"
+)
+
+// TestStoreBlockingPop checks BLPop and BRPop across keys, waking on a push,
+// deadlines and the order in which waiters are served.
+func TestStoreBlockingPop(t *testing.T) {
+	db := withTestStore(t)
+	ctx := context.Background()
+
+	// Scenario 1: The first non-empty list is popped right away
+	_, _ = db.RPush(ctx, "b", "b1", "b2")
+	key, val, err := db.BLPop(ctx, "a", "b")
+	if err != nil || key != "b" || val != "b1" {
+		t.Fatalf("BLPop = %q, %v (err=%v)", key, val, err)
+	}
+	key, val, err = db.BRPop(ctx, "a", "b")
+	if err != nil || key != "b" || val != "b2" {
+		t.Fatalf("BRPop = %q, %v (err=%v)", key, val, err)
+	}
+	if exists, _ := db.Exists(ctx, "b"); exists {
+		t.Error("Emptied list should be deleted")
+	}
+
+	// Scenario 2: A waiting pop is woken by a push
+	type popped struct {
+		key string
+		val interface{}
+		err error
+	}
+	done := make(chan popped, 1)
+	go func() {
+		key, val, err := db.BLPop(ctx, "a", "b")
+		done <- popped{key, val, err}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	_, _ = db.LPush(ctx, "a", "a1")
+	select {
+	case p := <-done:
+		if p.err != nil || p.key != "a" || p.val != "a1" {
+			t.Errorf("Woken BLPop = %+v", p)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("BLPop was not woken by LPush")
+	}
+
+	// Scenario 3: The context deadline ends the wait
+	dctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := db.BLPop(dctx, "a"); !IsContextCanceled(err) {
+		t.Errorf("Expected ErrContextCanceled, got %v", err)
+	}
+
+	// Scenario 4: Waiters are served in the order they started waiting
+	results := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		i := i
+		go func() {
+			if _, _, err := db.BRPop(ctx, "queue"); err == nil {
+				results <- i
+			}
+		}()
+		time.Sleep(20 * time.Millisecond)
+	}
+	for i := 0; i < 3; i++ {
+		_, _ = db.RPush(ctx, "queue", i)
+		select {
+		case got := <-results:
+			if got != i {
+				t.Errorf("Push %d served waiter %d", i, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Push %d served no waiter", i)
+		}
+	}
+
+	// Scenario 5: A batch push serves several waiters
+	for i := 0; i < 2; i++ {
+		go func() {
+			if _, val, err := db.BLPop(ctx, "batch"); err == nil {
+				results <- int(val.(int64))
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	_, _ = db.RPush(ctx, "batch", int64(1), int64(2))
+	for i := 0; i < 2; i++ {
+		select {
+		case <-results:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Batch push left a waiter blocked")
+		}
+	}
+
+	// Scenario 6: Errors
+	_ = db.Set(ctx, "str", "x", 0)
+	if _, _, err := db.BLPop(ctx, "str"); !IsInvalidType(err) {
+		t.Errorf("Expected ErrInvalidType, got %v", err)
+	}
+	if _, _, err := db.BLPop(ctx); err != ErrEmptyValues {
+		t.Errorf("Expected ErrEmptyValues, got %v", err)
+	}
+}
+
+// TestStoreBLMove checks moving elements between lists, waiting on an empty
+// source and type errors.
+func TestStoreBLMove(t *testing.T) {
+	db := withTestStore(t)
+	ctx := context.Background()
+
+	// Scenario 1: Moving between lists and rotating a list
+	_, _ = db.RPush(ctx, "src", "a", "b", "c")
+	val, err := db.BLMove(ctx, "src", "dst", ListRight, ListLeft)
+	if err != nil || val != "c" {
+		t.Fatalf("BLMove = %v (err=%v)", val, err)
+	}
+	if _, err := db.BLMove(ctx, "src", "src", ListLeft, ListRight); err != nil {
+		t.Fatalf("Rotating BLMove failed: %v", err)
+	}
+	if src, _ := db.LRange(ctx, "src", 0, -1); !reflect.DeepEqual(src, []interface{}{"b", "a"}) {
+		t.Errorf("Unexpected source: %v", src)
+	}
+	if dst, _ := db.LRange(ctx, "dst", 0, -1); !reflect.DeepEqual(dst, []interface{}{"c"}) {
+		t.Errorf("Unexpected destination: %v", dst)
+	}
+
+	// Scenario 2: An empty source waits for a push
+	done := make(chan interface{}, 1)
+	go func() {
+		val, _ := db.BLMove(ctx, "empty", "dst", ListLeft, ListLeft)
+		done <- val
+	}()
+	time.Sleep(50 * time.Millisecond)
+	_, _ = db.RPush(ctx, "empty", "late")
+	select {
+	case val := <-done:
+		if val != "late" {
+			t.Errorf("Woken BLMove = %v", val)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("BLMove was not woken by RPush")
+	}
+
+	// Scenario 3: A destination of another type leaves the source untouched
+	_ = db.Set(ctx, "str", "x", 0)
+	if _, err := db.BLMove(ctx, "src", "str", ListLeft, ListLeft); !IsInvalidType(err) {
+		t.Errorf("Expected ErrInvalidType, got %v", err)
+	}
+	if n, _ := db.LLen(ctx, "src"); n != 2 {
+		t.Errorf("Expected the source to keep 2 elements, got %d", n)
+	}
+}
+
+// TestStoreListIndexing checks LIndex, LSet and LInsert, including negative
+// indexes and a missing pivot.
+func TestStoreListIndexing(t *testing.T) {
+	db := withTestStore(t)
+	ctx := context.Background()
+	_, _ = db.RPush(ctx, "list", "a", "b", "c")
+
+	// Scenario 1: Reading by index
+	if val, err := db.LIndex(ctx, "list", 1); err != nil || val != "b" {
+		t.Errorf("LIndex 1 = %v (err=%v)", val, err)
+	}
+	if val, err := db.LIndex(ctx, "list", -1); err != nil || val != "c" {
+		t.Errorf("LIndex -1 = %v (err=%v)", val, err)
+	}
+	if _, err := db.LIndex(ctx, "list", 3); !IsIndexOutOfRange(err) {
+		t.Errorf("Expected ErrIndexOutOfRange, got %v", err)
+	}
+	if _, err := db.LIndex(ctx, "missing", 0); !IsKeyNotFound(err) {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+
+	// Scenario 2: Replacing by index
+	if err := db.LSet(ctx, "list", -3, "A"); err != nil {
+		t.Fatalf("LSet failed: %v", err)
+	}
+	if err := db.LSet(ctx, "list", -4, "x"); !IsIndexOutOfRange(err) {
+		t.Errorf("Expected ErrIndexOutOfRange, got %v", err)
+	}
+	if err := db.LSet(ctx, "missing", 0, "x"); !IsKeyNotFound(err) {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+
+	// Scenario 3: Inserting around a pivot
+	if n, err := db.LInsert(ctx, "list", true, "b", "before-b"); err != nil || n != 4 {
+		t.Errorf("LInsert before = %d (err=%v)", n, err)
+	}
+	if n, err := db.LInsert(ctx, "list", false, "c", "after-c"); err != nil || n != 5 {
+		t.Errorf("LInsert after = %d (err=%v)", n, err)
+	}
+	if n, err := db.LInsert(ctx, "list", true, "nope", "x"); err != nil || n != -1 {
+		t.Errorf("LInsert with a missing pivot = %d (err=%v)", n, err)
+	}
+	want := []interface{}{"A", "before-b", "b", "c", "after-c"}
+	if list, _ := db.LRange(ctx, "list", 0, -1); !reflect.DeepEqual(list, want) {
+		t.Errorf("List = %v, want %v", list, want)
+	}
+
+	// Scenario 4: Wrong type
+	_ = db.Set(ctx, "str", "x", 0)
+	if _, err := db.LIndex(ctx, "str", 0); !IsInvalidType(err) {
+		t.Errorf("Expected ErrInvalidType, got %v", err)
+	}
+
+	// Scenario 5: Raw entries hold lists as plain slices, and restore as lists
+	raw, err := db.GetRawEntry(ctx, "list")
+	if values, ok := raw.Value.([]interface{}); err != nil || !ok || !reflect.DeepEqual(values, want) {
+		t.Fatalf("GetRawEntry = %#v (err=%v)", raw.Value, err)
+	}
+	if err := db.RestoreRawEntry(ctx, "copy", raw); err != nil {
+		t.Fatalf("RestoreRawEntry failed: %v", err)
+	}
+	if val, err := db.LIndex(ctx, "copy", -1); err != nil || val != "after-c" {
+		t.Errorf("LIndex on the restored list = %v (err=%v)", val, err)
+	}
+}
+
+// TestStoreLRem checks removing elements from the head, from the tail and
+// everywhere.
+func TestStoreLRem(t *testing.T) {
+	db := withTestStore(t)
+	ctx := context.Background()
+	_, _ = db.RPush(ctx, "list", "x", "a", "x", "b", "x", "c", "x")
+
+	// Scenario 1: The first matches
+	if n, err := db.LRem(ctx, "list", 2, "x"); err != nil || n != 2 {
+		t.Errorf("LRem 2 = %d (err=%v)", n, err)
+	}
+	if list, _ := db.LRange(ctx, "list", 0, -1); !reflect.DeepEqual(list, []interface{}{"a", "b", "x", "c", "x"}) {
+		t.Errorf("After LRem 2: %v", list)
+	}
+
+	// Scenario 2: The last match
+	if n, err := db.LRem(ctx, "list", -1, "x"); err != nil || n != 1 {
+		t.Errorf("LRem -1 = %d (err=%v)", n, err)
+	}
+	if list, _ := db.LRange(ctx, "list", 0, -1); !reflect.DeepEqual(list, []interface{}{"a", "b", "x", "c"}) {
+		t.Errorf("After LRem -1: %v", list)
+	}
+
+	// Scenario 3: All matches, and none
+	_, _ = db.RPush(ctx, "list", "x")
+	if n, err := db.LRem(ctx, "list", 0, "x"); err != nil || n != 2 {
+		t.Errorf("LRem 0 = %d (err=%v)", n, err)
+	}
+	if n, err := db.LRem(ctx, "list", 0, "x"); err != nil || n != 0 {
+		t.Errorf("LRem without matches = %d (err=%v)", n, err)
+	}
+
+	// Scenario 4: Removing everything deletes the key
+	_, _ = db.RPush(ctx, "same", "y", "y")
+	if n, _ := db.LRem(ctx, "same", 0, "y"); n != 2 {
+		t.Errorf("Expected 2 removed, got %d", n)
+	}
+	if exists, _ := db.Exists(ctx, "same"); exists {
+		t.Error("Emptied list should be deleted")
+	}
+}
+
+// TestStoreLPos checks LPos with RANK, COUNT and MAXLEN.
+func TestStoreLPos(t *testing.T) {
+	db := withTestStore(t)
+	ctx := context.Background()
+	_, _ = db.RPush(ctx, "list", "a", "b", "c", "1", "2", "3", "c", "c")
+
+	tests := []struct {
+		name string
+		opts LPosOptions
+		want []int
+	}{
+		{"every match", LPosOptions{}, []int{2, 6, 7}},
+		{"first match", LPosOptions{Count: 1}, []int{2}},
+		{"from the second match", LPosOptions{Rank: 2}, []int{6, 7}},
+		{"from the tail", LPosOptions{Rank: -1, Count: 2}, []int{7, 6}},
+		{"second from the tail", LPosOptions{Rank: -2}, []int{6, 2}},
+		{"within MAXLEN", LPosOptions{MaxLen: 7}, []int{2, 6}},
+		{"within MAXLEN from the tail", LPosOptions{Rank: -1, MaxLen: 2}, []int{7, 6}},
+		{"rank past the matches", LPosOptions{Rank: 4}, []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := db.LPos(ctx, "list", "c", tt.opts)
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LPos = %v (err=%v), want %v", got, err, tt.want)
+			}
+		})
+	}
+
+	if _, err := db.LPos(ctx, "missing", "c", LPosOptions{}); !IsKeyNotFound(err) {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+// TestStoreLMove checks LMove and RPopLPush, including lists on different
+// shards.
+func TestStoreLMove(t *testing.T) {
+	db := NewStore(Config{ShardCount: 16})
+	t.Cleanup(func() { _ = db.Close() })
+	ctx := context.Background()
+
+	// Scenario 1: Moving across shards
+	source, destination := "src", "dst"
+	for i := 0; db.(*DB).getShardIndex(source) == db.(*DB).getShardIndex(destination); i++ {
+		destination = fmt.Sprintf("dst%d", i)
+	}
+	_, _ = db.RPush(ctx, source, "a", "b", "c")
+	if val, err := db.LMove(ctx, source, destination, ListLeft, ListRight); err != nil || val != "a" {
+		t.Errorf("LMove = %v (err=%v)", val, err)
+	}
+	if val, err := db.RPopLPush(ctx, source, destination); err != nil || val != "c" {
+		t.Errorf("RPopLPush = %v (err=%v)", val, err)
+	}
+	if list, _ := db.LRange(ctx, destination, 0, -1); !reflect.DeepEqual(list, []interface{}{"c", "a"}) {
+		t.Errorf("Destination = %v", list)
+	}
+
+	// Scenario 2: Rotating a list onto itself
+	_, _ = db.RPush(ctx, "ring", "1", "2", "3")
+	if val, err := db.RPopLPush(ctx, "ring", "ring"); err != nil || val != "3" {
+		t.Errorf("RPopLPush onto itself = %v (err=%v)", val, err)
+	}
+	if list, _ := db.LRange(ctx, "ring", 0, -1); !reflect.DeepEqual(list, []interface{}{"3", "1", "2"}) {
+		t.Errorf("Rotated list = %v", list)
+	}
+
+	// Scenario 3: Missing source and wrong destination type
+	if _, err := db.LMove(ctx, "missing", destination, ListLeft, ListLeft); !IsKeyNotFound(err) {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+	_ = db.Set(ctx, "str", "x", 0)
+	if _, err := db.RPopLPush(ctx, source, "str"); !IsInvalidType(err) {
+		t.Errorf("Expected ErrInvalidType, got %v", err)
+	}
+	if list, _ := db.LRange(ctx, source, 0, -1); !reflect.DeepEqual(list, []interface{}{"b"}) {
+		t.Errorf("A failed move should leave the source alone, got %v", list)
+	}
+}
+
+// benchListSize is the backlog the list benchmarks run against, large enough
+// for per-operation copies of the whole list to dominate.
+const benchListSize = 100000
+
+func newBenchList(b *testing.B, key string) contracts.StoreHandler {
+	b.Helper()
+	db := NewStore(Config{ShardCount: 1})
+	b.Cleanup(func() { _ = db.Close() })
+	values := make([]interface{}, benchListSize)
+	for i := range values {
+		values[i] = i
+	}
+	if _, err := db.RPush(context.Background(), key, values...); err != nil {
+		b.Fatal(err)
+	}
+	return db
+}
+
+// BenchmarkListLPush pushes onto the head of a long list.
+func BenchmarkListLPush(b *testing.B) {
+	db := newBenchList(b, "list")
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = db.LPush(ctx, "list", i)
+	}
+}
+
+// BenchmarkListQueue uses a long list as a FIFO queue: RPush at the tail and
+// LPop at the head, keeping its length constant.
+func BenchmarkListQueue(b *testing.B) {
+	db := newBenchList(b, "queue")
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = db.RPush(ctx, "queue", i)
+		_, _ = db.LPop(ctx, "queue")
+	}
+}
+
+// BenchmarkListLRange reads a page from the middle of a long list.
+func BenchmarkListLRange(b *testing.B) {
+	db := newBenchList(b, "list")
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = db.LRange(ctx, "list", benchListSize/2, benchListSize/2+99)
+	}
+}
+
+// BenchmarkListLTrim trims one element off each end of a long list, refilling
+// it so its length stays constant.
+func BenchmarkListLTrim(b *testing.B) {
+	db := newBenchList(b, "list")
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = db.LTrim(ctx, "list", 1, -2)
+		_, _ = db.LPush(ctx, "list", i)
+		_, _ = db.RPush(ctx, "list", i)
+	}
+}
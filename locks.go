@@ -0,0 +1,113 @@
+package hermes
+
+import (
+	"context"
+	"sort"
+)
+
+// heldShards records the shards a committing transaction has locked. Store
+// methods called from the commit find it in their context and skip locking
+// those shards again, since sync.RWMutex is not reentrant.
+type heldShards struct {
+	shards map[*shard]struct{}
+}
+
+type heldShardsKey struct{}
+
+func withHeldShards(ctx context.Context, held *heldShards) context.Context {
+	return context.WithValue(ctx, heldShardsKey{}, held)
+}
+
+func (db *DB) holds(ctx context.Context, sh *shard) bool {
+	held, _ := ctx.Value(heldShardsKey{}).(*heldShards)
+	if held == nil {
+		return false
+	}
+	_, ok := held.shards[sh]
+	return ok
+}
+
+// shardLocker is implemented by stores that let a transaction hold the locks of
+// all shards it touches while it commits.
+type shardLocker interface {
+	lockKeys(keys []string, all bool) (map[*shard]struct{}, func())
+}
+
+func noUnlock() {}
+
+// lockShard write-locks sh unless the caller already holds it and returns the
+// matching unlock function, which publishes the events queued meanwhile.
+func (db *DB) lockShard(ctx context.Context, sh *shard) func() {
+	if db.holds(ctx, sh) {
+		return noUnlock
+	}
+	sh.mu.Lock()
+	return func() {
+		sh.mu.Unlock()
+		db.flushEvents()
+	}
+}
+
+func (db *DB) rlockShard(ctx context.Context, sh *shard) func() {
+	if db.holds(ctx, sh) {
+		return noUnlock
+	}
+	sh.mu.RLock()
+	return sh.mu.RUnlock
+}
+
+// lockShards write-locks the shards with the given indexes in ascending order.
+// Every path that holds more than one shard lock goes through here, which is
+// what keeps multi-shard operations free of lock-order deadlocks.
+func (db *DB) lockShards(ctx context.Context, indexes []int) func() {
+	sorted := append([]int(nil), indexes...)
+	sort.Ints(sorted)
+
+	locked := make([]*shard, 0, len(sorted))
+	for i, idx := range sorted {
+		if i > 0 && idx == sorted[i-1] {
+			continue
+		}
+		sh := db.shards[idx]
+		if db.holds(ctx, sh) {
+			continue
+		}
+		sh.mu.Lock()
+		locked = append(locked, sh)
+	}
+
+	return func() {
+		for i := len(locked) - 1; i >= 0; i-- {
+			locked[i].mu.Unlock()
+		}
+		db.flushEvents()
+	}
+}
+
+func (db *DB) allShardIndexes() []int {
+	indexes := make([]int, len(db.shards))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	return indexes
+}
+
+// lockKeys locks the shards of keys, or every shard when all is set, and
+// returns the set of locked shards for the store calls made while they are held.
+func (db *DB) lockKeys(keys []string, all bool) (map[*shard]struct{}, func()) {
+	var indexes []int
+	if all {
+		indexes = db.allShardIndexes()
+	} else {
+		indexes = make([]int, len(keys))
+		for i, key := range keys {
+			indexes[i] = db.getShardIndex(key)
+		}
+	}
+
+	shards := make(map[*shard]struct{}, len(indexes))
+	for _, idx := range indexes {
+		shards[db.shards[idx]] = struct{}{}
+	}
+	return shards, db.lockShards(context.Background(), indexes)
+}
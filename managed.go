@@ -0,0 +1,71 @@
+package hermes
+
+import (
+	"context"
+
+	"github.com/themedef/go-hermes/internal/contracts"
+)
+
+const defaultTransactionRetries = 10
+
+// Update runs fn inside a new transaction and commits it if fn returns nil. If
+// fn returns an error or panics, the transaction is rolled back and the error
+// (or panic) is passed on. When the commit, or fn itself, fails with
+// ErrTransactionConflict, fn is run again in a fresh transaction up to
+// Config.TransactionRetries times, so fn must be safe to call more than once.
+func (db *DB) Update(ctx context.Context, fn func(tx contracts.TransactionHandler) error) error {
+	retries := db.config.TransactionRetries
+	if retries < 0 {
+		retries = 0
+	}
+
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		select {
+		case <-ctx.Done():
+			db.logger.Warn("Update operation canceled")
+			return ErrContextCanceled
+		default:
+		}
+
+		err = db.runManaged(fn, false)
+		if !IsTransactionConflict(err) {
+			return err
+		}
+		db.logger.Info("Update retrying after transaction conflict", "attempt", attempt+1)
+	}
+	return err
+}
+
+// View runs fn inside a read-only transaction, which is always rolled back.
+// Queuing a write inside fn returns ErrTransactionReadOnly.
+func (db *DB) View(ctx context.Context, fn func(tx contracts.TransactionHandler) error) error {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("View operation canceled")
+		return ErrContextCanceled
+	default:
+	}
+
+	return db.runManaged(fn, true)
+}
+
+func (db *DB) runManaged(fn func(tx contracts.TransactionHandler) error, readOnly bool) error {
+	tx := NewTransaction(db).(*Transaction)
+	tx.readOnly = readOnly
+
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if err := fn(tx); err != nil || readOnly {
+		return err
+	}
+
+	committed = true
+	_, err := tx.Commit()
+	return err
+}
@@ -0,0 +1,245 @@
+package hermes
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/themedef/go-hermes/internal/resp"
+)
+
+var (
+	errNestedMulti         = errors.New("MULTI calls can not be nested")
+	errExecWithoutMulti    = errors.New("EXEC without MULTI")
+	errDiscardWithoutMulti = errors.New("DISCARD without MULTI")
+	errWatchInsideMulti    = errors.New("WATCH inside MULTI is not allowed")
+)
+
+// commandSession is the WATCH/MULTI state of a CommandAPI. Each CommandAPI is one
+// client session: commands sent after MULTI are queued and run by EXEC inside a
+// Transaction that also carries the keys passed to WATCH.
+type commandSession struct {
+	mu     sync.Mutex
+	tx     *Transaction
+	multi  bool
+	queued [][]string
+}
+
+// sessionTx returns the transaction of the current session, starting one if
+// needed. The caller holds c.session.mu.
+func (c *CommandAPI) sessionTx() *Transaction {
+	if c.session.tx == nil {
+		c.session.tx = NewTransaction(c.db).(*Transaction)
+	}
+	return c.session.tx
+}
+
+func (c *CommandAPI) watchKeys(ctx context.Context, keys []string) error {
+	c.session.mu.Lock()
+	defer c.session.mu.Unlock()
+	if c.session.multi {
+		return errWatchInsideMulti
+	}
+	return c.sessionTx().Watch(ctx, keys...)
+}
+
+func (c *CommandAPI) unwatchKeys() {
+	c.session.mu.Lock()
+	defer c.session.mu.Unlock()
+	if c.session.tx != nil && !c.session.multi {
+		_ = c.session.tx.Rollback()
+		c.session.tx = nil
+	}
+}
+
+func (c *CommandAPI) startMulti() error {
+	c.session.mu.Lock()
+	defer c.session.mu.Unlock()
+	if c.session.multi {
+		return errNestedMulti
+	}
+	c.sessionTx()
+	c.session.multi = true
+	return nil
+}
+
+// enqueue queues parts if the session is inside MULTI.
+func (c *CommandAPI) enqueue(parts []string) bool {
+	c.session.mu.Lock()
+	defer c.session.mu.Unlock()
+	if !c.session.multi {
+		return false
+	}
+	c.session.queued = append(c.session.queued, append([]string(nil), parts...))
+	return true
+}
+
+func (c *CommandAPI) discardMulti() error {
+	c.session.mu.Lock()
+	defer c.session.mu.Unlock()
+	if !c.session.multi {
+		return errDiscardWithoutMulti
+	}
+	c.resetSession()
+	return nil
+}
+
+// resetSession drops queued commands and watched keys. The caller holds
+// c.session.mu.
+func (c *CommandAPI) resetSession() {
+	if c.session.tx != nil {
+		_ = c.session.tx.Rollback()
+	}
+	c.session.tx = nil
+	c.session.multi = false
+	c.session.queued = nil
+}
+
+// closeSession releases the watched keys and subscriptions of a client that
+// went away.
+func (c *CommandAPI) closeSession() {
+	c.session.mu.Lock()
+	c.resetSession()
+	c.session.mu.Unlock()
+	c.closeSubscriptions()
+}
+
+// execQueued runs the commands queued since MULTI and returns one slot per
+// command, as made by format from its reply or error; a failing command does
+// not stop the others. If a watched key changed nothing runs and the error is
+// ErrTransactionConflict.
+func (c *CommandAPI) execQueued(ctx context.Context, format replyFormat) ([]interface{}, error) {
+	c.session.mu.Lock()
+	if !c.session.multi {
+		c.session.mu.Unlock()
+		return nil, errExecWithoutMulti
+	}
+	tx, queued := c.session.tx, c.session.queued
+	c.session.tx, c.session.queued, c.session.multi = nil, nil, false
+	c.session.mu.Unlock()
+
+	for _, parts := range queued {
+		cmd, args := strings.ToUpper(parts[0]), parts[1:]
+		keys, all := commandKeys(parts)
+		err := tx.queue(ctx, cmd, keys, all, func(ctx context.Context) (interface{}, error) {
+			reply, err := c.run(ctx, cmd, args)
+			return format(cmd, reply, err), nil
+		})
+		if err != nil {
+			_ = tx.Rollback()
+			return nil, err
+		}
+	}
+	committed, err := tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	results := make([]interface{}, len(committed))
+	for i, r := range committed {
+		results[i] = r.Value
+	}
+	return results, nil
+}
+
+// runSession handles the transaction commands. format makes the slots of the
+// EXEC reply.
+func (c *CommandAPI) runSession(ctx context.Context, cmd string, args []string, format replyFormat) (interface{}, error) {
+	switch cmd {
+	case "MULTI":
+		if len(args) != 0 {
+			return nil, respWrongArgs(cmd)
+		}
+		if err := c.startMulti(); err != nil {
+			return nil, err
+		}
+		return resp.SimpleString("OK"), nil
+
+	case "EXEC":
+		if len(args) != 0 {
+			return nil, respWrongArgs(cmd)
+		}
+		results, err := c.execQueued(ctx, format)
+		if err != nil {
+			if IsTransactionConflict(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return results, nil
+
+	case "DISCARD":
+		if len(args) != 0 {
+			return nil, respWrongArgs(cmd)
+		}
+		if err := c.discardMulti(); err != nil {
+			return nil, err
+		}
+		return resp.SimpleString("OK"), nil
+
+	case "WATCH":
+		if len(args) < 1 {
+			return nil, respWrongArgs(cmd)
+		}
+		if err := c.watchKeys(ctx, args); err != nil {
+			return nil, err
+		}
+		return resp.SimpleString("OK"), nil
+	}
+	return nil, errUnknownCommand(cmd)
+}
+
+// commandKeys returns the keys a queued command touches, so EXEC can lock their
+// shards. Commands that scan or clear the whole keyspace report all.
+func commandKeys(parts []string) (keys []string, all bool) {
+	args := parts[1:]
+	switch strings.ToUpper(parts[0]) {
+	case "PING", "ECHO", "HELP", "QUIT", "EXIT", "UNWATCH",
+		"PUBLISH", "PUBSUB", "SUBSCRIBE", "UNSUBSCRIBE", "PSUBSCRIBE", "PUNSUBSCRIBE":
+		return nil, false
+	case "FLUSHALL", "FLUSHDB", "DROPALL", "FIND":
+		return nil, true
+	case "DEL", "EXISTS", "MGET":
+		return args, false
+	case "MSET":
+		for i := 0; i < len(args); i += 2 {
+			keys = append(keys, args[i])
+		}
+		return keys, false
+	case "RENAME", "LMOVE", "RPOPLPUSH", "BLMOVE":
+		if len(args) > 2 {
+			args = args[:2]
+		}
+		return args, false
+	case "BLPOP", "BRPOP":
+		if len(args) > 1 {
+			return args[:len(args)-1], false
+		}
+		return nil, false
+	case "XGROUP":
+		if len(args) > 1 {
+			return args[1:2], false
+		}
+		return nil, false
+	case "XREAD", "XREADGROUP":
+		for i, arg := range args {
+			if strings.ToUpper(arg) == "STREAMS" {
+				streams := args[i+1:]
+				return streams[:len(streams)/2], false
+			}
+		}
+		return nil, false
+	}
+	if len(args) > 0 {
+		return args[:1], false
+	}
+	return nil, false
+}
+
+func isSessionCommand(cmd string) bool {
+	switch cmd {
+	case "MULTI", "EXEC", "DISCARD", "WATCH":
+		return true
+	}
+	return false
+}
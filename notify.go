@@ -0,0 +1,155 @@
+package hermes
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/themedef/go-hermes/internal/types"
+)
+
+type Event = types.Event
+
+// EventClass selects the keyspace events a store generates, see
+// Config.NotifyEvents.
+type EventClass = types.EventClass
+
+const (
+	NotifyGeneric = types.EventClassGeneric
+	NotifyString  = types.EventClassString
+	NotifyList    = types.EventClassList
+	NotifyHash    = types.EventClassHash
+	NotifySet     = types.EventClassSet
+	NotifyZSet    = types.EventClassZSet
+	NotifyStream  = types.EventClassStream
+	NotifyExpired = types.EventClassExpired
+	NotifyAll     = types.EventClassAll
+	NotifyNone    = types.EventClassNone
+)
+
+// ParseNotifyEvents turns Redis notify-keyspace-events flags such as "g$lx"
+// or "A" into an EventClass for Config.NotifyEvents.
+func ParseNotifyEvents(flags string) (EventClass, error) {
+	return types.ParseEventClasses(flags)
+}
+
+// SubscribeOptions configures a subscription, see Subscribe.
+type SubscribeOptions = types.SubscribeOptions
+
+// SlowConsumerPolicy decides what happens to messages for a subscriber that
+// does not keep up.
+type SlowConsumerPolicy = types.SlowConsumerPolicy
+
+const (
+	PolicyDropNewest = types.PolicyDropNewest
+	PolicyDropOldest = types.PolicyDropOldest
+	PolicyBlock      = types.PolicyBlock
+	PolicyDisconnect = types.PolicyDisconnect
+)
+
+type SubscriptionStats = types.SubscriptionStats
+
+type eventOriginKey struct{}
+
+// withEventOrigin marks ctx so that events published by writes running with it
+// report origin.
+func withEventOrigin(ctx context.Context, origin types.EventOrigin) context.Context {
+	return context.WithValue(ctx, eventOriginKey{}, origin)
+}
+
+func eventOrigin(ctx context.Context) types.EventOrigin {
+	if origin, ok := ctx.Value(eventOriginKey{}).(types.EventOrigin); ok {
+		return origin
+	}
+	return types.OriginClient
+}
+
+// notifies reports whether events of op are generated at all, so callers can
+// skip building them.
+func (db *DB) notifies(op types.EventOp) bool {
+	return db.config.NotifyEvents.Has(op.Class()) && db.pubsub.HasSubscribers()
+}
+
+// eventOutbox holds the events of writes that still hold their shard locks.
+// Publishing waits for slow subscribers, PolicyBlock ones up to their timeout,
+// so it happens only once the locks are released: readers and writers of the
+// shards are never held up by a subscriber.
+type eventOutbox struct {
+	mu      sync.Mutex
+	queue   []types.Event
+	pending atomic.Int64
+	// publishing is held by the goroutine delivering the queue, which keeps
+	// events in the order they were queued.
+	publishing sync.Mutex
+}
+
+// notify queues ev for the subscribers of ev.Key unless its class is disabled
+// in Config.NotifyEvents. The origin comes from ctx unless ev already sets
+// one. The caller holds the lock of ev.Key's shard, and the unlock function
+// from lockShard or lockShards publishes ev.
+func (db *DB) notify(ctx context.Context, ev types.Event) {
+	if !db.notifies(ev.Op) {
+		return
+	}
+	if ev.Origin == "" {
+		ev.Origin = eventOrigin(ctx)
+	}
+	ev.OldValue, ev.NewValue = eventValue(ev.OldValue), eventValue(ev.NewValue)
+	db.events.mu.Lock()
+	db.events.queue = append(db.events.queue, ev)
+	db.events.pending.Add(1)
+	db.events.mu.Unlock()
+}
+
+// eventValue returns v as an event carries it: aggregates are copied, while
+// the shard lock still guards them, so later writes to the key do not show
+// through to subscribers. Lists become plain slices, as GetRawEntry returns
+// them.
+func eventValue(v interface{}) interface{} {
+	if list, ok := v.(*types.Deque); ok {
+		return list.Values()
+	}
+	return types.Entry{Value: v}.Clone().Value
+}
+
+// flushEvents publishes the queued events. If another goroutine is already
+// publishing, it returns at once and leaves the events to that one, so a
+// subscriber that writes to the store from its loop does not wait on itself.
+func (db *DB) flushEvents() {
+	for db.events.pending.Load() > 0 && db.events.publishing.TryLock() {
+		for {
+			db.events.mu.Lock()
+			batch := db.events.queue
+			db.events.queue = nil
+			db.events.mu.Unlock()
+			if len(batch) == 0 {
+				break
+			}
+			for _, ev := range batch {
+				db.pubsub.PublishEvent(ev)
+			}
+			db.events.pending.Add(-int64(len(batch)))
+		}
+		db.events.publishing.Unlock()
+	}
+}
+
+// removeExpired deletes key, whose entry has expired, and announces the
+// expiry. The caller holds the shard's write lock.
+func (db *DB) removeExpired(sh *shard, key string, entry types.Entry) {
+	delete(sh.data, key)
+	db.notify(context.Background(), types.Event{Op: types.EventExpired, Key: key, Type: entry.Type, OldValue: entry.Value, Origin: types.OriginExpiry})
+}
+
+// ttlLeft returns how long a key expiring at expiration has left, zero if it
+// does not expire.
+func ttlLeft(expiration time.Time) time.Duration {
+	if expiration.IsZero() {
+		return 0
+	}
+	if left := time.Until(expiration); left > 0 {
+		return left
+	}
+	return 0
+}
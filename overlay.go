@@ -0,0 +1,20 @@
+package hermes
+
+import (
+	"github.com/themedef/go-hermes/internal/logger"
+	"github.com/themedef/go-hermes/internal/pubsub"
+	"github.com/themedef/go-hermes/internal/types"
+)
+
+// newOverlayDB returns the private store a Transaction applies its queued writes
+// to, so reads inside the transaction see them before Commit. It has a single
+// shard and no logging, persistence, subscribers or background cleanup.
+func newOverlayDB() *DB {
+	quiet, _ := logger.NewLogger(logger.Config{})
+	return &DB{
+		shards: []*shard{{data: make(map[string]types.Entry)}},
+		logger: quiet,
+		pubsub: pubsub.NewPubSub(pubsub.Config{BufferSize: 1}),
+		config: Config{NotifyEvents: NotifyNone},
+	}
+}
@@ -0,0 +1,320 @@
+package hermes
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/themedef/go-hermes/internal/pubsub"
+	"github.com/themedef/go-hermes/internal/resp"
+	"github.com/themedef/go-hermes/internal/types"
+)
+
+// sessionMessageBuffer is the number of messages a CommandAPI session buffers
+// for its reader. Once it is full the forwarders wait, and messages pile up in
+// the store subscriptions, whose policy and counters decide what is dropped.
+const sessionMessageBuffer = 1000
+
+// sessionSubscriptionBuffer is the buffer of each store subscription of a
+// CommandAPI session, kept well below PubSubBufferSize since a RESP server
+// holds one per subscribed channel of every connection.
+const sessionSubscriptionBuffer = 256
+
+type PubSubMessage = types.PubSubMessage
+
+// subscriberSession holds the channels and patterns a CommandAPI session is
+// subscribed to. Each subscription is forwarded into messages by its own
+// goroutine; cancel functions stop it and unsubscribe from the store. Channel
+// subscriptions are bound to ctx, which ends with the session.
+type subscriberSession struct {
+	mu       sync.Mutex
+	ctx      context.Context
+	cancel   context.CancelFunc
+	messages chan PubSubMessage
+	channels map[string]func()
+	patterns map[string]func()
+	wg       sync.WaitGroup
+}
+
+// subscriptionChange is the confirmation of one SUBSCRIBE, UNSUBSCRIBE,
+// PSUBSCRIBE or PUNSUBSCRIBE argument: the session's subscription count after
+// it. name is empty when an unsubscribe without arguments had nothing to do.
+type subscriptionChange struct {
+	kind  string
+	name  string
+	count int
+}
+
+// Messages returns the channel the session's SUBSCRIBE and PSUBSCRIBE
+// messages arrive on. A reader falling behind makes the store subscriptions
+// drop messages by their policy, as counted by SubscriptionStats.
+func (c *CommandAPI) Messages() <-chan PubSubMessage {
+	c.subs.mu.Lock()
+	defer c.subs.mu.Unlock()
+	return c.sessionMessages()
+}
+
+// sessionMessages returns the message channel, creating it and the session's
+// context if needed. The caller holds c.subs.mu.
+func (c *CommandAPI) sessionMessages() chan PubSubMessage {
+	if c.subs.messages == nil {
+		c.subs.messages = make(chan PubSubMessage, sessionMessageBuffer)
+		c.subs.ctx, c.subs.cancel = context.WithCancel(context.Background())
+	}
+	return c.subs.messages
+}
+
+// subscriptionCount returns how many channels and patterns the session is
+// subscribed to.
+func (c *CommandAPI) subscriptionCount() int {
+	c.subs.mu.Lock()
+	defer c.subs.mu.Unlock()
+	return len(c.subs.channels) + len(c.subs.patterns)
+}
+
+func (c *CommandAPI) subscribe(channels []string) []subscriptionChange {
+	c.subs.mu.Lock()
+	defer c.subs.mu.Unlock()
+	if c.subs.channels == nil {
+		c.subs.channels = make(map[string]func())
+	}
+	messages := c.sessionMessages()
+
+	changes := make([]subscriptionChange, 0, len(channels))
+	for _, channel := range channels {
+		if _, ok := c.subs.channels[channel]; !ok {
+			channel := channel
+			ctx, cancel := context.WithCancel(c.subs.ctx)
+			ch := c.db.SubscribeContext(ctx, channel, SubscribeOptions{BufferSize: sessionSubscriptionBuffer})
+			stopped := make(chan struct{})
+			// The store closes ch once the subscription is gone; waiting for
+			// that keeps UNSUBSCRIBE synchronous.
+			c.subs.channels[channel] = func() {
+				cancel()
+				<-stopped
+			}
+			c.subs.wg.Add(1)
+			go func() {
+				defer c.subs.wg.Done()
+				defer close(stopped)
+				for msg := range ch {
+					forwardMessage(messages, ctx.Done(), PubSubMessage{Channel: channel, Message: msg})
+				}
+			}()
+		}
+		changes = append(changes, subscriptionChange{kind: "subscribe", name: channel, count: len(c.subs.channels) + len(c.subs.patterns)})
+	}
+	return changes
+}
+
+func (c *CommandAPI) psubscribe(patterns []string) []subscriptionChange {
+	c.subs.mu.Lock()
+	defer c.subs.mu.Unlock()
+	if c.subs.patterns == nil {
+		c.subs.patterns = make(map[string]func())
+	}
+	messages := c.sessionMessages()
+
+	changes := make([]subscriptionChange, 0, len(patterns))
+	for _, pattern := range patterns {
+		if _, ok := c.subs.patterns[pattern]; !ok {
+			pattern := pattern
+			ch := c.db.PSubscribe(pattern, SubscribeOptions{BufferSize: sessionSubscriptionBuffer})
+			done := make(chan struct{})
+			c.subs.patterns[pattern] = func() {
+				close(done)
+				c.db.PUnsubscribe(pattern, ch)
+			}
+			c.subs.wg.Add(1)
+			go func() {
+				defer c.subs.wg.Done()
+				for msg := range ch {
+					if !forwardMessage(messages, done, PubSubMessage{Pattern: msg.Pattern, Channel: msg.Key, Message: msg.Message}) {
+						return
+					}
+				}
+			}()
+		}
+		changes = append(changes, subscriptionChange{kind: "psubscribe", name: pattern, count: len(c.subs.channels) + len(c.subs.patterns)})
+	}
+	return changes
+}
+
+// forwardMessage waits for room in messages rather than dropping msg, so that
+// a slow reader is handled by the subscription's policy where it is counted.
+// It gives up and returns false once done is closed.
+func forwardMessage(messages chan PubSubMessage, done <-chan struct{}, msg PubSubMessage) bool {
+	select {
+	case messages <- msg:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// unsubscribe removes the session's subscriptions to channels, or to every
+// channel if none are given.
+func (c *CommandAPI) unsubscribe(channels []string) []subscriptionChange {
+	c.subs.mu.Lock()
+	defer c.subs.mu.Unlock()
+	return c.cancelSubscriptions("unsubscribe", c.subs.channels, channels)
+}
+
+// punsubscribe is unsubscribe for patterns.
+func (c *CommandAPI) punsubscribe(patterns []string) []subscriptionChange {
+	c.subs.mu.Lock()
+	defer c.subs.mu.Unlock()
+	return c.cancelSubscriptions("punsubscribe", c.subs.patterns, patterns)
+}
+
+// cancelSubscriptions removes names from subs. The caller holds c.subs.mu.
+func (c *CommandAPI) cancelSubscriptions(kind string, subs map[string]func(), names []string) []subscriptionChange {
+	if len(names) == 0 {
+		for name := range subs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return []subscriptionChange{{kind: kind, count: len(c.subs.channels) + len(c.subs.patterns)}}
+		}
+	}
+
+	changes := make([]subscriptionChange, 0, len(names))
+	for _, name := range names {
+		if cancel, ok := subs[name]; ok {
+			cancel()
+			delete(subs, name)
+		}
+		changes = append(changes, subscriptionChange{kind: kind, name: name, count: len(c.subs.channels) + len(c.subs.patterns)})
+	}
+	return changes
+}
+
+// closeSubscriptions drops every subscription of the session and closes its
+// message channel.
+func (c *CommandAPI) closeSubscriptions() {
+	c.subs.mu.Lock()
+	defer c.subs.mu.Unlock()
+	if c.subs.cancel != nil {
+		c.subs.cancel()
+	}
+	for name, cancel := range c.subs.channels {
+		cancel()
+		delete(c.subs.channels, name)
+	}
+	for name, cancel := range c.subs.patterns {
+		cancel()
+		delete(c.subs.patterns, name)
+	}
+	c.subs.wg.Wait()
+	if c.subs.messages != nil {
+		close(c.subs.messages)
+		c.subs.messages = nil
+		c.subs.ctx, c.subs.cancel = nil, nil
+	}
+}
+
+// pubsubChannels returns the channels with subscribers, limited to those
+// matching pattern when it is not empty.
+func (c *CommandAPI) pubsubChannels(pattern string) []string {
+	var channels []string
+	for _, channel := range c.db.ListSubscriptions() {
+		if pattern == "" || pubsub.Match(pattern, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// runPubSub handles PUBLISH, PUBSUB and the subscribe commands. The subscribe
+// commands reply with one resp.Push confirmation per argument.
+func (c *CommandAPI) runPubSub(cmd string, args []string) (interface{}, error) {
+	switch cmd {
+	case "PUBLISH":
+		if len(args) != 2 {
+			return nil, respWrongArgs(cmd)
+		}
+		return int64(c.db.Publish(args[0], args[1])), nil
+
+	case "PUBSUB":
+		if len(args) == 0 {
+			return nil, respWrongArgs(cmd)
+		}
+		switch strings.ToUpper(args[0]) {
+		case "CHANNELS":
+			if len(args) > 2 {
+				return nil, respWrongArgs("pubsub|channels")
+			}
+			pattern := ""
+			if len(args) == 2 {
+				pattern = args[1]
+			}
+			channels := c.pubsubChannels(pattern)
+			out := make([]interface{}, len(channels))
+			for i, channel := range channels {
+				out[i] = channel
+			}
+			return out, nil
+		case "NUMSUB":
+			counts := c.db.NumSub(args[1:]...)
+			out := make([]interface{}, 0, 2*(len(args)-1))
+			for _, channel := range args[1:] {
+				out = append(out, channel, int64(counts[channel]))
+			}
+			return out, nil
+		case "NUMPAT":
+			if len(args) != 1 {
+				return nil, respWrongArgs("pubsub|numpat")
+			}
+			return int64(c.db.NumPat()), nil
+		}
+		return nil, resp.Error("ERR unknown subcommand '" + strings.ToLower(args[0]) + "'")
+
+	case "SUBSCRIBE", "PSUBSCRIBE":
+		if len(args) == 0 {
+			return nil, respWrongArgs(cmd)
+		}
+		if cmd == "SUBSCRIBE" {
+			return respSubscriptionChanges(c.subscribe(args)), nil
+		}
+		return respSubscriptionChanges(c.psubscribe(args)), nil
+
+	case "UNSUBSCRIBE":
+		return respSubscriptionChanges(c.unsubscribe(args)), nil
+
+	case "PUNSUBSCRIBE":
+		return respSubscriptionChanges(c.punsubscribe(args)), nil
+	}
+	return nil, errUnknownCommand(cmd)
+}
+
+func respSubscriptionChanges(changes []subscriptionChange) []interface{} {
+	out := make([]interface{}, len(changes))
+	for i, ch := range changes {
+		var name interface{} = ch.name
+		if ch.name == "" {
+			name = nil
+		}
+		out[i] = resp.Push{ch.kind, name, int64(ch.count)}
+	}
+	return out
+}
+
+// isSubscribeCommand reports whether cmd changes the session's subscriptions.
+func isSubscribeCommand(cmd string) bool {
+	switch cmd {
+	case "SUBSCRIBE", "UNSUBSCRIBE", "PSUBSCRIBE", "PUNSUBSCRIBE":
+		return true
+	}
+	return false
+}
+
+// respMessage returns the push a subscribed RESP client receives for msg.
+func respMessage(msg PubSubMessage) resp.Push {
+	if msg.Pattern != "" {
+		return resp.Push{"pmessage", msg.Pattern, msg.Channel, msg.Message}
+	}
+	return resp.Push{"message", msg.Channel, msg.Message}
+}
@@ -0,0 +1,423 @@
+package hermes
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/themedef/go-hermes/internal/contracts"
+	"github.com/themedef/go-hermes/internal/resp"
+)
+
+const respServerVersion = "1.0.0"
+
+// RESPServer serves the store over the Redis serialization protocol, so redis-cli
+// and Redis client libraries can talk to an embedded instance. Commands are
+// executed with CommandAPI.Do, one CommandAPI per connection so MULTI, WATCH and
+// SUBSCRIBE state is not shared between clients; connection-level commands
+// (HELLO, SELECT, CLIENT, COMMAND, QUIT) are handled here. Messages for a
+// subscribed connection are written as pushes by a second goroutine.
+type RESPServer struct {
+	db  contracts.StoreHandler
+	ctx context.Context
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+	nextID   atomic.Int64
+}
+
+type respConn struct {
+	id       int64
+	conn     net.Conn
+	r        *resp.Reader
+	commands *CommandAPI
+	name     string
+	quit     bool
+
+	// ctx is canceled once the client is gone, ending the commands waiting
+	// for it. readErr is the error that stopped readRequests.
+	ctx     context.Context
+	cancel  context.CancelFunc
+	readErr error
+
+	// wmu serializes replies with the pushes written by forwardMessages.
+	wmu        sync.Mutex
+	w          *resp.Writer
+	forwarding bool
+}
+
+// respRequest is a command read by readRequests. more reports whether the
+// client had already sent further input, so the reply can wait to be flushed.
+type respRequest struct {
+	parts []string
+	more  bool
+}
+
+// respFrames is a reply written as several consecutive replies, such as the
+// confirmations of SUBSCRIBE with more than one channel.
+type respFrames []interface{}
+
+func NewRESPServer(ctx context.Context, db contracts.StoreHandler) *RESPServer {
+	return &RESPServer{
+		db:    db,
+		ctx:   ctx,
+		conns: make(map[net.Conn]struct{}),
+	}
+}
+
+func (s *RESPServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		s.db.Logger().Error("RESP server failed to listen", "addr", addr, "error", err)
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until Close is called. It always returns a
+// non-nil error; after Close it is net.ErrClosed.
+func (s *RESPServer) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = l.Close()
+		return net.ErrClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	s.db.Logger().Info("RESP server listening", "addr", l.Addr().String())
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return net.ErrClosed
+			}
+			s.db.Logger().Error("RESP server accept failed", "error", err)
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return net.ErrClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.serveConn(conn)
+	}
+}
+
+func (s *RESPServer) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Close stops accepting connections, closes every open connection and waits for
+// their handlers to return.
+func (s *RESPServer) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	s.db.Logger().Info("RESP server closed")
+	return err
+}
+
+func (s *RESPServer) serveConn(conn net.Conn) {
+	c := &respConn{
+		id:       s.nextID.Add(1),
+		conn:     conn,
+		r:        resp.NewReader(conn),
+		w:        resp.NewWriter(conn),
+		commands: &CommandAPI{db: s.db},
+	}
+	c.ctx, c.cancel = context.WithCancel(s.ctx)
+	defer func() {
+		c.cancel()
+		_ = conn.Close()
+		c.commands.closeSession()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+	s.db.Logger().Debug("RESP client connected", "id", c.id, "remote", conn.RemoteAddr().String())
+
+	requests := make(chan respRequest)
+	s.wg.Add(1)
+	go s.readRequests(c, requests)
+
+	for req := range requests {
+		if err := s.handle(c, req); err != nil || c.quit {
+			return
+		}
+	}
+
+	err := c.readErr
+	if errors.Is(err, resp.ErrProtocol) {
+		_ = c.w.WriteError(resp.Error("ERR Protocol error: " + err.Error()))
+		_ = c.w.Flush()
+	} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		s.db.Logger().Debug("RESP client read failed", "id", c.id, "error", err)
+	}
+}
+
+// readRequests reads the connection's commands while serveConn runs them, so
+// a client that hangs up is noticed even while its command is blocked. A
+// failed read cancels the connection's context and closes requests.
+func (s *RESPServer) readRequests(c *respConn, requests chan<- respRequest) {
+	defer s.wg.Done()
+	defer close(requests)
+	for {
+		parts, err := c.r.ReadCommand()
+		if err != nil {
+			c.readErr = err
+			c.cancel()
+			return
+		}
+		select {
+		case requests <- respRequest{parts: parts, more: c.r.Buffered() > 0}:
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// handle runs one command and writes its reply. The write lock is held from
+// dispatch on, so a message published to a channel the command just
+// subscribed to cannot overtake the subscribe confirmation. Commands that may
+// block take the lock only for their reply, so pushes keep flowing meanwhile.
+func (s *RESPServer) handle(c *respConn, req respRequest) error {
+	blocking := isBlockingCommand(strings.ToUpper(req.parts[0]))
+	if !blocking {
+		c.wmu.Lock()
+	}
+	reply, err := s.dispatch(c, req.parts)
+	if blocking {
+		c.wmu.Lock()
+	}
+	defer c.wmu.Unlock()
+
+	if err != nil {
+		err = c.w.WriteError(err)
+	} else if frames, ok := reply.(respFrames); ok {
+		for _, frame := range frames {
+			if err = c.w.WriteValue(frame); err != nil {
+				break
+			}
+		}
+	} else {
+		err = c.w.WriteValue(reply)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Replies to pipelined commands are flushed together once the batch that
+	// has already arrived is drained.
+	if !req.more || c.quit {
+		return c.w.Flush()
+	}
+	return nil
+}
+
+// isBlockingCommand reports whether cmd may wait for another client's write.
+// These run with the connection's context, so they end when the client hangs
+// up instead of taking an element nobody will read. Other commands run with
+// the server's: whatever the client sent before leaving is still applied.
+func isBlockingCommand(cmd string) bool {
+	switch cmd {
+	case "BLPOP", "BRPOP", "BLMOVE", "XREAD", "XREADGROUP":
+		return true
+	}
+	return false
+}
+
+// forwardMessages writes the connection's pub/sub messages as pushes until its
+// session is closed.
+func (s *RESPServer) forwardMessages(c *respConn, messages <-chan PubSubMessage) {
+	defer s.wg.Done()
+	for msg := range messages {
+		c.wmu.Lock()
+		err := c.w.WriteValue(respMessage(msg))
+		if err == nil && len(messages) == 0 {
+			err = c.w.Flush()
+		}
+		c.wmu.Unlock()
+		if err != nil {
+			// Keep draining so the session's forwarders never block.
+			for range messages {
+			}
+			return
+		}
+	}
+}
+
+func (s *RESPServer) dispatch(c *respConn, parts []string) (interface{}, error) {
+	cmd := strings.ToUpper(parts[0])
+	args := parts[1:]
+
+	// A RESP2 connection with subscriptions can only manage them; RESP3
+	// delivers messages as pushes next to ordinary replies.
+	if c.w.Protocol == resp.RESP2 && c.commands.subscriptionCount() > 0 {
+		switch cmd {
+		case "SUBSCRIBE", "UNSUBSCRIBE", "PSUBSCRIBE", "PUNSUBSCRIBE", "QUIT":
+		case "PING":
+			if len(args) > 1 {
+				return nil, respWrongArgs(cmd)
+			}
+			msg := ""
+			if len(args) == 1 {
+				msg = args[0]
+			}
+			return []interface{}{"pong", msg}, nil
+		default:
+			return nil, resp.Error("ERR Can't execute '" + strings.ToLower(cmd) +
+				"': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context")
+		}
+	}
+
+	if isSubscribeCommand(cmd) {
+		reply, err := c.commands.Do(s.ctx, parts)
+		if err != nil {
+			return nil, err
+		}
+		if !c.forwarding {
+			c.forwarding = true
+			s.wg.Add(1)
+			go s.forwardMessages(c, c.commands.Messages())
+		}
+		if frames, ok := reply.([]interface{}); ok {
+			return respFrames(frames), nil
+		}
+		return reply, nil
+	}
+
+	switch cmd {
+	case "HELLO":
+		return s.hello(c, args)
+
+	case "QUIT":
+		c.quit = true
+		return resp.SimpleString("OK"), nil
+
+	case "SELECT":
+		if len(args) != 1 {
+			return nil, respWrongArgs(cmd)
+		}
+		if args[0] != "0" {
+			return nil, resp.Error("ERR DB index is out of range")
+		}
+		return resp.SimpleString("OK"), nil
+
+	case "AUTH":
+		return nil, resp.Error("ERR AUTH called without any password configured for the default user")
+
+	case "CLIENT":
+		if len(args) == 0 {
+			return nil, respWrongArgs(cmd)
+		}
+		switch strings.ToUpper(args[0]) {
+		case "SETNAME":
+			if len(args) != 2 {
+				return nil, respWrongArgs("client|setname")
+			}
+			c.name = args[1]
+			return resp.SimpleString("OK"), nil
+		case "GETNAME":
+			if c.name == "" {
+				return nil, nil
+			}
+			return c.name, nil
+		case "ID":
+			return c.id, nil
+		case "SETINFO":
+			return resp.SimpleString("OK"), nil
+		default:
+			return nil, resp.Error("ERR unknown subcommand '" + strings.ToLower(args[0]) + "'")
+		}
+
+	case "COMMAND":
+		// redis-cli asks for command docs on startup; an empty reply makes it
+		// fall back to plain completion.
+		if len(args) > 0 && strings.ToUpper(args[0]) == "COUNT" {
+			return int64(0), nil
+		}
+		return []interface{}{}, nil
+	}
+
+	ctx := s.ctx
+	if isBlockingCommand(cmd) {
+		ctx = c.ctx
+	}
+	return c.commands.Do(ctx, parts)
+}
+
+func (s *RESPServer) hello(c *respConn, args []string) (interface{}, error) {
+	if len(args) > 0 {
+		proto, err := strconv.Atoi(args[0])
+		if err != nil || (proto != resp.RESP2 && proto != resp.RESP3) {
+			return nil, resp.Error("NOPROTO unsupported protocol version")
+		}
+
+		for i := 1; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "AUTH":
+				if i+2 >= len(args) {
+					return nil, respErrSyntax
+				}
+				i += 2
+			case "SETNAME":
+				if i+1 >= len(args) {
+					return nil, respErrSyntax
+				}
+				c.name = args[i+1]
+				i++
+			default:
+				return nil, respErrSyntax
+			}
+		}
+		c.w.Protocol = proto
+	}
+
+	return map[string]interface{}{
+		"server":  "hermes",
+		"version": respServerVersion,
+		"proto":   int64(c.w.Protocol),
+		"id":      c.id,
+		"mode":    "standalone",
+		"role":    "master",
+		"modules": []interface{}{},
+	}, nil
+}
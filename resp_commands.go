@@ -0,0 +1,128 @@
+package hermes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/themedef/go-hermes/internal/resp"
+	"github.com/themedef/go-hermes/internal/types"
+)
+
+var (
+	respErrWrongType = resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+	respErrNotInt    = resp.Error("ERR value is not an integer or out of range")
+	respErrSyntax    = resp.Error("ERR syntax error")
+	respErrNoSuchKey = resp.Error("ERR no such key")
+)
+
+func respWrongArgs(cmd string) error {
+	return resp.Error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+}
+
+func respInvalidExpire(cmd string) error {
+	return resp.Error(fmt.Sprintf("ERR invalid expire time in '%s' command", strings.ToLower(cmd)))
+}
+
+func errUnknownCommand(cmd string) error {
+	return resp.Error(fmt.Sprintf("ERR unknown command '%s'", strings.ToLower(cmd)))
+}
+
+// respError translates store errors into the error replies Redis clients expect.
+func respError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case IsInvalidType(err):
+		return respErrWrongType
+	case IsInvalidValueType(err):
+		return respErrNotInt
+	case IsInvalidTTL(err):
+		return resp.Error("ERR invalid expire time")
+	case IsKeyNotFound(err):
+		return respErrNoSuchKey
+	case IsIndexOutOfRange(err):
+		return resp.Error("ERR index out of range")
+	case IsInvalidScore(err):
+		return resp.Error("ERR resulting score is not a number (NaN)")
+	case IsInvalidOptions(err):
+		return resp.Error("ERR GT, LT, and/or NX options at the same time are not compatible")
+	case IsInvalidStreamID(err):
+		return resp.Error("ERR Invalid stream ID specified as stream command argument")
+	case IsStreamIDTooSmall(err):
+		return resp.Error("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	case IsGroupExists(err):
+		return resp.Error("BUSYGROUP Consumer Group name already exists")
+	case IsGroupNotFound(err):
+		return resp.Error("NOGROUP No such key or consumer group")
+	}
+	if _, ok := err.(resp.Error); ok {
+		return err
+	}
+	return resp.Error("ERR " + err.Error())
+}
+
+func respBulk(v interface{}) interface{} {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return val
+	case []byte:
+		return string(val)
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+func respBulks(values []interface{}) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		out[i] = respBulk(v)
+	}
+	return out
+}
+
+// respZMembers flattens members into member, score pairs when withScores is set.
+func respZMembers(members []types.ZMember, withScores bool) []interface{} {
+	out := make([]interface{}, 0, len(members)*2)
+	for _, m := range members {
+		out = append(out, m.Member)
+		if withScores {
+			out = append(out, formatScore(m.Score))
+		}
+	}
+	return out
+}
+
+func respBool(ok bool) int64 {
+	if ok {
+		return 1
+	}
+	return 0
+}
+
+// Do executes a command and returns a typed reply using Redis semantics: nil,
+// int64, string (bulk), resp.SimpleString (status), []interface{}, resp.Set or
+// map[string]interface{}. Failures are returned as resp.Error values carrying a
+// Redis error code. Commands are parsed and run exactly as for Execute; the
+// replies are meant to be encoded for a protocol client rather than read by a
+// person.
+func (c *CommandAPI) Do(ctx context.Context, parts []string) (interface{}, error) {
+	if len(parts) == 0 {
+		return nil, resp.Error("ERR empty command")
+	}
+	reply, err := c.dispatch(ctx, strings.ToUpper(parts[0]), parts, respSlot)
+	if err != nil {
+		return nil, respError(err)
+	}
+	return reply, nil
+}
+
+// respSlot is the replyFormat of Do.
+func respSlot(_ string, reply interface{}, err error) interface{} {
+	if err != nil {
+		return respError(err)
+	}
+	return reply
+}
@@ -0,0 +1,510 @@
+package hermes
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/themedef/go-hermes/internal/resp"
+)
+
+type respTestClient struct {
+	conn net.Conn
+	r    *resp.Reader
+}
+
+func startRESPServer(t *testing.T) (*RESPServer, string) {
+	t.Helper()
+	db := NewStore(Config{})
+	srv := NewRESPServer(context.Background(), db)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return srv, l.Addr().String()
+}
+
+func dialRESP(t *testing.T, addr string) *respTestClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &respTestClient{conn: conn, r: resp.NewReader(conn)}
+}
+
+func (c *respTestClient) send(t *testing.T, cmds ...[]string) {
+	t.Helper()
+	var b strings.Builder
+	for _, parts := range cmds {
+		fmt.Fprintf(&b, "*%d\r\n", len(parts))
+		for _, p := range parts {
+			fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(p), p)
+		}
+	}
+	if _, err := c.conn.Write([]byte(b.String())); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+}
+
+func (c *respTestClient) do(t *testing.T, parts ...string) interface{} {
+	t.Helper()
+	c.send(t, parts)
+	return c.read(t)
+}
+
+func (c *respTestClient) read(t *testing.T) interface{} {
+	t.Helper()
+	v, err := c.r.ReadValue()
+	if err != nil {
+		t.Fatalf("ReadValue failed: %v", err)
+	}
+	return v
+}
+
+// TestRESPServerCommands checks typed replies for the core data types.
+func TestRESPServerCommands(t *testing.T) {
+	_, addr := startRESPServer(t)
+	c := dialRESP(t, addr)
+
+	// Scenario 1: strings and nil replies
+	if got := c.do(t, "PING"); got != resp.SimpleString("PONG") {
+		t.Errorf("PING: got %#v", got)
+	}
+	if got := c.do(t, "SET", "greeting", "hello"); got != resp.SimpleString("OK") {
+		t.Errorf("SET: got %#v", got)
+	}
+	if got := c.do(t, "GET", "greeting"); got != "hello" {
+		t.Errorf("GET: got %#v", got)
+	}
+	if got := c.do(t, "GET", "missing"); got != nil {
+		t.Errorf("GET missing: expected nil, got %#v", got)
+	}
+	if got := c.do(t, "SET", "greeting", "x", "NX"); got != nil {
+		t.Errorf("SET NX on existing key: expected nil, got %#v", got)
+	}
+
+	// Scenario 2: counters and integers
+	if got := c.do(t, "INCR", "counter"); got != int64(1) {
+		t.Errorf("INCR: got %#v", got)
+	}
+	if got := c.do(t, "INCRBY", "counter", "9"); got != int64(10) {
+		t.Errorf("INCRBY: got %#v", got)
+	}
+
+	// Scenario 3: lists
+	if got := c.do(t, "RPUSH", "list", "a", "b", "c"); got != int64(3) {
+		t.Errorf("RPUSH: got %#v", got)
+	}
+	if got := c.do(t, "LRANGE", "list", "0", "-1"); !reflect.DeepEqual(got, []interface{}{"a", "b", "c"}) {
+		t.Errorf("LRANGE: got %#v", got)
+	}
+	if got := c.do(t, "LPOP", "list"); got != "a" {
+		t.Errorf("LPOP: got %#v", got)
+	}
+	if got := c.do(t, "BLPOP", "missing", "list", "0"); !reflect.DeepEqual(got, []interface{}{"list", "b"}) {
+		t.Errorf("BLPOP: got %#v", got)
+	}
+	if got := c.do(t, "BLMOVE", "list", "moved", "RIGHT", "LEFT", "0"); got != "c" {
+		t.Errorf("BLMOVE: got %#v", got)
+	}
+	if got := c.do(t, "BRPOP", "list", "0.05"); got != nil {
+		t.Errorf("BRPOP on an empty list: got %#v", got)
+	}
+	_ = c.do(t, "RPUSH", "list", "x", "y", "x")
+	if got := c.do(t, "LPOS", "list", "x", "COUNT", "0"); !reflect.DeepEqual(got, []interface{}{int64(0), int64(2)}) {
+		t.Errorf("LPOS COUNT: got %#v", got)
+	}
+	if got := c.do(t, "LINSERT", "list", "AFTER", "y", "z"); got != int64(4) {
+		t.Errorf("LINSERT: got %#v", got)
+	}
+	if got := c.do(t, "LSET", "list", "10", "v"); got != resp.Error("ERR index out of range") {
+		t.Errorf("LSET out of range: got %#v", got)
+	}
+	if got := c.do(t, "RPOPLPUSH", "list", "moved"); got != "x" {
+		t.Errorf("RPOPLPUSH: got %#v", got)
+	}
+	if got := c.do(t, "LINDEX", "moved", "0"); got != "x" {
+		t.Errorf("LINDEX: got %#v", got)
+	}
+
+	// Scenario 4: hashes and sets
+	if got := c.do(t, "HSET", "h", "f1", "v1", "f2", "v2"); got != int64(2) {
+		t.Errorf("HSET: got %#v", got)
+	}
+	if got := c.do(t, "HGETALL", "h"); !reflect.DeepEqual(got, []interface{}{"f1", "v1", "f2", "v2"}) {
+		t.Errorf("HGETALL: got %#v", got)
+	}
+	if got := c.do(t, "HINCRBY", "h", "n", "5"); got != int64(5) {
+		t.Errorf("HINCRBY: got %#v", got)
+	}
+	if got := c.do(t, "HINCRBYFLOAT", "h", "n", "0.5"); got != "5.5" {
+		t.Errorf("HINCRBYFLOAT: got %#v", got)
+	}
+	if got := c.do(t, "HINCRBY", "h", "f1", "1"); got != resp.Error("ERR hash value is not an integer or the increment would overflow") {
+		t.Errorf("HINCRBY on a string: got %#v", got)
+	}
+	if got := c.do(t, "HMGET", "h", "f2", "nope"); !reflect.DeepEqual(got, []interface{}{"v2", nil}) {
+		t.Errorf("HMGET: got %#v", got)
+	}
+	if got := c.do(t, "HKEYS", "h"); !reflect.DeepEqual(got, []interface{}{"f1", "f2", "n"}) {
+		t.Errorf("HKEYS: got %#v", got)
+	}
+	if got := c.do(t, "HSTRLEN", "h", "f1"); got != int64(2) {
+		t.Errorf("HSTRLEN: got %#v", got)
+	}
+	if got := c.do(t, "HEXPIRE", "h", "100", "FIELDS", "2", "f1", "nope"); !reflect.DeepEqual(got, []interface{}{int64(1), int64(-2)}) {
+		t.Errorf("HEXPIRE: got %#v", got)
+	}
+	if got, ok := c.do(t, "HTTL", "h", "FIELDS", "1", "f1").([]interface{}); !ok || len(got) != 1 || got[0].(int64) <= 0 {
+		t.Errorf("HTTL: got %#v", got)
+	}
+	if got := c.do(t, "HPERSIST", "h", "FIELDS", "1", "f1"); !reflect.DeepEqual(got, []interface{}{int64(1)}) {
+		t.Errorf("HPERSIST: got %#v", got)
+	}
+	if got := c.do(t, "SADD", "s", "x", "y", "x"); got != int64(2) {
+		t.Errorf("SADD: got %#v", got)
+	}
+	if got := c.do(t, "SISMEMBER", "s", "y"); got != int64(1) {
+		t.Errorf("SISMEMBER: got %#v", got)
+	}
+
+	// Scenario 5: sorted sets
+	if got := c.do(t, "ZADD", "board", "1", "a", "2", "b"); got != int64(2) {
+		t.Errorf("ZADD: got %#v", got)
+	}
+	if got := c.do(t, "ZRANGE", "board", "0", "-1", "WITHSCORES"); !reflect.DeepEqual(got, []interface{}{"a", "1", "b", "2"}) {
+		t.Errorf("ZRANGE: got %#v", got)
+	}
+	if got := c.do(t, "ZSCORE", "board", "missing"); got != nil {
+		t.Errorf("ZSCORE missing: got %#v", got)
+	}
+
+	// Scenario 6: keyspace commands
+	if got := c.do(t, "EXPIRE", "greeting", "100"); got != int64(1) {
+		t.Errorf("EXPIRE: got %#v", got)
+	}
+	if got, ok := c.do(t, "TTL", "greeting").(int64); !ok || got <= 0 || got > 100 {
+		t.Errorf("TTL: got %#v", got)
+	}
+	if got := c.do(t, "TTL", "missing"); got != int64(-2) {
+		t.Errorf("TTL missing: got %#v", got)
+	}
+	if got := c.do(t, "TYPE", "h"); got != resp.SimpleString("hash") {
+		t.Errorf("TYPE: got %#v", got)
+	}
+	if got := c.do(t, "DEL", "h", "s", "missing"); got != int64(2) {
+		t.Errorf("DEL: got %#v", got)
+	}
+
+	// Scenario 7: streams and consumer groups
+	if got := c.do(t, "XADD", "events", "1-1", "type", "click", "x", "10"); got != "1-1" {
+		t.Errorf("XADD: got %#v", got)
+	}
+	entry := []interface{}{"1-1", []interface{}{"type", "click", "x", "10"}}
+	if got := c.do(t, "XRANGE", "events", "-", "+"); !reflect.DeepEqual(got, []interface{}{entry}) {
+		t.Errorf("XRANGE: got %#v", got)
+	}
+	if got := c.do(t, "XGROUP", "CREATE", "events", "g", "0"); got != resp.SimpleString("OK") {
+		t.Errorf("XGROUP CREATE: got %#v", got)
+	}
+	if got := c.do(t, "XREADGROUP", "GROUP", "g", "c", "STREAMS", "events", ">"); !reflect.DeepEqual(got, []interface{}{[]interface{}{"events", []interface{}{entry}}}) {
+		t.Errorf("XREADGROUP: got %#v", got)
+	}
+	if got := c.do(t, "XREAD", "COUNT", "1", "BLOCK", "10", "STREAMS", "events", "$"); got != nil {
+		t.Errorf("XREAD timeout: expected nil, got %#v", got)
+	}
+	if got := c.do(t, "XACK", "events", "g", "1-1"); got != int64(1) {
+		t.Errorf("XACK: got %#v", got)
+	}
+	if got, ok := c.do(t, "XGROUP", "CREATE", "events", "g", "$").(resp.Error); !ok || !strings.HasPrefix(string(got), "BUSYGROUP") {
+		t.Errorf("Expected BUSYGROUP, got %#v", got)
+	}
+	if got := c.do(t, "TYPE", "events"); got != resp.SimpleString("stream") {
+		t.Errorf("TYPE stream: got %#v", got)
+	}
+}
+
+// TestRESPServerErrors checks error replies.
+func TestRESPServerErrors(t *testing.T) {
+	_, addr := startRESPServer(t)
+	c := dialRESP(t, addr)
+
+	c.do(t, "RPUSH", "list", "a")
+	if got, ok := c.do(t, "GET", "list").(resp.Error); !ok || !strings.HasPrefix(string(got), "WRONGTYPE") {
+		t.Errorf("Expected WRONGTYPE, got %#v", got)
+	}
+	if got, ok := c.do(t, "GET").(resp.Error); !ok || !strings.Contains(string(got), "wrong number of arguments") {
+		t.Errorf("Expected arity error, got %#v", got)
+	}
+	if got, ok := c.do(t, "NOPE").(resp.Error); !ok || !strings.HasPrefix(string(got), "ERR unknown command") {
+		t.Errorf("Expected unknown command error, got %#v", got)
+	}
+	if got, ok := c.do(t, "INCRBY", "n", "x").(resp.Error); !ok || !strings.HasPrefix(string(got), "ERR value is not an integer") {
+		t.Errorf("Expected integer error, got %#v", got)
+	}
+
+	// The connection stays usable after errors
+	if got := c.do(t, "PING", "still here"); got != "still here" {
+		t.Errorf("PING after errors: got %#v", got)
+	}
+}
+
+// TestRESPServerPipelining checks that a batch of commands gets replies in order.
+func TestRESPServerPipelining(t *testing.T) {
+	_, addr := startRESPServer(t)
+	c := dialRESP(t, addr)
+
+	const n = 100
+	cmds := make([][]string, 0, n+1)
+	for i := 0; i < n; i++ {
+		cmds = append(cmds, []string{"INCR", "pipelined"})
+	}
+	cmds = append(cmds, []string{"GET", "pipelined"})
+	c.send(t, cmds...)
+
+	for i := 1; i <= n; i++ {
+		if got := c.read(t); got != int64(i) {
+			t.Fatalf("Reply %d: got %#v", i, got)
+		}
+	}
+	if got := c.read(t); got != fmt.Sprint(n) {
+		t.Errorf("GET after pipeline: got %#v", got)
+	}
+
+	// Scenario: inline commands as typed by telnet
+	if _, err := c.conn.Write([]byte("PING\r\n")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if got := c.read(t); got != resp.SimpleString("PONG") {
+		t.Errorf("Inline PING: got %#v", got)
+	}
+}
+
+// TestRESPServerHello checks protocol negotiation.
+func TestRESPServerHello(t *testing.T) {
+	_, addr := startRESPServer(t)
+	c := dialRESP(t, addr)
+
+	// Scenario 1: RESP3 switches maps, sets and nulls to their native types
+	hello, ok := c.do(t, "HELLO", "3").(map[string]interface{})
+	if !ok || hello["proto"] != int64(3) || hello["server"] != "hermes" {
+		t.Fatalf("Unexpected HELLO reply %#v", hello)
+	}
+	if got := c.do(t, "GET", "missing"); got != nil {
+		t.Errorf("GET missing under RESP3: expected nil, got %#v", got)
+	}
+	c.do(t, "SADD", "s", "m")
+	if got := c.do(t, "SMEMBERS", "s"); !reflect.DeepEqual(got, []interface{}{"m"}) {
+		t.Errorf("SMEMBERS under RESP3: got %#v", got)
+	}
+
+	c.do(t, "HSET", "h", "f", "v")
+	if got := c.do(t, "HGETALL", "h"); !reflect.DeepEqual(got, map[string]interface{}{"f": "v"}) {
+		t.Errorf("HGETALL under RESP3: got %#v", got)
+	}
+
+	// Scenario 2: unsupported protocol versions are refused
+	if got, ok := c.do(t, "HELLO", "4").(resp.Error); !ok || !strings.HasPrefix(string(got), "NOPROTO") {
+		t.Errorf("Expected NOPROTO, got %#v", got)
+	}
+
+	// Scenario 3: QUIT closes the connection after replying
+	if got := c.do(t, "QUIT"); got != resp.SimpleString("OK") {
+		t.Errorf("QUIT: got %#v", got)
+	}
+	if _, err := c.r.ReadValue(); err == nil {
+		t.Error("Expected the connection to be closed after QUIT")
+	}
+}
+
+// TestRESPServerClose checks that Close disconnects clients and stops Serve.
+func TestRESPServerClose(t *testing.T) {
+	db := NewStore(Config{})
+	srv := NewRESPServer(context.Background(), db)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(l) }()
+
+	c := dialRESP(t, l.Addr().String())
+	c.do(t, "PING")
+
+	if err := srv.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != net.ErrClosed {
+			t.Errorf("Expected net.ErrClosed from Serve, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve did not return after Close")
+	}
+	if _, err := c.r.ReadValue(); err == nil {
+		t.Error("Expected client connection to be closed")
+	}
+}
+
+// TestRESPServerBlockedDisconnect checks that a client hanging up ends its
+// blocked command instead of leaving a waiter behind.
+func TestRESPServerBlockedDisconnect(t *testing.T) {
+	srv, addr := startRESPServer(t)
+	db := srv.db.(*DB)
+	waitBlocked := func(want int64) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for db.blocked.count.Load() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %d blocked waiters, got %d", want, db.blocked.count.Load())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	// Scenario 1: a gone BLPOP client does not take the next pushed element
+	gone := dialRESP(t, addr)
+	gone.send(t, []string{"BLPOP", "queue", "0"})
+	waitBlocked(1)
+	gone.conn.Close()
+	waitBlocked(0)
+
+	c := dialRESP(t, addr)
+	c.do(t, "RPUSH", "queue", "job")
+	if got := c.do(t, "LPOP", "queue"); got != "job" {
+		t.Errorf("Expected the pushed element to stay in the list, got %#v", got)
+	}
+
+	// Scenario 2: the same holds for XREAD BLOCK
+	gone = dialRESP(t, addr)
+	gone.send(t, []string{"XREAD", "BLOCK", "0", "STREAMS", "events", "$"})
+	waitBlocked(1)
+	gone.conn.Close()
+	waitBlocked(0)
+
+	// Scenario 3: Close returns while a connected client is blocked
+	blocked := dialRESP(t, addr)
+	blocked.send(t, []string{"BLMOVE", "queue", "done", "LEFT", "RIGHT", "0"})
+	waitBlocked(1)
+	closed := make(chan struct{})
+	go func() {
+		srv.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close did not return with a blocked client")
+	}
+}
+
+// TestRESPServerMultiExec checks transactions over the wire, with WATCH state
+// kept per connection.
+func TestRESPServerMultiExec(t *testing.T) {
+	_, addr := startRESPServer(t)
+	c := dialRESP(t, addr)
+	other := dialRESP(t, addr)
+
+	// Scenario 1: replies of queued commands come back as one array
+	c.do(t, "MULTI")
+	if got := c.do(t, "INCR", "n"); got != resp.SimpleString("QUEUED") {
+		t.Fatalf("Expected QUEUED, got %#v", got)
+	}
+	c.do(t, "LPUSH", "n", "x")
+	c.do(t, "INCR", "n")
+	got, ok := c.do(t, "EXEC").([]interface{})
+	if !ok || len(got) != 3 || got[0] != int64(1) || got[2] != int64(2) {
+		t.Fatalf("Unexpected EXEC reply %#v", got)
+	}
+	if e, ok := got[1].(resp.Error); !ok || !strings.HasPrefix(string(e), "WRONGTYPE") {
+		t.Errorf("Expected WRONGTYPE inside EXEC reply, got %#v", got[1])
+	}
+
+	// Scenario 2: a write from another connection aborts EXEC with a null reply
+	c.do(t, "WATCH", "n")
+	c.do(t, "MULTI")
+	c.do(t, "SET", "n", "100")
+	other.do(t, "INCR", "n")
+	if got := c.do(t, "EXEC"); got != nil {
+		t.Errorf("Expected null EXEC reply, got %#v", got)
+	}
+	if got := c.do(t, "GET", "n"); got != "3" {
+		t.Errorf("Expected 3, got %#v", got)
+	}
+
+	// Scenario 3: MULTI state is not shared between connections
+	c.do(t, "MULTI")
+	if got := other.do(t, "GET", "n"); got != "3" {
+		t.Errorf("Expected other connection to run GET directly, got %#v", got)
+	}
+	if got := c.do(t, "DISCARD"); got != resp.SimpleString("OK") {
+		t.Errorf("DISCARD: got %#v", got)
+	}
+	if got, ok := c.do(t, "EXEC").(resp.Error); !ok || string(got) != "ERR EXEC without MULTI" {
+		t.Errorf("Expected EXEC without MULTI error, got %#v", got)
+	}
+}
+
+// TestRESPServerPubSub checks SUBSCRIBE confirmations, message pushes and the
+// RESP2 subscribed mode.
+func TestRESPServerPubSub(t *testing.T) {
+	_, addr := startRESPServer(t)
+	sub := dialRESP(t, addr)
+	pub := dialRESP(t, addr)
+
+	// Scenario 1: one confirmation per channel
+	sub.send(t, []string{"SUBSCRIBE", "news", "sport"})
+	for i, want := range [][]interface{}{{"subscribe", "news", int64(1)}, {"subscribe", "sport", int64(2)}} {
+		if got := sub.read(t); !reflect.DeepEqual(got, want) {
+			t.Errorf("Confirmation %d: expected %#v, got %#v", i, want, got)
+		}
+	}
+
+	// Scenario 2: PUBLISH returns the receiver count and the message is pushed
+	if got := pub.do(t, "PUBLISH", "news", "hello"); got != int64(1) {
+		t.Errorf("Expected 1 receiver, got %#v", got)
+	}
+	if got := sub.read(t); !reflect.DeepEqual(got, []interface{}{"message", "news", "hello"}) {
+		t.Errorf("Unexpected message %#v", got)
+	}
+	if got := pub.do(t, "PUBSUB", "NUMSUB", "news"); !reflect.DeepEqual(got, []interface{}{"news", int64(1)}) {
+		t.Errorf("Unexpected NUMSUB reply %#v", got)
+	}
+
+	// Scenario 3: a RESP2 subscriber can only manage subscriptions and PING
+	if got, ok := sub.do(t, "GET", "news").(resp.Error); !ok || !strings.Contains(string(got), "only (P)SUBSCRIBE") {
+		t.Errorf("Expected subscribed mode error, got %#v", got)
+	}
+	if got := sub.do(t, "PING"); !reflect.DeepEqual(got, []interface{}{"pong", ""}) {
+		t.Errorf("Unexpected PING reply %#v", got)
+	}
+
+	// Scenario 4: pattern messages carry the pattern
+	sub.do(t, "PSUBSCRIBE", "s*")
+	pub.do(t, "PUBLISH", "sport", "goal")
+	got := []interface{}{sub.read(t), sub.read(t)}
+	want := []interface{}{"pmessage", "s*", "sport", "goal"}
+	if !reflect.DeepEqual(got[0], want) && !reflect.DeepEqual(got[1], want) {
+		t.Errorf("Expected a pmessage among %#v", got)
+	}
+
+	// Scenario 5: leaving every subscription restores normal commands
+	sub.send(t, []string{"UNSUBSCRIBE"})
+	sub.read(t)
+	sub.read(t)
+	sub.do(t, "PUNSUBSCRIBE")
+	if got := sub.do(t, "GET", "news"); got != nil {
+		t.Errorf("Expected null GET reply, got %#v", got)
+	}
+}
@@ -6,7 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"github.com/themedef/go-hermes/internal/contracts"
+	"github.com/themedef/go-hermes/internal/types"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type APIHandler struct {
@@ -71,17 +74,58 @@ func (h *APIHandler) RunServer(port, prefix string, middlewares ...func(http.Han
 		prefix + "/llen":          h.LLenHandler,
 		prefix + "/lrange":        h.LRangeHandler,
 		prefix + "/ltrim":         h.LTrimHandler,
+		prefix + "/lindex":        h.LIndexHandler,
+		prefix + "/lset":          h.LSetHandler,
+		prefix + "/linsert":       h.LInsertHandler,
+		prefix + "/lrem":          h.LRemHandler,
+		prefix + "/lpos":          h.LPosHandler,
+		prefix + "/lmove":         h.LMoveHandler,
+		prefix + "/rpoplpush":     h.RPopLPushHandler,
+		prefix + "/blpop":         h.BLPopHandler,
+		prefix + "/brpop":         h.BRPopHandler,
+		prefix + "/blmove":        h.BLMoveHandler,
 		prefix + "/hset":          h.HSetHandler,
 		prefix + "/hget":          h.HGetHandler,
 		prefix + "/hdel":          h.HDelHandler,
 		prefix + "/hgetall":       h.HGetAllHandler,
 		prefix + "/hexists":       h.HExistsHandler,
 		prefix + "/hlen":          h.HLenHandler,
+		prefix + "/hmset":         h.HMSetHandler,
+		prefix + "/hmget":         h.HMGetHandler,
+		prefix + "/hsetnx":        h.HSetNXHandler,
+		prefix + "/hincrby":       h.HIncrByHandler,
+		prefix + "/hincrbyfloat":  h.HIncrByFloatHandler,
+		prefix + "/hkeys":         h.HKeysHandler,
+		prefix + "/hvals":         h.HValsHandler,
+		prefix + "/hstrlen":       h.HStrLenHandler,
+		prefix + "/hexpire":       h.HExpireHandler,
+		prefix + "/httl":          h.HTTLHandler,
+		prefix + "/hpersist":      h.HPersistHandler,
 		prefix + "/sadd":          h.SAddHandler,
 		prefix + "/srem":          h.SRemHandler,
 		prefix + "/smembers":      h.SMembersHandler,
 		prefix + "/sismember":     h.SIsMemberHandler,
 		prefix + "/scard":         h.SCardHandler,
+		prefix + "/zadd":          h.ZAddHandler,
+		prefix + "/zincrby":       h.ZIncrByHandler,
+		prefix + "/zrem":          h.ZRemHandler,
+		prefix + "/zscore":        h.ZScoreHandler,
+		prefix + "/zrank":         h.ZRankHandler,
+		prefix + "/zrange":        h.ZRangeHandler,
+		prefix + "/zpopmin":       h.ZPopMinHandler,
+		prefix + "/zpopmax":       h.ZPopMaxHandler,
+		prefix + "/zcard":         h.ZCardHandler,
+		prefix + "/zcount":        h.ZCountHandler,
+		prefix + "/xadd":          h.XAddHandler,
+		prefix + "/xlen":          h.XLenHandler,
+		prefix + "/xrange":        h.XRangeHandler,
+		prefix + "/xtrim":         h.XTrimHandler,
+		prefix + "/xread":         h.XReadHandler,
+		prefix + "/xgroup":        h.XGroupHandler,
+		prefix + "/xreadgroup":    h.XReadGroupHandler,
+		prefix + "/xack":          h.XAckHandler,
+		prefix + "/xpending":      h.XPendingHandler,
+		prefix + "/xclaim":        h.XClaimHandler,
 		prefix + "/exists":        h.ExistsHandler,
 		prefix + "/expire":        h.ExpireHandler,
 		prefix + "/persist":       h.PersistHandler,
@@ -94,6 +138,10 @@ func (h *APIHandler) RunServer(port, prefix string, middlewares ...func(http.Han
 		prefix + "/subscribe":     h.SubscribeHandler,
 		prefix + "/subscriptions": h.ListSubscriptionsHandler,
 		prefix + "/closeallsub":   h.CloseAllSubscriptionsHandler,
+		prefix + "/publish":       h.PublishHandler,
+		prefix + "/numsub":        h.NumSubHandler,
+		prefix + "/numpat":        h.NumPatHandler,
+		prefix + "/substats":      h.SubscriptionStatsHandler,
 	}
 	for pattern, handler := range handlers {
 		mux.Handle(pattern, applyMiddleware(handler, middlewares...))
@@ -357,7 +405,8 @@ func (h *APIHandler) LPushHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "At least one value required", http.StatusBadRequest)
 		return
 	}
-	if err := h.db.LPush(h.ctx, req.Key, req.Values...); err != nil {
+	length, err := h.db.LPush(h.ctx, req.Key, req.Values...)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -365,6 +414,7 @@ func (h *APIHandler) LPushHandler(w http.ResponseWriter, r *http.Request) {
 		"message": "LPUSH success",
 		"key":     req.Key,
 		"count":   len(req.Values),
+		"length":  length,
 	})
 }
 
@@ -384,7 +434,8 @@ func (h *APIHandler) RPushHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "At least one value required", http.StatusBadRequest)
 		return
 	}
-	if err := h.db.RPush(h.ctx, req.Key, req.Values...); err != nil {
+	length, err := h.db.RPush(h.ctx, req.Key, req.Values...)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -392,6 +443,7 @@ func (h *APIHandler) RPushHandler(w http.ResponseWriter, r *http.Request) {
 		"message": "RPUSH success",
 		"key":     req.Key,
 		"count":   len(req.Values),
+		"length":  length,
 	})
 }
 
@@ -512,285 +564,1486 @@ func (h *APIHandler) LTrimHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err := h.db.LTrim(h.ctx, req.Key, req.Start, req.End); err != nil {
-		if IsKeyNotFound(err) {
-			http.Error(w, "Key not found", http.StatusNotFound)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.db.LTrim(h.ctx, req.Key, req.Start, req.End); err != nil {
+		if IsKeyNotFound(err) {
+			http.Error(w, "Key not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"message": "LTRIM success",
+		"key":     req.Key,
+		"start":   req.Start,
+		"end":     req.End,
+	})
+}
+
+func writeListError(w http.ResponseWriter, err error) {
+	switch {
+	case IsKeyNotFound(err):
+		http.Error(w, "Key not found", http.StatusNotFound)
+	case IsIndexOutOfRange(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case IsInvalidType(err):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrEmptyValues), IsInvalidKey(err):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case IsContextCanceled(err):
+		http.Error(w, err.Error(), http.StatusRequestTimeout)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *APIHandler) LIndexHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Key   string `json:"key"`
+		Index int    `json:"index"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	value, err := h.db.LIndex(h.ctx, req.Key, req.Index)
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"key":   req.Key,
+		"index": req.Index,
+		"value": value,
+	})
+}
+
+func (h *APIHandler) LSetHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Key   string      `json:"key"`
+		Index int         `json:"index"`
+		Value interface{} `json:"value"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.db.LSet(h.ctx, req.Key, req.Index, req.Value); err != nil {
+		writeListError(w, err)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"message": "LSET success",
+		"key":     req.Key,
+		"index":   req.Index,
+	})
+}
+
+func (h *APIHandler) LInsertHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Key      string      `json:"key"`
+		Position string      `json:"position"`
+		Pivot    interface{} `json:"pivot"`
+		Value    interface{} `json:"value"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	before, err := parseLInsertWhere(req.Position)
+	if err != nil {
+		http.Error(w, "position must be before or after", http.StatusBadRequest)
+		return
+	}
+	length, err := h.db.LInsert(h.ctx, req.Key, before, req.Pivot, req.Value)
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+	if length < 0 {
+		http.Error(w, "Pivot not found", http.StatusNotFound)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"message": "LINSERT success",
+		"key":     req.Key,
+		"length":  length,
+	})
+}
+
+func (h *APIHandler) LRemHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Key   string      `json:"key"`
+		Count int         `json:"count"`
+		Value interface{} `json:"value"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	removed, err := h.db.LRem(h.ctx, req.Key, req.Count, req.Value)
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"message": "LREM success",
+		"key":     req.Key,
+		"removed": removed,
+	})
+}
+
+func (h *APIHandler) LPosHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Key    string      `json:"key"`
+		Value  interface{} `json:"value"`
+		Rank   int         `json:"rank"`
+		Count  int         `json:"count"`
+		MaxLen int         `json:"maxlen"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts := types.LPosOptions{Rank: req.Rank, Count: req.Count, MaxLen: req.MaxLen}
+	positions, err := h.db.LPos(h.ctx, req.Key, req.Value, opts)
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"key":       req.Key,
+		"positions": positions,
+	})
+}
+
+func (h *APIHandler) LMoveHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Source      string `json:"source"`
+		Destination string `json:"destination"`
+		From        string `json:"from"`
+		To          string `json:"to"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, err1 := parseListSide(req.From)
+	to, err2 := parseListSide(req.To)
+	if err1 != nil || err2 != nil {
+		http.Error(w, "from and to must be left or right", http.StatusBadRequest)
+		return
+	}
+	h.lmove(w, "LMOVE", req.Source, req.Destination, from, to)
+}
+
+func (h *APIHandler) RPopLPushHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Source      string `json:"source"`
+		Destination string `json:"destination"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.lmove(w, "RPOPLPUSH", req.Source, req.Destination, types.ListRight, types.ListLeft)
+}
+
+func (h *APIHandler) lmove(w http.ResponseWriter, cmd, source, destination string, from, to types.ListSide) {
+	value, err := h.db.LMove(h.ctx, source, destination, from, to)
+	if err != nil {
+		writeListError(w, err)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"message":     cmd + " success",
+		"source":      source,
+		"destination": destination,
+		"value":       value,
+	})
+}
+
+// blockingContext bounds a long-poll request by its timeout in milliseconds;
+// 0 waits until the client goes away.
+func blockingContext(r *http.Request, timeout int) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(r.Context(), time.Duration(timeout)*time.Millisecond)
+	}
+	return context.WithCancel(r.Context())
+}
+
+// writeBlockingError reports the error of a blocking list pop. A pop that
+// found nothing before its timeout is not a failure: the reply is 204 No
+// Content.
+func writeBlockingError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case IsContextCanceled(err) && r.Context().Err() == nil, IsKeyNotFound(err):
+		w.WriteHeader(http.StatusNoContent)
+	case IsInvalidType(err):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrEmptyValues), IsInvalidKey(err):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case IsContextCanceled(err):
+		http.Error(w, err.Error(), http.StatusRequestTimeout)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *APIHandler) BLPopHandler(w http.ResponseWriter, r *http.Request) {
+	h.blockingPop(w, r, "BLPOP")
+}
+
+func (h *APIHandler) BRPopHandler(w http.ResponseWriter, r *http.Request) {
+	h.blockingPop(w, r, "BRPOP")
+}
+
+// blockingPop long-polls for an element of the first non-empty list among
+// "keys", waiting up to "timeout" milliseconds.
+func (h *APIHandler) blockingPop(w http.ResponseWriter, r *http.Request, cmd string) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Keys    []string `json:"keys"`
+		Timeout int      `json:"timeout"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Timeout < 0 {
+		http.Error(w, "timeout must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := blockingContext(r, req.Timeout)
+	defer cancel()
+	var (
+		key   string
+		value interface{}
+		err   error
+	)
+	if cmd == "BLPOP" {
+		key, value, err = h.db.BLPop(ctx, req.Keys...)
+	} else {
+		key, value, err = h.db.BRPop(ctx, req.Keys...)
+	}
+	if err != nil {
+		writeBlockingError(w, r, err)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"message": cmd + " success",
+		"key":     key,
+		"value":   value,
+	})
+}
+
+// BLMoveHandler long-polls like BLPopHandler, moving the element from
+// "source" to "destination". "from" and "to" are "left" or "right".
+func (h *APIHandler) BLMoveHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Source      string `json:"source"`
+		Destination string `json:"destination"`
+		From        string `json:"from"`
+		To          string `json:"to"`
+		Timeout     int    `json:"timeout"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, err1 := parseListSide(req.From)
+	to, err2 := parseListSide(req.To)
+	if err1 != nil || err2 != nil {
+		http.Error(w, "from and to must be left or right", http.StatusBadRequest)
+		return
+	}
+	if req.Timeout < 0 {
+		http.Error(w, "timeout must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := blockingContext(r, req.Timeout)
+	defer cancel()
+	value, err := h.db.BLMove(ctx, req.Source, req.Destination, from, to)
+	if err != nil {
+		writeBlockingError(w, r, err)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"message":     "BLMOVE success",
+		"source":      req.Source,
+		"destination": req.Destination,
+		"value":       value,
+	})
+}
+
+func (h *APIHandler) HSetHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Key   string      `json:"key"`
+		Field string      `json:"field"`
+		Value interface{} `json:"value"`
+		TTL   int         `json:"ttl"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.db.HSet(h.ctx, req.Key, req.Field, req.Value, req.TTL); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"message": "HSET success",
+		"key":     req.Key,
+		"field":   req.Field,
+	})
+}
+
+func (h *APIHandler) HGetHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	key := r.URL.Query().Get("key")
+	field := r.URL.Query().Get("field")
+	value, err := h.db.HGet(h.ctx, key, field)
+	if err != nil {
+		if IsKeyNotFound(err) {
+			http.Error(w, "Field not found or key not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"key":   key,
+		"field": field,
+		"value": value,
+	})
+}
+
+func (h *APIHandler) HDelHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodDelete) {
+		return
+	}
+	var req struct {
+		Key   string `json:"key"`
+		Field string `json:"field"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.db.HDel(h.ctx, req.Key, req.Field); err != nil {
+		if IsKeyNotFound(err) {
+			http.Error(w, "Field or key not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"message": "HDEL success",
+		"key":     req.Key,
+		"field":   req.Field,
+	})
+}
+
+func (h *APIHandler) HGetAllHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	key := r.URL.Query().Get("key")
+	result, err := h.db.HGetAll(h.ctx, key)
+	if err != nil {
+		if IsKeyNotFound(err) {
+			http.Error(w, "Key not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"key":    key,
+		"fields": result,
+	})
+}
+
+func (h *APIHandler) HExistsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	key := r.URL.Query().Get("key")
+	field := r.URL.Query().Get("field")
+	exists, err := h.db.HExists(h.ctx, key, field)
+	if err != nil {
+		if IsKeyNotFound(err) {
+			http.Error(w, "Key not found (or expired)", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"key":    key,
+		"field":  field,
+		"exists": exists,
+	})
+}
+
+func (h *APIHandler) HLenHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	key := r.URL.Query().Get("key")
+	length, err := h.db.HLen(h.ctx, key)
+	if err != nil {
+		if IsKeyNotFound(err) {
+			http.Error(w, "Key not found", http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"key":    key,
+		"length": length,
+	})
+}
+
+func writeHashError(w http.ResponseWriter, err error) {
+	switch {
+	case IsKeyNotFound(err):
+		http.Error(w, "Key not found", http.StatusNotFound)
+	case IsInvalidType(err):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case IsInvalidValueType(err), IsInvalidTTL(err), errors.Is(err, ErrEmptyValues):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case IsContextCanceled(err):
+		http.Error(w, err.Error(), http.StatusRequestTimeout)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *APIHandler) HMSetHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Key    string                 `json:"key"`
+		Fields map[string]interface{} `json:"fields"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	added, err := h.db.HMSet(h.ctx, req.Key, req.Fields)
+	if err != nil {
+		writeHashError(w, err)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"message": "HMSET success",
+		"key":     req.Key,
+		"added":   added,
+	})
+}
+
+func (h *APIHandler) HMGetHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	key := r.URL.Query().Get("key")
+	fields := r.URL.Query()["field"]
+	values, err := h.db.HMGet(h.ctx, key, fields...)
+	if err != nil {
+		writeHashError(w, err)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"key":    key,
+		"fields": fields,
+		"values": values,
+	})
+}
+
+func (h *APIHandler) HSetNXHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Key   string      `json:"key"`
+		Field string      `json:"field"`
+		Value interface{} `json:"value"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ok, err := h.db.HSetNX(h.ctx, req.Key, req.Field, req.Value)
+	if err != nil {
+		writeHashError(w, err)
+		return
+	}
+	if !ok {
+		http.Error(w, "Field already exists", http.StatusConflict)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"message": "HSETNX success",
+		"key":     req.Key,
+		"field":   req.Field,
+	})
+}
+
+func (h *APIHandler) HIncrByHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Key       string `json:"key"`
+		Field     string `json:"field"`
+		Increment int64  `json:"increment"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	value, err := h.db.HIncrBy(h.ctx, req.Key, req.Field, req.Increment)
+	if err != nil {
+		writeHashError(w, err)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"key":   req.Key,
+		"field": req.Field,
+		"value": value,
+	})
+}
+
+func (h *APIHandler) HIncrByFloatHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Key       string  `json:"key"`
+		Field     string  `json:"field"`
+		Increment float64 `json:"increment"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	value, err := h.db.HIncrByFloat(h.ctx, req.Key, req.Field, req.Increment)
+	if err != nil {
+		writeHashError(w, err)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"key":   req.Key,
+		"field": req.Field,
+		"value": value,
+	})
+}
+
+func (h *APIHandler) HKeysHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	key := r.URL.Query().Get("key")
+	fields, err := h.db.HKeys(h.ctx, key)
+	if err != nil {
+		writeHashError(w, err)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"key":    key,
+		"fields": fields,
+	})
+}
+
+func (h *APIHandler) HValsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	key := r.URL.Query().Get("key")
+	values, err := h.db.HVals(h.ctx, key)
+	if err != nil {
+		writeHashError(w, err)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"key":    key,
+		"values": values,
+	})
+}
+
+func (h *APIHandler) HStrLenHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	key := r.URL.Query().Get("key")
+	field := r.URL.Query().Get("field")
+	length, err := h.db.HStrLen(h.ctx, key, field)
+	if err != nil {
+		writeHashError(w, err)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"key":    key,
+		"field":  field,
+		"length": length,
+	})
+}
+
+func (h *APIHandler) HExpireHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Key    string   `json:"key"`
+		TTL    int      `json:"ttl"`
+		Fields []string `json:"fields"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	results, err := h.db.HExpire(h.ctx, req.Key, req.TTL, req.Fields...)
+	if err != nil {
+		writeHashError(w, err)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"key":     req.Key,
+		"fields":  req.Fields,
+		"results": results,
+	})
+}
+
+func (h *APIHandler) HTTLHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	key := r.URL.Query().Get("key")
+	fields := r.URL.Query()["field"]
+	ttls, err := h.db.HTTL(h.ctx, key, fields...)
+	if err != nil {
+		writeHashError(w, err)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"key":    key,
+		"fields": fields,
+		"ttls":   ttls,
+	})
+}
+
+func (h *APIHandler) HPersistHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Key    string   `json:"key"`
+		Fields []string `json:"fields"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	results, err := h.db.HPersist(h.ctx, req.Key, req.Fields...)
+	if err != nil {
+		writeHashError(w, err)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"key":     req.Key,
+		"fields":  req.Fields,
+		"results": results,
+	})
+}
+
+func (h *APIHandler) SAddHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Key     string        `json:"key"`
+		Members []interface{} `json:"members"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.db.SAdd(h.ctx, req.Key, req.Members...); err != nil {
+		if IsKeyNotFound(err) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else if IsInvalidType(err) {
+			http.Error(w, err.Error(), http.StatusConflict)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"message": "SADD success",
+		"key":     req.Key,
+		"count":   len(req.Members),
+	})
+}
+
+func (h *APIHandler) SRemHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Key     string        `json:"key"`
+		Members []interface{} `json:"members"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.db.SRem(h.ctx, req.Key, req.Members...); err != nil {
+		if IsKeyNotFound(err) {
+			http.Error(w, "Key not found or already empty", http.StatusNotFound)
+		} else if IsInvalidType(err) {
+			http.Error(w, err.Error(), http.StatusConflict)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"message": "SREM success",
+		"key":     req.Key,
+		"count":   len(req.Members),
+	})
+}
+
+func (h *APIHandler) SMembersHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	key := r.URL.Query().Get("key")
+	members, err := h.db.SMembers(h.ctx, key)
+	if err != nil {
+		if IsKeyNotFound(err) {
+			http.Error(w, "Key not found or expired", http.StatusNotFound)
+		} else if IsInvalidType(err) {
+			http.Error(w, err.Error(), http.StatusConflict)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"key":     key,
+		"members": members,
+	})
+}
+
+func (h *APIHandler) SIsMemberHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	key := r.URL.Query().Get("key")
+	member := r.URL.Query().Get("member")
+	if key == "" || member == "" {
+		http.Error(w, "Missing key or member parameter", http.StatusBadRequest)
+		return
+	}
+	isMember, err := h.db.SIsMember(h.ctx, key, member)
+	if err != nil {
+		if IsKeyNotFound(err) {
+			http.Error(w, "Key not found or expired", http.StatusNotFound)
+		} else if IsInvalidType(err) {
+			http.Error(w, err.Error(), http.StatusConflict)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"key":       key,
+		"member":    member,
+		"is_member": isMember,
+	})
+}
+
+func (h *APIHandler) SCardHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	key := r.URL.Query().Get("key")
+	count, err := h.db.SCard(h.ctx, key)
+	if err != nil {
+		if IsKeyNotFound(err) {
+			http.Error(w, "Key not found or expired", http.StatusNotFound)
+		} else if IsInvalidType(err) {
+			http.Error(w, err.Error(), http.StatusConflict)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"key":   key,
+		"count": count,
+	})
+}
+
+func writeZSetError(w http.ResponseWriter, err error) {
+	switch {
+	case IsKeyNotFound(err):
+		http.Error(w, "Key or member not found", http.StatusNotFound)
+	case IsInvalidType(err):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case IsInvalidScore(err), IsInvalidOptions(err), errors.Is(err, ErrEmptyValues), IsInvalidKey(err):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *APIHandler) ZAddHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Key     string          `json:"key"`
+		Members []types.ZMember `json:"members"`
+		NX      bool            `json:"nx"`
+		XX      bool            `json:"xx"`
+		GT      bool            `json:"gt"`
+		LT      bool            `json:"lt"`
+		CH      bool            `json:"ch"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts := types.ZAddOptions{NX: req.NX, XX: req.XX, GT: req.GT, LT: req.LT, CH: req.CH}
+	count, err := h.db.ZAdd(h.ctx, req.Key, opts, req.Members...)
+	if err != nil {
+		writeZSetError(w, err)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"message": "ZADD success",
+		"key":     req.Key,
+		"count":   count,
+	})
+}
+
+func (h *APIHandler) ZIncrByHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Key       string  `json:"key"`
+		Member    string  `json:"member"`
+		Increment float64 `json:"increment"`
+		NX        bool    `json:"nx"`
+		XX        bool    `json:"xx"`
+		GT        bool    `json:"gt"`
+		LT        bool    `json:"lt"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts := types.ZAddOptions{NX: req.NX, XX: req.XX, GT: req.GT, LT: req.LT}
+	score, err := h.db.ZIncrBy(h.ctx, req.Key, req.Member, req.Increment, opts)
+	if err != nil {
+		if IsConditionNotMet(err) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeZSetError(w, err)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"message": "ZINCRBY success",
+		"key":     req.Key,
+		"member":  req.Member,
+		"score":   score,
+	})
+}
+
+func (h *APIHandler) ZRemHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Key     string   `json:"key"`
+		Members []string `json:"members"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	count, err := h.db.ZRem(h.ctx, req.Key, req.Members...)
+	if err != nil {
+		writeZSetError(w, err)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"message": "ZREM success",
+		"key":     req.Key,
+		"count":   count,
+	})
+}
+
+func (h *APIHandler) ZScoreHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	key := r.URL.Query().Get("key")
+	member := r.URL.Query().Get("member")
+	if key == "" || member == "" {
+		http.Error(w, "Missing key or member parameter", http.StatusBadRequest)
+		return
+	}
+	score, err := h.db.ZScore(h.ctx, key, member)
+	if err != nil {
+		writeZSetError(w, err)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"key":    key,
+		"member": member,
+		"score":  score,
+	})
+}
+
+func (h *APIHandler) ZRankHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	key := r.URL.Query().Get("key")
+	member := r.URL.Query().Get("member")
+	if key == "" || member == "" {
+		http.Error(w, "Missing key or member parameter", http.StatusBadRequest)
+		return
+	}
+	var (
+		rank int
+		err  error
+	)
+	if r.URL.Query().Get("rev") == "true" {
+		rank, err = h.db.ZRevRank(h.ctx, key, member)
+	} else {
+		rank, err = h.db.ZRank(h.ctx, key, member)
+	}
+	if err != nil {
+		writeZSetError(w, err)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"key":    key,
+		"member": member,
+		"rank":   rank,
+	})
+}
+
+// ZRangeHandler serves rank, score and lex ranges. "by" selects the range kind
+// ("rank", "score" or "lex"); score and lex bounds use the command syntax, e.g.
+// "(1.5", "+inf", "[a" or "-".
+func (h *APIHandler) ZRangeHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Key    string `json:"key"`
+		By     string `json:"by"`
+		Start  int    `json:"start"`
+		Stop   int    `json:"stop"`
+		Min    string `json:"min"`
+		Max    string `json:"max"`
+		Rev    bool   `json:"rev"`
+		Offset int    `json:"offset"`
+		Count  int    `json:"count"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts := types.ZRangeOptions{Rev: req.Rev, Offset: req.Offset, Count: req.Count}
+
+	var (
+		members []types.ZMember
+		err     error
+	)
+	switch req.By {
+	case "", "rank":
+		members, err = h.db.ZRange(h.ctx, req.Key, req.Start, req.Stop, req.Rev)
+	case "score":
+		min, err1 := types.ParseScoreBound(req.Min)
+		max, err2 := types.ParseScoreBound(req.Max)
+		if err1 != nil || err2 != nil {
+			http.Error(w, "min or max is not a float", http.StatusBadRequest)
+			return
+		}
+		members, err = h.db.ZRangeByScore(h.ctx, req.Key, min, max, opts)
+	case "lex":
+		min, err1 := types.ParseLexBound(req.Min)
+		max, err2 := types.ParseLexBound(req.Max)
+		if err1 != nil || err2 != nil {
+			http.Error(w, "min or max not valid string range item", http.StatusBadRequest)
+			return
+		}
+		members, err = h.db.ZRangeByLex(h.ctx, req.Key, min, max, opts)
+	default:
+		http.Error(w, "by must be rank, score or lex", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeZSetError(w, err)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"key":     req.Key,
+		"members": members,
+	})
+}
+
+func (h *APIHandler) ZPopMinHandler(w http.ResponseWriter, r *http.Request) {
+	h.zpopHandler(w, r, false)
+}
+
+func (h *APIHandler) ZPopMaxHandler(w http.ResponseWriter, r *http.Request) {
+	h.zpopHandler(w, r, true)
+}
+
+func (h *APIHandler) zpopHandler(w http.ResponseWriter, r *http.Request, max bool) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Key   string `json:"key"`
+		Count int    `json:"count"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Count == 0 {
+		req.Count = 1
+	}
+	var (
+		members []types.ZMember
+		err     error
+	)
+	if max {
+		members, err = h.db.ZPopMax(h.ctx, req.Key, req.Count)
+	} else {
+		members, err = h.db.ZPopMin(h.ctx, req.Key, req.Count)
+	}
+	if err != nil {
+		writeZSetError(w, err)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"key":     req.Key,
+		"members": members,
+	})
+}
+
+func (h *APIHandler) ZCardHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	key := r.URL.Query().Get("key")
+	count, err := h.db.ZCard(h.ctx, key)
+	if err != nil {
+		writeZSetError(w, err)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"key":   key,
+		"count": count,
+	})
+}
+
+func (h *APIHandler) ZCountHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	key := r.URL.Query().Get("key")
+	min, err1 := types.ParseScoreBound(r.URL.Query().Get("min"))
+	max, err2 := types.ParseScoreBound(r.URL.Query().Get("max"))
+	if err1 != nil || err2 != nil {
+		http.Error(w, "min or max is not a float", http.StatusBadRequest)
+		return
+	}
+	count, err := h.db.ZCount(h.ctx, key, min, max)
+	if err != nil {
+		writeZSetError(w, err)
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"key":   key,
+		"count": count,
+	})
+}
+
+func writeStreamError(w http.ResponseWriter, err error) {
+	switch {
+	case IsKeyNotFound(err):
+		http.Error(w, "Key not found", http.StatusNotFound)
+	case IsGroupNotFound(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case IsInvalidType(err), IsGroupExists(err):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case IsInvalidStreamID(err), IsStreamIDTooSmall(err), IsInvalidOptions(err), errors.Is(err, ErrEmptyValues), IsInvalidKey(err):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case IsContextCanceled(err):
+		http.Error(w, err.Error(), http.StatusRequestTimeout)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// xreadRequest holds the JSON fields XREAD and XREADGROUP share. Block is in
+// milliseconds; when it is absent the read does not wait, and 0 waits until
+// the client goes away.
+type xreadRequest struct {
+	Keys  []string `json:"keys"`
+	IDs   []string `json:"ids"`
+	Count int      `json:"count"`
+	Block *int     `json:"block"`
+	NoAck bool     `json:"noack"`
+}
+
+func (req xreadRequest) options() (types.XReadOptions, error) {
+	opts := types.XReadOptions{Count: req.Count, NoAck: req.NoAck}
+	if req.Block != nil {
+		if *req.Block < 0 {
+			return opts, errors.New("block must not be negative")
 		}
-		return
+		opts.Block, opts.Timeout = true, time.Duration(*req.Block)*time.Millisecond
 	}
-	helperEncodeJSON(w, map[string]interface{}{
-		"message": "LTRIM success",
-		"key":     req.Key,
-		"start":   req.Start,
-		"end":     req.End,
-	})
+	return opts, nil
 }
 
-func (h *APIHandler) HSetHandler(w http.ResponseWriter, r *http.Request) {
+func (h *APIHandler) XAddHandler(w http.ResponseWriter, r *http.Request) {
 	if !requireMethod(w, r, http.MethodPost) {
 		return
 	}
 	var req struct {
-		Key   string      `json:"key"`
-		Field string      `json:"field"`
-		Value interface{} `json:"value"`
-		TTL   int         `json:"ttl"`
+		Key    string                 `json:"key"`
+		ID     string                 `json:"id"`
+		Fields map[string]interface{} `json:"fields"`
 	}
 	if err := decodeRequest(r, &req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err := h.db.HSet(h.ctx, req.Key, req.Field, req.Value, req.TTL); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if req.ID == "" {
+		req.ID = "*"
+	}
+	id, err := h.db.XAdd(h.ctx, req.Key, req.ID, req.Fields)
+	if err != nil {
+		writeStreamError(w, err)
 		return
 	}
 	helperEncodeJSON(w, map[string]interface{}{
-		"message": "HSET success",
+		"message": "XADD success",
 		"key":     req.Key,
-		"field":   req.Field,
+		"id":      id,
 	})
 }
 
-func (h *APIHandler) HGetHandler(w http.ResponseWriter, r *http.Request) {
+func (h *APIHandler) XLenHandler(w http.ResponseWriter, r *http.Request) {
 	if !requireMethod(w, r, http.MethodGet) {
 		return
 	}
 	key := r.URL.Query().Get("key")
-	field := r.URL.Query().Get("field")
-	value, err := h.db.HGet(h.ctx, key, field)
+	length, err := h.db.XLen(h.ctx, key)
 	if err != nil {
-		if IsKeyNotFound(err) {
-			http.Error(w, "Field not found or key not found", http.StatusNotFound)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+		writeStreamError(w, err)
 		return
 	}
 	helperEncodeJSON(w, map[string]interface{}{
-		"key":   key,
-		"field": field,
-		"value": value,
+		"key":    key,
+		"length": length,
 	})
 }
 
-func (h *APIHandler) HDelHandler(w http.ResponseWriter, r *http.Request) {
-	if !requireMethod(w, r, http.MethodDelete) {
+func (h *APIHandler) XRangeHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
 		return
 	}
 	var req struct {
 		Key   string `json:"key"`
-		Field string `json:"field"`
+		Start string `json:"start"`
+		End   string `json:"end"`
+		Count int    `json:"count"`
+		Rev   bool   `json:"rev"`
 	}
 	if err := decodeRequest(r, &req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err := h.db.HDel(h.ctx, req.Key, req.Field); err != nil {
-		if IsKeyNotFound(err) {
-			http.Error(w, "Field or key not found", http.StatusNotFound)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+	if req.Start == "" {
+		req.Start = "-"
+	}
+	if req.End == "" {
+		req.End = "+"
+	}
+
+	var (
+		entries []types.StreamEntry
+		err     error
+	)
+	if req.Rev {
+		entries, err = h.db.XRevRange(h.ctx, req.Key, req.End, req.Start, req.Count)
+	} else {
+		entries, err = h.db.XRange(h.ctx, req.Key, req.Start, req.End, req.Count)
+	}
+	if err != nil {
+		writeStreamError(w, err)
 		return
 	}
 	helperEncodeJSON(w, map[string]interface{}{
-		"message": "HDEL success",
 		"key":     req.Key,
-		"field":   req.Field,
+		"entries": entries,
 	})
 }
 
-func (h *APIHandler) HGetAllHandler(w http.ResponseWriter, r *http.Request) {
-	if !requireMethod(w, r, http.MethodGet) {
+func (h *APIHandler) XTrimHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
 		return
 	}
-	key := r.URL.Query().Get("key")
-	result, err := h.db.HGetAll(h.ctx, key)
+	var req struct {
+		Key    string `json:"key"`
+		MaxLen int    `json:"maxlen"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	removed, err := h.db.XTrim(h.ctx, req.Key, req.MaxLen)
 	if err != nil {
-		if IsKeyNotFound(err) {
-			http.Error(w, "Key not found", http.StatusNotFound)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+		writeStreamError(w, err)
 		return
 	}
 	helperEncodeJSON(w, map[string]interface{}{
-		"key":    key,
-		"fields": result,
+		"message": "XTRIM success",
+		"key":     req.Key,
+		"removed": removed,
 	})
 }
 
-func (h *APIHandler) HExistsHandler(w http.ResponseWriter, r *http.Request) {
-	if !requireMethod(w, r, http.MethodGet) {
+// XReadHandler reads from streams, waiting for new entries when "block" is
+// set. A blocking read ends early when the client disconnects.
+func (h *APIHandler) XReadHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
 		return
 	}
-	key := r.URL.Query().Get("key")
-	field := r.URL.Query().Get("field")
-	exists, err := h.db.HExists(h.ctx, key, field)
+	var req xreadRequest
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts, err := req.options()
 	if err != nil {
-		if IsKeyNotFound(err) {
-			http.Error(w, "Key not found (or expired)", http.StatusNotFound)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	streams, err := h.db.XRead(r.Context(), opts, req.Keys, req.IDs)
+	if err != nil {
+		writeStreamError(w, err)
 		return
 	}
 	helperEncodeJSON(w, map[string]interface{}{
-		"key":    key,
-		"field":  field,
-		"exists": exists,
+		"streams": streams,
 	})
 }
 
-func (h *APIHandler) HLenHandler(w http.ResponseWriter, r *http.Request) {
-	if !requireMethod(w, r, http.MethodGet) {
+func (h *APIHandler) XGroupHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
 		return
 	}
-	key := r.URL.Query().Get("key")
-	length, err := h.db.HLen(h.ctx, key)
-	if err != nil {
-		if IsKeyNotFound(err) {
-			http.Error(w, "Key not found", http.StatusNotFound)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+	var req struct {
+		Key      string `json:"key"`
+		Action   string `json:"action"`
+		Group    string `json:"group"`
+		ID       string `json:"id"`
+		MkStream bool   `json:"mkstream"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	helperEncodeJSON(w, map[string]interface{}{
-		"key":    key,
-		"length": length,
-	})
+	switch req.Action {
+	case "create":
+		if req.ID == "" {
+			req.ID = "$"
+		}
+		if err := h.db.XGroupCreate(h.ctx, req.Key, req.Group, req.ID, req.MkStream); err != nil {
+			writeStreamError(w, err)
+			return
+		}
+		helperEncodeJSON(w, map[string]interface{}{
+			"message": "XGROUP CREATE success",
+			"key":     req.Key,
+			"group":   req.Group,
+		})
+	case "destroy":
+		destroyed, err := h.db.XGroupDestroy(h.ctx, req.Key, req.Group)
+		if err != nil {
+			writeStreamError(w, err)
+			return
+		}
+		helperEncodeJSON(w, map[string]interface{}{
+			"key":       req.Key,
+			"group":     req.Group,
+			"destroyed": destroyed,
+		})
+	default:
+		http.Error(w, "action must be create or destroy", http.StatusBadRequest)
+	}
 }
 
-func (h *APIHandler) SAddHandler(w http.ResponseWriter, r *http.Request) {
+func (h *APIHandler) XReadGroupHandler(w http.ResponseWriter, r *http.Request) {
 	if !requireMethod(w, r, http.MethodPost) {
 		return
 	}
 	var req struct {
-		Key     string        `json:"key"`
-		Members []interface{} `json:"members"`
+		xreadRequest
+		Group    string `json:"group"`
+		Consumer string `json:"consumer"`
 	}
 	if err := decodeRequest(r, &req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err := h.db.SAdd(h.ctx, req.Key, req.Members...); err != nil {
-		if IsKeyNotFound(err) {
-			http.Error(w, err.Error(), http.StatusNotFound)
-		} else if IsInvalidType(err) {
-			http.Error(w, err.Error(), http.StatusConflict)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+	opts, err := req.options()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.IDs) == 0 {
+		req.IDs = make([]string, len(req.Keys))
+		for i := range req.IDs {
+			req.IDs[i] = ">"
 		}
+	}
+	streams, err := h.db.XReadGroup(r.Context(), req.Group, req.Consumer, opts, req.Keys, req.IDs)
+	if err != nil {
+		writeStreamError(w, err)
 		return
 	}
 	helperEncodeJSON(w, map[string]interface{}{
-		"message": "SADD success",
-		"key":     req.Key,
-		"count":   len(req.Members),
+		"group":    req.Group,
+		"consumer": req.Consumer,
+		"streams":  streams,
 	})
 }
 
-func (h *APIHandler) SRemHandler(w http.ResponseWriter, r *http.Request) {
+func (h *APIHandler) XAckHandler(w http.ResponseWriter, r *http.Request) {
 	if !requireMethod(w, r, http.MethodPost) {
 		return
 	}
 	var req struct {
-		Key     string        `json:"key"`
-		Members []interface{} `json:"members"`
+		Key   string   `json:"key"`
+		Group string   `json:"group"`
+		IDs   []string `json:"ids"`
 	}
 	if err := decodeRequest(r, &req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if err := h.db.SRem(h.ctx, req.Key, req.Members...); err != nil {
-		if IsKeyNotFound(err) {
-			http.Error(w, "Key not found or already empty", http.StatusNotFound)
-		} else if IsInvalidType(err) {
-			http.Error(w, err.Error(), http.StatusConflict)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+	acked, err := h.db.XAck(h.ctx, req.Key, req.Group, req.IDs...)
+	if err != nil {
+		writeStreamError(w, err)
 		return
 	}
 	helperEncodeJSON(w, map[string]interface{}{
-		"message": "SREM success",
+		"message": "XACK success",
 		"key":     req.Key,
-		"count":   len(req.Members),
+		"acked":   acked,
 	})
 }
 
-func (h *APIHandler) SMembersHandler(w http.ResponseWriter, r *http.Request) {
+func (h *APIHandler) XPendingHandler(w http.ResponseWriter, r *http.Request) {
 	if !requireMethod(w, r, http.MethodGet) {
 		return
 	}
-	key := r.URL.Query().Get("key")
-	members, err := h.db.SMembers(h.ctx, key)
+	q := r.URL.Query()
+	key, group := q.Get("key"), q.Get("group")
+	pending, err := h.db.XPending(h.ctx, key, group, q.Get("consumer"))
 	if err != nil {
-		if IsKeyNotFound(err) {
-			http.Error(w, "Key not found or expired", http.StatusNotFound)
-		} else if IsInvalidType(err) {
-			http.Error(w, err.Error(), http.StatusConflict)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+		writeStreamError(w, err)
 		return
 	}
 	helperEncodeJSON(w, map[string]interface{}{
 		"key":     key,
-		"members": members,
+		"group":   group,
+		"pending": pending,
 	})
 }
 
-func (h *APIHandler) SIsMemberHandler(w http.ResponseWriter, r *http.Request) {
-	if !requireMethod(w, r, http.MethodGet) {
+func (h *APIHandler) XClaimHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
 		return
 	}
-	key := r.URL.Query().Get("key")
-	member := r.URL.Query().Get("member")
-	if key == "" || member == "" {
-		http.Error(w, "Missing key or member parameter", http.StatusBadRequest)
-		return
+	var req struct {
+		Key      string   `json:"key"`
+		Group    string   `json:"group"`
+		Consumer string   `json:"consumer"`
+		MinIdle  int      `json:"min_idle"`
+		IDs      []string `json:"ids"`
 	}
-	isMember, err := h.db.SIsMember(h.ctx, key, member)
-	if err != nil {
-		if IsKeyNotFound(err) {
-			http.Error(w, "Key not found or expired", http.StatusNotFound)
-		} else if IsInvalidType(err) {
-			http.Error(w, err.Error(), http.StatusConflict)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	helperEncodeJSON(w, map[string]interface{}{
-		"key":       key,
-		"member":    member,
-		"is_member": isMember,
-	})
-}
-
-func (h *APIHandler) SCardHandler(w http.ResponseWriter, r *http.Request) {
-	if !requireMethod(w, r, http.MethodGet) {
+	if req.MinIdle < 0 {
+		http.Error(w, "min_idle must not be negative", http.StatusBadRequest)
 		return
 	}
-	key := r.URL.Query().Get("key")
-	count, err := h.db.SCard(h.ctx, key)
+	entries, err := h.db.XClaim(h.ctx, req.Key, req.Group, req.Consumer, time.Duration(req.MinIdle)*time.Millisecond, req.IDs...)
 	if err != nil {
-		if IsKeyNotFound(err) {
-			http.Error(w, "Key not found or expired", http.StatusNotFound)
-		} else if IsInvalidType(err) {
-			http.Error(w, err.Error(), http.StatusConflict)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+		writeStreamError(w, err)
 		return
 	}
 	helperEncodeJSON(w, map[string]interface{}{
-		"key":   key,
-		"count": count,
+		"key":     req.Key,
+		"group":   req.Group,
+		"entries": entries,
 	})
 }
 
@@ -998,6 +2251,10 @@ func (h *APIHandler) DropAllHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// sseBufferSize is the default message buffer of a /subscribe stream, kept
+// well below PubSubBufferSize since every client holds one.
+const sseBufferSize = 256
+
 func (h *APIHandler) SubscribeHandler(w http.ResponseWriter, r *http.Request) {
 	if !requireMethod(w, r, http.MethodGet) {
 		return
@@ -1007,6 +2264,34 @@ func (h *APIHandler) SubscribeHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Missing key parameter", http.StatusBadRequest)
 		return
 	}
+	opts := SubscribeOptions{
+		BufferSize:     sseBufferSize,
+		InitialMessage: "Subscribed to " + key,
+	}
+	if policy := r.URL.Query().Get("policy"); policy != "" {
+		if err := opts.Policy.UnmarshalText([]byte(policy)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if buffer := r.URL.Query().Get("buffer"); buffer != "" {
+		size, err := strconv.Atoi(buffer)
+		if err != nil || size <= 0 {
+			http.Error(w, "Invalid buffer parameter", http.StatusBadRequest)
+			return
+		}
+		opts.BufferSize = size
+	}
+	// A reconnecting EventSource sends the id of the last event it saw; the
+	// events of a retained channel after it are replayed first.
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		id, err := strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid Last-Event-ID header", http.StatusBadRequest)
+			return
+		}
+		opts.LastID = id
+	}
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
@@ -1015,22 +2300,13 @@ func (h *APIHandler) SubscribeHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
 		return
 	}
-	msgChan := h.db.Subscribe(key)
-	defer h.db.Unsubscribe(key, msgChan)
-	fmt.Fprintf(w, "data: Subscribed to %s\n\n", key)
-	flusher.Flush()
-	ctx := r.Context()
-	for {
-		select {
-		case msg, ok := <-msgChan:
-			if !ok {
-				return
-			}
-			fmt.Fprintf(w, "data: %s\n\n", msg)
-			flusher.Flush()
-		case <-ctx.Done():
-			return
+	// The subscription ends with the request, and the loop with it.
+	for ev := range h.db.SubscribeEventsContext(r.Context(), key, opts) {
+		if ev.ID > 0 {
+			fmt.Fprintf(w, "id: %d\n", ev.ID)
 		}
+		fmt.Fprintf(w, "data: %s\n\n", ev)
+		flusher.Flush()
 	}
 }
 
@@ -1065,3 +2341,55 @@ func (h *APIHandler) CloseAllSubscriptionsHandler(w http.ResponseWriter, r *http
 		"key":     req.Key,
 	})
 }
+
+func (h *APIHandler) PublishHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Channel string `json:"channel"`
+		Message string `json:"message"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Channel == "" {
+		http.Error(w, "Missing channel", http.StatusBadRequest)
+		return
+	}
+	receivers := h.db.Publish(req.Channel, req.Message)
+	helperEncodeJSON(w, map[string]interface{}{
+		"message":   "Published",
+		"channel":   req.Channel,
+		"receivers": receivers,
+	})
+}
+
+func (h *APIHandler) NumSubHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	channels := r.URL.Query()["channel"]
+	helperEncodeJSON(w, map[string]interface{}{
+		"subscribers": h.db.NumSub(channels...),
+	})
+}
+
+func (h *APIHandler) NumPatHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"patterns": h.db.NumPat(),
+	})
+}
+
+func (h *APIHandler) SubscriptionStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	helperEncodeJSON(w, map[string]interface{}{
+		"subscriptions": h.db.SubscriptionStats(),
+	})
+}
@@ -0,0 +1,193 @@
+package hermes
+
+import (
+	"github.com/themedef/go-hermes/internal/contracts"
+	"github.com/themedef/go-hermes/internal/types"
+)
+
+// savepoint marks a position in the transaction's queue. Nothing is applied
+// before Commit, so rolling back to a savepoint only has to drop the
+// operations queued after it and restore the overlay reads are answered from.
+type savepoint struct {
+	name     string
+	commands int
+	keys     int
+	allKeys  bool
+	overlay  map[string]types.Entry
+	staged   map[string]bool
+}
+
+// Savepoint marks the current point of the transaction under name. A later
+// RollbackTo(name) discards the operations queued since. Reusing a name hides
+// the earlier savepoint until the newer one is rolled past.
+func (t *Transaction) Savepoint(name string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return ErrTransactionNotActive
+	}
+	if name == "" {
+		return ErrEmptyValues
+	}
+	if t.child != nil {
+		return ErrNestedTransactionOpen
+	}
+
+	sp := savepoint{
+		name:     name,
+		commands: len(t.commands),
+		keys:     len(t.keys),
+		allKeys:  t.allKeys,
+	}
+	if t.overlay != nil {
+		sp.overlay = cloneEntries(t.overlay.shards[0].data)
+		sp.staged = make(map[string]bool, len(t.staged))
+		for key := range t.staged {
+			sp.staged[key] = true
+		}
+	}
+	t.savepoints = append(t.savepoints, sp)
+	t.db.Logger().Info("Transaction savepoint created", "name", name)
+	return nil
+}
+
+// RollbackTo discards the operations queued after the savepoint name and every
+// savepoint created after it. The savepoint itself is kept, so the transaction
+// can roll back to it again. Watched keys are not affected.
+func (t *Transaction) RollbackTo(name string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return ErrTransactionNotActive
+	}
+	if t.child != nil {
+		return ErrNestedTransactionOpen
+	}
+
+	i := len(t.savepoints) - 1
+	for i >= 0 && t.savepoints[i].name != name {
+		i--
+	}
+	if i < 0 {
+		return ErrSavepointNotFound
+	}
+
+	sp := t.savepoints[i]
+	t.savepoints = t.savepoints[:i+1]
+	t.commands = t.commands[:sp.commands]
+	t.keys = t.keys[:sp.keys]
+	t.allKeys = sp.allKeys
+	if t.overlay != nil {
+		t.overlay.shards[0].data = cloneEntries(sp.overlay)
+		t.staged = make(map[string]bool, len(sp.staged))
+		for key := range sp.staged {
+			t.staged[key] = true
+		}
+	}
+	t.db.Logger().Info("Transaction rolled back to savepoint", "name", name)
+	return nil
+}
+
+// Begin starts a transaction nested in t. Reads through it see t's queued
+// writes; its own writes stay private until its Commit merges them into t,
+// while its Rollback drops them and leaves t as it was. t cannot queue writes,
+// commit or use savepoints while the nested transaction is open.
+func (t *Transaction) Begin() (contracts.TransactionHandler, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return nil, ErrTransactionNotActive
+	}
+	if t.child != nil {
+		return nil, ErrNestedTransactionOpen
+	}
+
+	child := &Transaction{
+		db:       t.db,
+		active:   true,
+		readOnly: t.readOnly,
+		watcher:  t.watcher,
+		locker:   t.locker,
+		parent:   t,
+	}
+	t.child = child
+	t.db.Logger().Info("Nested transaction started")
+	return child, nil
+}
+
+// mergeIntoParent appends the nested transaction's queue, overlay and watched
+// keys to its parent and ends it. The caller holds t.mu.
+func (t *Transaction) mergeIntoParent() error {
+	p := t.parent
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.child == t {
+		p.child = nil
+	}
+	if !p.active {
+		t.clear()
+		return ErrTransactionNotActive
+	}
+
+	p.commands = append(p.commands, t.commands...)
+	p.keys = append(p.keys, t.keys...)
+	p.allKeys = p.allKeys || t.allKeys
+	if t.overlay != nil {
+		if p.overlay == nil {
+			p.overlay = newOverlayDB()
+			p.staged = make(map[string]bool)
+		}
+		for key := range t.staged {
+			if entry, ok := t.overlay.shards[0].data[key]; ok {
+				p.overlay.shards[0].data[key] = entry
+			} else {
+				delete(p.overlay.shards[0].data, key)
+			}
+			p.staged[key] = true
+		}
+	}
+	for key, v := range t.watched {
+		if _, ok := p.watched[key]; ok {
+			// The parent's older version is the stricter check.
+			t.watcher.unwatchKey(key)
+			continue
+		}
+		if p.watched == nil {
+			p.watched = make(map[string]keyVersion)
+		}
+		p.watched[key] = v
+	}
+	t.watched = nil
+
+	t.clear()
+	t.db.Logger().Info("Nested transaction merged into parent")
+	return nil
+}
+
+// detach unregisters a nested transaction from its parent. The caller holds
+// t.mu.
+func (t *Transaction) detach() {
+	p := t.parent
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.child == t {
+		p.child = nil
+	}
+}
+
+// root returns the outermost transaction, the one whose Commit applies the
+// queue.
+func (t *Transaction) root() *Transaction {
+	for t.parent != nil {
+		t = t.parent
+	}
+	return t
+}
+
+func cloneEntries(data map[string]types.Entry) map[string]types.Entry {
+	clone := make(map[string]types.Entry, len(data))
+	for key, entry := range data {
+		clone[key] = entry.Clone()
+	}
+	return clone
+}
@@ -0,0 +1,200 @@
+package hermes
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/themedef/go-hermes/internal/types"
+)
+
+// TestSnapshotRoundTrip checks that every data type and its expiration survive a save/load cycle.
+func TestSnapshotRoundTrip(t *testing.T) {
+	src := withTestStore(t)
+	ctx := context.Background()
+
+	if err := src.Set(ctx, "str", "value", 3600); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, err := src.Incr(ctx, "counter"); err != nil {
+		t.Fatalf("Incr failed: %v", err)
+	}
+	if _, err := src.RPush(ctx, "list", "a", int64(2), 3.5); err != nil {
+		t.Fatalf("RPush failed: %v", err)
+	}
+	if err := src.HSet(ctx, "hash", "name", "hermes", 600); err != nil {
+		t.Fatalf("HSet failed: %v", err)
+	}
+	_ = src.HSet(ctx, "hash", "token", "t1", 0)
+	if _, err := src.HExpire(ctx, "hash", 120, "token"); err != nil {
+		t.Fatalf("HExpire failed: %v", err)
+	}
+	if err := src.SAdd(ctx, "set", "x", "y", true); err != nil {
+		t.Fatalf("SAdd failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := src.SaveSnapshot(ctx, &buf); err != nil {
+		t.Fatalf("SaveSnapshot failed: %v", err)
+	}
+
+	dst := NewStore(Config{ShardCount: 4})
+	defer dst.Close()
+	if err := dst.LoadSnapshot(ctx, &buf); err != nil {
+		t.Fatalf("LoadSnapshot failed: %v", err)
+	}
+
+	val, ttl, err := dst.GetWithDetails(ctx, "str")
+	if err != nil || val != "value" {
+		t.Fatalf("Expected 'value', got %v (err=%v)", val, err)
+	}
+	if ttl <= 3500 || ttl > 3600 {
+		t.Errorf("Expected TTL close to 3600, got %d", ttl)
+	}
+
+	counter, err := dst.Get(ctx, "counter")
+	if err != nil || counter != int64(1) {
+		t.Errorf("Expected counter int64(1), got %v (err=%v)", counter, err)
+	}
+
+	list, err := dst.LRange(ctx, "list", 0, -1)
+	if err != nil {
+		t.Fatalf("LRange failed: %v", err)
+	}
+	if !reflect.DeepEqual(list, []interface{}{"a", int64(2), 3.5}) {
+		t.Errorf("Unexpected list after load: %v", list)
+	}
+
+	hash, err := dst.HGetAll(ctx, "hash")
+	if err != nil || hash["name"] != "hermes" {
+		t.Errorf("Unexpected hash after load: %v (err=%v)", hash, err)
+	}
+	_, hashTTL, _ := dst.GetWithDetails(ctx, "hash")
+	if hashTTL <= 0 {
+		t.Errorf("Expected hash TTL to be preserved, got %d", hashTTL)
+	}
+	if ttls, _ := dst.HTTL(ctx, "hash", "token", "name"); len(ttls) != 2 || ttls[0] <= 100 || ttls[1] != -1 {
+		t.Errorf("Expected the field expiration to be preserved, got %v", ttls)
+	}
+
+	members, err := dst.SMembers(ctx, "set")
+	if err != nil || len(members) != 3 {
+		t.Fatalf("Unexpected set after load: %v (err=%v)", members, err)
+	}
+	for _, m := range []interface{}{"x", "y", true} {
+		if ok, _ := dst.SIsMember(ctx, "set", m); !ok {
+			t.Errorf("Expected %v to be a member after load", m)
+		}
+	}
+}
+
+// TestSnapshotSkipsExpired checks that expired keys are not written or restored.
+func TestSnapshotSkipsExpired(t *testing.T) {
+	db := withTestStore(t)
+	ctx := context.Background()
+
+	_ = db.Set(ctx, "keep", "v", 0)
+	_ = db.RestoreRawEntry(ctx, "gone", types.Entry{
+		Value:      "old",
+		Type:       types.String,
+		Expiration: time.Now().Add(-time.Minute),
+	})
+
+	var buf bytes.Buffer
+	if err := db.SaveSnapshot(ctx, &buf); err != nil {
+		t.Fatalf("SaveSnapshot failed: %v", err)
+	}
+	if err := db.DropAll(ctx); err != nil {
+		t.Fatalf("DropAll failed: %v", err)
+	}
+	if err := db.LoadSnapshot(ctx, &buf); err != nil {
+		t.Fatalf("LoadSnapshot failed: %v", err)
+	}
+
+	keys, _ := db.FindByValue(ctx, "v")
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"keep"}) {
+		t.Errorf("Expected only 'keep', got %v", keys)
+	}
+	if exists, _ := db.Exists(ctx, "gone"); exists {
+		t.Error("Expired key should not be restored")
+	}
+}
+
+// TestSnapshotCorruption checks that damaged snapshots are rejected without touching existing data.
+func TestSnapshotCorruption(t *testing.T) {
+	db := withTestStore(t)
+	ctx := context.Background()
+
+	_ = db.Set(ctx, "k", "v", 0)
+	var buf bytes.Buffer
+	if err := db.SaveSnapshot(ctx, &buf); err != nil {
+		t.Fatalf("SaveSnapshot failed: %v", err)
+	}
+	data := buf.Bytes()
+
+	flipped := append([]byte(nil), data...)
+	flipped[len(flipped)-12] ^= 0xFF
+	if err := db.LoadSnapshot(ctx, bytes.NewReader(flipped)); !IsInvalidSnapshot(err) {
+		t.Errorf("Expected ErrInvalidSnapshot for flipped byte, got %v", err)
+	}
+
+	truncated := data[:len(data)-3]
+	if err := db.LoadSnapshot(ctx, bytes.NewReader(truncated)); !IsInvalidSnapshot(err) {
+		t.Errorf("Expected ErrInvalidSnapshot for truncated data, got %v", err)
+	}
+
+	if err := db.LoadSnapshot(ctx, bytes.NewReader([]byte("not a snapshot"))); !IsInvalidSnapshot(err) {
+		t.Errorf("Expected ErrInvalidSnapshot for garbage, got %v", err)
+	}
+
+	if val, err := db.Get(ctx, "k"); err != nil || val != "v" {
+		t.Errorf("Failed load must keep existing data, got %v (err=%v)", val, err)
+	}
+}
+
+// TestSnapshotUnsupportedValue checks that values the codec cannot encode are reported.
+func TestSnapshotUnsupportedValue(t *testing.T) {
+	db := withTestStore(t)
+	ctx := context.Background()
+
+	_ = db.Set(ctx, "ch", make(chan int), 0)
+	var buf bytes.Buffer
+	if err := db.SaveSnapshot(ctx, &buf); !IsUnsupportedValue(err) {
+		t.Errorf("Expected ErrUnsupportedValue, got %v", err)
+	}
+}
+
+// TestSnapshotFileOnStartup checks that Config.SnapshotFile is loaded by NewStore.
+func TestSnapshotFileOnStartup(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "dump.hdb")
+
+	src := withTestStore(t)
+	_ = src.Set(ctx, "persisted", "yes", 0)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := src.SaveSnapshot(ctx, f); err != nil {
+		t.Fatalf("SaveSnapshot failed: %v", err)
+	}
+	_ = f.Close()
+
+	db := NewStore(Config{SnapshotFile: path})
+	defer db.Close()
+	if val, err := db.Get(ctx, "persisted"); err != nil || val != "yes" {
+		t.Errorf("Expected 'yes' from snapshot file, got %v (err=%v)", val, err)
+	}
+
+	empty := NewStore(Config{SnapshotFile: filepath.Join(t.TempDir(), "missing.hdb")})
+	defer empty.Close()
+	if exists, _ := empty.Exists(ctx, "persisted"); exists {
+		t.Error("Missing snapshot file should start an empty store")
+	}
+}
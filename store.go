@@ -2,26 +2,34 @@ package hermes
 
 import (
 	"context"
-	"fmt"
 	"github.com/themedef/go-hermes/internal/types"
 	"hash/fnv"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/themedef/go-hermes/internal/contracts"
 	"github.com/themedef/go-hermes/internal/logger"
+	"github.com/themedef/go-hermes/internal/persistence"
 	"github.com/themedef/go-hermes/internal/pubsub"
 )
 
 type Config struct {
-	ShardCount       int
-	CleanupInterval  time.Duration
-	EnableLogging    bool
-	LogFile          string
-	LogBufferSize    int
-	MinLevel         logger.LogLevel
-	PubSubBufferSize int
+	ShardCount              int
+	CleanupInterval         time.Duration
+	EnableLogging           bool
+	LogFile                 string
+	LogBufferSize           int
+	MinLevel                logger.LogLevel
+	PubSubBufferSize        int
+	SnapshotFile            string
+	AppendLogFile           string
+	AppendFsync             persistence.FsyncPolicy
+	AppendRewriteMinSize    int64
+	AppendRewritePercentage int
+	TransactionRetries      int
+	NotifyEvents            EventClass
 }
 
 type shard struct {
@@ -38,6 +46,11 @@ type DB struct {
 	commands      contracts.CommandsHandler
 	cleanupCtx    context.Context
 	cleanupCancel context.CancelFunc
+	aof           *persistence.AppendLog
+	replayClock   atomic.Int64
+	watches       watchRegistry
+	blocked       blockRegistry
+	events        eventOutbox
 }
 
 func NewStore(config Config) contracts.StoreHandler {
@@ -49,6 +62,22 @@ func NewStore(config Config) contracts.StoreHandler {
 		config.ShardCount = 1
 	}
 
+	if config.AppendRewriteMinSize == 0 {
+		config.AppendRewriteMinSize = defaultAppendRewriteMinSize
+	}
+
+	if config.AppendRewritePercentage == 0 {
+		config.AppendRewritePercentage = defaultAppendRewritePercentage
+	}
+
+	if config.TransactionRetries == 0 {
+		config.TransactionRetries = defaultTransactionRetries
+	}
+
+	if config.NotifyEvents == 0 {
+		config.NotifyEvents = NotifyAll
+	}
+
 	dbLogger, err := logger.NewLogger(logger.Config{
 		LogFile:    config.LogFile,
 		Enabled:    config.EnableLogging,
@@ -80,7 +109,14 @@ func NewStore(config Config) contracts.StoreHandler {
 	}
 	db.commands = NewCommandAPI(db)
 
+	if err := db.restorePersistedState(); err != nil {
+		log.Fatalf("Failed to restore persisted state: %v", err)
+	}
+
 	go db.cleanupExpiredKeys(config.CleanupInterval)
+	if db.aof != nil {
+		go db.autoRewriteAppendLog(appendRewriteCheckInterval)
+	}
 
 	return db
 }
@@ -105,13 +141,6 @@ func ttlSecondsToTime(ttl int) (time.Time, error) {
 	return time.Now().Add(time.Duration(ttl) * time.Second), nil
 }
 
-func isExpired(e types.Entry) bool {
-	if e.Expiration.IsZero() {
-		return false
-	}
-	return time.Now().After(e.Expiration)
-}
-
 func (db *DB) setInternal(ctx context.Context, key string, value interface{}, ttl int, ifExists, ifNotExists bool) (bool, error) {
 	select {
 	case <-ctx.Done():
@@ -134,11 +163,14 @@ func (db *DB) setInternal(ctx context.Context, key string, value interface{}, tt
 		return false, err
 	}
 
+	if err := db.checkLoggable("Set", key, value); err != nil {
+		return false, err
+	}
+
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.Lock()
-	defer sh.mu.Unlock()
+	defer db.lockShard(ctx, sh)()
 
-	_, exists := sh.data[key]
+	old, exists := sh.data[key]
 
 	if ifExists && !exists {
 		db.logger.Warn("key does not exist for XX operation", "key", key)
@@ -155,12 +187,17 @@ func (db *DB) setInternal(ctx context.Context, key string, value interface{}, tt
 		Type:       types.String,
 	}
 	sh.data[key] = newEntry
+	db.afterWrite(opSet, key, value, unixNano(expiration))
 
 	db.logger.Info("key set successfully",
 		"key", key,
 		"value", value,
 		"ttl", ttl)
-	db.pubsub.Publish(key, fmt.Sprintf("SET: %v", value))
+	ev := types.Event{Op: types.EventSet, Key: key, Type: types.String, NewValue: value, TTL: ttlLeft(expiration)}
+	if exists && !db.isExpired(old) {
+		ev.OldValue = old.Value
+	}
+	db.notify(ctx, ev)
 
 	return true, nil
 }
@@ -187,17 +224,17 @@ func (db *DB) Get(ctx context.Context, key string) (interface{}, error) {
 	}
 
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.RLock()
+	unlock := db.rlockShard(ctx, sh)
 	entry, exists := sh.data[key]
-	sh.mu.RUnlock()
+	unlock()
 
-	if !exists || isExpired(entry) {
+	if !exists || db.isExpired(entry) {
 		if exists {
-			sh.mu.Lock()
-			if latestEntry, ok := sh.data[key]; ok && isExpired(latestEntry) {
-				delete(sh.data, key)
+			unlock = db.lockShard(ctx, sh)
+			if latestEntry, ok := sh.data[key]; ok && db.isExpired(latestEntry) {
+				db.removeExpired(sh, key, latestEntry)
 			}
-			sh.mu.Unlock()
+			unlock()
 		}
 		db.logger.Warn("attempt to Get a non-existent or expired key", "key", key)
 		return nil, ErrKeyNotFound
@@ -224,14 +261,17 @@ func (db *DB) SetCAS(ctx context.Context, key string, oldValue, newValue interfa
 		return err
 	}
 
+	if err := db.checkLoggable("SetCAS", key, newValue); err != nil {
+		return err
+	}
+
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.Lock()
-	defer sh.mu.Unlock()
+	defer db.lockShard(ctx, sh)()
 
 	entry, exists := sh.data[key]
-	if !exists || isExpired(entry) {
+	if !exists || db.isExpired(entry) {
 		if exists {
-			delete(sh.data, key)
+			db.removeExpired(sh, key, entry)
 			db.logger.Info("auto-removed expired key in SetCAS", "key", key)
 		}
 		db.logger.Warn("key not found or expired in SetCAS", "key", key)
@@ -252,13 +292,14 @@ func (db *DB) SetCAS(ctx context.Context, key string, oldValue, newValue interfa
 		Type:       entry.Type,
 	}
 	sh.data[key] = newEntry
+	db.afterWrite(opSet, key, newValue, unixNano(expiration))
 
 	db.logger.Info("CAS update successful",
 		"key", key,
 		"old_value", oldValue,
 		"new_value", newValue,
 		"ttl", ttl)
-	db.pubsub.Publish(key, fmt.Sprintf("CAS: %v -> %v", oldValue, newValue))
+	db.notify(ctx, types.Event{Op: types.EventCAS, Key: key, Type: entry.Type, OldValue: oldValue, NewValue: newValue, TTL: ttlLeft(expiration)})
 
 	return nil
 }
@@ -277,14 +318,17 @@ func (db *DB) GetSet(ctx context.Context, key string, newValue interface{}, ttl
 		return nil, err
 	}
 
+	if err := db.checkLoggable("GetSet", key, newValue); err != nil {
+		return nil, err
+	}
+
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.Lock()
-	defer sh.mu.Unlock()
+	defer db.lockShard(ctx, sh)()
 
 	entry, exists := sh.data[key]
 
-	if exists && isExpired(entry) {
-		delete(sh.data, key)
+	if exists && db.isExpired(entry) {
+		db.removeExpired(sh, key, entry)
 		exists = false
 		db.logger.Info("GetSet removed expired key", "key", key)
 	}
@@ -303,8 +347,9 @@ func (db *DB) GetSet(ctx context.Context, key string, newValue interface{}, ttl
 	}
 
 	sh.data[key] = newEntry
+	db.afterWrite(opSet, key, newValue, unixNano(expiration))
 	db.logger.Info("GetSet operation successful", "key", key, "oldValue", oldValue, "newValue", newValue, "ttl", ttl)
-	db.pubsub.Publish(key, fmt.Sprintf("GETSET: %v -> %v", oldValue, newValue))
+	db.notify(ctx, types.Event{Op: types.EventGetSet, Key: key, Type: types.String, OldValue: oldValue, NewValue: newValue, TTL: ttlLeft(expiration)})
 
 	return oldValue, nil
 }
@@ -318,12 +363,13 @@ func (db *DB) Incr(ctx context.Context, key string) (int64, error) {
 	}
 
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.Lock()
-	defer sh.mu.Unlock()
+	defer db.lockShard(ctx, sh)()
 
 	entry, exists := sh.data[key]
-	if !exists || isExpired(entry) {
+	if !exists || db.isExpired(entry) {
 		sh.data[key] = types.Entry{Value: int64(1), Type: types.String}
+		db.afterWrite(opIncrBy, key, int64(1))
+		db.notify(ctx, types.Event{Op: types.EventIncrBy, Key: key, Type: types.String, NewValue: int64(1)})
 		db.logger.Info("Incr created new key with value=1", "key", key)
 		return 1, nil
 	}
@@ -337,6 +383,8 @@ func (db *DB) Incr(ctx context.Context, key string) (int64, error) {
 	val++
 	entry.Value = val
 	sh.data[key] = entry
+	db.afterWrite(opIncrBy, key, int64(1))
+	db.notify(ctx, types.Event{Op: types.EventIncrBy, Key: key, Type: types.String, NewValue: val, TTL: ttlLeft(entry.Expiration)})
 
 	db.logger.Info("Incr operation successful", "key", key, "newVal", val)
 	return val, nil
@@ -351,12 +399,13 @@ func (db *DB) Decr(ctx context.Context, key string) (int64, error) {
 	}
 
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.Lock()
-	defer sh.mu.Unlock()
+	defer db.lockShard(ctx, sh)()
 
 	entry, exists := sh.data[key]
-	if !exists || isExpired(entry) {
+	if !exists || db.isExpired(entry) {
 		sh.data[key] = types.Entry{Value: int64(-1), Type: types.String}
+		db.afterWrite(opIncrBy, key, int64(-1))
+		db.notify(ctx, types.Event{Op: types.EventIncrBy, Key: key, Type: types.String, NewValue: int64(-1)})
 		db.logger.Info("Decr created new key with value=-1", "key", key)
 		return -1, nil
 	}
@@ -370,6 +419,8 @@ func (db *DB) Decr(ctx context.Context, key string) (int64, error) {
 	val--
 	entry.Value = val
 	sh.data[key] = entry
+	db.afterWrite(opIncrBy, key, int64(-1))
+	db.notify(ctx, types.Event{Op: types.EventIncrBy, Key: key, Type: types.String, NewValue: val, TTL: ttlLeft(entry.Expiration)})
 
 	db.logger.Info("Decr operation successful", "key", key, "newVal", val)
 	return val, nil
@@ -384,13 +435,14 @@ func (db *DB) IncrBy(ctx context.Context, key string, increment int64) (int64, e
 	}
 
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.Lock()
-	defer sh.mu.Unlock()
+	defer db.lockShard(ctx, sh)()
 
 	entry, exists := sh.data[key]
-	if !exists || isExpired(entry) {
+	if !exists || db.isExpired(entry) {
 		newVal := increment
 		sh.data[key] = types.Entry{Value: newVal, Type: types.String}
+		db.afterWrite(opIncrBy, key, increment)
+		db.notify(ctx, types.Event{Op: types.EventIncrBy, Key: key, Type: types.String, NewValue: newVal})
 		db.logger.Info("IncrBy created key", "key", key, "value", newVal)
 		return newVal, nil
 	}
@@ -409,6 +461,8 @@ func (db *DB) IncrBy(ctx context.Context, key string, increment int64) (int64, e
 	current += increment
 	entry.Value = current
 	sh.data[key] = entry
+	db.afterWrite(opIncrBy, key, increment)
+	db.notify(ctx, types.Event{Op: types.EventIncrBy, Key: key, Type: types.String, NewValue: current, TTL: ttlLeft(entry.Expiration)})
 	db.logger.Info("IncrBy success", "key", key, "newValue", current)
 	return current, nil
 }
@@ -417,127 +471,89 @@ func (db *DB) DecrBy(ctx context.Context, key string, decrement int64) (int64, e
 	return db.IncrBy(ctx, key, -decrement)
 }
 
-func (db *DB) LPush(ctx context.Context, key string, values ...interface{}) error {
+func (db *DB) LPush(ctx context.Context, key string, values ...interface{}) (int, error) {
 	select {
 	case <-ctx.Done():
 		db.logger.Warn("LPush operation canceled", "key", key)
-		return ErrContextCanceled
+		return 0, ErrContextCanceled
 	default:
 	}
 
 	if key == "" {
 		db.logger.Error("LPush failed: empty key")
-		return ErrInvalidKey
+		return 0, ErrInvalidKey
 	}
 
 	if len(values) == 0 {
 		db.logger.Warn("LPush called with no values", "key", key)
-		return ErrEmptyValues
-	}
-
-	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.Lock()
-	defer sh.mu.Unlock()
-
-	entry, exists := sh.data[key]
-
-	if exists && isExpired(entry) {
-		delete(sh.data, key)
-		exists = false
-		db.logger.Info("LPush removed expired key before pushing", "key", key)
+		return 0, ErrEmptyValues
 	}
 
-	reversed := make([]interface{}, len(values))
-	for i, j := 0, len(values)-1; i < len(values); i, j = i+1, j-1 {
-		reversed[i] = values[j]
+	if err := db.checkLoggable("LPush", key, values...); err != nil {
+		return 0, err
 	}
 
-	if exists {
-		if entry.Type != types.List {
-			db.logger.Error("LPush failed: existing key is not a list", "key", key)
-			return ErrInvalidType
-		}
-
-		list, ok := entry.Value.([]interface{})
-		if !ok {
-			db.logger.Error("LPush failed: stored value is not []interface{}", "key", key)
-			return ErrInvalidType
-		}
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.lockShard(ctx, sh)()
 
-		newList := make([]interface{}, 0, len(reversed)+len(list))
-		newList = append(newList, reversed...)
-		newList = append(newList, list...)
-		entry.Value = newList
-	} else {
-		entry = types.Entry{
-			Value:      reversed,
-			Type:       types.List,
-			Expiration: time.Time{},
-		}
+	list, entry, err := db.listForWrite(sh, key, "LPush", true)
+	if err != nil {
+		return 0, err
+	}
+	for _, v := range values {
+		list.PushFront(v)
 	}
+	length := list.Len()
 
 	sh.data[key] = entry
-	db.pubsub.Publish(key, fmt.Sprintf("LPush: %v", values))
+	db.afterWrite(opLPush, append([]interface{}{key}, values...)...)
+	db.notify(ctx, types.Event{Op: types.EventLPush, Key: key, Type: types.List, NewValue: values, TTL: ttlLeft(entry.Expiration)})
 	db.logger.Info("LPush operation successful",
 		"key", key,
 		"values", values,
-		"count", len(values))
-	return nil
+		"count", len(values),
+		"length", length)
+	return length, nil
 }
 
-func (db *DB) RPush(ctx context.Context, key string, values ...interface{}) error {
+func (db *DB) RPush(ctx context.Context, key string, values ...interface{}) (int, error) {
 	select {
 	case <-ctx.Done():
 		db.logger.Warn("RPush operation canceled", "key", key)
-		return ErrContextCanceled
+		return 0, ErrContextCanceled
 	default:
 	}
 
 	if len(values) == 0 {
 		db.logger.Warn("RPush called with no values", "key", key)
-		return ErrEmptyValues
+		return 0, ErrEmptyValues
 	}
 
-	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.Lock()
-	defer sh.mu.Unlock()
-
-	entry, exists := sh.data[key]
-
-	if exists && isExpired(entry) {
-		delete(sh.data, key)
-		exists = false
-		db.logger.Info("RPush removed expired key before pushing", "key", key)
+	if err := db.checkLoggable("RPush", key, values...); err != nil {
+		return 0, err
 	}
 
-	if exists {
-		if entry.Type != types.List {
-			db.logger.Error("RPush failed: existing key is not a list", "key", key)
-			return ErrInvalidType
-		}
-
-		list, ok := entry.Value.([]interface{})
-		if !ok {
-			db.logger.Error("RPush failed: stored value is not []interface{}", "key", key)
-			return ErrInvalidType
-		}
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.lockShard(ctx, sh)()
 
-		entry.Value = append(list, values...)
-	} else {
-		entry = types.Entry{
-			Value:      values,
-			Type:       types.List,
-			Expiration: time.Time{},
-		}
+	list, entry, err := db.listForWrite(sh, key, "RPush", true)
+	if err != nil {
+		return 0, err
 	}
+	for _, v := range values {
+		list.PushBack(v)
+	}
+	length := list.Len()
 
 	sh.data[key] = entry
-	db.pubsub.Publish(key, fmt.Sprintf("RPush: %v", values))
+	db.afterWrite(opRPush, append([]interface{}{key}, values...)...)
+	db.notify(ctx, types.Event{Op: types.EventRPush, Key: key, Type: types.List, NewValue: values, TTL: ttlLeft(entry.Expiration)})
 	db.logger.Info("RPush operation successful",
 		"key", key,
 		"values", values,
-		"count", len(values))
-	return nil
+		"count", len(values),
+		"length", length)
+	return length, nil
 }
 
 func (db *DB) LPop(ctx context.Context, key string) (interface{}, error) {
@@ -549,36 +565,28 @@ func (db *DB) LPop(ctx context.Context, key string) (interface{}, error) {
 	}
 
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.Lock()
-	defer sh.mu.Unlock()
-
-	entry, exists := sh.data[key]
-	if !exists || isExpired(entry) {
-		db.logger.Warn("LPop failed: key not found or expired", "key", key)
-		return nil, ErrKeyNotFound
-	}
+	defer db.lockShard(ctx, sh)()
 
-	if entry.Type != types.List {
-		db.logger.Error("LPop failed: existing key is not a list", "key", key)
-		return nil, ErrInvalidType
+	list, entry, err := db.listForWrite(sh, key, "LPop", false)
+	if err != nil {
+		return nil, err
 	}
 
-	list, ok := entry.Value.([]interface{})
-	if !ok || len(list) == 0 {
-		db.logger.Warn("LPop failed: list empty or invalid type", "key", key)
+	val, ok := list.PopFront()
+	if !ok {
+		db.logger.Warn("LPop failed: list empty", "key", key)
 		return nil, ErrEmptyList
 	}
-
-	val := list[0]
-	list = list[1:]
-	if len(list) == 0 {
+	if list.Len() == 0 {
 		delete(sh.data, key)
 		db.logger.Info("LPop removed the key as the list is now empty", "key", key)
-	} else {
-		entry.Value = list
-		sh.data[key] = entry
 	}
 
+	db.afterWrite(opLPop, key)
+	db.notify(ctx, types.Event{Op: types.EventLPop, Key: key, Type: types.List, OldValue: val, TTL: ttlLeft(entry.Expiration)})
+	if list.Len() == 0 {
+		db.notify(ctx, types.Event{Op: types.EventDel, Key: key, Type: types.List})
+	}
 	db.logger.Info("LPop operation successful", "key", key, "poppedValue", val)
 	return val, nil
 }
@@ -592,36 +600,28 @@ func (db *DB) RPop(ctx context.Context, key string) (interface{}, error) {
 	}
 
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.Lock()
-	defer sh.mu.Unlock()
-
-	entry, exists := sh.data[key]
-	if !exists || isExpired(entry) {
-		db.logger.Warn("RPop failed: key not found or expired", "key", key)
-		return nil, ErrKeyNotFound
-	}
+	defer db.lockShard(ctx, sh)()
 
-	if entry.Type != types.List {
-		db.logger.Error("RPop failed: existing key is not a list", "key", key)
-		return nil, ErrInvalidType
+	list, entry, err := db.listForWrite(sh, key, "RPop", false)
+	if err != nil {
+		return nil, err
 	}
 
-	list, ok := entry.Value.([]interface{})
-	if !ok || len(list) == 0 {
-		db.logger.Warn("RPop failed: list empty or invalid type", "key", key)
+	val, ok := list.PopBack()
+	if !ok {
+		db.logger.Warn("RPop failed: list empty", "key", key)
 		return nil, ErrEmptyList
 	}
-
-	val := list[len(list)-1]
-	list = list[:len(list)-1]
-	if len(list) == 0 {
+	if list.Len() == 0 {
 		delete(sh.data, key)
 		db.logger.Info("RPop removed the key as the list is now empty", "key", key)
-	} else {
-		entry.Value = list
-		sh.data[key] = entry
 	}
 
+	db.afterWrite(opRPop, key)
+	db.notify(ctx, types.Event{Op: types.EventRPop, Key: key, Type: types.List, OldValue: val, TTL: ttlLeft(entry.Expiration)})
+	if list.Len() == 0 {
+		db.notify(ctx, types.Event{Op: types.EventDel, Key: key, Type: types.List})
+	}
 	db.logger.Info("RPop operation successful", "key", key, "poppedValue", val)
 	return val, nil
 }
@@ -635,62 +635,22 @@ func (db *DB) LLen(ctx context.Context, key string) (int, error) {
 	}
 
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.RLock()
-	defer sh.mu.RUnlock()
-
-	entry, exists := sh.data[key]
-	if !exists || isExpired(entry) {
-		db.logger.Warn("LLen failed: key not found or expired", "key", key)
-		return 0, ErrKeyNotFound
-	}
+	defer db.rlockShard(ctx, sh)()
 
-	if entry.Type != types.List {
-		db.logger.Error("LLen failed: key is not a list", "key", key)
-		return 0, ErrInvalidType
-	}
-
-	list, ok := entry.Value.([]interface{})
-	if !ok {
-		db.logger.Error("LLen failed: value is not a valid list", "key", key)
-		return 0, ErrInvalidType
+	list, err := db.listForRead(sh, key, "LLen")
+	if err != nil {
+		return 0, err
 	}
 
-	length := len(list)
+	length := list.Len()
 	db.logger.Info("LLen operation successful", "key", key, "length", length)
 	return length, nil
 }
 
-func (db *DB) LRange(ctx context.Context, key string, start, end int) ([]interface{}, error) {
-	select {
-	case <-ctx.Done():
-		db.logger.Warn("LRange operation canceled", "key", key)
-		return nil, ErrContextCanceled
-	default:
-	}
-
-	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.RLock()
-	defer sh.mu.RUnlock()
-
-	entry, exists := sh.data[key]
-	if !exists || isExpired(entry) {
-		db.logger.Warn("LRange failed: key not found or expired", "key", key)
-		return nil, ErrKeyNotFound
-	}
-
-	if entry.Type != types.List {
-		db.logger.Error("LRange failed: existing key is not a list", "key", key)
-		return nil, ErrInvalidType
-	}
-
-	list, ok := entry.Value.([]interface{})
-	if !ok {
-		db.logger.Error("LRange failed: value is not a valid list", "key", key)
-		return nil, ErrInvalidType
-	}
-
-	length := len(list)
-
+// clampListRange resolves the start and end indexes of LRANGE and LTRIM, which
+// may count from the end when negative, against a list of the given length.
+// ok is false when the range is empty.
+func clampListRange(start, end, length int) (int, int, bool) {
 	if start < 0 {
 		start = length + start
 	}
@@ -704,12 +664,31 @@ func (db *DB) LRange(ctx context.Context, key string, start, end int) ([]interfa
 	if end >= length {
 		end = length - 1
 	}
+	return start, end, start <= end && start < length
+}
+
+func (db *DB) LRange(ctx context.Context, key string, start, end int) ([]interface{}, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("LRange operation canceled", "key", key)
+		return nil, ErrContextCanceled
+	default:
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.rlockShard(ctx, sh)()
 
-	if start > end || start >= length {
+	list, err := db.listForRead(sh, key, "LRange")
+	if err != nil {
+		return nil, err
+	}
+
+	start, end, ok := clampListRange(start, end, list.Len())
+	if !ok {
 		return []interface{}{}, nil
 	}
 
-	result := list[start : end+1]
+	result := list.Range(start, end)
 
 	db.logger.Info("LRange operation successful", "key", key, "start", start, "end", end, "resultLength", len(result))
 	return result, nil
@@ -724,65 +703,39 @@ func (db *DB) LTrim(ctx context.Context, key string, start, stop int) error {
 	}
 
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.Lock()
-	defer sh.mu.Unlock()
+	defer db.lockShard(ctx, sh)()
 
-	entry, exists := sh.data[key]
-	if !exists || isExpired(entry) {
-		db.logger.Warn("LTrim failed: key not found or expired", "key", key)
-		return ErrKeyNotFound
-	}
-	if entry.Type != types.List {
-		db.logger.Error("LTrim failed: existing key is not a list", "key", key)
-		return ErrInvalidType
+	list, entry, err := db.listForWrite(sh, key, "LTrim", false)
+	if err != nil {
+		return err
 	}
 
-	list, ok := entry.Value.([]interface{})
+	length := list.Len()
+	start, stop, ok := clampListRange(start, stop, length)
 	if !ok {
-		db.logger.Error("LTrim failed: stored value is not a valid list", "key", key)
-		return ErrInvalidType
-	}
-
-	length := len(list)
-
-	if start < 0 {
-		start = length + start
-	}
-	if stop < 0 {
-		stop = length + stop
-	}
-
-	if start < 0 {
-		start = 0
-	}
-	if stop >= length {
-		stop = length - 1
-	}
-
-	if start > stop || start >= length {
 		delete(sh.data, key)
+		db.afterWrite(opLTrim, key, int64(start), int64(stop))
+		db.notify(ctx, types.Event{Op: types.EventLTrim, Key: key, Type: types.List})
+		db.notify(ctx, types.Event{Op: types.EventDel, Key: key, Type: types.List})
 		db.logger.Info("LTrim removed the key because range is empty", "key", key)
 		return nil
 	}
 
-	newList := list[start : stop+1]
-	if len(newList) == 0 {
-		delete(sh.data, key)
-		db.logger.Info("LTrim removed the key because trimmed list is empty", "key", key)
-		return nil
-	}
-
-	entry.Value = newList
-	sh.data[key] = entry
+	list.Trim(start, stop)
+	db.afterWrite(opLTrim, key, int64(start), int64(stop))
+	db.notify(ctx, types.Event{Op: types.EventLTrim, Key: key, Type: types.List, TTL: ttlLeft(entry.Expiration)})
 	db.logger.Info("LTrim operation successful",
 		"key", key,
 		"originalLength", length,
-		"newLength", len(newList),
+		"newLength", list.Len(),
 		"start", start,
 		"stop", stop)
 	return nil
 }
 
+// HSet sets field of the hash at key, creating the hash if needed. The field
+// loses any expiration set with HExpire. A non-zero ttl, in seconds, sets the
+// expiration of the whole key, not of the field; zero leaves it unchanged.
 func (db *DB) HSet(ctx context.Context, key string, field string, value interface{}, ttl int) error {
 	select {
 	case <-ctx.Done():
@@ -797,41 +750,28 @@ func (db *DB) HSet(ctx context.Context, key string, field string, value interfac
 		return err
 	}
 
+	if err := db.checkLoggable("HSet", key, value); err != nil {
+		return err
+	}
+
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.Lock()
-	defer sh.mu.Unlock()
+	defer db.lockShard(ctx, sh)()
 
-	entry, exists := sh.data[key]
-	if exists && isExpired(entry) {
-		delete(sh.data, key)
-		exists = false
-		db.logger.Info("HSet removed expired key before setting hash field", "key", key)
+	hash, entry, err := db.hashForWrite(sh, key, "HSet", true)
+	if err != nil {
+		return err
 	}
-
-	if !exists {
-		entry = types.Entry{
-			Type:       types.Hash,
-			Value:      make(map[string]interface{}),
-			Expiration: expiration,
-		}
-	} else {
-		if entry.Type != types.Hash {
-			db.logger.Error("HSet failed: existing key is not a hash", "key", key)
-			return ErrInvalidType
-		}
-		if ttl == 0 {
-			expiration = entry.Expiration
-		}
+	if ttl != 0 {
+		entry.Expiration = expiration
 	}
 
-	hash := entry.Value.(map[string]interface{})
+	oldValue := hash[field]
 	hash[field] = value
+	entry.ClearFieldExpiration(field)
 
-	sh.data[key] = types.Entry{
-		Value:      hash,
-		Type:       types.Hash,
-		Expiration: expiration,
-	}
+	sh.data[key] = entry
+	db.afterWrite(opHSet, key, field, value, unixNano(entry.Expiration))
+	db.notify(ctx, types.Event{Op: types.EventHSet, Key: key, Field: field, Type: types.Hash, OldValue: oldValue, NewValue: value, TTL: ttlLeft(entry.Expiration)})
 
 	db.logger.Info("HSet operation successful", "key", key, "field", field, "value", value, "ttl", ttl)
 	return nil
@@ -846,21 +786,13 @@ func (db *DB) HGet(ctx context.Context, key string, field string) (interface{},
 	}
 
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.RLock()
-	defer sh.mu.RUnlock()
+	defer db.rlockShard(ctx, sh)()
 
-	entry, exists := sh.data[key]
-	if !exists || isExpired(entry) {
-		db.logger.Warn("HGet failed: key not found or expired", "key", key)
-		return nil, ErrKeyNotFound
-	}
-
-	if entry.Type != types.Hash {
-		db.logger.Error("HGet failed: existing key is not a hash", "key", key)
-		return nil, ErrInvalidType
+	hash, err := db.hashForRead(sh, key, "HGet")
+	if err != nil {
+		return nil, err
 	}
 
-	hash := entry.Value.(map[string]interface{})
 	val, found := hash[field]
 	if !found {
 		db.logger.Warn("HGet failed: field not found in hash", "key", key, "field", field)
@@ -880,30 +812,28 @@ func (db *DB) HDel(ctx context.Context, key string, field string) error {
 	}
 
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.Lock()
-	defer sh.mu.Unlock()
+	defer db.lockShard(ctx, sh)()
 
-	entry, exists := sh.data[key]
-	if !exists || isExpired(entry) {
-		db.logger.Warn("HDel failed: key not found or expired", "key", key)
-		return ErrKeyNotFound
-	}
-
-	if entry.Type != types.Hash {
-		db.logger.Error("HDel failed: existing key is not a hash", "key", key)
-		return ErrInvalidType
+	hash, entry, err := db.hashForWrite(sh, key, "HDel", false)
+	if err != nil {
+		return err
 	}
 
-	hash := entry.Value.(map[string]interface{})
+	oldValue := hash[field]
 	delete(hash, field)
+	entry.ClearFieldExpiration(field)
 
 	if len(hash) == 0 {
 		delete(sh.data, key)
 		db.logger.Info("HDel removed the entire hash because it became empty", "key", key)
 	} else {
-		entry.Value = hash
 		sh.data[key] = entry
 	}
+	db.afterWrite(opHDel, key, field)
+	db.notify(ctx, types.Event{Op: types.EventHDel, Key: key, Field: field, Type: types.Hash, OldValue: oldValue, TTL: ttlLeft(entry.Expiration)})
+	if len(hash) == 0 {
+		db.notify(ctx, types.Event{Op: types.EventDel, Key: key, Type: types.Hash})
+	}
 	db.logger.Info("HDel operation successful", "key", key, "field", field)
 	return nil
 }
@@ -917,21 +847,13 @@ func (db *DB) HGetAll(ctx context.Context, key string) (map[string]interface{},
 	}
 
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.RLock()
-	defer sh.mu.RUnlock()
+	defer db.rlockShard(ctx, sh)()
 
-	entry, exists := sh.data[key]
-	if !exists || isExpired(entry) {
-		db.logger.Warn("HGetAll failed: key not found or expired", "key", key)
-		return nil, ErrKeyNotFound
-	}
-
-	if entry.Type != types.Hash {
-		db.logger.Error("HGetAll failed: existing key is not a hash", "key", key)
-		return nil, ErrInvalidType
+	hash, err := db.hashForRead(sh, key, "HGetAll")
+	if err != nil {
+		return nil, err
 	}
 
-	hash := entry.Value.(map[string]interface{})
 	result := make(map[string]interface{}, len(hash))
 	for k, v := range hash {
 		result[k] = v
@@ -949,24 +871,11 @@ func (db *DB) HExists(ctx context.Context, key string, field string) (bool, erro
 	}
 
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.RLock()
-	defer sh.mu.RUnlock()
-
-	entry, exists := sh.data[key]
-	if !exists || isExpired(entry) {
-		db.logger.Warn("HExists failed: key not found or expired", "key", key)
-		return false, ErrKeyNotFound
-	}
-
-	if entry.Type != types.Hash {
-		db.logger.Error("HExists failed: key is not a hash", "key", key)
-		return false, ErrInvalidType
-	}
+	defer db.rlockShard(ctx, sh)()
 
-	hash, ok := entry.Value.(map[string]interface{})
-	if !ok {
-		db.logger.Error("HExists failed: value is not a valid hash", "key", key)
-		return false, ErrInvalidType
+	hash, err := db.hashForRead(sh, key, "HExists")
+	if err != nil {
+		return false, err
 	}
 
 	_, found := hash[field]
@@ -983,24 +892,11 @@ func (db *DB) HLen(ctx context.Context, key string) (int, error) {
 	}
 
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.RLock()
-	defer sh.mu.RUnlock()
+	defer db.rlockShard(ctx, sh)()
 
-	entry, exists := sh.data[key]
-	if !exists || isExpired(entry) {
-		db.logger.Warn("HLen failed: key not found or expired", "key", key)
-		return 0, ErrKeyNotFound
-	}
-
-	if entry.Type != types.Hash {
-		db.logger.Error("HLen failed: key is not a hash", "key", key)
-		return 0, ErrInvalidType
-	}
-
-	hash, ok := entry.Value.(map[string]interface{})
-	if !ok {
-		db.logger.Error("HLen failed: value is not a valid hash", "key", key)
-		return 0, ErrInvalidType
+	hash, err := db.hashForRead(sh, key, "HLen")
+	if err != nil {
+		return 0, err
 	}
 
 	length := len(hash)
@@ -1025,14 +921,17 @@ func (db *DB) SAdd(ctx context.Context, key string, members ...interface{}) erro
 		return ErrEmptyValues
 	}
 
+	if err := db.checkLoggable("SAdd", key, members...); err != nil {
+		return err
+	}
+
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.Lock()
-	defer sh.mu.Unlock()
+	defer db.lockShard(ctx, sh)()
 
 	entry, exists := sh.data[key]
 
-	if exists && isExpired(entry) {
-		delete(sh.data, key)
+	if exists && db.isExpired(entry) {
+		db.removeExpired(sh, key, entry)
 		exists = false
 		db.logger.Info("SAdd removed expired key before adding members", "key", key)
 	}
@@ -1047,6 +946,8 @@ func (db *DB) SAdd(ctx context.Context, key string, members ...interface{}) erro
 			Type:       types.Set,
 			Expiration: time.Time{},
 		}
+		db.afterWrite(opSAdd, append([]interface{}{key}, members...)...)
+		db.notify(ctx, types.Event{Op: types.EventSAdd, Key: key, Type: types.Set, NewValue: members})
 		db.logger.Info("SAdd created new set", "key", key, "members", members)
 		return nil
 	}
@@ -1066,6 +967,8 @@ func (db *DB) SAdd(ctx context.Context, key string, members ...interface{}) erro
 		setVal[m] = struct{}{}
 	}
 	sh.data[key] = entry
+	db.afterWrite(opSAdd, append([]interface{}{key}, members...)...)
+	db.notify(ctx, types.Event{Op: types.EventSAdd, Key: key, Type: types.Set, NewValue: members, TTL: ttlLeft(entry.Expiration)})
 
 	db.logger.Info("SAdd operation successful",
 		"key", key,
@@ -1086,12 +989,15 @@ func (db *DB) SRem(ctx context.Context, key string, members ...interface{}) erro
 		return ErrEmptyValues
 	}
 
+	if err := db.checkLoggable("SRem", key, members...); err != nil {
+		return err
+	}
+
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.Lock()
-	defer sh.mu.Unlock()
+	defer db.lockShard(ctx, sh)()
 
 	entry, exists := sh.data[key]
-	if !exists || isExpired(entry) {
+	if !exists || db.isExpired(entry) {
 		db.logger.Warn("SRem failed: key not found or expired", "key", key)
 		return ErrKeyNotFound
 	}
@@ -1110,9 +1016,12 @@ func (db *DB) SRem(ctx context.Context, key string, members ...interface{}) erro
 	for _, m := range members {
 		delete(setVal, m)
 	}
+	db.afterWrite(opSRem, append([]interface{}{key}, members...)...)
+	db.notify(ctx, types.Event{Op: types.EventSRem, Key: key, Type: types.Set, OldValue: members, TTL: ttlLeft(entry.Expiration)})
 
 	if len(setVal) == 0 {
 		delete(sh.data, key)
+		db.notify(ctx, types.Event{Op: types.EventDel, Key: key, Type: types.Set})
 		db.logger.Info("SRem removed key because set is empty", "key", key)
 		return nil
 	}
@@ -1133,11 +1042,10 @@ func (db *DB) SMembers(ctx context.Context, key string) ([]interface{}, error) {
 	}
 
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.RLock()
-	defer sh.mu.RUnlock()
+	defer db.rlockShard(ctx, sh)()
 
 	entry, exists := sh.data[key]
-	if !exists || isExpired(entry) {
+	if !exists || db.isExpired(entry) {
 		db.logger.Warn("SMembers failed: key not found or expired", "key", key)
 		return nil, ErrKeyNotFound
 	}
@@ -1172,11 +1080,10 @@ func (db *DB) SIsMember(ctx context.Context, key string, member interface{}) (bo
 	}
 
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.RLock()
-	defer sh.mu.RUnlock()
+	defer db.rlockShard(ctx, sh)()
 
 	entry, exists := sh.data[key]
-	if !exists || isExpired(entry) {
+	if !exists || db.isExpired(entry) {
 		db.logger.Warn("SIsMember failed: key not found or expired", "key", key)
 		return false, ErrKeyNotFound
 	}
@@ -1209,11 +1116,10 @@ func (db *DB) SCard(ctx context.Context, key string) (int, error) {
 	}
 
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.RLock()
-	defer sh.mu.RUnlock()
+	defer db.rlockShard(ctx, sh)()
 
 	entry, exists := sh.data[key]
-	if !exists || isExpired(entry) {
+	if !exists || db.isExpired(entry) {
 		db.logger.Warn("SCard failed: key not found or expired", "key", key)
 		return 0, ErrKeyNotFound
 	}
@@ -1245,11 +1151,10 @@ func (db *DB) Exists(ctx context.Context, key string) (bool, error) {
 	}
 
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.RLock()
-	defer sh.mu.RUnlock()
+	defer db.rlockShard(ctx, sh)()
 
 	entry, exists := sh.data[key]
-	if !exists || isExpired(entry) {
+	if !exists || db.isExpired(entry) {
 		db.logger.Info("Exists check: key not found or expired", "key", key)
 		return false, nil
 	}
@@ -1273,16 +1178,17 @@ func (db *DB) Expire(ctx context.Context, key string, ttl int) (bool, error) {
 	}
 
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.Lock()
-	defer sh.mu.Unlock()
+	defer db.lockShard(ctx, sh)()
 
 	entry, exists := sh.data[key]
-	if !exists || isExpired(entry) {
+	if !exists || db.isExpired(entry) {
 		return false, ErrKeyNotFound
 	}
 
 	entry.Expiration = expiration
 	sh.data[key] = entry
+	db.afterWrite(opExpireAt, key, unixNano(expiration))
+	db.notify(ctx, types.Event{Op: types.EventExpire, Key: key, Type: entry.Type, TTL: ttlLeft(expiration)})
 	db.logger.Info("Expire set", "key", key, "ttl", ttl)
 	return true, nil
 }
@@ -1296,11 +1202,10 @@ func (db *DB) Persist(ctx context.Context, key string) (bool, error) {
 	}
 
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.Lock()
-	defer sh.mu.Unlock()
+	defer db.lockShard(ctx, sh)()
 
 	entry, exists := sh.data[key]
-	if !exists || isExpired(entry) {
+	if !exists || db.isExpired(entry) {
 		return false, ErrKeyNotFound
 	}
 
@@ -1310,6 +1215,8 @@ func (db *DB) Persist(ctx context.Context, key string) (bool, error) {
 
 	entry.Expiration = time.Time{}
 	sh.data[key] = entry
+	db.afterWrite(opExpireAt, key, int64(0))
+	db.notify(ctx, types.Event{Op: types.EventPersist, Key: key, Type: entry.Type})
 	db.logger.Info("Persist successful", "key", key)
 	return true, nil
 }
@@ -1323,11 +1230,10 @@ func (db *DB) Type(ctx context.Context, key string) (interface{}, error) {
 	}
 
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.RLock()
-	defer sh.mu.RUnlock()
+	defer db.rlockShard(ctx, sh)()
 
 	entry, exists := sh.data[key]
-	if !exists || isExpired(entry) {
+	if !exists || db.isExpired(entry) {
 		db.logger.Warn("Type check failed: key not found or expired", "key", key)
 		return -1, ErrKeyNotFound
 	}
@@ -1345,11 +1251,10 @@ func (db *DB) GetWithDetails(ctx context.Context, key string) (interface{}, int,
 	}
 
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.RLock()
-	defer sh.mu.RUnlock()
+	defer db.rlockShard(ctx, sh)()
 
 	entry, exists := sh.data[key]
-	if !exists || isExpired(entry) {
+	if !exists || db.isExpired(entry) {
 		db.logger.Warn("GetWithDetails failed: key not found or expired", "key", key)
 		return nil, 0, ErrKeyNotFound
 	}
@@ -1382,22 +1287,20 @@ func (db *DB) Rename(ctx context.Context, oldKey, newKey string) error {
 		return nil
 	}
 
-	oldShard := db.shards[db.getShardIndex(oldKey)]
-	newShard := db.shards[db.getShardIndex(newKey)]
+	oldIndex, newIndex := db.getShardIndex(oldKey), db.getShardIndex(newKey)
+	oldShard, newShard := db.shards[oldIndex], db.shards[newIndex]
 
-	oldShard.mu.Lock()
-	defer oldShard.mu.Unlock()
+	// Both shards are locked in index order, the same order Transaction.Commit
+	// uses, so concurrent renames and commits cannot deadlock.
+	defer db.lockShards(ctx, []int{oldIndex, newIndex})()
 
 	entry, exists := oldShard.data[oldKey]
-	if !exists || isExpired(entry) {
+	if !exists || db.isExpired(entry) {
 		db.logger.Warn("Rename failed: oldKey not found or expired", "oldKey", oldKey)
 		return ErrKeyNotFound
 	}
 
 	if oldShard != newShard {
-		newShard.mu.Lock()
-		defer newShard.mu.Unlock()
-
 		if _, conflict := newShard.data[newKey]; conflict {
 			db.logger.Warn("Rename failed: newKey already exists", "newKey", newKey)
 			return ErrKeyExists
@@ -1414,10 +1317,11 @@ func (db *DB) Rename(ctx context.Context, oldKey, newKey string) error {
 		oldShard.data[newKey] = entry
 		delete(oldShard.data, oldKey)
 	}
+	db.afterWrite(opRename, oldKey, newKey)
 
 	db.logger.Info("Rename operation successful", "oldKey", oldKey, "newKey", newKey)
-	db.pubsub.Publish(oldKey, "RENAMED")
-	db.pubsub.Publish(newKey, "CREATED (via rename)")
+	db.notify(ctx, types.Event{Op: types.EventRenameFrom, Key: oldKey, Type: entry.Type, NewValue: newKey, TTL: ttlLeft(entry.Expiration)})
+	db.notify(ctx, types.Event{Op: types.EventRenameTo, Key: newKey, Type: entry.Type, OldValue: oldKey, TTL: ttlLeft(entry.Expiration)})
 
 	return nil
 }
@@ -1433,13 +1337,13 @@ func (db *DB) FindByValue(ctx context.Context, value interface{}) ([]string, err
 	var keys []string
 
 	for _, sh := range db.shards {
-		sh.mu.RLock()
+		unlock := db.rlockShard(ctx, sh)
 		for k, entry := range sh.data {
-			if !isExpired(entry) && entry.Value == value {
+			if !db.isExpired(entry) && entry.Value == value {
 				keys = append(keys, k)
 			}
 		}
-		sh.mu.RUnlock()
+		unlock()
 	}
 
 	if len(keys) == 0 {
@@ -1459,17 +1363,17 @@ func (db *DB) Delete(ctx context.Context, key string) error {
 	}
 
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.Lock()
-	defer sh.mu.Unlock()
+	defer db.lockShard(ctx, sh)()
 
-	_, exists := sh.data[key]
+	entry, exists := sh.data[key]
 	if !exists {
 		db.logger.Warn("attempt to Delete a non-existent key", "key", key)
 		return ErrKeyNotFound
 	}
 
 	delete(sh.data, key)
-	db.pubsub.Publish(key, "DELETE")
+	db.afterWrite(opDel, key)
+	db.notify(ctx, types.Event{Op: types.EventDel, Key: key, Type: entry.Type, OldValue: entry.Value})
 	db.logger.Info("Delete operation successful", "key", key)
 	return nil
 }
@@ -1482,14 +1386,15 @@ func (db *DB) DropAll(ctx context.Context) error {
 	default:
 	}
 
+	unlock := db.lockShards(ctx, db.allShardIndexes())
 	for _, sh := range db.shards {
-		sh.mu.Lock()
-		for key := range sh.data {
-			db.pubsub.Publish(key, "FLUSH_ALL")
+		for key, entry := range sh.data {
+			db.notify(ctx, types.Event{Op: types.EventFlushAll, Key: key, Type: entry.Type})
 			delete(sh.data, key)
 		}
-		sh.mu.Unlock()
 	}
+	db.afterWrite(opDropAll)
+	unlock()
 	db.logger.Info("DropAll operation completed: all keys removed")
 	return nil
 }
@@ -1503,14 +1408,19 @@ func (db *DB) GetRawEntry(ctx context.Context, key string) (types.Entry, error)
 	}
 
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.RLock()
-	defer sh.mu.RUnlock()
+	defer db.rlockShard(ctx, sh)()
 
 	entry, exists := sh.data[key]
-	if !exists || isExpired(entry) {
+	if !exists || db.isExpired(entry) {
 		return types.Entry{}, ErrKeyNotFound
 	}
-	return entry, nil
+	// Lists are handed out as plain slices, the form they had before being
+	// stored as deques, so callers asserting []interface{} keep working.
+	if list, ok := entry.Value.(*types.Deque); ok {
+		entry.Value = list.Values()
+		return entry, nil
+	}
+	return entry.Clone(), nil
 }
 
 func (db *DB) RestoreRawEntry(ctx context.Context, key string, e types.Entry) error {
@@ -1521,11 +1431,17 @@ func (db *DB) RestoreRawEntry(ctx context.Context, key string, e types.Entry) er
 	default:
 	}
 
+	if err := db.checkLoggable("RestoreRawEntry", key, e); err != nil {
+		return err
+	}
+
 	sh := db.shards[db.getShardIndex(key)]
-	sh.mu.Lock()
-	defer sh.mu.Unlock()
+	defer db.lockShard(ctx, sh)()
 
+	e = storedList(e.Clone())
 	sh.data[key] = e
+	db.afterWrite(opRestore, key, e)
+	db.notify(ctx, types.Event{Op: types.EventRestore, Key: key, Type: e.Type, NewValue: e.Value, TTL: ttlLeft(e.Expiration)})
 	return nil
 }
 
@@ -1574,10 +1490,9 @@ func (db *DB) processShard(sh *shard, basePct, aggression float64, cooldownThr f
 		return 0, 0
 	}
 
-	sh.mu.Lock()
-	defer sh.mu.Unlock()
+	defer db.lockShard(context.Background(), sh)()
 
-	var expiredKeys []string
+	var expiredKeys, expiringHashes []string
 	checked := 0
 	aggressiveMode := false
 
@@ -1587,24 +1502,30 @@ func (db *DB) processShard(sh *shard, basePct, aggression float64, cooldownThr f
 		}
 
 		checked++
-		if isExpired(entry) {
+		if db.isExpired(entry) {
 			expiredKeys = append(expiredKeys, key)
 
 			if len(expiredKeys) > int(float64(checked)*cooldownThr) {
 				checkLimit = int(float64(checkLimit) * aggression)
 				aggressiveMode = true
 			}
+		} else if db.fieldsMayHaveExpired(entry) {
+			expiringHashes = append(expiringHashes, key)
 		}
 	}
 
 	deleted := 0
 	for _, key := range expiredKeys {
-		if entry, exists := sh.data[key]; exists && isExpired(entry) {
-			delete(sh.data, key)
-			db.pubsub.Publish(key, "EXPIRED")
+		if entry, exists := sh.data[key]; exists && db.isExpired(entry) {
+			db.removeExpired(sh, key, entry)
 			deleted++
 		}
 	}
+	for _, key := range expiringHashes {
+		if entry, exists := sh.data[key]; exists {
+			db.removeExpiredFields(sh, key, entry)
+		}
+	}
 
 	if deleted > 0 {
 		efficiency := safeDivide(deleted, checked)
@@ -1636,9 +1557,20 @@ func safeDivide(a, b int) float64 {
 	return float64(a) / float64(b)
 }
 
-func (db *DB) Subscribe(key string) chan string {
+// Subscribe delivers the events of key as strings. opts sets the slow
+// consumer policy; by default messages are dropped while the channel is full.
+func (db *DB) Subscribe(key string, opts ...types.SubscribeOptions) chan string {
 	db.logger.Debug("New subscription", "key", key)
-	return db.pubsub.Subscribe(key)
+	return db.pubsub.Subscribe(key, opts...)
+}
+
+// SubscribeContext is Subscribe for a subscription that lasts as long as ctx:
+// once ctx is done it is removed and the channel closed, so the caller never
+// has to unsubscribe. opts can set a buffer size smaller than
+// Config.PubSubBufferSize and a first message to queue.
+func (db *DB) SubscribeContext(ctx context.Context, key string, opts ...types.SubscribeOptions) <-chan string {
+	db.logger.Debug("New subscription", "key", key)
+	return db.pubsub.SubscribeContext(ctx, key, opts...)
 }
 
 func (db *DB) Unsubscribe(key string, ch chan string) {
@@ -1646,6 +1578,23 @@ func (db *DB) Unsubscribe(key string, ch chan string) {
 	db.pubsub.Unsubscribe(key, ch)
 }
 
+func (db *DB) SubscribeEvents(key string, opts ...types.SubscribeOptions) chan types.Event {
+	db.logger.Debug("New event subscription", "key", key)
+	return db.pubsub.SubscribeEvents(key, opts...)
+}
+
+// SubscribeEventsContext is SubscribeEvents for a subscription that lasts as
+// long as ctx.
+func (db *DB) SubscribeEventsContext(ctx context.Context, key string, opts ...types.SubscribeOptions) <-chan types.Event {
+	db.logger.Debug("New event subscription", "key", key)
+	return db.pubsub.SubscribeEventsContext(ctx, key, opts...)
+}
+
+func (db *DB) UnsubscribeEvents(key string, ch chan types.Event) {
+	db.logger.Debug("Removing event subscription", "key", key)
+	db.pubsub.UnsubscribeEvents(key, ch)
+}
+
 func (db *DB) ListSubscriptions() []string {
 	return db.pubsub.ListSubscribers()
 }
@@ -1655,6 +1604,78 @@ func (db *DB) CloseAllSubscriptionsForKey(key string) {
 	db.pubsub.UnsubscribeAllForKey(key)
 }
 
+func (db *DB) PSubscribe(pattern string, opts ...types.SubscribeOptions) chan types.PatternMessage {
+	db.logger.Debug("New pattern subscription", "pattern", pattern)
+	return db.pubsub.PSubscribe(pattern, opts...)
+}
+
+func (db *DB) PUnsubscribe(pattern string, ch chan types.PatternMessage) {
+	db.logger.Debug("Removing pattern subscription", "pattern", pattern)
+	db.pubsub.PUnsubscribe(pattern, ch)
+}
+
+func (db *DB) PSubscribeEvents(pattern string, opts ...types.SubscribeOptions) chan types.Event {
+	db.logger.Debug("New pattern event subscription", "pattern", pattern)
+	return db.pubsub.PSubscribeEvents(pattern, opts...)
+}
+
+func (db *DB) PUnsubscribeEvents(pattern string, ch chan types.Event) {
+	db.logger.Debug("Removing pattern event subscription", "pattern", pattern)
+	db.pubsub.PUnsubscribeEvents(pattern, ch)
+}
+
+func (db *DB) ListPatternSubscriptions() []string {
+	return db.pubsub.ListPatterns()
+}
+
+func (db *DB) CloseAllSubscriptionsForPattern(pattern string) {
+	db.logger.Warn("Closing all subscriptions for pattern", "pattern", pattern)
+	db.pubsub.UnsubscribeAllForPattern(pattern)
+}
+
+// Publish sends message to the subscribers of channel and of every pattern
+// matching it, and returns how many subscriptions received it. Channels share
+// the key namespace with keyspace events, but publishing does not touch the
+// key.
+func (db *DB) Publish(channel, message string) int {
+	receivers := db.pubsub.Publish(channel, message)
+	db.logger.Debug("Message published", "channel", channel, "receivers", receivers)
+	return receivers
+}
+
+// NumSub returns the number of subscriptions to each channel, not counting
+// pattern subscriptions.
+func (db *DB) NumSub(channels ...string) map[string]int {
+	return db.pubsub.NumSub(channels...)
+}
+
+// NumPat returns the number of distinct subscribed patterns.
+func (db *DB) NumPat() int {
+	return db.pubsub.NumPat()
+}
+
+// SubscriptionStats returns the policy, buffer use and delivered and dropped
+// message counts of every subscription.
+func (db *DB) SubscriptionStats() []SubscriptionStats {
+	return db.pubsub.Stats()
+}
+
+// SetChannelRetention makes channel keep its last size messages, including the
+// keyspace events of the key of the same name. Retained messages carry
+// increasing IDs in Event.ID; a subscriber passing the last ID it saw in
+// SubscribeOptions.LastID gets the ones it missed first. A size of zero
+// disables retention.
+func (db *DB) SetChannelRetention(channel string, size int) {
+	db.logger.Info("Channel retention set", "channel", channel, "size", size)
+	db.pubsub.SetRetention(channel, size)
+}
+
+// ChannelHistory returns the retained messages of channel with an ID above
+// after, oldest first.
+func (db *DB) ChannelHistory(channel string, after uint64) []Event {
+	return db.pubsub.History(channel, after)
+}
+
 func (db *DB) Logger() contracts.LoggerHandler {
 	return db.logger
 }
@@ -1683,6 +1704,12 @@ func (db *DB) Close() error {
 		db.logger.Info("PubSub closed successfully")
 	}
 
+	if db.aof != nil {
+		if err := db.aof.Close(); err != nil {
+			db.logger.Error("append-only log close error", "error", err)
+		}
+	}
+
 	if db.logger != nil {
 		err := db.logger.Close()
 		if err != nil {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/themedef/go-hermes/internal/types"
+	"reflect"
 	"sync"
 	"testing"
 	"time"
@@ -395,20 +396,23 @@ func TestStoreLPush(t *testing.T) {
 	ctx := context.Background()
 
 	// Simple LPush check
-	err := db.LPush(ctx, "myList", 1)
+	_, err := db.LPush(ctx, "myList", 1)
 	if err != nil {
 		t.Fatalf("LPush failed: %v", err)
 	}
-	err = db.LPush(ctx, "myList", 2)
+	n, err := db.LPush(ctx, "myList", 2, 3)
 	if err != nil {
 		t.Fatalf("LPush failed: %v", err)
 	}
+	if n != 3 {
+		t.Errorf("Expected length 3, got %d", n)
+	}
 	val, err := db.LPop(ctx, "myList")
 	if err != nil {
 		t.Fatalf("LPop failed: %v", err)
 	}
-	if val != 2 {
-		t.Errorf("Expected 2, got %v", val)
+	if val != 3 {
+		t.Errorf("Expected 3, got %v", val)
 	}
 
 	// Attempt LPush on a non-list key
@@ -416,7 +420,7 @@ func TestStoreLPush(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Set failed: %v", err)
 	}
-	err = db.LPush(ctx, "strKey", "item")
+	_, err = db.LPush(ctx, "strKey", "item")
 	if !IsInvalidType(err) {
 		t.Errorf("Expected ErrInvalidType, got %v", err)
 	}
@@ -428,14 +432,17 @@ func TestStoreRPush(t *testing.T) {
 	ctx := context.Background()
 
 	// Simple RPush check
-	err := db.RPush(ctx, "myListRP", "a")
+	_, err := db.RPush(ctx, "myListRP", "a")
 	if err != nil {
 		t.Fatalf("RPush failed: %v", err)
 	}
-	err = db.RPush(ctx, "myListRP", "b")
+	n, err := db.RPush(ctx, "myListRP", "b")
 	if err != nil {
 		t.Fatalf("RPush failed: %v", err)
 	}
+	if n != 2 {
+		t.Errorf("Expected length 2, got %d", n)
+	}
 	val, err := db.RPop(ctx, "myListRP")
 	if err != nil {
 		t.Fatalf("RPop failed: %v", err)
@@ -449,7 +456,7 @@ func TestStoreRPush(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Set failed: %v", err)
 	}
-	err = db.RPush(ctx, "strKeyRP", "x")
+	_, err = db.RPush(ctx, "strKeyRP", "x")
 	if !IsInvalidType(err) {
 		t.Errorf("Expected ErrInvalidType, got %v", err)
 	}
@@ -467,7 +474,7 @@ func TestStoreLPop(t *testing.T) {
 	}
 
 	// Normal scenario
-	err = db.LPush(ctx, "popList", 1, 2)
+	_, err = db.LPush(ctx, "popList", 1, 2)
 	if err != nil {
 		t.Fatalf("LPush for popList failed: %v", err)
 	}
@@ -492,7 +499,7 @@ func TestStoreRPop(t *testing.T) {
 	}
 
 	// Normal scenario
-	err = db.RPush(ctx, "popList2", "a", "b")
+	_, err = db.RPush(ctx, "popList2", "a", "b")
 	if err != nil {
 		t.Fatalf("RPush failed: %v", err)
 	}
@@ -510,7 +517,7 @@ func TestStoreLLen(t *testing.T) {
 	db := withTestStore(t)
 	ctx := context.Background()
 
-	err := db.LPush(ctx, "lenList", "x", "y", "z")
+	_, err := db.LPush(ctx, "lenList", "x", "y", "z")
 	if err != nil {
 		t.Fatalf("LPush failed: %v", err)
 	}
@@ -528,7 +535,7 @@ func TestStoreLRange(t *testing.T) {
 	db := withTestStore(t)
 	ctx := context.Background()
 
-	err := db.RPush(ctx, "rangeList", 1, 2, 3, 4, 5)
+	_, err := db.RPush(ctx, "rangeList", 1, 2, 3, 4, 5)
 	if err != nil {
 		t.Fatalf("RPush failed: %v", err)
 	}
@@ -547,7 +554,7 @@ func TestStoreLTrim(t *testing.T) {
 	db := withTestStore(t)
 	ctx := context.Background()
 
-	err := db.RPush(ctx, "trimList", 1, 2, 3, 4, 5)
+	_, err := db.RPush(ctx, "trimList", 1, 2, 3, 4, 5)
 	if err != nil {
 		t.Fatalf("RPush failed: %v", err)
 	}
@@ -905,7 +912,7 @@ func TestStoreType(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Setup Set failed: %v", err)
 	}
-	err = db.LPush(ctx, "list", "elem")
+	_, err = db.LPush(ctx, "list", "elem")
 	if err != nil {
 		t.Fatalf("Setup LPush failed: %v", err)
 	}
@@ -1136,12 +1143,315 @@ func TestStorePubSub(t *testing.T) {
 	}
 }
 
+// TestStoreEvents checks the typed keyspace events published by writes.
+func TestStoreEvents(t *testing.T) {
+	db := withTestStore(t)
+	ctx := context.Background()
+
+	events := db.SubscribeEvents("user")
+	legacy := db.Subscribe("user")
+	patterned := db.PSubscribeEvents("us*")
+
+	next := func(ch chan Event) Event {
+		t.Helper()
+		select {
+		case ev := <-ch:
+			return ev
+		case <-time.After(time.Second):
+			t.Fatal("Timeout waiting for event")
+		}
+		return Event{}
+	}
+
+	// Scenario 1: Set carries the new value, type, TTL and origin
+	_ = db.Set(ctx, "user", "ann", 60)
+	ev := next(events)
+	if ev.Op != types.EventSet || ev.Key != "user" || ev.Type != types.String || ev.NewValue != "ann" {
+		t.Errorf("Unexpected set event %+v", ev)
+	}
+	if ev.TTL <= 0 || ev.TTL > time.Minute || ev.Origin != types.OriginClient || ev.Timestamp.IsZero() {
+		t.Errorf("Unexpected set metadata %+v", ev)
+	}
+	if msg := <-legacy; msg != "SET: ann" {
+		t.Errorf("Expected legacy message 'SET: ann', got %q", msg)
+	}
+	if ev := next(patterned); ev.Pattern != "us*" || ev.Op != types.EventSet {
+		t.Errorf("Unexpected pattern event %+v", ev)
+	}
+
+	// Scenario 2: SetCAS reports the old and new value
+	_ = db.SetCAS(ctx, "user", "ann", "bob", 0)
+	if ev := next(events); ev.Op != types.EventCAS || ev.OldValue != "ann" || ev.NewValue != "bob" || ev.TTL != 0 {
+		t.Errorf("Unexpected cas event %+v", ev)
+	}
+	if msg := <-legacy; msg != "CAS: ann -> bob" {
+		t.Errorf("Expected legacy message 'CAS: ann -> bob', got %q", msg)
+	}
+
+	// Scenario 3: writes applied by a transaction report their origin
+	tx := db.Transaction()
+	_ = tx.Delete(ctx, "user")
+	if _, err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if ev := next(events); ev.Op != types.EventDel || ev.OldValue != "bob" || ev.Origin != types.OriginTransaction {
+		t.Errorf("Unexpected del event %+v", ev)
+	}
+	if msg := <-legacy; msg != "DELETE" {
+		t.Errorf("Expected legacy message 'DELETE', got %q", msg)
+	}
+
+	db.UnsubscribeEvents("user", events)
+	if _, ok := <-events; ok {
+		t.Error("Event channel not closed after UnsubscribeEvents")
+	}
+	db.PUnsubscribeEvents("us*", patterned)
+	db.Unsubscribe("user", legacy)
+}
+
+// TestStoreWriteEvents checks that every kind of write publishes an event.
+func TestStoreWriteEvents(t *testing.T) {
+	db := withTestStore(t)
+	ctx := context.Background()
+
+	events := db.PSubscribeEvents("*")
+	defer db.PUnsubscribeEvents("*", events)
+
+	expect := func(op types.EventOp, key string) Event {
+		t.Helper()
+		select {
+		case ev := <-events:
+			if ev.Op != op || ev.Key != key {
+				t.Fatalf("Expected %s on %q, got %+v", op, key, ev)
+			}
+			return ev
+		case <-time.After(time.Second):
+			t.Fatalf("Timeout waiting for %s on %q", op, key)
+		}
+		return Event{}
+	}
+
+	// Scenario 1: counters report the new value
+	_, _ = db.Incr(ctx, "n")
+	_, _ = db.IncrBy(ctx, "n", 4)
+	if ev := expect(types.EventIncrBy, "n"); ev.NewValue != int64(1) {
+		t.Errorf("Expected new value 1, got %+v", ev)
+	}
+	if ev := expect(types.EventIncrBy, "n"); ev.NewValue != int64(5) || ev.String() != "INCRBY: 5" {
+		t.Errorf("Expected new value 5, got %+v", ev)
+	}
+
+	// Scenario 2: popping the last element reports the pop and the deletion
+	_, _ = db.RPush(ctx, "l", "a", "b")
+	expect(types.EventRPush, "l")
+	_, _ = db.LPop(ctx, "l")
+	if ev := expect(types.EventLPop, "l"); ev.OldValue != "a" {
+		t.Errorf("Expected popped value a, got %+v", ev)
+	}
+	_, _ = db.RPop(ctx, "l")
+	expect(types.EventRPop, "l")
+	expect(types.EventDel, "l")
+
+	// Scenario 3: hash, set and sorted set writes
+	_ = db.HSet(ctx, "h", "f", "v1", 0)
+	_ = db.HSet(ctx, "h", "f", "v2", 0)
+	expect(types.EventHSet, "h")
+	if ev := expect(types.EventHSet, "h"); ev.Field != "f" || ev.OldValue != "v1" || ev.NewValue != "v2" {
+		t.Errorf("Unexpected hset event %+v", ev)
+	}
+	_ = db.HDel(ctx, "h", "f")
+	expect(types.EventHDel, "h")
+	expect(types.EventDel, "h")
+
+	_ = db.SAdd(ctx, "s", "x")
+	expect(types.EventSAdd, "s")
+	_ = db.SRem(ctx, "s", "x")
+	expect(types.EventSRem, "s")
+	expect(types.EventDel, "s")
+
+	_, _ = db.ZAdd(ctx, "z", types.ZAddOptions{}, types.ZMember{Member: "m", Score: 1})
+	expect(types.EventZAdd, "z")
+	_, _ = db.ZIncrBy(ctx, "z", "m", 2, types.ZAddOptions{})
+	if ev := expect(types.EventZIncrBy, "z"); ev.Field != "m" || ev.NewValue != float64(3) {
+		t.Errorf("Unexpected zincr event %+v", ev)
+	}
+	_, _ = db.ZPopMax(ctx, "z", 1)
+	expect(types.EventZPopMax, "z")
+	expect(types.EventDel, "z")
+
+	// Scenario 4: TTL changes
+	_ = db.Set(ctx, "k", "v", 0)
+	expect(types.EventSet, "k")
+	_, _ = db.Expire(ctx, "k", 60)
+	if ev := expect(types.EventExpire, "k"); ev.TTL <= 0 {
+		t.Errorf("Expected a TTL on the expire event, got %+v", ev)
+	}
+	_, _ = db.Persist(ctx, "k")
+	expect(types.EventPersist, "k")
+
+	// Scenario 5: a command that finds its key expired announces the expiry
+	_ = db.Set(ctx, "gone", "v", 1)
+	expect(types.EventSet, "gone")
+	time.Sleep(1100 * time.Millisecond)
+	_, _ = db.LPush(ctx, "gone", "a")
+	if ev := expect(types.EventExpired, "gone"); ev.Origin != types.OriginExpiry {
+		t.Errorf("Expected expiry origin, got %+v", ev)
+	}
+	expect(types.EventLPush, "gone")
+
+	// Scenario 6: aggregate values are copies that later writes do not change
+	_ = db.RestoreRawEntry(ctx, "restored", types.Entry{Value: map[string]interface{}{"a": "1"}, Type: types.Hash})
+	ev := expect(types.EventRestore, "restored")
+	_ = db.HSet(ctx, "restored", "b", "2", 0)
+	expect(types.EventHSet, "restored")
+	if hash, ok := ev.NewValue.(map[string]interface{}); !ok || len(hash) != 1 {
+		t.Errorf("Expected the restore event to keep one field, got %#v", ev.NewValue)
+	}
+	_, _ = db.RPush(ctx, "list", "a")
+	expect(types.EventRPush, "list")
+	_ = db.Delete(ctx, "list")
+	if ev := expect(types.EventDel, "list"); !reflect.DeepEqual(ev.OldValue, []interface{}{"a"}) {
+		t.Errorf("Expected the deleted list as a slice, got %#v", ev.OldValue)
+	}
+}
+
+// TestStoreNotifyEvents checks that Config.NotifyEvents filters event classes.
+func TestStoreNotifyEvents(t *testing.T) {
+	ctx := context.Background()
+
+	// Scenario 1: flags parse like Redis notify-keyspace-events
+	classes, err := ParseNotifyEvents("gl")
+	if err != nil || classes != NotifyGeneric|NotifyList {
+		t.Fatalf("Expected generic and list classes, got %v (%v)", classes, err)
+	}
+	if classes, _ := ParseNotifyEvents("KEA"); classes != NotifyAll {
+		t.Errorf("Expected all classes for KEA, got %v", classes)
+	}
+	if classes, _ := ParseNotifyEvents(""); classes != NotifyNone {
+		t.Errorf("Expected no classes for an empty string, got %v", classes)
+	}
+	if _, err := ParseNotifyEvents("q"); err == nil {
+		t.Error("Expected an error for an unknown class")
+	}
+
+	// Scenario 2: only the selected classes are published
+	db := NewStore(Config{NotifyEvents: classes})
+	defer db.Close()
+	events := db.SubscribeEvents("k")
+	_ = db.Set(ctx, "k", "v", 0)
+	_ = db.Delete(ctx, "k")
+	_, _ = db.RPush(ctx, "k", "a")
+	for _, op := range []types.EventOp{types.EventDel, types.EventRPush} {
+		select {
+		case ev := <-events:
+			if ev.Op != op {
+				t.Errorf("Expected %s, got %s", op, ev.Op)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timeout waiting for %s", op)
+		}
+	}
+
+	// Scenario 3: NotifyNone disables keyspace events
+	quiet := NewStore(Config{NotifyEvents: NotifyNone})
+	defer quiet.Close()
+	msgs := quiet.Subscribe("k")
+	_ = quiet.Set(ctx, "k", "v", 0)
+	select {
+	case msg := <-msgs:
+		t.Errorf("Expected no events, got %q", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// TestStoreBlockingSubscriber checks that a PolicyBlock subscriber that does
+// not keep up delays only the writer, not other users of the key's shard.
+func TestStoreBlockingSubscriber(t *testing.T) {
+	db := withTestStore(t)
+	ctx := context.Background()
+
+	events := db.SubscribeEvents("k", SubscribeOptions{Policy: PolicyBlock, BufferSize: 1, BlockTimeout: time.Second})
+	_ = db.Set(ctx, "k", 1, 0)
+
+	// Scenario 1: the buffer is full, so the next write waits for the subscriber
+	written := make(chan struct{})
+	go func() {
+		_ = db.Set(ctx, "k", 2, 0)
+		close(written)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-written:
+		t.Fatal("Expected the write to wait for the blocking subscriber")
+	default:
+	}
+
+	// Scenario 2: meanwhile the shard is free, as a subscriber reading the
+	// store from its loop needs
+	read := make(chan interface{}, 1)
+	go func() {
+		val, _ := db.Get(ctx, "k")
+		read <- val
+	}()
+	select {
+	case val := <-read:
+		if val != 2 {
+			t.Errorf("Expected the written value 2, got %v", val)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Get waited for the blocked subscriber")
+	}
+
+	// Scenario 3: reading the events lets the writer finish, in order
+	for _, want := range []interface{}{1, 2} {
+		if ev := <-events; ev.NewValue != want {
+			t.Errorf("Expected event with value %v, got %+v", want, ev)
+		}
+	}
+	<-written
+}
+
+// TestStoreChannelRetention checks that a retained channel keeps both
+// published messages and keyspace events, and that a subscriber resumes after
+// the last ID it saw.
+func TestStoreChannelRetention(t *testing.T) {
+	db := withTestStore(t)
+	ctx := context.Background()
+
+	db.SetChannelRetention("k", 10)
+	_ = db.Set(ctx, "k", "v", 0)
+	db.Publish("k", "hello")
+	_ = db.Delete(ctx, "k")
+
+	history := db.ChannelHistory("k", 0)
+	if len(history) != 3 {
+		t.Fatalf("Expected 3 retained events, got %d", len(history))
+	}
+	for i, op := range []types.EventOp{types.EventSet, types.EventMessage, types.EventDel} {
+		if history[i].Op != op || history[i].ID != uint64(i+1) {
+			t.Errorf("Event %d: expected %s with ID %d, got %s with ID %d", i, op, i+1, history[i].Op, history[i].ID)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events := db.SubscribeEventsContext(ctx, "k", SubscribeOptions{LastID: 2})
+	select {
+	case ev := <-events:
+		if ev.ID != 3 || ev.Op != types.EventDel {
+			t.Errorf("Expected the delete with ID 3, got %s with ID %d", ev.Op, ev.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for the replayed event")
+	}
+}
+
 // TestStoreTypeValidation checks for type errors when using incorrect operations.
 func TestStoreTypeValidation(t *testing.T) {
 	db := withTestStore(t)
 	ctx := context.Background()
 
-	err := db.LPush(ctx, "listKey", "item")
+	_, err := db.LPush(ctx, "listKey", "item")
 	if err != nil {
 		t.Fatalf("LPush failed: %v", err)
 	}
@@ -1156,7 +1466,7 @@ func TestStoreTypeValidation(t *testing.T) {
 		t.Fatalf("Set failed: %v", err)
 	}
 	// Attempt LPush on a string
-	err = db.LPush(ctx, "strKey", "item")
+	_, err = db.LPush(ctx, "strKey", "item")
 	if !IsInvalidType(err) {
 		t.Errorf("Expected ErrInvalidType, got %v", err)
 	}
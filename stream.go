@@ -0,0 +1,744 @@
+package hermes
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/themedef/go-hermes/internal/types"
+)
+
+type (
+	StreamID      = types.StreamID
+	StreamEntry   = types.StreamEntry
+	StreamPending = types.StreamPending
+	StreamRead    = types.StreamRead
+	XReadOptions  = types.XReadOptions
+)
+
+var ParseStreamID = types.ParseStreamID
+
+// streamForRead returns the stream stored at key. The caller holds the shard lock.
+func (db *DB) streamForRead(sh *shard, key, op string) (*types.StreamLog, error) {
+	entry, exists := sh.data[key]
+	if !exists || db.isExpired(entry) {
+		db.logger.Warn(op+" failed: key not found or expired", "key", key)
+		return nil, ErrKeyNotFound
+	}
+	if entry.Type != types.Stream {
+		db.logger.Error(op+" failed: existing key is not a stream", "key", key)
+		return nil, ErrInvalidType
+	}
+	stream, ok := entry.Value.(*types.StreamLog)
+	if !ok {
+		db.logger.Error(op+" failed: stored value is not a valid stream", "key", key)
+		return nil, ErrInvalidType
+	}
+	return stream, nil
+}
+
+// streamForWrite is streamForRead for mutations: an expired key is dropped and,
+// when create is set, a missing key yields a new empty stream that is not yet
+// stored.
+func (db *DB) streamForWrite(sh *shard, key, op string, create bool) (*types.StreamLog, types.Entry, error) {
+	entry, exists := sh.data[key]
+	if exists && db.isExpired(entry) {
+		db.removeExpired(sh, key, entry)
+		exists = false
+		db.logger.Info(op+" removed expired key", "key", key)
+	}
+	if !exists {
+		if !create {
+			db.logger.Warn(op+" failed: key not found or expired", "key", key)
+			return nil, types.Entry{}, ErrKeyNotFound
+		}
+		stream := types.NewStreamLog()
+		return stream, types.Entry{Value: stream, Type: types.Stream}, nil
+	}
+	stream, err := db.streamForRead(sh, key, op)
+	return stream, entry, err
+}
+
+// groupForWrite returns the consumer group of the stream at key. A missing
+// stream is reported as a missing group, as Redis does.
+func (db *DB) groupForWrite(sh *shard, key, group, op string) (*types.StreamLog, *types.StreamGroup, error) {
+	stream, _, err := db.streamForWrite(sh, key, op, false)
+	if IsKeyNotFound(err) {
+		return nil, nil, ErrGroupNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	g := stream.Group(group)
+	if g == nil {
+		db.logger.Warn(op+" failed: consumer group not found", "key", key, "group", group)
+		return nil, nil, ErrGroupNotFound
+	}
+	return stream, g, nil
+}
+
+// nextStreamID resolves the ID given to XAdd: "*", "ms-*" or an explicit ID,
+// which must be greater than the stream's last ID.
+func (db *DB) nextStreamID(stream *types.StreamLog, id string) (types.StreamID, error) {
+	var next types.StreamID
+	var ok bool
+	switch {
+	case id == "*":
+		next, ok = stream.NextID(db.now())
+	case strings.HasSuffix(id, "-*"):
+		ms, err := strconv.ParseUint(strings.TrimSuffix(id, "-*"), 10, 64)
+		if err != nil {
+			return types.StreamID{}, ErrInvalidStreamID
+		}
+		next, ok = stream.NextIDAt(ms)
+	default:
+		parsed, err := types.ParseStreamID(id)
+		if err != nil {
+			return types.StreamID{}, err
+		}
+		next, ok = parsed, stream.LastID().Less(parsed)
+	}
+	if !ok {
+		return types.StreamID{}, ErrStreamIDTooSmall
+	}
+	return next, nil
+}
+
+func xaddLogArgs(key string, entry types.StreamEntry) []interface{} {
+	fields := make([]string, 0, len(entry.Fields))
+	for f := range entry.Fields {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+
+	args := make([]interface{}, 0, 2+2*len(fields))
+	args = append(args, key, entry.ID.String())
+	for _, f := range fields {
+		args = append(args, f, entry.Fields[f])
+	}
+	return args
+}
+
+func streamIDLogArgs(args []interface{}, ids []types.StreamID) []interface{} {
+	for _, id := range ids {
+		args = append(args, id.String())
+	}
+	return args
+}
+
+func parseStreamIDs(ids []string) ([]types.StreamID, error) {
+	parsed := make([]types.StreamID, len(ids))
+	for i, id := range ids {
+		var err error
+		if parsed[i], err = types.ParseStreamID(id); err != nil {
+			return nil, err
+		}
+	}
+	return parsed, nil
+}
+
+// XAdd appends an entry with the given fields to the stream at key, creating
+// the stream if needed, and returns the entry's ID. id is "*" to generate the
+// ID from the current time, "ms-*" to generate only the sequence number, or an
+// explicit "ms-seq" greater than the stream's last ID.
+func (db *DB) XAdd(ctx context.Context, key, id string, fields map[string]interface{}) (types.StreamID, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("XAdd operation canceled", "key", key)
+		return types.StreamID{}, ErrContextCanceled
+	default:
+	}
+
+	if key == "" {
+		db.logger.Error("XAdd failed: empty key")
+		return types.StreamID{}, ErrInvalidKey
+	}
+	if len(fields) == 0 {
+		db.logger.Warn("XAdd called with no fields", "key", key)
+		return types.StreamID{}, ErrEmptyValues
+	}
+
+	if err := db.checkLoggable("XAdd", key, fields); err != nil {
+		return types.StreamID{}, err
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.lockShard(ctx, sh)()
+
+	stream, entry, err := db.streamForWrite(sh, key, "XAdd", true)
+	if err != nil {
+		return types.StreamID{}, err
+	}
+	next, err := db.nextStreamID(stream, id)
+	if err != nil {
+		db.logger.Error("XAdd failed: invalid ID", "key", key, "id", id, "error", err)
+		return types.StreamID{}, err
+	}
+
+	values := make(map[string]interface{}, len(fields))
+	for f, v := range fields {
+		values[f] = v
+	}
+	added := types.StreamEntry{ID: next, Fields: values}
+	stream.Append(added)
+	sh.data[key] = entry
+
+	db.afterWrite(opXAdd, xaddLogArgs(key, added)...)
+	db.notify(ctx, types.Event{Op: types.EventXAdd, Key: key, Type: types.Stream, NewValue: added, TTL: ttlLeft(entry.Expiration)})
+
+	db.logger.Info("XAdd operation successful", "key", key, "id", next.String())
+	return next, nil
+}
+
+// XLen returns the number of entries in the stream.
+func (db *DB) XLen(ctx context.Context, key string) (int, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("XLen operation canceled", "key", key)
+		return 0, ErrContextCanceled
+	default:
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.rlockShard(ctx, sh)()
+
+	stream, err := db.streamForRead(sh, key, "XLen")
+	if err != nil {
+		return 0, err
+	}
+	n := stream.Len()
+	db.logger.Info("XLen operation successful", "key", key, "length", n)
+	return n, nil
+}
+
+// XRange returns the entries with IDs from start to end, oldest first, at most
+// count of them when count > 0. The bounds accept "-" and "+" for the ends of
+// the stream, a bare millisecond time, and a "(" prefix to exclude the bound.
+func (db *DB) XRange(ctx context.Context, key, start, end string, count int) ([]types.StreamEntry, error) {
+	return db.xrange(ctx, "XRange", key, start, end, count, false)
+}
+
+// XRevRange is XRange newest first; note that end comes before start.
+func (db *DB) XRevRange(ctx context.Context, key, end, start string, count int) ([]types.StreamEntry, error) {
+	return db.xrange(ctx, "XRevRange", key, start, end, count, true)
+}
+
+func (db *DB) xrange(ctx context.Context, op, key, start, end string, count int, rev bool) ([]types.StreamEntry, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn(op+" operation canceled", "key", key)
+		return nil, ErrContextCanceled
+	default:
+	}
+
+	from, to, ok, err := types.ParseStreamRange(start, end)
+	if err != nil {
+		db.logger.Error(op+" failed: invalid range", "key", key, "start", start, "end", end)
+		return nil, err
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.rlockShard(ctx, sh)()
+
+	stream, err := db.streamForRead(sh, key, op)
+	if err != nil {
+		return nil, err
+	}
+	entries := []types.StreamEntry{}
+	if ok {
+		entries = stream.Range(from, to, count, rev)
+	}
+	db.logger.Info(op+" operation successful", "key", key, "count", len(entries))
+	return entries, nil
+}
+
+// XTrim drops the oldest entries of the stream until at most maxLen remain and
+// returns how many it dropped.
+func (db *DB) XTrim(ctx context.Context, key string, maxLen int) (int, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("XTrim operation canceled", "key", key)
+		return 0, ErrContextCanceled
+	default:
+	}
+
+	if maxLen < 0 {
+		db.logger.Error("XTrim failed: negative length", "key", key, "maxLen", maxLen)
+		return 0, ErrInvalidOptions
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.lockShard(ctx, sh)()
+
+	stream, entry, err := db.streamForWrite(sh, key, "XTrim", false)
+	if err != nil {
+		return 0, err
+	}
+	removed := stream.Trim(maxLen)
+	if removed > 0 {
+		db.afterWrite(opXTrim, key, int64(maxLen))
+		db.notify(ctx, types.Event{Op: types.EventXTrim, Key: key, Type: types.Stream, OldValue: removed, TTL: ttlLeft(entry.Expiration)})
+	}
+
+	db.logger.Info("XTrim operation successful", "key", key, "removed", removed)
+	return removed, nil
+}
+
+// XRead returns the entries added after the given IDs to the streams at keys,
+// which pair up with ids. The ID "$" stands for the stream's last ID, so only
+// entries added from now on are read. Streams with nothing new are left out of
+// the result. With opts.Block the call waits for new entries when there are
+// none yet, except inside a transaction; an empty result then means the wait
+// timed out.
+func (db *DB) XRead(ctx context.Context, opts types.XReadOptions, keys, ids []string) ([]types.StreamRead, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("XRead operation canceled", "keys", keys)
+		return nil, ErrContextCanceled
+	default:
+	}
+
+	if len(keys) == 0 {
+		db.logger.Warn("XRead called with no streams")
+		return nil, ErrEmptyValues
+	}
+	if len(keys) != len(ids) {
+		db.logger.Error("XRead failed: keys and IDs do not pair up", "keys", len(keys), "ids", len(ids))
+		return nil, ErrInvalidOptions
+	}
+
+	after := make([]types.StreamID, len(keys))
+	for i, id := range ids {
+		if id == "$" {
+			after[i] = db.streamLastID(ctx, keys[i])
+			continue
+		}
+		var err error
+		if after[i], err = types.ParseStreamID(id); err != nil {
+			db.logger.Error("XRead failed: invalid ID", "key", keys[i], "id", id)
+			return nil, err
+		}
+	}
+
+	var reads []types.StreamRead
+	try := func() (bool, error) {
+		var err error
+		reads, err = db.xreadOnce(ctx, keys, after, opts.Count)
+		return len(reads) > 0, err
+	}
+	var err error
+	if opts.Block && canBlock(ctx) {
+		err = db.block(ctx, keys, opts.Timeout, try)
+	} else {
+		_, err = try()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	db.logger.Info("XRead operation successful", "keys", keys, "streams", len(reads))
+	return reads, nil
+}
+
+// streamLastID returns the last ID of the stream at key, or the zero ID if there
+// is none.
+func (db *DB) streamLastID(ctx context.Context, key string) types.StreamID {
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.rlockShard(ctx, sh)()
+
+	entry, exists := sh.data[key]
+	if !exists || db.isExpired(entry) {
+		return types.StreamID{}
+	}
+	if stream, ok := entry.Value.(*types.StreamLog); ok {
+		return stream.LastID()
+	}
+	return types.StreamID{}
+}
+
+func (db *DB) xreadOnce(ctx context.Context, keys []string, after []types.StreamID, count int) ([]types.StreamRead, error) {
+	reads := []types.StreamRead{}
+	for i, key := range keys {
+		entries, err := db.streamAfter(ctx, key, after[i], count)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) > 0 {
+			reads = append(reads, types.StreamRead{Key: key, Entries: entries})
+		}
+	}
+	return reads, nil
+}
+
+func (db *DB) streamAfter(ctx context.Context, key string, after types.StreamID, count int) ([]types.StreamEntry, error) {
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.rlockShard(ctx, sh)()
+
+	if entry, exists := sh.data[key]; !exists || db.isExpired(entry) {
+		return nil, nil
+	}
+	stream, err := db.streamForRead(sh, key, "XRead")
+	if err != nil {
+		return nil, err
+	}
+	return stream.After(after, count), nil
+}
+
+// XGroupCreate creates a consumer group on the stream at key that delivers the
+// entries after id, where "$" stands for the stream's last ID. With mkStream a
+// missing stream is created empty; otherwise it is ErrKeyNotFound.
+func (db *DB) XGroupCreate(ctx context.Context, key, group, id string, mkStream bool) error {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("XGroupCreate operation canceled", "key", key)
+		return ErrContextCanceled
+	default:
+	}
+
+	if key == "" {
+		db.logger.Error("XGroupCreate failed: empty key")
+		return ErrInvalidKey
+	}
+	if group == "" {
+		db.logger.Error("XGroupCreate failed: empty group name", "key", key)
+		return ErrEmptyValues
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.lockShard(ctx, sh)()
+
+	stream, entry, err := db.streamForWrite(sh, key, "XGroupCreate", mkStream)
+	if err != nil {
+		return err
+	}
+	start := stream.LastID()
+	if id != "$" {
+		if start, err = types.ParseStreamID(id); err != nil {
+			db.logger.Error("XGroupCreate failed: invalid ID", "key", key, "id", id)
+			return err
+		}
+	}
+	if !stream.AddGroup(types.NewStreamGroup(group, start)) {
+		db.logger.Warn("XGroupCreate failed: group exists", "key", key, "group", group)
+		return ErrGroupExists
+	}
+	sh.data[key] = entry
+
+	var created int64
+	if mkStream {
+		created = 1
+	}
+	db.afterWrite(opXGroup, key, "CREATE", group, start.String(), created)
+	db.notify(ctx, types.Event{Op: types.EventXGroupCreate, Key: key, Field: group, Type: types.Stream, TTL: ttlLeft(entry.Expiration)})
+
+	db.logger.Info("XGroupCreate operation successful", "key", key, "group", group, "id", start.String())
+	return nil
+}
+
+// XGroupDestroy removes a consumer group with its pending entries and reports
+// whether it existed.
+func (db *DB) XGroupDestroy(ctx context.Context, key, group string) (bool, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("XGroupDestroy operation canceled", "key", key)
+		return false, ErrContextCanceled
+	default:
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.lockShard(ctx, sh)()
+
+	stream, entry, err := db.streamForWrite(sh, key, "XGroupDestroy", false)
+	if err != nil {
+		return false, err
+	}
+	if !stream.RemoveGroup(group) {
+		db.logger.Info("XGroupDestroy found no such group", "key", key, "group", group)
+		return false, nil
+	}
+
+	db.afterWrite(opXGroup, key, "DESTROY", group)
+	db.notify(ctx, types.Event{Op: types.EventXGroupDestroy, Key: key, Field: group, Type: types.Stream, TTL: ttlLeft(entry.Expiration)})
+
+	db.logger.Info("XGroupDestroy operation successful", "key", key, "group", group)
+	return true, nil
+}
+
+// XReadGroup reads as consumer of group from the streams at keys, which pair up
+// with ids. The ID ">" delivers entries no consumer of the group has received
+// yet and, unless opts.NoAck is set, adds them to the pending entries list
+// until XAck. Any other ID returns the consumer's own pending entries after
+// it; an entry trimmed from the stream meanwhile comes back with nil Fields.
+// opts.Block only applies when every ID is ">".
+func (db *DB) XReadGroup(ctx context.Context, group, consumer string, opts types.XReadOptions, keys, ids []string) ([]types.StreamRead, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("XReadGroup operation canceled", "group", group, "keys", keys)
+		return nil, ErrContextCanceled
+	default:
+	}
+
+	if group == "" || consumer == "" {
+		db.logger.Error("XReadGroup failed: empty group or consumer name")
+		return nil, ErrEmptyValues
+	}
+	if len(keys) == 0 {
+		db.logger.Warn("XReadGroup called with no streams", "group", group)
+		return nil, ErrEmptyValues
+	}
+	if len(keys) != len(ids) {
+		db.logger.Error("XReadGroup failed: keys and IDs do not pair up", "keys", len(keys), "ids", len(ids))
+		return nil, ErrInvalidOptions
+	}
+
+	history := make([]*types.StreamID, len(keys))
+	onlyNew := true
+	for i, id := range ids {
+		if id == ">" {
+			continue
+		}
+		from, err := types.ParseStreamID(id)
+		if err != nil {
+			db.logger.Error("XReadGroup failed: invalid ID", "key", keys[i], "id", id)
+			return nil, err
+		}
+		history[i] = &from
+		onlyNew = false
+	}
+
+	var reads []types.StreamRead
+	try := func() (bool, error) {
+		var err error
+		reads, err = db.xreadGroupOnce(ctx, group, consumer, opts, keys, history)
+		return len(reads) > 0, err
+	}
+	var err error
+	if opts.Block && onlyNew && canBlock(ctx) {
+		err = db.block(ctx, keys, opts.Timeout, try)
+	} else {
+		_, err = try()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	db.logger.Info("XReadGroup operation successful", "group", group, "consumer", consumer, "streams", len(reads))
+	return reads, nil
+}
+
+// xreadGroupOnce reads each stream once: new entries where history is nil,
+// the consumer's pending entries after *history otherwise.
+func (db *DB) xreadGroupOnce(ctx context.Context, group, consumer string, opts types.XReadOptions, keys []string, history []*types.StreamID) ([]types.StreamRead, error) {
+	reads := []types.StreamRead{}
+	for i, key := range keys {
+		var entries []types.StreamEntry
+		var err error
+		if history[i] == nil {
+			entries, err = db.deliverNew(ctx, key, group, consumer, opts)
+			if err == nil && len(entries) == 0 {
+				continue
+			}
+		} else {
+			entries, err = db.pendingEntries(ctx, key, group, consumer, *history[i], opts.Count)
+		}
+		if err != nil {
+			return nil, err
+		}
+		reads = append(reads, types.StreamRead{Key: key, Entries: entries})
+	}
+	return reads, nil
+}
+
+// deliverNew hands the group's undelivered entries of the stream at key to
+// consumer.
+func (db *DB) deliverNew(ctx context.Context, key, group, consumer string, opts types.XReadOptions) ([]types.StreamEntry, error) {
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.lockShard(ctx, sh)()
+
+	stream, g, err := db.groupForWrite(sh, key, group, "XReadGroup")
+	if err != nil {
+		return nil, err
+	}
+	entries := stream.After(g.LastDelivered, opts.Count)
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	g.LastDelivered = entries[len(entries)-1].ID
+	var noAck int64
+	if opts.NoAck {
+		noAck = 1
+	} else {
+		now := db.now()
+		for _, e := range entries {
+			g.Deliver(e.ID, consumer, now)
+		}
+	}
+	// Replaying reads the same entries again, since the group's position is
+	// part of the logged state.
+	db.afterWrite(opXReadGroup, key, group, consumer, int64(len(entries)), noAck)
+	return entries, nil
+}
+
+// pendingEntries returns the entries pending for consumer with an ID greater
+// than after.
+func (db *DB) pendingEntries(ctx context.Context, key, group, consumer string, after types.StreamID, count int) ([]types.StreamEntry, error) {
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.rlockShard(ctx, sh)()
+
+	stream, err := db.streamForRead(sh, key, "XReadGroup")
+	if IsKeyNotFound(err) {
+		return nil, ErrGroupNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	g := stream.Group(group)
+	if g == nil {
+		return nil, ErrGroupNotFound
+	}
+
+	entries := []types.StreamEntry{}
+	for _, p := range g.PendingList(consumer) {
+		if !after.Less(p.ID) {
+			continue
+		}
+		if count > 0 && len(entries) == count {
+			break
+		}
+		entry, ok := stream.Get(p.ID)
+		if !ok {
+			entry = types.StreamEntry{ID: p.ID}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// XAck acknowledges entries of a consumer group, removing them from its
+// pending entries list, and returns how many were pending.
+func (db *DB) XAck(ctx context.Context, key, group string, ids ...string) (int, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("XAck operation canceled", "key", key)
+		return 0, ErrContextCanceled
+	default:
+	}
+
+	if len(ids) == 0 {
+		db.logger.Warn("XAck called with no IDs", "key", key)
+		return 0, ErrEmptyValues
+	}
+	parsed, err := parseStreamIDs(ids)
+	if err != nil {
+		db.logger.Error("XAck failed: invalid ID", "key", key)
+		return 0, err
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.lockShard(ctx, sh)()
+
+	_, g, err := db.groupForWrite(sh, key, group, "XAck")
+	if err != nil {
+		return 0, err
+	}
+	acked := make([]types.StreamID, 0, len(parsed))
+	for _, id := range parsed {
+		if g.Ack(id) {
+			acked = append(acked, id)
+		}
+	}
+	if len(acked) > 0 {
+		db.afterWrite(opXAck, streamIDLogArgs([]interface{}{key, group}, acked)...)
+	}
+
+	db.logger.Info("XAck operation successful", "key", key, "group", group, "acked", len(acked))
+	return len(acked), nil
+}
+
+// XPending returns the pending entries of a consumer group ordered by ID,
+// only those of consumer when it is not empty.
+func (db *DB) XPending(ctx context.Context, key, group, consumer string) ([]types.StreamPending, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("XPending operation canceled", "key", key)
+		return nil, ErrContextCanceled
+	default:
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.rlockShard(ctx, sh)()
+
+	stream, err := db.streamForRead(sh, key, "XPending")
+	if IsKeyNotFound(err) {
+		return nil, ErrGroupNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	g := stream.Group(group)
+	if g == nil {
+		db.logger.Warn("XPending failed: consumer group not found", "key", key, "group", group)
+		return nil, ErrGroupNotFound
+	}
+	pending := g.PendingList(consumer)
+	db.logger.Info("XPending operation successful", "key", key, "group", group, "pending", len(pending))
+	return pending, nil
+}
+
+// XClaim transfers pending entries that have been idle for at least minIdle to
+// consumer and returns them. Each claim counts as a new delivery. IDs that are
+// not pending, or not idle long enough, are skipped; pending entries that were
+// trimmed from the stream are dropped from the pending entries list.
+func (db *DB) XClaim(ctx context.Context, key, group, consumer string, minIdle time.Duration, ids ...string) ([]types.StreamEntry, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("XClaim operation canceled", "key", key)
+		return nil, ErrContextCanceled
+	default:
+	}
+
+	if consumer == "" {
+		db.logger.Error("XClaim failed: empty consumer name", "key", key)
+		return nil, ErrEmptyValues
+	}
+	parsed, err := parseStreamIDs(ids)
+	if err != nil {
+		db.logger.Error("XClaim failed: invalid ID", "key", key)
+		return nil, err
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.lockShard(ctx, sh)()
+
+	stream, g, err := db.groupForWrite(sh, key, group, "XClaim")
+	if err != nil {
+		return nil, err
+	}
+
+	now := db.now()
+	claimed := []types.StreamEntry{}
+	changed := make([]types.StreamID, 0, len(parsed))
+	for _, id := range parsed {
+		p, ok := g.Pending[id]
+		if !ok || now.Sub(p.DeliveredAt) < minIdle {
+			continue
+		}
+		changed = append(changed, id)
+		entry, ok := stream.Get(id)
+		if !ok {
+			g.Ack(id)
+			continue
+		}
+		g.Deliver(id, consumer, now)
+		claimed = append(claimed, entry)
+	}
+	if len(changed) > 0 {
+		db.afterWrite(opXClaim, streamIDLogArgs([]interface{}{key, group, consumer}, changed)...)
+	}
+
+	db.logger.Info("XClaim operation successful", "key", key, "group", group, "consumer", consumer, "claimed", len(claimed))
+	return claimed, nil
+}
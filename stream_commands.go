@@ -0,0 +1,497 @@
+package hermes
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/themedef/go-hermes/internal/resp"
+	"github.com/themedef/go-hermes/internal/types"
+)
+
+// xreadQuery holds the parsed arguments of XREAD and XREADGROUP.
+type xreadQuery struct {
+	group    string
+	consumer string
+	opts     types.XReadOptions
+	keys     []string
+	ids      []string
+}
+
+// parseXReadArgs parses [GROUP group consumer] [COUNT n] [BLOCK ms] [NOACK]
+// STREAMS key [key ...] id [id ...]. GROUP and NOACK are only accepted, and
+// GROUP is required, for XREADGROUP. BLOCK 0 waits until the context is done.
+func parseXReadArgs(cmd string, args []string) (xreadQuery, error) {
+	var q xreadQuery
+	grouped := cmd == "XREADGROUP"
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "GROUP":
+			if !grouped || i+2 >= len(args) {
+				return q, fmt.Errorf("syntax error")
+			}
+			q.group, q.consumer = args[i+1], args[i+2]
+			i += 2
+		case "COUNT":
+			if i+1 >= len(args) {
+				return q, fmt.Errorf("syntax error")
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return q, fmt.Errorf("value is not an integer or out of range")
+			}
+			q.opts.Count = n
+			i++
+		case "BLOCK":
+			if i+1 >= len(args) {
+				return q, fmt.Errorf("syntax error")
+			}
+			ms, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return q, fmt.Errorf("timeout is not an integer or out of range")
+			}
+			if ms < 0 {
+				return q, fmt.Errorf("timeout is negative")
+			}
+			q.opts.Block, q.opts.Timeout = true, time.Duration(ms)*time.Millisecond
+			i++
+		case "NOACK":
+			if !grouped {
+				return q, fmt.Errorf("syntax error")
+			}
+			q.opts.NoAck = true
+		case "STREAMS":
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return q, fmt.Errorf("Unbalanced '%s' list of streams: for each stream key an ID or '$' must be specified.", strings.ToLower(cmd))
+			}
+			q.keys, q.ids = rest[:len(rest)/2], rest[len(rest)/2:]
+			if grouped && q.group == "" {
+				return q, fmt.Errorf("Missing GROUP option for %s", cmd)
+			}
+			return q, nil
+		default:
+			return q, fmt.Errorf("syntax error")
+		}
+	}
+	return q, fmt.Errorf("syntax error")
+}
+
+func (c *CommandAPI) xread(ctx context.Context, q xreadQuery) ([]types.StreamRead, error) {
+	if q.group != "" {
+		return c.db.XReadGroup(ctx, q.group, q.consumer, q.opts, q.keys, q.ids)
+	}
+	return c.db.XRead(ctx, q.opts, q.keys, q.ids)
+}
+
+// parseXAddArgs parses key id field value [field value ...].
+func parseXAddArgs(args []string) (key, id string, fields map[string]interface{}, err error) {
+	if len(args) < 4 || len(args)%2 != 0 {
+		return "", "", nil, fmt.Errorf("Usage: XADD key id|* field value [field value ...]")
+	}
+	fields = make(map[string]interface{}, (len(args)-2)/2)
+	for i := 2; i < len(args); i += 2 {
+		fields[args[i]] = args[i+1]
+	}
+	return args[0], args[1], fields, nil
+}
+
+// parseXRangeCount parses the optional COUNT n that follows the bounds of
+// XRANGE and XREVRANGE.
+func parseXRangeCount(args []string) (int, error) {
+	switch {
+	case len(args) == 0:
+		return 0, nil
+	case len(args) == 2 && strings.ToUpper(args[0]) == "COUNT":
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return 0, fmt.Errorf("value is not an integer or out of range")
+		}
+		if n <= 0 {
+			// Redis returns nothing for a zero or negative COUNT.
+			return -1, nil
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("syntax error")
+}
+
+func (c *CommandAPI) xrange(ctx context.Context, cmd string, args []string) ([]types.StreamEntry, error) {
+	count, err := parseXRangeCount(args[3:])
+	if err != nil {
+		return nil, err
+	}
+	if count < 0 {
+		return []types.StreamEntry{}, nil
+	}
+	var entries []types.StreamEntry
+	if cmd == "XREVRANGE" {
+		entries, err = c.db.XRevRange(ctx, args[0], args[1], args[2], count)
+	} else {
+		entries, err = c.db.XRange(ctx, args[0], args[1], args[2], count)
+	}
+	if IsKeyNotFound(err) {
+		return []types.StreamEntry{}, nil
+	}
+	return entries, err
+}
+
+// parseXTrimArgs parses key MAXLEN [=|~] n. Trimming is always exact.
+func parseXTrimArgs(args []string) (string, int, error) {
+	if len(args) < 3 || strings.ToUpper(args[1]) != "MAXLEN" {
+		return "", 0, fmt.Errorf("Usage: XTRIM key MAXLEN [=|~] count")
+	}
+	n := args[2]
+	if (n == "=" || n == "~") && len(args) == 4 {
+		n = args[3]
+	} else if len(args) != 3 {
+		return "", 0, fmt.Errorf("syntax error")
+	}
+	maxLen, err := strconv.Atoi(n)
+	if err != nil {
+		return "", 0, fmt.Errorf("value is not an integer or out of range")
+	}
+	if maxLen < 0 {
+		return "", 0, fmt.Errorf("The MAXLEN argument must be >= 0.")
+	}
+	return args[0], maxLen, nil
+}
+
+func (c *CommandAPI) xtrim(ctx context.Context, args []string) (int, error) {
+	key, maxLen, err := parseXTrimArgs(args)
+	if err != nil {
+		return 0, err
+	}
+	n, err := c.db.XTrim(ctx, key, maxLen)
+	if IsKeyNotFound(err) {
+		return 0, nil
+	}
+	return n, err
+}
+
+// xgroup runs XGROUP CREATE key group id [MKSTREAM] and XGROUP DESTROY key
+// group. CREATE replies true; DESTROY whether the group existed.
+func (c *CommandAPI) xgroup(ctx context.Context, args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, fmt.Errorf("Usage: XGROUP CREATE key group id|$ [MKSTREAM] | DESTROY key group")
+	}
+	switch strings.ToUpper(args[0]) {
+	case "CREATE":
+		if len(args) < 4 || len(args) > 5 {
+			return false, fmt.Errorf("Usage: XGROUP CREATE key group id|$ [MKSTREAM]")
+		}
+		mkStream := false
+		if len(args) == 5 {
+			if strings.ToUpper(args[4]) != "MKSTREAM" {
+				return false, fmt.Errorf("syntax error")
+			}
+			mkStream = true
+		}
+		err := c.db.XGroupCreate(ctx, args[1], args[2], args[3], mkStream)
+		if IsKeyNotFound(err) {
+			return false, fmt.Errorf("The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+		}
+		return err == nil, err
+	case "DESTROY":
+		if len(args) != 3 {
+			return false, fmt.Errorf("Usage: XGROUP DESTROY key group")
+		}
+		return c.db.XGroupDestroy(ctx, args[1], args[2])
+	}
+	return false, fmt.Errorf("unknown XGROUP subcommand '%s'", args[0])
+}
+
+// xpendingQuery holds the parsed arguments of XPENDING key group [start end
+// count [consumer]]. Without a range the summary form is returned.
+type xpendingQuery struct {
+	key, group string
+	summary    bool
+	from, to   types.StreamID
+	empty      bool
+	count      int
+	consumer   string
+}
+
+func parseXPendingArgs(args []string) (xpendingQuery, error) {
+	q := xpendingQuery{summary: len(args) == 2}
+	if len(args) != 2 && len(args) != 5 && len(args) != 6 {
+		return q, fmt.Errorf("Usage: XPENDING key group [start end count [consumer]]")
+	}
+	q.key, q.group = args[0], args[1]
+	if q.summary {
+		return q, nil
+	}
+	from, to, ok, err := types.ParseStreamRange(args[2], args[3])
+	if err != nil {
+		return q, err
+	}
+	count, err := strconv.Atoi(args[4])
+	if err != nil {
+		return q, fmt.Errorf("value is not an integer or out of range")
+	}
+	q.from, q.to, q.empty, q.count = from, to, !ok || count <= 0, count
+	if len(args) == 6 {
+		q.consumer = args[5]
+	}
+	return q, nil
+}
+
+// xpending returns the pending entries q selects; for the summary form all of
+// them.
+func (c *CommandAPI) xpending(ctx context.Context, q xpendingQuery) ([]types.StreamPending, error) {
+	pending, err := c.db.XPending(ctx, q.key, q.group, q.consumer)
+	if err != nil || q.summary {
+		return pending, err
+	}
+	out := []types.StreamPending{}
+	if q.empty {
+		return out, nil
+	}
+	for _, p := range pending {
+		if len(out) == q.count {
+			break
+		}
+		if !p.ID.Less(q.from) && !q.to.Less(p.ID) {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+// pendingConsumers counts the pending entries per consumer, ordered by
+// consumer name.
+func pendingConsumers(pending []types.StreamPending) ([]string, map[string]int) {
+	counts := make(map[string]int)
+	var names []string
+	for _, p := range pending {
+		if counts[p.Consumer] == 0 {
+			names = append(names, p.Consumer)
+		}
+		counts[p.Consumer]++
+	}
+	sort.Strings(names)
+	return names, counts
+}
+
+// parseXClaimArgs parses key group consumer min-idle-ms id [id ...].
+func parseXClaimArgs(args []string) (time.Duration, error) {
+	if len(args) < 5 {
+		return 0, fmt.Errorf("Usage: XCLAIM key group consumer min-idle-ms id [id ...]")
+	}
+	ms, err := strconv.Atoi(args[3])
+	if err != nil || ms < 0 {
+		return 0, fmt.Errorf("Invalid min-idle-time argument for XCLAIM")
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// streamFields returns the fields of an entry ordered by name.
+func streamFields(entry types.StreamEntry) []string {
+	fields := make([]string, 0, len(entry.Fields))
+	for f := range entry.Fields {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// formatStreamEntries renders the entries of a stream reply for Execute, one
+// "id field=value ..." line per entry.
+func formatStreamEntries(entries []interface{}) string {
+	if len(entries) == 0 {
+		return "(empty stream)"
+	}
+	return formatLines(entries, func(e interface{}) string {
+		entry := e.([]interface{})
+		var b strings.Builder
+		b.WriteString(formatText(entry[0]))
+		fields, ok := entry[1].([]interface{})
+		if !ok {
+			b.WriteString(" (deleted)")
+		}
+		for i := 0; i+1 < len(fields); i += 2 {
+			fmt.Fprintf(&b, " %v=%v", fields[i], fields[i+1])
+		}
+		return b.String()
+	})
+}
+
+// formatPending renders an XPENDING reply for Execute: the summary as a count
+// and ID range followed by one line per consumer, entries one per line.
+func formatPending(reply []interface{}) string {
+	if len(reply) == 0 {
+		return "(empty list)"
+	}
+	if count, ok := reply[0].(int64); ok {
+		if count == 0 {
+			return "(empty list)"
+		}
+		lines := []string{fmt.Sprintf("%d pending, %v .. %v", count, reply[1], reply[2])}
+		for _, c := range reply[3].([]interface{}) {
+			consumer := c.([]interface{})
+			lines = append(lines, fmt.Sprintf("%v: %v", consumer[0], consumer[1]))
+		}
+		return strings.Join(lines, "\n")
+	}
+	return formatLines(reply, func(p interface{}) string {
+		entry := p.([]interface{})
+		return fmt.Sprintf("%v %v idle=%vms deliveries=%v", entry[0], entry[1], entry[2], entry[3])
+	})
+}
+
+// runStream handles the stream commands.
+func (c *CommandAPI) runStream(ctx context.Context, cmd string, args []string) (interface{}, error) {
+	switch cmd {
+	case "XADD":
+		if len(args) < 4 || len(args)%2 != 0 {
+			return nil, respWrongArgs(cmd)
+		}
+		key, id, fields, _ := parseXAddArgs(args)
+		added, err := c.db.XAdd(ctx, key, id, fields)
+		if err != nil {
+			return nil, err
+		}
+		return added.String(), nil
+
+	case "XLEN":
+		if len(args) != 1 {
+			return nil, respWrongArgs(cmd)
+		}
+		n, err := c.db.XLen(ctx, args[0])
+		if err != nil {
+			if IsKeyNotFound(err) {
+				return int64(0), nil
+			}
+			return nil, err
+		}
+		return int64(n), nil
+
+	case "XRANGE", "XREVRANGE":
+		if len(args) < 3 {
+			return nil, respWrongArgs(cmd)
+		}
+		entries, err := c.xrange(ctx, cmd, args)
+		if err != nil {
+			return nil, err
+		}
+		return respStreamEntries(entries), nil
+
+	case "XTRIM":
+		if len(args) < 3 {
+			return nil, respWrongArgs(cmd)
+		}
+		n, err := c.xtrim(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+		return int64(n), nil
+
+	case "XREAD", "XREADGROUP":
+		q, err := parseXReadArgs(cmd, args)
+		if err != nil {
+			return nil, err
+		}
+		reads, err := c.xread(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		if len(reads) == 0 {
+			return nil, nil
+		}
+		out := make([]interface{}, len(reads))
+		for i, r := range reads {
+			out[i] = []interface{}{r.Key, respStreamEntries(r.Entries)}
+		}
+		return out, nil
+
+	case "XGROUP":
+		if len(args) == 0 {
+			return nil, respWrongArgs(cmd)
+		}
+		ok, err := c.xgroup(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+		if strings.ToUpper(args[0]) == "CREATE" {
+			return resp.SimpleString("OK"), nil
+		}
+		return respBool(ok), nil
+
+	case "XACK":
+		if len(args) < 3 {
+			return nil, respWrongArgs(cmd)
+		}
+		n, err := c.db.XAck(ctx, args[0], args[1], args[2:]...)
+		if err != nil {
+			return nil, err
+		}
+		return int64(n), nil
+
+	case "XPENDING":
+		if len(args) < 2 {
+			return nil, respWrongArgs(cmd)
+		}
+		q, err := parseXPendingArgs(args)
+		if err != nil {
+			return nil, err
+		}
+		pending, err := c.xpending(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		if q.summary {
+			if len(pending) == 0 {
+				return []interface{}{int64(0), nil, nil, nil}, nil
+			}
+			names, counts := pendingConsumers(pending)
+			consumers := make([]interface{}, len(names))
+			for i, name := range names {
+				consumers[i] = []interface{}{name, strconv.Itoa(counts[name])}
+			}
+			return []interface{}{int64(len(pending)), pending[0].ID.String(), pending[len(pending)-1].ID.String(), consumers}, nil
+		}
+		now := time.Now()
+		out := make([]interface{}, len(pending))
+		for i, p := range pending {
+			out[i] = []interface{}{p.ID.String(), p.Consumer, now.Sub(p.DeliveredAt).Milliseconds(), int64(p.Deliveries)}
+		}
+		return out, nil
+
+	case "XCLAIM":
+		if len(args) < 5 {
+			return nil, respWrongArgs(cmd)
+		}
+		minIdle, err := parseXClaimArgs(args)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := c.db.XClaim(ctx, args[0], args[1], args[2], minIdle, args[4:]...)
+		if err != nil {
+			return nil, err
+		}
+		return respStreamEntries(entries), nil
+	}
+	return nil, errUnknownCommand(cmd)
+}
+
+// respStreamEntries encodes entries the way Redis does: an array of [id,
+// [field, value, ...]] pairs, with a nil field list for entries that were
+// trimmed while pending.
+func respStreamEntries(entries []types.StreamEntry) []interface{} {
+	out := make([]interface{}, len(entries))
+	for i, e := range entries {
+		if e.Fields == nil {
+			out[i] = []interface{}{e.ID.String(), nil}
+			continue
+		}
+		fields := make([]interface{}, 0, 2*len(e.Fields))
+		for _, f := range streamFields(e) {
+			fields = append(fields, f, respBulk(e.Fields[f]))
+		}
+		out[i] = []interface{}{e.ID.String(), fields}
+	}
+	return out
+}
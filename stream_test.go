@@ -0,0 +1,306 @@
+package hermes
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/themedef/go-hermes/internal/types"
+)
+
+func streamIDs(entries []StreamEntry) []string {
+	ids := make([]string, len(entries))
+	for i, e := range entries {
+		ids[i] = e.ID.String()
+	}
+	return ids
+}
+
+func equalIDs(got []StreamEntry, want ...string) bool {
+	ids := streamIDs(got)
+	if len(ids) != len(want) {
+		return false
+	}
+	for i := range ids {
+		if ids[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func xfields(kv ...string) map[string]interface{} {
+	m := make(map[string]interface{}, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		m[kv[i]] = kv[i+1]
+	}
+	return m
+}
+
+// TestStoreXAddAndRange checks ID generation, XRANGE/XREVRANGE bounds and XTRIM.
+func TestStoreXAddAndRange(t *testing.T) {
+	db := withTestStore(t)
+	ctx := context.Background()
+
+	// Scenario 1: Explicit, partial and generated IDs
+	for _, id := range []string{"1-1", "1-*", "5"} {
+		if _, err := db.XAdd(ctx, "s", id, xfields("n", id)); err != nil {
+			t.Fatalf("XAdd(%s) failed: %v", id, err)
+		}
+	}
+	auto, err := db.XAdd(ctx, "s", "*", xfields("n", "auto"))
+	if err != nil || auto.Ms < uint64(time.Now().Add(-time.Minute).UnixMilli()) {
+		t.Fatalf("XAdd(*) = %v (err=%v)", auto, err)
+	}
+
+	// Scenario 2: IDs must grow
+	if _, err := db.XAdd(ctx, "s", "5-0", xfields("n", "dup")); !IsStreamIDTooSmall(err) {
+		t.Errorf("Expected ErrStreamIDTooSmall, got %v", err)
+	}
+	if _, err := db.XAdd(ctx, "s", "x-1", xfields("n", "bad")); !IsInvalidStreamID(err) {
+		t.Errorf("Expected ErrInvalidStreamID, got %v", err)
+	}
+	if _, err := db.XAdd(ctx, "empty", "*", nil); err == nil {
+		t.Error("Expected error for XAdd without fields")
+	}
+
+	// Scenario 3: Ranges
+	got, _ := db.XRange(ctx, "s", "-", "+", 0)
+	if !equalIDs(got, "1-1", "1-2", "5-0", auto.String()) {
+		t.Errorf("XRange all = %v", streamIDs(got))
+	}
+	if got[0].Fields["n"] != "1-1" {
+		t.Errorf("Fields = %v", got[0].Fields)
+	}
+	got, _ = db.XRange(ctx, "s", "1", "(5-0", 0)
+	if !equalIDs(got, "1-1", "1-2") {
+		t.Errorf("XRange 1 (5-0 = %v", streamIDs(got))
+	}
+	got, _ = db.XRevRange(ctx, "s", "+", "-", 2)
+	if !equalIDs(got, auto.String(), "5-0") {
+		t.Errorf("XRevRange count 2 = %v", streamIDs(got))
+	}
+	if _, err := db.XRange(ctx, "missing", "-", "+", 0); !IsKeyNotFound(err) {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+
+	// Scenario 4: Trimming keeps the newest entries and the last ID
+	if n, _ := db.XTrim(ctx, "s", 1); n != 3 {
+		t.Errorf("XTrim removed %d, want 3", n)
+	}
+	if n, _ := db.XLen(ctx, "s"); n != 1 {
+		t.Errorf("XLen = %d, want 1", n)
+	}
+	if _, err := db.XAdd(ctx, "s", "5-1", xfields("n", "old")); !IsStreamIDTooSmall(err) {
+		t.Errorf("Expected ErrStreamIDTooSmall after trim, got %v", err)
+	}
+	if typ, _ := db.Type(ctx, "s"); typ != types.Stream {
+		t.Errorf("Type = %v, want stream", typ)
+	}
+}
+
+// TestStoreXReadBlocking checks that XRead waits for entries and times out.
+func TestStoreXReadBlocking(t *testing.T) {
+	db := withTestStore(t)
+	ctx := context.Background()
+	db.XAdd(ctx, "s", "1-1", xfields("a", "1"))
+
+	// Scenario 1: Reading after an ID returns what follows it
+	reads, err := db.XRead(ctx, XReadOptions{}, []string{"s", "missing"}, []string{"0", "0"})
+	if err != nil || len(reads) != 1 || reads[0].Key != "s" || !equalIDs(reads[0].Entries, "1-1") {
+		t.Fatalf("XRead = %+v (err=%v)", reads, err)
+	}
+
+	// Scenario 2: "$" blocks until a new entry arrives
+	done := make(chan []StreamRead, 1)
+	go func() {
+		reads, _ := db.XRead(ctx, XReadOptions{Block: true, Timeout: 5 * time.Second}, []string{"s"}, []string{"$"})
+		done <- reads
+	}()
+	time.Sleep(50 * time.Millisecond)
+	db.XAdd(ctx, "s", "2-1", xfields("b", "2"))
+	select {
+	case reads := <-done:
+		if len(reads) != 1 || !equalIDs(reads[0].Entries, "2-1") {
+			t.Errorf("Blocked XRead = %+v", reads)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Blocked XRead was not woken")
+	}
+
+	// Scenario 3: The timeout yields an empty result
+	start := time.Now()
+	reads, err = db.XRead(ctx, XReadOptions{Block: true, Timeout: 50 * time.Millisecond}, []string{"s"}, []string{"$"})
+	if err != nil || len(reads) != 0 || time.Since(start) < 50*time.Millisecond {
+		t.Errorf("Timed out XRead = %+v (err=%v) after %v", reads, err, time.Since(start))
+	}
+
+	// Scenario 4: Canceling the context ends the wait
+	cctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := db.XRead(cctx, XReadOptions{Block: true}, []string{"s"}, []string{"$"}); !IsContextCanceled(err) {
+		t.Errorf("Expected ErrContextCanceled, got %v", err)
+	}
+}
+
+// TestStoreConsumerGroups checks XREADGROUP delivery, the pending entries
+// list, XACK and XCLAIM.
+func TestStoreConsumerGroups(t *testing.T) {
+	db := withTestStore(t)
+	ctx := context.Background()
+	for _, id := range []string{"1-1", "2-1", "3-1"} {
+		db.XAdd(ctx, "jobs", id, xfields("job", id))
+	}
+
+	// Scenario 1: Group creation
+	if err := db.XGroupCreate(ctx, "jobs", "workers", "0", false); err != nil {
+		t.Fatalf("XGroupCreate failed: %v", err)
+	}
+	if err := db.XGroupCreate(ctx, "jobs", "workers", "0", false); !IsGroupExists(err) {
+		t.Errorf("Expected ErrGroupExists, got %v", err)
+	}
+	if err := db.XGroupCreate(ctx, "nostream", "g", "$", false); !IsKeyNotFound(err) {
+		t.Errorf("Expected ErrKeyNotFound without MKSTREAM, got %v", err)
+	}
+	if _, err := db.XReadGroup(ctx, "nogroup", "c", XReadOptions{}, []string{"jobs"}, []string{">"}); !IsGroupNotFound(err) {
+		t.Errorf("Expected ErrGroupNotFound, got %v", err)
+	}
+
+	// Scenario 2: Consumers share the new entries
+	a, _ := db.XReadGroup(ctx, "workers", "alice", XReadOptions{Count: 2}, []string{"jobs"}, []string{">"})
+	b, _ := db.XReadGroup(ctx, "workers", "bob", XReadOptions{}, []string{"jobs"}, []string{">"})
+	if len(a) != 1 || !equalIDs(a[0].Entries, "1-1", "2-1") || len(b) != 1 || !equalIDs(b[0].Entries, "3-1") {
+		t.Fatalf("Deliveries: alice=%+v bob=%+v", a, b)
+	}
+	if none, _ := db.XReadGroup(ctx, "workers", "bob", XReadOptions{}, []string{"jobs"}, []string{">"}); len(none) != 0 {
+		t.Errorf("Expected nothing new, got %+v", none)
+	}
+
+	// Scenario 3: Pending entries and history reads
+	pending, _ := db.XPending(ctx, "jobs", "workers", "")
+	if len(pending) != 3 || pending[0].Consumer != "alice" || pending[2].Consumer != "bob" || pending[0].Deliveries != 1 {
+		t.Errorf("XPending = %+v", pending)
+	}
+	history, _ := db.XReadGroup(ctx, "workers", "alice", XReadOptions{}, []string{"jobs"}, []string{"0"})
+	if len(history) != 1 || !equalIDs(history[0].Entries, "1-1", "2-1") {
+		t.Errorf("History = %+v", history)
+	}
+
+	// Scenario 4: Acknowledging removes entries from the list
+	if n, _ := db.XAck(ctx, "jobs", "workers", "1-1", "1-1", "9-9"); n != 1 {
+		t.Errorf("XAck = %d, want 1", n)
+	}
+	if pending, _ := db.XPending(ctx, "jobs", "workers", "alice"); len(pending) != 1 || pending[0].ID.String() != "2-1" {
+		t.Errorf("XPending alice = %+v", pending)
+	}
+
+	// Scenario 5: Claiming respects the idle time and counts a delivery
+	if claimed, _ := db.XClaim(ctx, "jobs", "workers", "carol", time.Hour, "2-1"); len(claimed) != 0 {
+		t.Errorf("Claimed entries that were not idle: %+v", claimed)
+	}
+	claimed, err := db.XClaim(ctx, "jobs", "workers", "carol", 0, "2-1", "1-1")
+	if err != nil || !equalIDs(claimed, "2-1") {
+		t.Errorf("XClaim = %+v (err=%v)", claimed, err)
+	}
+	pending, _ = db.XPending(ctx, "jobs", "workers", "carol")
+	if len(pending) != 1 || pending[0].Deliveries != 2 {
+		t.Errorf("XPending carol = %+v", pending)
+	}
+
+	// Scenario 6: Trimmed pending entries come back without fields
+	db.XTrim(ctx, "jobs", 0)
+	history, _ = db.XReadGroup(ctx, "workers", "carol", XReadOptions{}, []string{"jobs"}, []string{"0"})
+	if len(history) != 1 || len(history[0].Entries) != 1 || history[0].Entries[0].Fields != nil {
+		t.Errorf("History after trim = %+v", history)
+	}
+
+	// Scenario 7: A blocked group read is woken by XADD
+	done := make(chan []StreamRead, 1)
+	go func() {
+		reads, _ := db.XReadGroup(ctx, "workers", "alice", XReadOptions{Block: true, Timeout: 5 * time.Second, NoAck: true}, []string{"jobs"}, []string{">"})
+		done <- reads
+	}()
+	time.Sleep(50 * time.Millisecond)
+	db.XAdd(ctx, "jobs", "4-1", xfields("job", "4-1"))
+	select {
+	case reads := <-done:
+		if len(reads) != 1 || !equalIDs(reads[0].Entries, "4-1") {
+			t.Errorf("Blocked XReadGroup = %+v", reads)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Blocked XReadGroup was not woken")
+	}
+	if pending, _ := db.XPending(ctx, "jobs", "workers", "alice"); len(pending) != 0 {
+		t.Errorf("NOACK delivery was added to the pending list: %+v", pending)
+	}
+
+	// Scenario 8: Destroying the group
+	if ok, _ := db.XGroupDestroy(ctx, "jobs", "workers"); !ok {
+		t.Error("XGroupDestroy reported no group")
+	}
+	if _, err := db.XPending(ctx, "jobs", "workers", ""); !IsGroupNotFound(err) {
+		t.Errorf("Expected ErrGroupNotFound after destroy, got %v", err)
+	}
+}
+
+// TestStreamPersistence checks that streams and their groups survive snapshots
+// and log replay.
+func TestStreamPersistence(t *testing.T) {
+	ctx := context.Background()
+	db := withTestStore(t)
+	db.XAdd(ctx, "s", "1-1", xfields("a", "1"))
+	db.XAdd(ctx, "s", "2-1", xfields("b", "2"))
+	db.XGroupCreate(ctx, "s", "g", "0", false)
+	db.XReadGroup(ctx, "g", "c", XReadOptions{Count: 1}, []string{"s"}, []string{">"})
+
+	// Scenario 1: Snapshot round trip
+	var buf bytes.Buffer
+	if err := db.SaveSnapshot(ctx, &buf); err != nil {
+		t.Fatalf("SaveSnapshot failed: %v", err)
+	}
+	restored := withTestStore(t)
+	if err := restored.LoadSnapshot(ctx, &buf); err != nil {
+		t.Fatalf("LoadSnapshot failed: %v", err)
+	}
+	got, _ := restored.XRange(ctx, "s", "-", "+", 0)
+	if !equalIDs(got, "1-1", "2-1") || got[1].Fields["b"] != "2" {
+		t.Errorf("Restored stream = %+v", got)
+	}
+	if pending, _ := restored.XPending(ctx, "s", "g", ""); len(pending) != 1 || pending[0].Consumer != "c" {
+		t.Errorf("Restored pending = %+v", pending)
+	}
+
+	// Scenario 2: Append-only log replay
+	path := t.TempDir() + "/stream.aof"
+	logged := openAOFStore(t, path, FsyncAlways)
+	logged.XAdd(ctx, "s", "*", xfields("a", "1"))
+	logged.XAdd(ctx, "s", "*", xfields("b", "2"))
+	logged.XAdd(ctx, "s", "*", xfields("c", "3"))
+	logged.XGroupCreate(ctx, "s", "g", "0", false)
+	logged.XReadGroup(ctx, "g", "c1", XReadOptions{Count: 2}, []string{"s"}, []string{">"})
+	all, _ := logged.XRange(ctx, "s", "-", "+", 0)
+	logged.XAck(ctx, "s", "g", all[0].ID.String())
+	logged.XClaim(ctx, "s", "g", "c2", 0, all[1].ID.String())
+	logged.XTrim(ctx, "s", 2)
+	want, _ := logged.XRange(ctx, "s", "-", "+", 0)
+	wantPending, _ := logged.XPending(ctx, "s", "g", "")
+	if err := logged.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	replayed := openAOFStore(t, path, FsyncAlways)
+	got, _ = replayed.XRange(ctx, "s", "-", "+", 0)
+	if !equalIDs(got, streamIDs(want)...) {
+		t.Errorf("Replayed stream = %v, want %v", streamIDs(got), streamIDs(want))
+	}
+	pending, _ := replayed.XPending(ctx, "s", "g", "")
+	if len(pending) != 1 || pending[0].ID != wantPending[0].ID || pending[0].Consumer != "c2" || pending[0].Deliveries != 2 {
+		t.Errorf("Replayed pending = %+v, want %+v", pending, wantPending)
+	}
+	// The group goes on from where it was: only the third entry is new.
+	reads, _ := replayed.XReadGroup(ctx, "g", "c1", XReadOptions{}, []string{"s"}, []string{">"})
+	if len(reads) != 1 || !equalIDs(reads[0].Entries, streamIDs(want)[1]) {
+		t.Errorf("Replayed group position: %+v", reads)
+	}
+}
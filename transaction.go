@@ -12,13 +12,42 @@ import (
 type Transaction struct {
 	mu       sync.Mutex
 	db       contracts.StoreHandler
-	commands []func() error
+	commands []txCommand
 	rollback []func()
 	active   bool
+	readOnly bool
+	overlay  *DB
+	staged   map[string]bool
+	watcher  keyWatcher
+	watched  map[string]keyVersion
+	locker   shardLocker
+	keys     []string
+	allKeys  bool
+	held     heldShards
+	undoCtx  context.Context
+
+	savepoints []savepoint
+	parent     *Transaction
+	child      *Transaction
+}
+
+// txCommand is a queued operation. Commit saves a deep copy of each of its keys
+// right before running it; rolling back restores those copies, so an undo
+// reverts exactly what the operation changed, including aggregates it
+// modified in place.
+type txCommand struct {
+	op   string
+	keys []string
+	run  func() (interface{}, error)
 }
 
+type TxResult = types.TxResult
+
 func NewTransaction(db contracts.StoreHandler) contracts.TransactionHandler {
 	tx := &Transaction{db: db}
+	tx.watcher, _ = db.(keyWatcher)
+	tx.locker, _ = db.(shardLocker)
+	tx.undoCtx = withHeldShards(context.Background(), &tx.held)
 	_ = tx.begin()
 	return tx
 }
@@ -37,21 +66,52 @@ func (t *Transaction) begin() error {
 	return nil
 }
 
-func (t *Transaction) Commit() error {
+// Commit applies the queued operations and returns one result per operation, in
+// the order they were queued.
+func (t *Transaction) Commit() ([]TxResult, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	if !t.active {
-		return ErrTransactionNotActive
+		return nil, ErrTransactionNotActive
+	}
+	if t.child != nil {
+		return nil, ErrNestedTransactionOpen
+	}
+	if t.parent != nil {
+		return nil, t.mergeIntoParent()
 	}
 	defer t.clear()
+
+	// Every shard the transaction touches stays locked until all operations
+	// (and, on failure, their undo) have run, so other clients observe either
+	// none or all of its effects.
+	unlock := t.lock()
+	defer unlock()
+
+	if key, changed := t.changedWatchedKey(); changed {
+		t.db.Logger().Warn("Transaction aborted: watched key changed", "key", key)
+		return nil, ErrTransactionConflict
+	}
+	results := make([]TxResult, 0, len(t.commands))
 	for _, cmd := range t.commands {
-		if err := cmd(); err != nil {
+		undo, err := t.saveKeys(cmd.keys)
+		var value interface{}
+		if err == nil {
+			t.rollback = append(t.rollback, undo)
+			value, err = cmd.run()
+		}
+		if err != nil {
 			t.rollbackCommands()
-			return fmt.Errorf("%w: %v", ErrTransactionFailed, err)
+			return nil, fmt.Errorf("%w: %s: %v", ErrTransactionFailed, cmd.op, err)
+		}
+		result := TxResult{Op: cmd.op, Value: value}
+		if len(cmd.keys) > 0 {
+			result.Key = cmd.keys[0]
 		}
+		results = append(results, result)
 	}
 	t.db.Logger().Info("Transaction committed")
-	return nil
+	return results, nil
 }
 
 func (t *Transaction) Rollback() error {
@@ -60,6 +120,9 @@ func (t *Transaction) Rollback() error {
 	if !t.active {
 		return nil
 	}
+	if t.parent != nil {
+		t.detach()
+	}
 	t.rollbackCommands()
 	t.clear()
 	t.db.Logger().Info("Transaction rolled back")
@@ -73,13 +136,235 @@ func (t *Transaction) rollbackCommands() {
 }
 
 func (t *Transaction) clear() {
+	t.unwatchAll()
 	t.commands = nil
 	t.rollback = nil
+	t.keys = nil
+	t.allKeys = false
+	t.overlay = nil
+	t.staged = nil
+	t.savepoints = nil
+	t.child = nil
 	t.active = false
 }
 
+// bind records the keys a queued operation touches, so Commit can lock their
+// shards, and returns the context the operation runs with at commit time.
+func (t *Transaction) bind(ctx context.Context, keys ...string) context.Context {
+	t.keys = append(t.keys, keys...)
+	ctx = withEventOrigin(ctx, types.OriginTransaction)
+	return withHeldShards(ctx, &t.root().held)
+}
+
+// add queues run, an operation that writes keys. run is also applied to the
+// transaction's overlay right away, so later reads inside the transaction see
+// its effect; an error it returns there is left for Commit to report, exactly
+// as it would have been without the overlay.
+func (t *Transaction) add(ctx context.Context, op string, keys []string, run func(ctx context.Context, db contracts.StoreHandler) (interface{}, error)) error {
+	if t.readOnly {
+		return fmt.Errorf("%w: %s", ErrTransactionReadOnly, op)
+	}
+	if t.child != nil {
+		return ErrNestedTransactionOpen
+	}
+	if err := t.stage(ctx, keys); err != nil {
+		return err
+	}
+	_, _ = run(ctx, t.overlay)
+
+	ctx = t.bind(ctx, keys...)
+	t.commands = append(t.commands, txCommand{op: op, keys: keys, run: func() (interface{}, error) {
+		return run(ctx, t.db)
+	}})
+	return nil
+}
+
+// stage copies keys into the overlay the first time the transaction writes them.
+func (t *Transaction) stage(ctx context.Context, keys []string) error {
+	if t.overlay == nil {
+		t.overlay = newOverlayDB()
+		t.staged = make(map[string]bool)
+	}
+	for _, key := range keys {
+		if t.staged[key] {
+			continue
+		}
+		entry, existed, err := t.viewEntry(ctx, key)
+		if err != nil {
+			return err
+		}
+		if existed {
+			t.overlay.shards[0].data[key] = storedList(entry)
+		}
+		t.staged[key] = true
+	}
+	return nil
+}
+
+// reader returns where reads of key are served from: the overlay once the
+// transaction has written the key, the parent transaction's view for a nested
+// transaction, the store otherwise.
+func (t *Transaction) reader(key string) contracts.StoreHandler {
+	if t.staged[key] {
+		return t.overlay
+	}
+	if p := t.parent; p != nil {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.reader(key)
+	}
+	return t.db
+}
+
+// viewEntry returns a copy of key as reads through the transaction see it.
+func (t *Transaction) viewEntry(ctx context.Context, key string) (types.Entry, bool, error) {
+	if t.staged[key] {
+		return rawEntryOrNil(ctx, t.overlay, key)
+	}
+	if p := t.parent; p != nil {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.viewEntry(ctx, key)
+	}
+	return rawEntryOrNil(ctx, t.db, key)
+}
+
+// saveKeys copies the current entries of keys and returns a function that puts
+// them back, deleting keys that did not exist. Commit calls it with the shard
+// locks held.
+func (t *Transaction) saveKeys(keys []string) (func(), error) {
+	type saved struct {
+		key     string
+		entry   types.Entry
+		existed bool
+	}
+	entries := make([]saved, 0, len(keys))
+	for _, key := range keys {
+		entry, existed, err := t.getRawEntryOrNil(t.undoCtx, key)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, saved{key: key, entry: entry, existed: existed})
+	}
+	return func() {
+		for i := len(entries) - 1; i >= 0; i-- {
+			e := entries[i]
+			if e.existed {
+				_ = t.db.RestoreRawEntry(t.undoCtx, e.key, e.entry)
+			} else if exists, _ := t.db.Exists(t.undoCtx, e.key); exists {
+				_ = t.db.Delete(t.undoCtx, e.key)
+			}
+		}
+	}, nil
+}
+
+// lock acquires the shard locks of every queued and watched key.
+func (t *Transaction) lock() func() {
+	if t.locker == nil {
+		return noUnlock
+	}
+	keys := make([]string, 0, len(t.keys)+len(t.watched))
+	keys = append(keys, t.keys...)
+	for key := range t.watched {
+		keys = append(keys, key)
+	}
+	shards, unlock := t.locker.lockKeys(keys, t.allKeys)
+	t.held.shards = shards
+	return func() {
+		t.held.shards = nil
+		unlock()
+	}
+}
+
+// Watch makes Commit fail with ErrTransactionConflict if any of the keys is
+// written, deleted or expires before the transaction commits. Watch keys before
+// reading the values the queued operations depend on.
+func (t *Transaction) Watch(ctx context.Context, keys ...string) error {
+	select {
+	case <-ctx.Done():
+		t.db.Logger().Warn("Watch operation canceled", "keys", keys)
+		return ErrContextCanceled
+	default:
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return ErrTransactionNotActive
+	}
+	if len(keys) == 0 {
+		return ErrEmptyValues
+	}
+	if t.watcher == nil {
+		return fmt.Errorf("watch is not supported by %T", t.db)
+	}
+	if t.watched == nil {
+		t.watched = make(map[string]keyVersion, len(keys))
+	}
+	for _, key := range keys {
+		if key == "" {
+			return ErrInvalidKey
+		}
+		if _, ok := t.watched[key]; ok {
+			continue
+		}
+		t.watched[key] = t.watcher.watchKey(key)
+	}
+	t.db.Logger().Info("Transaction watching keys", "keys", keys)
+	return nil
+}
+
+// Unwatch forgets every watched key; queued operations are kept.
+func (t *Transaction) Unwatch() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return ErrTransactionNotActive
+	}
+	t.unwatchAll()
+	return nil
+}
+
+func (t *Transaction) unwatchAll() {
+	for key := range t.watched {
+		t.watcher.unwatchKey(key)
+	}
+	t.watched = nil
+}
+
+func (t *Transaction) changedWatchedKey() (string, bool) {
+	for key, v := range t.watched {
+		if t.watcher.keyChanged(t.undoCtx, key, v) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// queue adds an arbitrary operation touching keys, or any key when all is set,
+// to the transaction. No undo is recorded for it. CommandAPI uses it to run the
+// commands queued between MULTI and EXEC, which report errors per command
+// instead of failing the commit.
+func (t *Transaction) queue(ctx context.Context, op string, keys []string, all bool, cmd func(ctx context.Context) (interface{}, error)) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return ErrTransactionNotActive
+	}
+	ctx = t.bind(ctx, keys...)
+	t.allKeys = t.allKeys || all
+	t.commands = append(t.commands, txCommand{op: op, run: func() (interface{}, error) {
+		return cmd(ctx)
+	}})
+	return nil
+}
+
 func (t *Transaction) getRawEntryOrNil(ctx context.Context, key string) (entry types.Entry, existed bool, err error) {
-	entry, err = t.db.GetRawEntry(ctx, key)
+	return rawEntryOrNil(ctx, t.db, key)
+}
+
+func rawEntryOrNil(ctx context.Context, db contracts.StoreHandler, key string) (entry types.Entry, existed bool, err error) {
+	entry, err = db.GetRawEntry(ctx, key)
 	if err != nil {
 		if IsKeyNotFound(err) || IsKeyExpired(err) {
 			return types.Entry{}, false, nil
@@ -95,21 +380,9 @@ func (t *Transaction) Set(ctx context.Context, key string, value interface{}, tt
 	if !t.active {
 		return ErrTransactionNotActive
 	}
-	oldEntry, existed, err := t.getRawEntryOrNil(ctx, key)
-	if err != nil {
-		return err
-	}
-	t.commands = append(t.commands, func() error {
-		return t.db.Set(ctx, key, value, ttl)
-	})
-	t.rollback = append(t.rollback, func() {
-		if existed {
-			_ = t.db.RestoreRawEntry(context.Background(), key, oldEntry)
-		} else {
-			_ = t.db.Delete(context.Background(), key)
-		}
+	return t.add(ctx, "Set", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		return nil, db.Set(ctx, key, value, ttl)
 	})
-	return nil
 }
 
 func (t *Transaction) SetNX(ctx context.Context, key string, value interface{}, ttl int) error {
@@ -118,22 +391,9 @@ func (t *Transaction) SetNX(ctx context.Context, key string, value interface{},
 	if !t.active {
 		return ErrTransactionNotActive
 	}
-	oldEntry, existed, err := t.getRawEntryOrNil(ctx, key)
-	if err != nil {
-		return err
-	}
-	t.commands = append(t.commands, func() error {
-		_, setErr := t.db.SetNX(ctx, key, value, ttl)
-		return setErr
-	})
-	t.rollback = append(t.rollback, func() {
-		if existed {
-			_ = t.db.RestoreRawEntry(context.Background(), key, oldEntry)
-		} else {
-			_ = t.db.Delete(context.Background(), key)
-		}
+	return t.add(ctx, "SetNX", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		return db.SetNX(ctx, key, value, ttl)
 	})
-	return nil
 }
 
 func (t *Transaction) SetXX(ctx context.Context, key string, value interface{}, ttl int) error {
@@ -142,28 +402,16 @@ func (t *Transaction) SetXX(ctx context.Context, key string, value interface{},
 	if !t.active {
 		return ErrTransactionNotActive
 	}
-	oldEntry, existed, err := t.getRawEntryOrNil(ctx, key)
-	if err != nil {
-		return err
-	}
-	t.commands = append(t.commands, func() error {
-		ok, xxErr := t.db.SetXX(ctx, key, value, ttl)
+	return t.add(ctx, "SetXX", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		ok, xxErr := db.SetXX(ctx, key, value, ttl)
 		if xxErr != nil {
-			return xxErr
+			return nil, xxErr
 		}
 		if !ok {
-			return ErrKeyNotFound
-		}
-		return nil
-	})
-	t.rollback = append(t.rollback, func() {
-		if existed {
-			_ = t.db.RestoreRawEntry(context.Background(), key, oldEntry)
-		} else {
-			_ = t.db.Delete(context.Background(), key)
+			return nil, ErrKeyNotFound
 		}
+		return true, nil
 	})
-	return nil
 }
 
 func (t *Transaction) Get(ctx context.Context, key string) (interface{}, error) {
@@ -172,7 +420,7 @@ func (t *Transaction) Get(ctx context.Context, key string) (interface{}, error)
 	if !t.active {
 		return nil, ErrTransactionNotActive
 	}
-	return t.db.Get(ctx, key)
+	return t.reader(key).Get(ctx, key)
 }
 
 func (t *Transaction) SetCAS(ctx context.Context, key string, oldValue, newValue interface{}, ttl int) error {
@@ -181,21 +429,9 @@ func (t *Transaction) SetCAS(ctx context.Context, key string, oldValue, newValue
 	if !t.active {
 		return ErrTransactionNotActive
 	}
-	oldEntry, existed, err := t.getRawEntryOrNil(ctx, key)
-	if err != nil {
-		return err
-	}
-	t.commands = append(t.commands, func() error {
-		return t.db.SetCAS(ctx, key, oldValue, newValue, ttl)
-	})
-	t.rollback = append(t.rollback, func() {
-		if existed {
-			_ = t.db.RestoreRawEntry(context.Background(), key, oldEntry)
-		} else {
-			_ = t.db.Delete(context.Background(), key)
-		}
+	return t.add(ctx, "SetCAS", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		return nil, db.SetCAS(ctx, key, oldValue, newValue, ttl)
 	})
-	return nil
 }
 
 func (t *Transaction) GetSet(ctx context.Context, key string, newValue interface{}, ttl int) (interface{}, error) {
@@ -204,25 +440,16 @@ func (t *Transaction) GetSet(ctx context.Context, key string, newValue interface
 	if !t.active {
 		return nil, ErrTransactionNotActive
 	}
-	oldEntry, existed, err := t.getRawEntryOrNil(ctx, key)
+	var old interface{}
+	var getErr error
+	err := t.add(ctx, "GetSet", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		old, getErr = db.GetSet(ctx, key, newValue, ttl)
+		return old, getErr
+	})
 	if err != nil {
 		return nil, err
 	}
-	t.commands = append(t.commands, func() error {
-		_, err := t.db.GetSet(ctx, key, newValue, ttl)
-		return err
-	})
-	t.rollback = append(t.rollback, func() {
-		if existed {
-			_ = t.db.RestoreRawEntry(context.Background(), key, oldEntry)
-		} else {
-			_ = t.db.Delete(context.Background(), key)
-		}
-	})
-	if !existed {
-		return nil, nil
-	}
-	return oldEntry.Value, nil
+	return old, getErr
 }
 
 func (t *Transaction) Incr(ctx context.Context, key string) error {
@@ -231,22 +458,9 @@ func (t *Transaction) Incr(ctx context.Context, key string) error {
 	if !t.active {
 		return ErrTransactionNotActive
 	}
-	oldEntry, existed, err := t.getRawEntryOrNil(ctx, key)
-	if err != nil {
-		return err
-	}
-	t.commands = append(t.commands, func() error {
-		_, incrErr := t.db.Incr(ctx, key)
-		return incrErr
-	})
-	t.rollback = append(t.rollback, func() {
-		if existed {
-			_ = t.db.RestoreRawEntry(context.Background(), key, oldEntry)
-		} else {
-			_ = t.db.Delete(context.Background(), key)
-		}
+	return t.add(ctx, "Incr", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		return db.Incr(ctx, key)
 	})
-	return nil
 }
 
 func (t *Transaction) Decr(ctx context.Context, key string) error {
@@ -255,22 +469,9 @@ func (t *Transaction) Decr(ctx context.Context, key string) error {
 	if !t.active {
 		return ErrTransactionNotActive
 	}
-	oldEntry, existed, err := t.getRawEntryOrNil(ctx, key)
-	if err != nil {
-		return err
-	}
-	t.commands = append(t.commands, func() error {
-		_, decrErr := t.db.Decr(ctx, key)
-		return decrErr
-	})
-	t.rollback = append(t.rollback, func() {
-		if existed {
-			_ = t.db.RestoreRawEntry(context.Background(), key, oldEntry)
-		} else {
-			_ = t.db.Delete(context.Background(), key)
-		}
+	return t.add(ctx, "Decr", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		return db.Decr(ctx, key)
 	})
-	return nil
 }
 
 func (t *Transaction) IncrBy(ctx context.Context, key string, increment int64) error {
@@ -279,22 +480,9 @@ func (t *Transaction) IncrBy(ctx context.Context, key string, increment int64) e
 	if !t.active {
 		return ErrTransactionNotActive
 	}
-	oldEntry, existed, err := t.getRawEntryOrNil(ctx, key)
-	if err != nil {
-		return err
-	}
-	t.commands = append(t.commands, func() error {
-		_, err := t.db.IncrBy(ctx, key, increment)
-		return err
-	})
-	t.rollback = append(t.rollback, func() {
-		if existed {
-			_ = t.db.RestoreRawEntry(context.Background(), key, oldEntry)
-		} else {
-			_ = t.db.Delete(context.Background(), key)
-		}
+	return t.add(ctx, "IncrBy", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		return db.IncrBy(ctx, key, increment)
 	})
-	return nil
 }
 
 func (t *Transaction) DecrBy(ctx context.Context, key string, decrement int64) error {
@@ -307,21 +495,9 @@ func (t *Transaction) LPush(ctx context.Context, key string, values ...interface
 	if !t.active {
 		return ErrTransactionNotActive
 	}
-	oldEntry, existed, err := t.getRawEntryOrNil(ctx, key)
-	if err != nil {
-		return err
-	}
-	t.commands = append(t.commands, func() error {
-		return t.db.LPush(ctx, key, values...)
-	})
-	t.rollback = append(t.rollback, func() {
-		if existed {
-			_ = t.db.RestoreRawEntry(context.Background(), key, oldEntry)
-		} else {
-			_ = t.db.Delete(context.Background(), key)
-		}
+	return t.add(ctx, "LPush", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		return db.LPush(ctx, key, values...)
 	})
-	return nil
 }
 
 func (t *Transaction) RPush(ctx context.Context, key string, values ...interface{}) error {
@@ -330,21 +506,9 @@ func (t *Transaction) RPush(ctx context.Context, key string, values ...interface
 	if !t.active {
 		return ErrTransactionNotActive
 	}
-	oldEntry, existed, err := t.getRawEntryOrNil(ctx, key)
-	if err != nil {
-		return err
-	}
-	t.commands = append(t.commands, func() error {
-		return t.db.RPush(ctx, key, values...)
-	})
-	t.rollback = append(t.rollback, func() {
-		if existed {
-			_ = t.db.RestoreRawEntry(context.Background(), key, oldEntry)
-		} else {
-			_ = t.db.Delete(context.Background(), key)
-		}
+	return t.add(ctx, "RPush", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		return db.RPush(ctx, key, values...)
 	})
-	return nil
 }
 
 func (t *Transaction) LPop(ctx context.Context, key string) (interface{}, error) {
@@ -353,29 +517,16 @@ func (t *Transaction) LPop(ctx context.Context, key string) (interface{}, error)
 	if !t.active {
 		return nil, ErrTransactionNotActive
 	}
-	oldEntry, existed, err := t.getRawEntryOrNil(ctx, key)
+	var popped interface{}
+	var popErr error
+	err := t.add(ctx, "LPop", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		popped, popErr = db.LPop(ctx, key)
+		return popped, popErr
+	})
 	if err != nil {
 		return nil, err
 	}
-	t.commands = append(t.commands, func() error {
-		_, err := t.db.LPop(ctx, key)
-		return err
-	})
-	t.rollback = append(t.rollback, func() {
-		if existed {
-			_ = t.db.RestoreRawEntry(context.Background(), key, oldEntry)
-		} else {
-			_ = t.db.Delete(context.Background(), key)
-		}
-	})
-	if !existed {
-		return nil, ErrKeyNotFound
-	}
-	list, ok := oldEntry.Value.([]interface{})
-	if !ok || len(list) == 0 {
-		return nil, ErrEmptyList
-	}
-	return list[0], nil
+	return popped, popErr
 }
 
 func (t *Transaction) RPop(ctx context.Context, key string) (interface{}, error) {
@@ -384,29 +535,16 @@ func (t *Transaction) RPop(ctx context.Context, key string) (interface{}, error)
 	if !t.active {
 		return nil, ErrTransactionNotActive
 	}
-	oldEntry, existed, err := t.getRawEntryOrNil(ctx, key)
+	var popped interface{}
+	var popErr error
+	err := t.add(ctx, "RPop", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		popped, popErr = db.RPop(ctx, key)
+		return popped, popErr
+	})
 	if err != nil {
 		return nil, err
 	}
-	t.commands = append(t.commands, func() error {
-		_, err := t.db.RPop(ctx, key)
-		return err
-	})
-	t.rollback = append(t.rollback, func() {
-		if existed {
-			_ = t.db.RestoreRawEntry(context.Background(), key, oldEntry)
-		} else {
-			_ = t.db.Delete(context.Background(), key)
-		}
-	})
-	if !existed {
-		return nil, ErrKeyNotFound
-	}
-	list, ok := oldEntry.Value.([]interface{})
-	if !ok || len(list) == 0 {
-		return nil, ErrEmptyList
-	}
-	return list[len(list)-1], nil
+	return popped, popErr
 }
 
 func (t *Transaction) LLen(ctx context.Context, key string) (int, error) {
@@ -415,7 +553,7 @@ func (t *Transaction) LLen(ctx context.Context, key string) (int, error) {
 	if !t.active {
 		return 0, ErrTransactionNotActive
 	}
-	return t.db.LLen(ctx, key)
+	return t.reader(key).LLen(ctx, key)
 }
 
 func (t *Transaction) LRange(ctx context.Context, key string, start, end int) ([]interface{}, error) {
@@ -424,7 +562,7 @@ func (t *Transaction) LRange(ctx context.Context, key string, start, end int) ([
 	if !t.active {
 		return nil, ErrTransactionNotActive
 	}
-	return t.db.LRange(ctx, key, start, end)
+	return t.reader(key).LRange(ctx, key, start, end)
 }
 
 func (t *Transaction) LTrim(ctx context.Context, key string, start, stop int) error {
@@ -433,149 +571,329 @@ func (t *Transaction) LTrim(ctx context.Context, key string, start, stop int) er
 	if !t.active {
 		return ErrTransactionNotActive
 	}
-	oldEntry, existed, err := t.getRawEntryOrNil(ctx, key)
-	if err != nil {
-		return err
-	}
-	t.commands = append(t.commands, func() error {
-		return t.db.LTrim(ctx, key, start, stop)
-	})
-	t.rollback = append(t.rollback, func() {
-		if existed {
-			_ = t.db.RestoreRawEntry(context.Background(), key, oldEntry)
-		} else {
-			_ = t.db.Delete(context.Background(), key)
-		}
+	return t.add(ctx, "LTrim", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		return nil, db.LTrim(ctx, key, start, stop)
 	})
-	return nil
 }
 
-func (t *Transaction) HSet(ctx context.Context, key, field string, value interface{}, ttl int) error {
+func (t *Transaction) LIndex(ctx context.Context, key string, index int) (interface{}, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	if !t.active {
-		return ErrTransactionNotActive
-	}
-	oldEntry, existed, err := t.getRawEntryOrNil(ctx, key)
-	if err != nil {
-		return err
+		return nil, ErrTransactionNotActive
 	}
-	t.commands = append(t.commands, func() error {
-		return t.db.HSet(ctx, key, field, value, ttl)
-	})
-	t.rollback = append(t.rollback, func() {
-		if existed {
-			_ = t.db.RestoreRawEntry(context.Background(), key, oldEntry)
-		} else {
-			_ = t.db.Delete(context.Background(), key)
-		}
-	})
-	return nil
+	return t.reader(key).LIndex(ctx, key, index)
 }
 
-func (t *Transaction) HGet(ctx context.Context, key, field string) (interface{}, error) {
+func (t *Transaction) LSet(ctx context.Context, key string, index int, value interface{}) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	if !t.active {
-		return nil, ErrTransactionNotActive
+		return ErrTransactionNotActive
 	}
-	return t.db.HGet(ctx, key, field)
+	return t.add(ctx, "LSet", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		return nil, db.LSet(ctx, key, index, value)
+	})
 }
 
-func (t *Transaction) HDel(ctx context.Context, key, field string) error {
+func (t *Transaction) LInsert(ctx context.Context, key string, before bool, pivot, value interface{}) (int, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	if !t.active {
-		return ErrTransactionNotActive
+		return 0, ErrTransactionNotActive
 	}
-	oldEntry, existed, err := t.getRawEntryOrNil(ctx, key)
+	var length int
+	var insertErr error
+	err := t.add(ctx, "LInsert", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		length, insertErr = db.LInsert(ctx, key, before, pivot, value)
+		return length, insertErr
+	})
 	if err != nil {
-		return err
+		return 0, err
 	}
-	t.commands = append(t.commands, func() error {
-		return t.db.HDel(ctx, key, field)
-	})
-	t.rollback = append(t.rollback, func() {
-		if existed {
-			_ = t.db.RestoreRawEntry(context.Background(), key, oldEntry)
-		} else {
-			_ = t.db.Delete(context.Background(), key)
-		}
-	})
-	return nil
+	return length, insertErr
 }
 
-func (t *Transaction) HGetAll(ctx context.Context, key string) (map[string]interface{}, error) {
+func (t *Transaction) LRem(ctx context.Context, key string, count int, value interface{}) (int, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	if !t.active {
-		return nil, ErrTransactionNotActive
+		return 0, ErrTransactionNotActive
+	}
+	var removed int
+	var remErr error
+	err := t.add(ctx, "LRem", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		removed, remErr = db.LRem(ctx, key, count, value)
+		return removed, remErr
+	})
+	if err != nil {
+		return 0, err
 	}
-	return t.db.HGetAll(ctx, key)
+	return removed, remErr
 }
 
-func (t *Transaction) HExists(ctx context.Context, key, field string) (bool, error) {
+func (t *Transaction) LPos(ctx context.Context, key string, value interface{}, opts types.LPosOptions) ([]int, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	if !t.active {
-		return false, ErrTransactionNotActive
+		return nil, ErrTransactionNotActive
 	}
-	return t.db.HExists(ctx, key, field)
+	return t.reader(key).LPos(ctx, key, value, opts)
 }
 
-func (t *Transaction) HLen(ctx context.Context, key string) (int, error) {
+func (t *Transaction) LMove(ctx context.Context, source, destination string, from, to types.ListSide) (interface{}, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	if !t.active {
-		return 0, ErrTransactionNotActive
+		return nil, ErrTransactionNotActive
+	}
+	var moved interface{}
+	var moveErr error
+	err := t.add(ctx, "LMove", []string{source, destination}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		moved, moveErr = db.LMove(ctx, source, destination, from, to)
+		return moved, moveErr
+	})
+	if err != nil {
+		return nil, err
 	}
-	return t.db.HLen(ctx, key)
+	return moved, moveErr
 }
 
-func (t *Transaction) SAdd(ctx context.Context, key string, members ...interface{}) error {
+func (t *Transaction) RPopLPush(ctx context.Context, source, destination string) (interface{}, error) {
+	return t.LMove(ctx, source, destination, types.ListRight, types.ListLeft)
+}
+
+func (t *Transaction) HSet(ctx context.Context, key, field string, value interface{}, ttl int) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	if !t.active {
 		return ErrTransactionNotActive
 	}
-	oldEntry, existed, err := t.getRawEntryOrNil(ctx, key)
-	if err != nil {
-		return err
-	}
-	t.commands = append(t.commands, func() error {
-		return t.db.SAdd(ctx, key, members...)
-	})
-	t.rollback = append(t.rollback, func() {
-		if existed {
-			_ = t.db.RestoreRawEntry(context.Background(), key, oldEntry)
-		} else {
-			_ = t.db.Delete(context.Background(), key)
-		}
+	return t.add(ctx, "HSet", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		return nil, db.HSet(ctx, key, field, value, ttl)
 	})
-	return nil
 }
 
-func (t *Transaction) SRem(ctx context.Context, key string, members ...interface{}) error {
+func (t *Transaction) HGet(ctx context.Context, key, field string) (interface{}, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return nil, ErrTransactionNotActive
+	}
+	return t.reader(key).HGet(ctx, key, field)
+}
+
+func (t *Transaction) HDel(ctx context.Context, key, field string) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	if !t.active {
 		return ErrTransactionNotActive
 	}
-	oldEntry, existed, err := t.getRawEntryOrNil(ctx, key)
+	return t.add(ctx, "HDel", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		return nil, db.HDel(ctx, key, field)
+	})
+}
+
+func (t *Transaction) HGetAll(ctx context.Context, key string) (map[string]interface{}, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return nil, ErrTransactionNotActive
+	}
+	return t.reader(key).HGetAll(ctx, key)
+}
+
+func (t *Transaction) HExists(ctx context.Context, key, field string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return false, ErrTransactionNotActive
+	}
+	return t.reader(key).HExists(ctx, key, field)
+}
+
+func (t *Transaction) HLen(ctx context.Context, key string) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return 0, ErrTransactionNotActive
+	}
+	return t.reader(key).HLen(ctx, key)
+}
+
+func (t *Transaction) HMSet(ctx context.Context, key string, fields map[string]interface{}) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return 0, ErrTransactionNotActive
+	}
+	var result int
+	var opErr error
+	err := t.add(ctx, "HMSet", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		result, opErr = db.HMSet(ctx, key, fields)
+		return result, opErr
+	})
 	if err != nil {
-		return err
+		return 0, err
 	}
-	t.commands = append(t.commands, func() error {
-		return t.db.SRem(ctx, key, members...)
+	return result, opErr
+}
+
+func (t *Transaction) HMGet(ctx context.Context, key string, fields ...string) ([]interface{}, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return nil, ErrTransactionNotActive
+	}
+	return t.reader(key).HMGet(ctx, key, fields...)
+}
+
+func (t *Transaction) HSetNX(ctx context.Context, key, field string, value interface{}) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return false, ErrTransactionNotActive
+	}
+	var result bool
+	var opErr error
+	err := t.add(ctx, "HSetNX", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		result, opErr = db.HSetNX(ctx, key, field, value)
+		return result, opErr
 	})
-	t.rollback = append(t.rollback, func() {
-		if existed {
-			_ = t.db.RestoreRawEntry(context.Background(), key, oldEntry)
-		} else {
-			_ = t.db.Delete(context.Background(), key)
-		}
+	if err != nil {
+		return false, err
+	}
+	return result, opErr
+}
+
+func (t *Transaction) HIncrBy(ctx context.Context, key, field string, increment int64) (int64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return 0, ErrTransactionNotActive
+	}
+	var result int64
+	var opErr error
+	err := t.add(ctx, "HIncrBy", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		result, opErr = db.HIncrBy(ctx, key, field, increment)
+		return result, opErr
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result, opErr
+}
+
+func (t *Transaction) HIncrByFloat(ctx context.Context, key, field string, increment float64) (float64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return 0, ErrTransactionNotActive
+	}
+	var result float64
+	var opErr error
+	err := t.add(ctx, "HIncrByFloat", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		result, opErr = db.HIncrByFloat(ctx, key, field, increment)
+		return result, opErr
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result, opErr
+}
+
+func (t *Transaction) HKeys(ctx context.Context, key string) ([]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return nil, ErrTransactionNotActive
+	}
+	return t.reader(key).HKeys(ctx, key)
+}
+
+func (t *Transaction) HVals(ctx context.Context, key string) ([]interface{}, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return nil, ErrTransactionNotActive
+	}
+	return t.reader(key).HVals(ctx, key)
+}
+
+func (t *Transaction) HStrLen(ctx context.Context, key, field string) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return 0, ErrTransactionNotActive
+	}
+	return t.reader(key).HStrLen(ctx, key, field)
+}
+
+func (t *Transaction) HExpire(ctx context.Context, key string, ttl int, fields ...string) ([]int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return nil, ErrTransactionNotActive
+	}
+	var result []int
+	var opErr error
+	err := t.add(ctx, "HExpire", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		result, opErr = db.HExpire(ctx, key, ttl, fields...)
+		return result, opErr
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, opErr
+}
+
+func (t *Transaction) HTTL(ctx context.Context, key string, fields ...string) ([]int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return nil, ErrTransactionNotActive
+	}
+	return t.reader(key).HTTL(ctx, key, fields...)
+}
+
+func (t *Transaction) HPersist(ctx context.Context, key string, fields ...string) ([]int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return nil, ErrTransactionNotActive
+	}
+	var result []int
+	var opErr error
+	err := t.add(ctx, "HPersist", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		result, opErr = db.HPersist(ctx, key, fields...)
+		return result, opErr
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, opErr
+}
+
+func (t *Transaction) SAdd(ctx context.Context, key string, members ...interface{}) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return ErrTransactionNotActive
+	}
+	return t.add(ctx, "SAdd", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		return nil, db.SAdd(ctx, key, members...)
+	})
+}
+
+func (t *Transaction) SRem(ctx context.Context, key string, members ...interface{}) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return ErrTransactionNotActive
+	}
+	return t.add(ctx, "SRem", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		return nil, db.SRem(ctx, key, members...)
 	})
-	return nil
 }
 
 func (t *Transaction) SMembers(ctx context.Context, key string) ([]interface{}, error) {
@@ -584,7 +902,7 @@ func (t *Transaction) SMembers(ctx context.Context, key string) ([]interface{},
 	if !t.active {
 		return nil, ErrTransactionNotActive
 	}
-	return t.db.SMembers(ctx, key)
+	return t.reader(key).SMembers(ctx, key)
 }
 
 func (t *Transaction) SIsMember(ctx context.Context, key string, member interface{}) (bool, error) {
@@ -593,7 +911,7 @@ func (t *Transaction) SIsMember(ctx context.Context, key string, member interfac
 	if !t.active {
 		return false, ErrTransactionNotActive
 	}
-	return t.db.SIsMember(ctx, key, member)
+	return t.reader(key).SIsMember(ctx, key, member)
 }
 
 func (t *Transaction) SCard(ctx context.Context, key string) (int, error) {
@@ -602,7 +920,146 @@ func (t *Transaction) SCard(ctx context.Context, key string) (int, error) {
 	if !t.active {
 		return 0, ErrTransactionNotActive
 	}
-	return t.db.SCard(ctx, key)
+	return t.reader(key).SCard(ctx, key)
+}
+
+func (t *Transaction) ZAdd(ctx context.Context, key string, opts types.ZAddOptions, members ...types.ZMember) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return ErrTransactionNotActive
+	}
+	return t.add(ctx, "ZAdd", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		return db.ZAdd(ctx, key, opts, members...)
+	})
+}
+
+func (t *Transaction) ZIncrBy(ctx context.Context, key string, member string, increment float64, opts types.ZAddOptions) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return ErrTransactionNotActive
+	}
+	return t.add(ctx, "ZIncrBy", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		return db.ZIncrBy(ctx, key, member, increment, opts)
+	})
+}
+
+func (t *Transaction) ZRem(ctx context.Context, key string, members ...string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return ErrTransactionNotActive
+	}
+	return t.add(ctx, "ZRem", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		return db.ZRem(ctx, key, members...)
+	})
+}
+
+func (t *Transaction) ZPopMin(ctx context.Context, key string, count int) ([]types.ZMember, error) {
+	return t.zpop(ctx, key, count, false)
+}
+
+func (t *Transaction) ZPopMax(ctx context.Context, key string, count int) ([]types.ZMember, error) {
+	return t.zpop(ctx, key, count, true)
+}
+
+func (t *Transaction) zpop(ctx context.Context, key string, count int, max bool) ([]types.ZMember, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return nil, ErrTransactionNotActive
+	}
+	op := "ZPopMin"
+	if max {
+		op = "ZPopMax"
+	}
+	var popped []types.ZMember
+	var popErr error
+	err := t.add(ctx, op, []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		if max {
+			popped, popErr = db.ZPopMax(ctx, key, count)
+		} else {
+			popped, popErr = db.ZPopMin(ctx, key, count)
+		}
+		return popped, popErr
+	})
+	if err != nil {
+		return nil, err
+	}
+	return popped, popErr
+}
+
+func (t *Transaction) ZScore(ctx context.Context, key string, member string) (float64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return 0, ErrTransactionNotActive
+	}
+	return t.reader(key).ZScore(ctx, key, member)
+}
+
+func (t *Transaction) ZRank(ctx context.Context, key string, member string) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return 0, ErrTransactionNotActive
+	}
+	return t.reader(key).ZRank(ctx, key, member)
+}
+
+func (t *Transaction) ZRevRank(ctx context.Context, key string, member string) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return 0, ErrTransactionNotActive
+	}
+	return t.reader(key).ZRevRank(ctx, key, member)
+}
+
+func (t *Transaction) ZRange(ctx context.Context, key string, start, stop int, rev bool) ([]types.ZMember, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return nil, ErrTransactionNotActive
+	}
+	return t.reader(key).ZRange(ctx, key, start, stop, rev)
+}
+
+func (t *Transaction) ZRangeByScore(ctx context.Context, key string, min, max types.ScoreBound, opts types.ZRangeOptions) ([]types.ZMember, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return nil, ErrTransactionNotActive
+	}
+	return t.reader(key).ZRangeByScore(ctx, key, min, max, opts)
+}
+
+func (t *Transaction) ZRangeByLex(ctx context.Context, key string, min, max types.LexBound, opts types.ZRangeOptions) ([]types.ZMember, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return nil, ErrTransactionNotActive
+	}
+	return t.reader(key).ZRangeByLex(ctx, key, min, max, opts)
+}
+
+func (t *Transaction) ZCard(ctx context.Context, key string) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return 0, ErrTransactionNotActive
+	}
+	return t.reader(key).ZCard(ctx, key)
+}
+
+func (t *Transaction) ZCount(ctx context.Context, key string, min, max types.ScoreBound) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return 0, ErrTransactionNotActive
+	}
+	return t.reader(key).ZCount(ctx, key, min, max)
 }
 
 func (t *Transaction) Exists(ctx context.Context, key string) (bool, error) {
@@ -611,7 +1068,7 @@ func (t *Transaction) Exists(ctx context.Context, key string) (bool, error) {
 	if !t.active {
 		return false, ErrTransactionNotActive
 	}
-	return t.db.Exists(ctx, key)
+	return t.reader(key).Exists(ctx, key)
 }
 
 func (t *Transaction) Expire(ctx context.Context, key string, ttl int) error {
@@ -620,28 +1077,16 @@ func (t *Transaction) Expire(ctx context.Context, key string, ttl int) error {
 	if !t.active {
 		return ErrTransactionNotActive
 	}
-	oldEntry, existed, err := t.getRawEntryOrNil(ctx, key)
-	if err != nil {
-		return err
-	}
-	t.commands = append(t.commands, func() error {
-		ok, err := t.db.Expire(ctx, key, ttl)
+	return t.add(ctx, "Expire", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		ok, err := db.Expire(ctx, key, ttl)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if !ok {
-			return ErrKeyNotFound
-		}
-		return nil
-	})
-	t.rollback = append(t.rollback, func() {
-		if existed {
-			_ = t.db.RestoreRawEntry(context.Background(), key, oldEntry)
-		} else {
-			_ = t.db.Delete(context.Background(), key)
+			return nil, ErrKeyNotFound
 		}
+		return true, nil
 	})
-	return nil
 }
 
 func (t *Transaction) Persist(ctx context.Context, key string) error {
@@ -650,28 +1095,16 @@ func (t *Transaction) Persist(ctx context.Context, key string) error {
 	if !t.active {
 		return ErrTransactionNotActive
 	}
-	oldEntry, existed, err := t.getRawEntryOrNil(ctx, key)
-	if err != nil {
-		return err
-	}
-	t.commands = append(t.commands, func() error {
-		ok, err := t.db.Persist(ctx, key)
+	return t.add(ctx, "Persist", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		ok, err := db.Persist(ctx, key)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if !ok {
-			return ErrKeyNotFound
-		}
-		return nil
-	})
-	t.rollback = append(t.rollback, func() {
-		if existed {
-			_ = t.db.RestoreRawEntry(context.Background(), key, oldEntry)
-		} else {
-			_ = t.db.Delete(context.Background(), key)
+			return nil, ErrKeyNotFound
 		}
+		return true, nil
 	})
-	return nil
 }
 
 func (t *Transaction) Type(ctx context.Context, key string) (interface{}, error) {
@@ -680,7 +1113,7 @@ func (t *Transaction) Type(ctx context.Context, key string) (interface{}, error)
 	if !t.active {
 		return -1, ErrTransactionNotActive
 	}
-	return t.db.Type(ctx, key)
+	return t.reader(key).Type(ctx, key)
 }
 
 func (t *Transaction) GetWithDetails(ctx context.Context, key string) (interface{}, int, error) {
@@ -689,7 +1122,7 @@ func (t *Transaction) GetWithDetails(ctx context.Context, key string) (interface
 	if !t.active {
 		return nil, 0, ErrTransactionNotActive
 	}
-	return t.db.GetWithDetails(ctx, key)
+	return t.reader(key).GetWithDetails(ctx, key)
 }
 
 func (t *Transaction) Rename(ctx context.Context, oldKey, newKey string) error {
@@ -698,30 +1131,9 @@ func (t *Transaction) Rename(ctx context.Context, oldKey, newKey string) error {
 	if !t.active {
 		return ErrTransactionNotActive
 	}
-	oldKeyEntry, oldExisted, err := t.getRawEntryOrNil(ctx, oldKey)
-	if err != nil {
-		return err
-	}
-	newKeyEntry, newExisted, err := t.getRawEntryOrNil(ctx, newKey)
-	if err != nil {
-		return err
-	}
-	t.commands = append(t.commands, func() error {
-		return t.db.Rename(ctx, oldKey, newKey)
+	return t.add(ctx, "Rename", []string{oldKey, newKey}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		return nil, db.Rename(ctx, oldKey, newKey)
 	})
-	t.rollback = append(t.rollback, func() {
-		if oldExisted {
-			_ = t.db.RestoreRawEntry(context.Background(), oldKey, oldKeyEntry)
-		} else {
-			_ = t.db.Delete(context.Background(), oldKey)
-		}
-		if newExisted {
-			_ = t.db.RestoreRawEntry(context.Background(), newKey, newKeyEntry)
-		} else {
-			_ = t.db.Delete(context.Background(), newKey)
-		}
-	})
-	return nil
 }
 
 func (t *Transaction) FindByValue(ctx context.Context, value interface{}) ([]string, error) {
@@ -730,7 +1142,42 @@ func (t *Transaction) FindByValue(ctx context.Context, value interface{}) ([]str
 	if !t.active {
 		return nil, ErrTransactionNotActive
 	}
-	return t.db.FindByValue(ctx, value)
+	return t.findByValue(ctx, value)
+}
+
+func (t *Transaction) findByValue(ctx context.Context, value interface{}) ([]string, error) {
+	var found []string
+	var err error
+	if p := t.parent; p != nil {
+		p.mu.Lock()
+		found, err = p.findByValue(ctx, value)
+		p.mu.Unlock()
+	} else {
+		found, err = t.db.FindByValue(ctx, value)
+	}
+	if err != nil && !IsKeyNotFound(err) {
+		return nil, err
+	}
+	if t.overlay == nil {
+		return found, err
+	}
+
+	// Keys the transaction has written are answered by the overlay instead.
+	keys := make([]string, 0, len(found))
+	for _, key := range found {
+		if !t.staged[key] {
+			keys = append(keys, key)
+		}
+	}
+	staged, err := t.overlay.FindByValue(ctx, value)
+	if err != nil && !IsKeyNotFound(err) {
+		return nil, err
+	}
+	keys = append(keys, staged...)
+	if len(keys) == 0 {
+		return nil, ErrKeyNotFound
+	}
+	return keys, nil
 }
 
 func (t *Transaction) Delete(ctx context.Context, key string) error {
@@ -739,19 +1186,7 @@ func (t *Transaction) Delete(ctx context.Context, key string) error {
 	if !t.active {
 		return ErrTransactionNotActive
 	}
-	oldEntry, existed, err := t.getRawEntryOrNil(ctx, key)
-	if err != nil {
-		return err
-	}
-	t.commands = append(t.commands, func() error {
-		return t.db.Delete(ctx, key)
+	return t.add(ctx, "Delete", []string{key}, func(ctx context.Context, db contracts.StoreHandler) (interface{}, error) {
+		return nil, db.Delete(ctx, key)
 	})
-	t.rollback = append(t.rollback, func() {
-		if existed {
-			_ = t.db.RestoreRawEntry(context.Background(), key, oldEntry)
-		} else {
-			_ = t.db.Delete(context.Background(), key)
-		}
-	})
-	return nil
 }
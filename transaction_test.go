@@ -26,7 +26,7 @@ func TestTransactionSetCommit(t *testing.T) {
 		t.Fatalf("Set in transaction failed: %v", err)
 	}
 
-	if err := tx.Commit(); err != nil {
+	if _, err := tx.Commit(); err != nil {
 		t.Fatalf("Commit failed: %v", err)
 	}
 
@@ -74,7 +74,7 @@ func TestTransactionDeleteCommit(t *testing.T) {
 		t.Fatalf("Delete in transaction failed: %v", err)
 	}
 
-	if err := tx.Commit(); err != nil {
+	if _, err := tx.Commit(); err != nil {
 		t.Fatalf("Commit failed: %v", err)
 	}
 
@@ -129,7 +129,7 @@ func TestTransactionIncrCommit(t *testing.T) {
 		t.Fatalf("Incr in transaction failed: %v", err)
 	}
 
-	if err := tx.Commit(); err != nil {
+	if _, err := tx.Commit(); err != nil {
 		t.Fatalf("Commit failed: %v", err)
 	}
 
@@ -157,7 +157,7 @@ func TestTransactionDecrCommit(t *testing.T) {
 		t.Fatalf("Decr in transaction failed: %v", err)
 	}
 
-	if err := tx.Commit(); err != nil {
+	if _, err := tx.Commit(); err != nil {
 		t.Fatalf("Commit failed: %v", err)
 	}
 
@@ -242,7 +242,7 @@ func TestTransactionSetNXExists(t *testing.T) {
 		t.Fatalf("SetNX returned error: %v", err)
 	}
 
-	err := tx.Commit()
+	_, err := tx.Commit()
 	if !errors.Is(err, ErrTransactionFailed) {
 		t.Fatalf("Expected ErrTransactionFailed, got: %v", err)
 	}
@@ -268,7 +268,7 @@ func TestTransactionSetXXNotExists(t *testing.T) {
 		t.Fatalf("SetXX returned error: %v", err)
 	}
 
-	err := tx.Commit()
+	_, err := tx.Commit()
 	if !errors.Is(err, ErrTransactionFailed) {
 		t.Fatalf("Expected ErrTransactionFailed, got: %v", err)
 	}
@@ -294,7 +294,7 @@ func TestTransactionSetCASWrongOld(t *testing.T) {
 		t.Fatalf("SetCAS returned error: %v", err)
 	}
 
-	err := tx.Commit()
+	_, err := tx.Commit()
 	if !errors.Is(err, ErrTransactionFailed) {
 		t.Fatalf("Expected ErrTransactionFailed, got: %v", err)
 	}
@@ -323,7 +323,7 @@ func TestTransactionExpireCommit(t *testing.T) {
 		t.Fatalf("Expire in transaction failed: %v", err)
 	}
 
-	if err := tx.Commit(); err != nil {
+	if _, err := tx.Commit(); err != nil {
 		t.Fatalf("Commit failed: %v", err)
 	}
 
@@ -386,10 +386,12 @@ func TestTransactionConcurrentIncrements(t *testing.T) {
 			for j := 0; j < increments; j++ {
 				tx := db.Transaction()
 				if err := tx.Incr(ctx, "sharedCounter"); err != nil {
-					t.Fatalf("Worker %d: Incr failed: %v", id, err)
+					t.Errorf("Worker %d: Incr failed: %v", id, err)
+					return
 				}
-				if err := tx.Commit(); err != nil {
-					t.Fatalf("Worker %d: Commit failed: %v", id, err)
+				if _, err := tx.Commit(); err != nil {
+					t.Errorf("Worker %d: Commit failed: %v", id, err)
+					return
 				}
 			}
 		}(i)
@@ -417,7 +419,7 @@ func TestTransactionLPushCommit(t *testing.T) {
 	if err := tx.LPush(ctx, "listKey", "val1"); err != nil {
 		t.Fatalf("LPush in transaction failed: %v", err)
 	}
-	if err := tx.Commit(); err != nil {
+	if _, err := tx.Commit(); err != nil {
 		t.Fatalf("Commit failed: %v", err)
 	}
 
@@ -458,7 +460,7 @@ func TestTransactionRPushCommit(t *testing.T) {
 	if err := tx.RPush(ctx, "listKey", "val1"); err != nil {
 		t.Fatalf("RPush in transaction failed: %v", err)
 	}
-	if err := tx.Commit(); err != nil {
+	if _, err := tx.Commit(); err != nil {
 		t.Fatalf("Commit failed: %v", err)
 	}
 
@@ -495,7 +497,7 @@ func TestTransactionLPopCommit(t *testing.T) {
 	db := setupTestDB()
 	ctx := context.Background()
 
-	if err := db.LPush(ctx, "listKey", "val1"); err != nil {
+	if _, err := db.LPush(ctx, "listKey", "val1"); err != nil {
 		t.Fatalf("Setup LPush failed: %v", err)
 	}
 
@@ -508,7 +510,7 @@ func TestTransactionLPopCommit(t *testing.T) {
 		t.Fatalf("Expected 'val1', got %v", popped)
 	}
 
-	if err := tx.Commit(); err != nil {
+	if _, err := tx.Commit(); err != nil {
 		t.Fatalf("Commit failed: %v", err)
 	}
 
@@ -523,7 +525,7 @@ func TestTransactionLPopRollback(t *testing.T) {
 	db := setupTestDB()
 	ctx := context.Background()
 
-	if err := db.LPush(ctx, "listKey", "val2", "val1"); err != nil {
+	if _, err := db.LPush(ctx, "listKey", "val2", "val1"); err != nil {
 		t.Fatalf("Setup LPush failed: %v", err)
 	}
 
@@ -556,7 +558,7 @@ func TestTransactionRPopCommit(t *testing.T) {
 	db := setupTestDB()
 	ctx := context.Background()
 
-	if err := db.RPush(ctx, "listKey", "val1"); err != nil {
+	if _, err := db.RPush(ctx, "listKey", "val1"); err != nil {
 		t.Fatalf("Setup RPush failed: %v", err)
 	}
 
@@ -569,7 +571,7 @@ func TestTransactionRPopCommit(t *testing.T) {
 		t.Fatalf("Expected 'val1', got %v", popped)
 	}
 
-	if err := tx.Commit(); err != nil {
+	if _, err := tx.Commit(); err != nil {
 		t.Fatalf("Commit failed: %v", err)
 	}
 
@@ -587,7 +589,7 @@ func TestTransactionRPopRollback(t *testing.T) {
 	db := setupTestDB()
 	ctx := context.Background()
 
-	if err := db.LPush(ctx, "listKey", "val2", "val1"); err != nil {
+	if _, err := db.LPush(ctx, "listKey", "val2", "val1"); err != nil {
 		t.Fatalf("Setup LPush failed: %v", err)
 	}
 
@@ -620,10 +622,10 @@ func TestTransactionLLenAndLRangeCommit(t *testing.T) {
 	db := setupTestDB()
 	ctx := context.Background()
 
-	if err := db.RPush(ctx, "listKey", "one"); err != nil {
+	if _, err := db.RPush(ctx, "listKey", "one"); err != nil {
 		t.Fatalf("Setup RPush failed: %v", err)
 	}
-	if err := db.RPush(ctx, "listKey", "two"); err != nil {
+	if _, err := db.RPush(ctx, "listKey", "two"); err != nil {
 		t.Fatalf("Setup RPush failed: %v", err)
 	}
 
@@ -632,7 +634,7 @@ func TestTransactionLLenAndLRangeCommit(t *testing.T) {
 		t.Fatalf("RPush in transaction failed: %v", err)
 	}
 
-	if err := tx.Commit(); err != nil {
+	if _, err := tx.Commit(); err != nil {
 		t.Fatalf("Commit failed: %v", err)
 	}
 
@@ -662,7 +664,7 @@ func TestTransactionHSetCommit(t *testing.T) {
 	if err := tx.HSet(ctx, "hashKey", "field1", "value1", 0); err != nil {
 		t.Fatalf("HSet in transaction failed: %v", err)
 	}
-	if err := tx.Commit(); err != nil {
+	if _, err := tx.Commit(); err != nil {
 		t.Fatalf("Commit failed: %v", err)
 	}
 
@@ -707,7 +709,7 @@ func TestTransactionHDelCommit(t *testing.T) {
 	if err := tx.HDel(ctx, "hashKey", "field1"); err != nil {
 		t.Fatalf("HDel in transaction failed: %v", err)
 	}
-	if err := tx.Commit(); err != nil {
+	if _, err := tx.Commit(); err != nil {
 		t.Fatalf("Commit failed: %v", err)
 	}
 
@@ -756,7 +758,7 @@ func TestTransactionHGetAllCommit(t *testing.T) {
 	if err := tx.HSet(ctx, "hashKey", "field2", "val2", 0); err != nil {
 		t.Fatalf("HSet in transaction failed: %v", err)
 	}
-	if err := tx.Commit(); err != nil {
+	if _, err := tx.Commit(); err != nil {
 		t.Fatalf("Commit failed: %v", err)
 	}
 
@@ -782,7 +784,7 @@ func TestTransactionHExistsAndHLenCommit(t *testing.T) {
 	if err := tx.HSet(ctx, "hashKey", "field2", "val2", 0); err != nil {
 		t.Fatalf("HSet in transaction failed: %v", err)
 	}
-	if err := tx.Commit(); err != nil {
+	if _, err := tx.Commit(); err != nil {
 		t.Fatalf("Commit failed: %v", err)
 	}
 
@@ -825,7 +827,7 @@ func TestTransactionExistsCommit(t *testing.T) {
 		t.Fatalf("Delete in transaction failed: %v", err)
 	}
 
-	if err := tx.Commit(); err != nil {
+	if _, err := tx.Commit(); err != nil {
 		t.Fatalf("Commit failed: %v", err)
 	}
 
@@ -856,7 +858,7 @@ func TestTransactionTypeCommit(t *testing.T) {
 		t.Fatalf("Expected type=String, got %v", typ)
 	}
 
-	if err := tx.Commit(); err != nil {
+	if _, err := tx.Commit(); err != nil {
 		t.Fatalf("Commit failed: %v", err)
 	}
 }
@@ -882,7 +884,7 @@ func TestTransactionGetWithDetailsCommit(t *testing.T) {
 		t.Fatalf("Expected TTL > 0, got %d", ttl)
 	}
 
-	if err := tx.Commit(); err != nil {
+	if _, err := tx.Commit(); err != nil {
 		t.Fatalf("Commit failed: %v", err)
 	}
 }
@@ -909,7 +911,7 @@ func TestTransactionRenameCommit(t *testing.T) {
 		t.Fatalf("Rename in transaction failed: %v", err)
 	}
 
-	if err := tx.Commit(); err != nil {
+	if _, err := tx.Commit(); err != nil {
 		t.Fatalf("Commit failed: %v", err)
 	}
 	_, err = db.Get(ctx, "oldKey")
@@ -977,7 +979,7 @@ func TestTransactionFindByValueCommit(t *testing.T) {
 	if err := tx.Set(ctx, "k2", "look", 0); err != nil {
 		t.Fatalf("Set(k2) in transaction failed: %v", err)
 	}
-	if err := tx.Commit(); err != nil {
+	if _, err := tx.Commit(); err != nil {
 		t.Fatalf("Commit failed: %v", err)
 	}
 
@@ -999,7 +1001,7 @@ func TestTransactionSAddCommit(t *testing.T) {
 	if err := tx.SAdd(ctx, "colors", "red", "green", "blue"); err != nil {
 		t.Fatalf("SAdd in transaction failed: %v", err)
 	}
-	if err := tx.Commit(); err != nil {
+	if _, err := tx.Commit(); err != nil {
 		t.Fatalf("Commit failed: %v", err)
 	}
 
@@ -1044,7 +1046,7 @@ func TestTransactionSRemCommit(t *testing.T) {
 	if err := tx.SRem(ctx, "numbers", 2, 4); err != nil {
 		t.Fatalf("SRem in transaction failed: %v", err)
 	}
-	if err := tx.Commit(); err != nil {
+	if _, err := tx.Commit(); err != nil {
 		t.Fatalf("Commit failed: %v", err)
 	}
 
@@ -1101,7 +1103,7 @@ func TestTransactionSMembersCommit(t *testing.T) {
 	if err := tx.SAdd(ctx, "foods", "apple", "banana"); err != nil {
 		t.Fatalf("SAdd in transaction failed: %v", err)
 	}
-	if err := tx.Commit(); err != nil {
+	if _, err := tx.Commit(); err != nil {
 		t.Fatalf("Commit failed: %v", err)
 	}
 
@@ -1123,7 +1125,7 @@ func TestTransactionSIsMemberCommit(t *testing.T) {
 	if err := tx.SAdd(ctx, "planets", "Earth", "Mars"); err != nil {
 		t.Fatalf("SAdd in transaction failed: %v", err)
 	}
-	if err := tx.Commit(); err != nil {
+	if _, err := tx.Commit(); err != nil {
 		t.Fatalf("Commit failed: %v", err)
 	}
 
@@ -1150,7 +1152,7 @@ func TestTransactionSCardCommit(t *testing.T) {
 	if err := tx.SAdd(ctx, "letters", "c"); err != nil {
 		t.Fatalf("SAdd in transaction failed: %v", err)
 	}
-	if err := tx.Commit(); err != nil {
+	if _, err := tx.Commit(); err != nil {
 		t.Fatalf("Commit failed: %v", err)
 	}
 
@@ -1168,7 +1170,7 @@ func TestTransactionLTrimCommit(t *testing.T) {
 	db := setupTestDB()
 	ctx := context.Background()
 
-	if err := db.RPush(ctx, "numbersList", 1, 2, 3, 4, 5); err != nil {
+	if _, err := db.RPush(ctx, "numbersList", 1, 2, 3, 4, 5); err != nil {
 		t.Fatalf("RPush setup failed: %v", err)
 	}
 
@@ -1176,7 +1178,7 @@ func TestTransactionLTrimCommit(t *testing.T) {
 	if err := tx.LTrim(ctx, "numbersList", 1, 2); err != nil {
 		t.Fatalf("LTrim in transaction failed: %v", err)
 	}
-	if err := tx.Commit(); err != nil {
+	if _, err := tx.Commit(); err != nil {
 		t.Fatalf("Commit failed: %v", err)
 	}
 
@@ -1194,7 +1196,7 @@ func TestTransactionLTrimRollback(t *testing.T) {
 	db := setupTestDB()
 	ctx := context.Background()
 
-	if err := db.RPush(ctx, "numbersList", 10, 20, 30, 40); err != nil {
+	if _, err := db.RPush(ctx, "numbersList", 10, 20, 30, 40); err != nil {
 		t.Fatalf("RPush setup failed: %v", err)
 	}
 
@@ -1214,3 +1216,841 @@ func TestTransactionLTrimRollback(t *testing.T) {
 		t.Fatalf("Expected [10 20 30 40], got %v", result)
 	}
 }
+
+// TestTransactionListEdits checks that LSet, LInsert, LRem and LMove are
+// visible to reads inside the transaction and applied on commit.
+func TestTransactionListEdits(t *testing.T) {
+	db := setupTestDB()
+	ctx := context.Background()
+
+	if _, err := db.RPush(ctx, "list", "a", "b", "a", "c"); err != nil {
+		t.Fatalf("RPush setup failed: %v", err)
+	}
+
+	tx := db.Transaction()
+	if err := tx.LSet(ctx, "list", -1, "C"); err != nil {
+		t.Fatalf("LSet in transaction failed: %v", err)
+	}
+	if n, err := tx.LInsert(ctx, "list", false, "b", "b2"); err != nil || n != 5 {
+		t.Fatalf("LInsert in transaction = %d (err=%v)", n, err)
+	}
+	if n, err := tx.LRem(ctx, "list", 0, "a"); err != nil || n != 2 {
+		t.Fatalf("LRem in transaction = %d (err=%v)", n, err)
+	}
+	if val, err := tx.RPopLPush(ctx, "list", "other"); err != nil || val != "C" {
+		t.Fatalf("RPopLPush in transaction = %v (err=%v)", val, err)
+	}
+	if pos, err := tx.LPos(ctx, "list", "b2", LPosOptions{}); err != nil || !reflect.DeepEqual(pos, []int{1}) {
+		t.Fatalf("LPos in transaction = %v (err=%v)", pos, err)
+	}
+	if val, err := tx.LIndex(ctx, "other", 0); err != nil || val != "C" {
+		t.Fatalf("LIndex in transaction = %v (err=%v)", val, err)
+	}
+	if list, _ := db.LRange(ctx, "list", 0, -1); len(list) != 4 {
+		t.Fatalf("The store changed before commit: %v", list)
+	}
+	if _, err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	if list, _ := db.LRange(ctx, "list", 0, -1); !reflect.DeepEqual(list, []interface{}{"b", "b2"}) {
+		t.Errorf("Expected [b b2], got %v", list)
+	}
+	if list, _ := db.LRange(ctx, "other", 0, -1); !reflect.DeepEqual(list, []interface{}{"C"}) {
+		t.Errorf("Expected [C], got %v", list)
+	}
+}
+
+// TestTransactionHashCounters checks that hash counters and multi-field writes
+// are visible inside the transaction and rolled back with it.
+func TestTransactionHashCounters(t *testing.T) {
+	db := setupTestDB()
+	ctx := context.Background()
+
+	if _, err := db.HMSet(ctx, "user:1", map[string]interface{}{"name": "Ann", "logins": int64(1)}); err != nil {
+		t.Fatalf("HMSet setup failed: %v", err)
+	}
+
+	tx := db.Transaction()
+	if n, err := tx.HIncrBy(ctx, "user:1", "logins", 2); err != nil || n != 3 {
+		t.Fatalf("HIncrBy in transaction = %d (err=%v)", n, err)
+	}
+	if ok, err := tx.HSetNX(ctx, "user:1", "name", "Bo"); err != nil || ok {
+		t.Fatalf("HSetNX on an existing field = %v (err=%v)", ok, err)
+	}
+	if _, err := tx.HMSet(ctx, "user:1", map[string]interface{}{"city": "Oslo"}); err != nil {
+		t.Fatalf("HMSet in transaction failed: %v", err)
+	}
+	if fields, err := tx.HKeys(ctx, "user:1"); err != nil || !reflect.DeepEqual(fields, []string{"city", "logins", "name"}) {
+		t.Fatalf("HKeys in transaction = %v (err=%v)", fields, err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+
+	values, err := db.HMGet(ctx, "user:1", "logins", "city")
+	if err != nil || !reflect.DeepEqual(values, []interface{}{int64(1), nil}) {
+		t.Errorf("Expected [1 <nil>] after rollback, got %v (err=%v)", values, err)
+	}
+
+	tx = db.Transaction()
+	_, _ = tx.HIncrByFloat(ctx, "user:1", "score", 1.5)
+	if _, err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if val, _ := db.HGet(ctx, "user:1", "score"); val != 1.5 {
+		t.Errorf("Expected score 1.5 after commit, got %v", val)
+	}
+}
+
+// TestTransactionHashFieldTTL checks that field expirations set in a
+// transaction are visible to its reads and applied on commit.
+func TestTransactionHashFieldTTL(t *testing.T) {
+	db := setupTestDB()
+	ctx := context.Background()
+
+	_, _ = db.HMSet(ctx, "session", map[string]interface{}{"token": "t", "user": "ann"})
+
+	tx := db.Transaction()
+	if res, err := tx.HExpire(ctx, "session", 60, "token", "missing"); err != nil || !reflect.DeepEqual(res, []int{1, -2}) {
+		t.Fatalf("HExpire in transaction = %v (err=%v)", res, err)
+	}
+	if ttls, err := tx.HTTL(ctx, "session", "token"); err != nil || len(ttls) != 1 || ttls[0] <= 0 {
+		t.Fatalf("HTTL in transaction = %v (err=%v)", ttls, err)
+	}
+	if ttls, _ := db.HTTL(ctx, "session", "token"); !reflect.DeepEqual(ttls, []int{-1}) {
+		t.Errorf("Expiration should not be visible before commit, got %v", ttls)
+	}
+	if _, err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if ttls, _ := db.HTTL(ctx, "session", "token", "user"); len(ttls) != 2 || ttls[0] <= 0 || ttls[1] != -1 {
+		t.Errorf("Expected [>0 -1] after commit, got %v", ttls)
+	}
+
+	tx = db.Transaction()
+	_, _ = tx.HPersist(ctx, "session", "token")
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	if ttls, _ := db.HTTL(ctx, "session", "token"); len(ttls) != 1 || ttls[0] <= 0 {
+		t.Errorf("Expected the expiration to survive the rollback, got %v", ttls)
+	}
+}
+
+// TestTransactionSortedSet checks that sorted set writes are queued and committed.
+func TestTransactionSortedSet(t *testing.T) {
+	db := setupTestDB()
+	ctx := context.Background()
+	tx := db.Transaction()
+
+	if err := tx.ZAdd(ctx, "z", types.ZAddOptions{}, types.ZMember{Member: "a", Score: 1}, types.ZMember{Member: "b", Score: 2}); err != nil {
+		t.Fatalf("ZAdd in transaction failed: %v", err)
+	}
+	if err := tx.ZIncrBy(ctx, "z", "a", 5, types.ZAddOptions{}); err != nil {
+		t.Fatalf("ZIncrBy in transaction failed: %v", err)
+	}
+	if _, err := db.ZCard(ctx, "z"); !IsKeyNotFound(err) {
+		t.Fatalf("Expected queued writes to be invisible before commit, got %v", err)
+	}
+
+	if _, err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	members, err := db.ZRange(ctx, "z", 0, -1, false)
+	if err != nil {
+		t.Fatalf("ZRange failed: %v", err)
+	}
+	want := []types.ZMember{{Member: "b", Score: 2}, {Member: "a", Score: 6}}
+	if !reflect.DeepEqual(members, want) {
+		t.Errorf("Expected %+v, got %+v", want, members)
+	}
+}
+
+// TestTransactionWatch checks that Commit aborts when a watched key changes.
+func TestTransactionWatch(t *testing.T) {
+	db := setupTestDB()
+	ctx := context.Background()
+
+	// Scenario 1: untouched watched key lets the transaction commit
+	if err := db.Set(ctx, "balance", 100, 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	tx := db.Transaction()
+	if err := tx.Watch(ctx, "balance"); err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	if err := tx.Set(ctx, "balance", 90, 0); err != nil {
+		t.Fatalf("Set in transaction failed: %v", err)
+	}
+	if _, err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	// Scenario 2: a concurrent write aborts the commit without applying anything
+	tx = db.Transaction()
+	if err := tx.Watch(ctx, "balance"); err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	if err := tx.Set(ctx, "balance", 80, 0); err != nil {
+		t.Fatalf("Set in transaction failed: %v", err)
+	}
+	if err := tx.Set(ctx, "audit", "debit", 0); err != nil {
+		t.Fatalf("Set in transaction failed: %v", err)
+	}
+	if err := db.Set(ctx, "balance", 500, 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, err := tx.Commit(); !IsTransactionConflict(err) {
+		t.Fatalf("Expected ErrTransactionConflict, got %v", err)
+	}
+	if val, _ := db.Get(ctx, "balance"); val != 500 {
+		t.Errorf("Expected balance 500, got %v", val)
+	}
+	if _, err := db.Get(ctx, "audit"); !IsKeyNotFound(err) {
+		t.Errorf("Expected audit to be absent, got %v", err)
+	}
+
+	// Scenario 3: deleting, renaming or dropping the key counts as a change
+	changes := []func() error{
+		func() error { return db.Delete(ctx, "balance") },
+		func() error { return db.Rename(ctx, "balance", "moved") },
+		func() error { return db.DropAll(ctx) },
+	}
+	for i, change := range changes {
+		if err := db.Set(ctx, "balance", 1, 0); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+		tx = db.Transaction()
+		if err := tx.Watch(ctx, "balance"); err != nil {
+			t.Fatalf("Watch failed: %v", err)
+		}
+		if err := change(); err != nil {
+			t.Fatalf("Change %d failed: %v", i, err)
+		}
+		if _, err := tx.Commit(); !IsTransactionConflict(err) {
+			t.Errorf("Change %d: expected ErrTransactionConflict, got %v", i, err)
+		}
+	}
+
+	// Scenario 4: a watched key that expires counts as changed
+	if err := db.Set(ctx, "session", "x", 1); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	tx = db.Transaction()
+	if err := tx.Watch(ctx, "session"); err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if _, err := tx.Commit(); !IsTransactionConflict(err) {
+		t.Errorf("Expected ErrTransactionConflict after expiry, got %v", err)
+	}
+
+	// Scenario 5: Unwatch forgets the keys
+	tx = db.Transaction()
+	if err := tx.Watch(ctx, "other"); err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	if err := db.Set(ctx, "other", 1, 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := tx.Unwatch(); err != nil {
+		t.Fatalf("Unwatch failed: %v", err)
+	}
+	if _, err := tx.Commit(); err != nil {
+		t.Errorf("Expected commit after Unwatch to succeed, got %v", err)
+	}
+	if n := db.(*DB).watches.count.Load(); n != 0 {
+		t.Errorf("Expected no watched keys left, got %d", n)
+	}
+
+	// Scenario 6: moving an element into a watched list counts as a change
+	for i, move := range []func() (interface{}, error){
+		func() (interface{}, error) { return db.LMove(ctx, "inbox", "done", ListLeft, ListRight) },
+		func() (interface{}, error) { return db.RPopLPush(ctx, "inbox", "done") },
+	} {
+		if _, err := db.RPush(ctx, "inbox", "job"); err != nil {
+			t.Fatalf("RPush failed: %v", err)
+		}
+		tx = db.Transaction()
+		if err := tx.Watch(ctx, "done"); err != nil {
+			t.Fatalf("Watch failed: %v", err)
+		}
+		if _, err := move(); err != nil {
+			t.Fatalf("Move %d failed: %v", i, err)
+		}
+		if _, err := tx.Commit(); !IsTransactionConflict(err) {
+			t.Errorf("Move %d: expected ErrTransactionConflict, got %v", i, err)
+		}
+	}
+}
+
+// TestTransactionCommitIsolation checks that readers cannot observe a commit
+// halfway through.
+func TestTransactionCommitIsolation(t *testing.T) {
+	db := NewStore(Config{ShardCount: 16})
+	ctx := context.Background()
+
+	tx := db.Transaction()
+	if err := tx.Set(ctx, "a", 1, 0); err != nil {
+		t.Fatalf("Set in transaction failed: %v", err)
+	}
+	started, release := make(chan struct{}), make(chan struct{})
+	err := tx.(*Transaction).queue(ctx, "Set", []string{"b"}, false, func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-release
+		return nil, db.Set(ctx, "b", 1, 0)
+	})
+	if err != nil {
+		t.Fatalf("queue failed: %v", err)
+	}
+
+	committed := make(chan error, 1)
+	go func() {
+		_, err := tx.Commit()
+		committed <- err
+	}()
+	<-started
+
+	read := make(chan interface{}, 1)
+	go func() {
+		val, _ := db.Get(ctx, "a")
+		read <- val
+	}()
+	select {
+	case val := <-read:
+		t.Fatalf("Get returned %v while the commit was in progress", val)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-committed; err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if val := <-read; val != 1 {
+		t.Errorf("Expected 1 after commit, got %v", val)
+	}
+}
+
+// TestTransactionCommitNoDeadlock checks that commits and renames touching the
+// same shards in opposite orders all complete.
+func TestTransactionCommitNoDeadlock(t *testing.T) {
+	db := NewStore(Config{ShardCount: 8})
+	ctx := context.Background()
+	keys := []string{"k1", "k2", "k3", "k4", "k5", "k6"}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		for w := 0; w < 4; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < 200; i++ {
+					a, b := keys[(i+w)%len(keys)], keys[(i+w+3)%len(keys)]
+					if w%2 == 0 {
+						a, b = b, a
+					}
+					tx := db.Transaction()
+					_ = tx.Set(ctx, a, i, 0)
+					_ = tx.Set(ctx, b, i, 0)
+					_, _ = tx.Commit()
+					_ = db.Rename(ctx, b, a+"-tmp")
+					_ = db.Delete(ctx, a+"-tmp")
+				}
+			}(w)
+		}
+		wg.Wait()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Concurrent commits and renames deadlocked")
+	}
+}
+
+// TestTransactionRollbackRestoresAggregates checks that a failed commit undoes
+// in-place changes to hashes, sets, lists and sorted sets.
+func TestTransactionRollbackRestoresAggregates(t *testing.T) {
+	db := setupTestDB()
+	ctx := context.Background()
+
+	if err := db.HSet(ctx, "profile", "name", "ann", 0); err != nil {
+		t.Fatalf("HSet setup failed: %v", err)
+	}
+	if err := db.SAdd(ctx, "tags", "a", "b"); err != nil {
+		t.Fatalf("SAdd setup failed: %v", err)
+	}
+	if _, err := db.RPush(ctx, "queue", 1, 2, 3); err != nil {
+		t.Fatalf("RPush setup failed: %v", err)
+	}
+	if _, err := db.ZAdd(ctx, "board", types.ZAddOptions{}, types.ZMember{Member: "x", Score: 1}); err != nil {
+		t.Fatalf("ZAdd setup failed: %v", err)
+	}
+
+	tx := db.Transaction()
+	steps := []error{
+		tx.HSet(ctx, "profile", "email", "ann@example.com", 0),
+		tx.HSet(ctx, "profile", "name", "bob", 0),
+		tx.HDel(ctx, "profile", "name"),
+		tx.SAdd(ctx, "tags", "c"),
+		tx.SRem(ctx, "tags", "a"),
+		tx.RPush(ctx, "queue", 4),
+		tx.LTrim(ctx, "queue", 1, 2),
+		tx.ZAdd(ctx, "board", types.ZAddOptions{}, types.ZMember{Member: "x", Score: 5}, types.ZMember{Member: "y", Score: 2}),
+		tx.LPush(ctx, "fresh", "v"),
+		// Fails at commit time because the key does not exist
+		tx.SetXX(ctx, "missing", 1, 0),
+	}
+	for i, err := range steps {
+		if err != nil {
+			t.Fatalf("Step %d failed to queue: %v", i, err)
+		}
+	}
+	if _, err := tx.Commit(); !errors.Is(err, ErrTransactionFailed) {
+		t.Fatalf("Expected ErrTransactionFailed, got %v", err)
+	}
+
+	hash, err := db.HGetAll(ctx, "profile")
+	if err != nil {
+		t.Fatalf("HGetAll failed: %v", err)
+	}
+	if !reflect.DeepEqual(hash, map[string]interface{}{"name": "ann"}) {
+		t.Errorf("Expected hash to be restored, got %v", hash)
+	}
+
+	members, err := db.SMembers(ctx, "tags")
+	if err != nil {
+		t.Fatalf("SMembers failed: %v", err)
+	}
+	if len(members) != 2 {
+		t.Errorf("Expected 2 set members, got %v", members)
+	}
+	for _, m := range []interface{}{"a", "b"} {
+		if ok, _ := db.SIsMember(ctx, "tags", m); !ok {
+			t.Errorf("Expected %v to be restored in set", m)
+		}
+	}
+
+	list, err := db.LRange(ctx, "queue", 0, -1)
+	if err != nil {
+		t.Fatalf("LRange failed: %v", err)
+	}
+	if !reflect.DeepEqual(list, []interface{}{1, 2, 3}) {
+		t.Errorf("Expected list [1 2 3], got %v", list)
+	}
+
+	zmembers, err := db.ZRange(ctx, "board", 0, -1, false)
+	if err != nil {
+		t.Fatalf("ZRange failed: %v", err)
+	}
+	if !reflect.DeepEqual(zmembers, []types.ZMember{{Member: "x", Score: 1}}) {
+		t.Errorf("Expected sorted set to be restored, got %v", zmembers)
+	}
+
+	if exists, _ := db.Exists(ctx, "fresh"); exists {
+		t.Error("Expected key created by the transaction to be removed")
+	}
+}
+
+// TestTransactionRollbackKeepsConcurrentWrites checks that rolling back an
+// uncommitted transaction does not overwrite changes made by other clients.
+func TestTransactionRollbackKeepsConcurrentWrites(t *testing.T) {
+	db := setupTestDB()
+	ctx := context.Background()
+
+	if err := db.HSet(ctx, "h", "f", "old", 0); err != nil {
+		t.Fatalf("HSet setup failed: %v", err)
+	}
+	tx := db.Transaction()
+	if err := tx.HSet(ctx, "h", "f", "tx", 0); err != nil {
+		t.Fatalf("HSet in transaction failed: %v", err)
+	}
+	if err := db.HSet(ctx, "h", "f", "other", 0); err != nil {
+		t.Fatalf("HSet failed: %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	if val, _ := db.HGet(ctx, "h", "f"); val != "other" {
+		t.Errorf("Expected concurrent write to survive rollback, got %v", val)
+	}
+}
+
+// TestTransactionReadYourOwnWrites checks that reads inside a transaction see
+// its queued writes while other clients do not.
+func TestTransactionReadYourOwnWrites(t *testing.T) {
+	db := setupTestDB()
+	ctx := context.Background()
+
+	if err := db.Set(ctx, "stock", int64(5), 0); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := db.HSet(ctx, "order", "status", "new", 0); err != nil {
+		t.Fatalf("HSet failed: %v", err)
+	}
+
+	tx := db.Transaction()
+
+	// Scenario 1: counters and strings
+	if err := tx.Decr(ctx, "stock"); err != nil {
+		t.Fatalf("Decr failed: %v", err)
+	}
+	if val, err := tx.Get(ctx, "stock"); err != nil || val != int64(4) {
+		t.Errorf("Expected tx.Get to return 4, got %v (%v)", val, err)
+	}
+	if val, _ := db.Get(ctx, "stock"); val != int64(5) {
+		t.Errorf("Expected store to still hold 5, got %v", val)
+	}
+
+	// Scenario 2: hashes, lists and sets
+	if err := tx.HSet(ctx, "order", "status", "paid", 0); err != nil {
+		t.Fatalf("HSet failed: %v", err)
+	}
+	if val, _ := tx.HGet(ctx, "order", "status"); val != "paid" {
+		t.Errorf("Expected tx.HGet to return paid, got %v", val)
+	}
+	if err := tx.RPush(ctx, "events", "created", "paid"); err != nil {
+		t.Fatalf("RPush failed: %v", err)
+	}
+	if list, _ := tx.LRange(ctx, "events", 0, -1); !reflect.DeepEqual(list, []interface{}{"created", "paid"}) {
+		t.Errorf("Expected tx.LRange to see pushed values, got %v", list)
+	}
+	if val, err := tx.LPop(ctx, "events"); err != nil || val != "created" {
+		t.Errorf("Expected tx.LPop to return created, got %v (%v)", val, err)
+	}
+	if n, _ := tx.LLen(ctx, "events"); n != 1 {
+		t.Errorf("Expected tx.LLen 1, got %d", n)
+	}
+	if err := tx.SAdd(ctx, "buyers", "ann"); err != nil {
+		t.Fatalf("SAdd failed: %v", err)
+	}
+	if ok, _ := tx.SIsMember(ctx, "buyers", "ann"); !ok {
+		t.Error("Expected tx.SIsMember to see ann")
+	}
+	if exists, _ := db.Exists(ctx, "buyers"); exists {
+		t.Error("Expected buyers to be invisible outside the transaction")
+	}
+
+	// Scenario 3: deletes and renames
+	if err := tx.Delete(ctx, "order"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if exists, _ := tx.Exists(ctx, "order"); exists {
+		t.Error("Expected tx.Exists to report the deleted key as missing")
+	}
+	if err := tx.Rename(ctx, "stock", "inventory"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if _, err := tx.Get(ctx, "stock"); !IsKeyNotFound(err) {
+		t.Errorf("Expected renamed key to be gone inside the transaction, got %v", err)
+	}
+	if val, _ := tx.Get(ctx, "inventory"); val != int64(4) {
+		t.Errorf("Expected inventory 4, got %v", val)
+	}
+	if keys, _ := tx.FindByValue(ctx, int64(4)); !reflect.DeepEqual(keys, []string{"inventory"}) {
+		t.Errorf("Expected FindByValue to see inventory, got %v", keys)
+	}
+
+	// Scenario 4: commit applies the same result to the store
+	if _, err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if val, _ := db.Get(ctx, "inventory"); val != int64(4) {
+		t.Errorf("Expected inventory 4 after commit, got %v", val)
+	}
+	if list, _ := db.LRange(ctx, "events", 0, -1); !reflect.DeepEqual(list, []interface{}{"paid"}) {
+		t.Errorf("Expected events [paid] after commit, got %v", list)
+	}
+	if exists, _ := db.Exists(ctx, "order"); exists {
+		t.Error("Expected order to be deleted after commit")
+	}
+}
+
+// TestTransactionCommitResults checks that Commit returns one result per queued
+// operation holding the values produced at commit time.
+func TestTransactionCommitResults(t *testing.T) {
+	db := setupTestDB()
+	ctx := context.Background()
+
+	_ = db.Set(ctx, "counter", int64(1), 0)
+	_ = db.Set(ctx, "name", "old", 0)
+	_, _ = db.RPush(ctx, "queue", "a", "b")
+	_, _ = db.ZAdd(ctx, "board", types.ZAddOptions{}, types.ZMember{Member: "x", Score: 1}, types.ZMember{Member: "y", Score: 2})
+
+	tx := db.Transaction()
+	_ = tx.Incr(ctx, "counter")
+	_, _ = tx.GetSet(ctx, "name", "new", 0)
+	_, _ = tx.LPop(ctx, "queue")
+	_, _ = tx.ZPopMin(ctx, "board", 1)
+	_ = tx.Set(ctx, "plain", "v", 0)
+
+	// Scenario 1: concurrent writes before commit are reflected in the results
+	_ = db.Set(ctx, "counter", int64(10), 0)
+	_ = db.Set(ctx, "name", "changed", 0)
+	_, _ = db.LPush(ctx, "queue", "z")
+
+	results, err := tx.Commit()
+	if err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	expected := []TxResult{
+		{Op: "Incr", Key: "counter", Value: int64(11)},
+		{Op: "GetSet", Key: "name", Value: "changed"},
+		{Op: "LPop", Key: "queue", Value: "z"},
+		{Op: "ZPopMin", Key: "board", Value: []types.ZMember{{Member: "x", Score: 1}}},
+		{Op: "Set", Key: "plain", Value: nil},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("Expected results %v, got %v", expected, results)
+	}
+
+	// Scenario 2: a failed commit returns no results
+	tx = db.Transaction()
+	_ = tx.Incr(ctx, "counter")
+	_ = tx.SetXX(ctx, "missing", 1, 0)
+	results, err = tx.Commit()
+	if !errors.Is(err, ErrTransactionFailed) {
+		t.Fatalf("Expected ErrTransactionFailed, got %v", err)
+	}
+	if results != nil {
+		t.Errorf("Expected nil results on failure, got %v", results)
+	}
+}
+
+// TestTransactionUpdateView checks the managed Update and View helpers.
+func TestTransactionUpdateView(t *testing.T) {
+	db := setupTestDB()
+	ctx := context.Background()
+
+	// Scenario 1: Update commits when fn returns nil
+	err := db.Update(ctx, func(tx contracts.TransactionHandler) error {
+		return tx.Set(ctx, "balance", int64(100), 0)
+	})
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if val, _ := db.Get(ctx, "balance"); val != int64(100) {
+		t.Errorf("Expected balance 100, got %v", val)
+	}
+
+	// Scenario 2: Update rolls back when fn returns an error
+	errStop := errors.New("stop")
+	err = db.Update(ctx, func(tx contracts.TransactionHandler) error {
+		_ = tx.Set(ctx, "balance", int64(0), 0)
+		return errStop
+	})
+	if !errors.Is(err, errStop) {
+		t.Errorf("Expected fn error, got %v", err)
+	}
+	if val, _ := db.Get(ctx, "balance"); val != int64(100) {
+		t.Errorf("Expected balance to stay 100, got %v", val)
+	}
+
+	// Scenario 3: Update rolls back and re-panics when fn panics
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected the panic to propagate")
+			}
+		}()
+		_ = db.Update(ctx, func(tx contracts.TransactionHandler) error {
+			_ = tx.Set(ctx, "balance", int64(0), 0)
+			panic("boom")
+		})
+	}()
+	if val, _ := db.Get(ctx, "balance"); val != int64(100) {
+		t.Errorf("Expected balance to stay 100 after panic, got %v", val)
+	}
+
+	// Scenario 4: Update retries after a conflict
+	attempts := 0
+	err = db.Update(ctx, func(tx contracts.TransactionHandler) error {
+		attempts++
+		if err := tx.Watch(ctx, "balance"); err != nil {
+			return err
+		}
+		if attempts == 1 {
+			_ = db.Set(ctx, "balance", int64(50), 0)
+		}
+		return tx.IncrBy(ctx, "balance", 10)
+	})
+	if err != nil {
+		t.Fatalf("Update with retry failed: %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("Expected 2 attempts, got %d", attempts)
+	}
+	if val, _ := db.Get(ctx, "balance"); val != int64(60) {
+		t.Errorf("Expected balance 60, got %v", val)
+	}
+
+	// Scenario 5: the retry budget is configurable
+	noRetry := NewStore(Config{TransactionRetries: -1})
+	attempts = 0
+	err = noRetry.Update(ctx, func(tx contracts.TransactionHandler) error {
+		attempts++
+		_ = tx.Watch(ctx, "k")
+		_ = noRetry.Set(ctx, "k", "changed", 0)
+		return tx.Set(ctx, "k", "mine", 0)
+	})
+	if !IsTransactionConflict(err) {
+		t.Errorf("Expected ErrTransactionConflict, got %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("Expected a single attempt, got %d", attempts)
+	}
+
+	// Scenario 6: View reads but refuses writes
+	var seen interface{}
+	err = db.View(ctx, func(tx contracts.TransactionHandler) error {
+		seen, _ = tx.Get(ctx, "balance")
+		return tx.Set(ctx, "balance", int64(0), 0)
+	})
+	if !IsTransactionReadOnly(err) {
+		t.Errorf("Expected ErrTransactionReadOnly, got %v", err)
+	}
+	if seen != int64(60) {
+		t.Errorf("Expected View to read 60, got %v", seen)
+	}
+	if val, _ := db.Get(ctx, "balance"); val != int64(60) {
+		t.Errorf("Expected balance to stay 60, got %v", val)
+	}
+}
+
+// TestTransactionSavepoints checks Savepoint and RollbackTo.
+func TestTransactionSavepoints(t *testing.T) {
+	db := setupTestDB()
+	ctx := context.Background()
+	_ = db.Set(ctx, "stock", int64(5), 0)
+
+	tx := db.Transaction()
+	_ = tx.Set(ctx, "order", "created", 0)
+	if err := tx.Savepoint("reserve"); err != nil {
+		t.Fatalf("Savepoint failed: %v", err)
+	}
+	_ = tx.Decr(ctx, "stock")
+	_ = tx.Set(ctx, "order", "reserved", 0)
+
+	// Scenario 1: RollbackTo drops later operations and restores reads
+	if err := tx.RollbackTo("reserve"); err != nil {
+		t.Fatalf("RollbackTo failed: %v", err)
+	}
+	if val, _ := tx.Get(ctx, "stock"); val != int64(5) {
+		t.Errorf("Expected stock 5 after RollbackTo, got %v", val)
+	}
+	if val, _ := tx.Get(ctx, "order"); val != "created" {
+		t.Errorf("Expected order created after RollbackTo, got %v", val)
+	}
+
+	// Scenario 2: the savepoint survives RollbackTo, later ones do not
+	_ = tx.Set(ctx, "order", "paid", 0)
+	_ = tx.Savepoint("later")
+	if err := tx.RollbackTo("reserve"); err != nil {
+		t.Fatalf("Second RollbackTo failed: %v", err)
+	}
+	if err := tx.RollbackTo("later"); !IsSavepointNotFound(err) {
+		t.Errorf("Expected ErrSavepointNotFound, got %v", err)
+	}
+	if err := tx.RollbackTo("missing"); !IsSavepointNotFound(err) {
+		t.Errorf("Expected ErrSavepointNotFound, got %v", err)
+	}
+
+	// Scenario 3: only the operations before the savepoint are committed
+	_ = tx.Set(ctx, "note", "kept", 0)
+	results, err := tx.Commit()
+	if err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Errorf("Expected 2 results, got %v", results)
+	}
+	if val, _ := db.Get(ctx, "stock"); val != int64(5) {
+		t.Errorf("Expected stock 5, got %v", val)
+	}
+	if val, _ := db.Get(ctx, "order"); val != "created" {
+		t.Errorf("Expected order created, got %v", val)
+	}
+	if val, _ := db.Get(ctx, "note"); val != "kept" {
+		t.Errorf("Expected note kept, got %v", val)
+	}
+}
+
+// TestTransactionNested checks nested transactions started with Begin.
+func TestTransactionNested(t *testing.T) {
+	db := setupTestDB()
+	ctx := context.Background()
+	_ = db.Set(ctx, "balance", int64(100), 0)
+
+	tx := db.Transaction()
+	_ = tx.IncrBy(ctx, "balance", 10)
+
+	// Scenario 1: a rolled back child leaves the parent untouched
+	child, err := tx.Begin()
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	if val, _ := child.Get(ctx, "balance"); val != int64(110) {
+		t.Errorf("Expected child to see parent write 110, got %v", val)
+	}
+	_ = child.IncrBy(ctx, "balance", 1000)
+	if err := tx.Set(ctx, "other", 1, 0); !IsNestedTransactionOpen(err) {
+		t.Errorf("Expected ErrNestedTransactionOpen, got %v", err)
+	}
+	if err := child.Rollback(); err != nil {
+		t.Fatalf("Child Rollback failed: %v", err)
+	}
+	if val, _ := tx.Get(ctx, "balance"); val != int64(110) {
+		t.Errorf("Expected parent to still see 110, got %v", val)
+	}
+
+	// Scenario 2: a committed child merges into the parent
+	child, _ = tx.Begin()
+	_ = child.IncrBy(ctx, "balance", 5)
+	_ = child.Set(ctx, "audit", "ok", 0)
+	if val, _ := tx.Get(ctx, "balance"); val != int64(110) {
+		t.Errorf("Expected parent not to see open child writes, got %v", val)
+	}
+	if results, err := child.Commit(); err != nil || results != nil {
+		t.Fatalf("Child Commit returned %v, %v", results, err)
+	}
+	if val, _ := tx.Get(ctx, "balance"); val != int64(115) {
+		t.Errorf("Expected parent to see merged 115, got %v", val)
+	}
+	if _, err := db.Get(ctx, "audit"); !IsKeyNotFound(err) {
+		t.Errorf("Expected nothing applied before the parent commits, got %v", err)
+	}
+
+	results, err := tx.Commit()
+	if err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if len(results) != 3 || results[1].Value != int64(115) {
+		t.Errorf("Unexpected results %v", results)
+	}
+	if val, _ := db.Get(ctx, "balance"); val != int64(115) {
+		t.Errorf("Expected balance 115, got %v", val)
+	}
+	if val, _ := db.Get(ctx, "audit"); val != "ok" {
+		t.Errorf("Expected audit ok, got %v", val)
+	}
+
+	// Scenario 3: a commit failing midway undoes merged child writes too
+	tx = db.Transaction()
+	_ = tx.Set(ctx, "balance", int64(0), 0)
+	child, _ = tx.Begin()
+	_ = child.Set(ctx, "audit", "failed", 0)
+	_ = child.SetXX(ctx, "missing", 1, 0)
+	_, _ = child.Commit()
+	if _, err := tx.Commit(); !IsTransactionFailed(err) {
+		t.Fatalf("Expected ErrTransactionFailed, got %v", err)
+	}
+	if val, _ := db.Get(ctx, "balance"); val != int64(115) {
+		t.Errorf("Expected balance restored to 115, got %v", val)
+	}
+	if val, _ := db.Get(ctx, "audit"); val != "ok" {
+		t.Errorf("Expected audit restored to ok, got %v", val)
+	}
+}
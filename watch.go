@@ -0,0 +1,125 @@
+package hermes
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// watchRegistry tracks the keys watched by optimistic transactions. Every write
+// to a watched key bumps its version, so a transaction can tell at commit time
+// whether anything it observed has changed. Keys nobody watches cost a single
+// atomic load per write.
+type watchRegistry struct {
+	mu    sync.Mutex
+	keys  map[string]*watchedKey
+	count atomic.Int64
+}
+
+type watchedKey struct {
+	version uint64
+	refs    int
+}
+
+// keyVersion is what a transaction remembers about a watched key.
+type keyVersion struct {
+	version uint64
+	live    bool
+}
+
+// keyWatcher is implemented by stores that support Transaction.Watch.
+type keyWatcher interface {
+	watchKey(key string) keyVersion
+	unwatchKey(key string)
+	keyChanged(ctx context.Context, key string, v keyVersion) bool
+}
+
+// afterWrite runs the bookkeeping every mutation needs once it has been applied:
+// it invalidates watchers of the affected keys, wakes readers blocked on them and
+// records the operation in the append-only log. Callers hold the locks of the
+// shards they modified.
+func (db *DB) afterWrite(op string, args ...interface{}) {
+	db.touch(op, args)
+	db.wake(op, args)
+	db.appendLog(op, args...)
+}
+
+func (db *DB) touch(op string, args []interface{}) {
+	if db.watches.count.Load() == 0 {
+		return
+	}
+
+	db.watches.mu.Lock()
+	defer db.watches.mu.Unlock()
+
+	if op == opDropAll {
+		for _, w := range db.watches.keys {
+			w.version++
+		}
+		return
+	}
+
+	n := 1
+	if op == opRename || op == opLMove {
+		n = 2
+	}
+	for i := 0; i < n && i < len(args); i++ {
+		if key, ok := args[i].(string); ok {
+			if w, ok := db.watches.keys[key]; ok {
+				w.version++
+			}
+		}
+	}
+}
+
+func (db *DB) watchKey(key string) keyVersion {
+	db.watches.mu.Lock()
+	if db.watches.keys == nil {
+		db.watches.keys = make(map[string]*watchedKey)
+	}
+	w, ok := db.watches.keys[key]
+	if !ok {
+		w = &watchedKey{}
+		db.watches.keys[key] = w
+		db.watches.count.Add(1)
+	}
+	w.refs++
+	version := w.version
+	db.watches.mu.Unlock()
+
+	return keyVersion{version: version, live: db.keyLive(context.Background(), key)}
+}
+
+func (db *DB) unwatchKey(key string) {
+	db.watches.mu.Lock()
+	defer db.watches.mu.Unlock()
+
+	w, ok := db.watches.keys[key]
+	if !ok {
+		return
+	}
+	w.refs--
+	if w.refs <= 0 {
+		delete(db.watches.keys, key)
+		db.watches.count.Add(-1)
+	}
+}
+
+// keyChanged reports whether key was written since v was taken. A key that
+// expired in the meantime counts as changed even though nothing wrote to it.
+func (db *DB) keyChanged(ctx context.Context, key string, v keyVersion) bool {
+	db.watches.mu.Lock()
+	w, ok := db.watches.keys[key]
+	changed := !ok || w.version != v.version
+	db.watches.mu.Unlock()
+
+	return changed || db.keyLive(ctx, key) != v.live
+}
+
+func (db *DB) keyLive(ctx context.Context, key string) bool {
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.rlockShard(ctx, sh)()
+
+	entry, exists := sh.data[key]
+	return exists && !db.isExpired(entry)
+}
@@ -0,0 +1,481 @@
+package hermes
+
+import (
+	"context"
+	"math"
+
+	"github.com/themedef/go-hermes/internal/types"
+)
+
+type (
+	ZMember       = types.ZMember
+	ZAddOptions   = types.ZAddOptions
+	ZRangeOptions = types.ZRangeOptions
+	ScoreBound    = types.ScoreBound
+	LexBound      = types.LexBound
+)
+
+var (
+	ParseScoreBound = types.ParseScoreBound
+	ParseLexBound   = types.ParseLexBound
+)
+
+func validateZAddOptions(opts types.ZAddOptions) error {
+	if (opts.NX && opts.XX) || (opts.GT && opts.LT) || (opts.NX && (opts.GT || opts.LT)) {
+		return ErrInvalidOptions
+	}
+	return nil
+}
+
+// zaddAllowed applies the NX/XX/GT/LT conditions to a single member.
+func zaddAllowed(opts types.ZAddOptions, exists bool, current, score float64) bool {
+	switch {
+	case opts.NX && exists, opts.XX && !exists:
+		return false
+	case exists && opts.GT && score <= current, exists && opts.LT && score >= current:
+		return false
+	}
+	return true
+}
+
+// zsetForRead returns the sorted set stored at key. The caller holds the shard lock.
+func (db *DB) zsetForRead(sh *shard, key, op string) (*types.SortedSet, error) {
+	entry, exists := sh.data[key]
+	if !exists || db.isExpired(entry) {
+		db.logger.Warn(op+" failed: key not found or expired", "key", key)
+		return nil, ErrKeyNotFound
+	}
+	if entry.Type != types.ZSet {
+		db.logger.Error(op+" failed: existing key is not a sorted set", "key", key)
+		return nil, ErrInvalidType
+	}
+	zset, ok := entry.Value.(*types.SortedSet)
+	if !ok {
+		db.logger.Error(op+" failed: stored value is not a valid sorted set", "key", key)
+		return nil, ErrInvalidType
+	}
+	return zset, nil
+}
+
+// zsetForWrite is zsetForRead for mutations: an expired key is dropped and, when
+// create is set, a missing key yields a new empty set that is not yet stored.
+func (db *DB) zsetForWrite(sh *shard, key, op string, create bool) (*types.SortedSet, types.Entry, error) {
+	entry, exists := sh.data[key]
+	if exists && db.isExpired(entry) {
+		db.removeExpired(sh, key, entry)
+		exists = false
+		db.logger.Info(op+" removed expired key", "key", key)
+	}
+	if !exists {
+		if !create {
+			db.logger.Warn(op+" failed: key not found or expired", "key", key)
+			return nil, types.Entry{}, ErrKeyNotFound
+		}
+		zset := types.NewSortedSet()
+		return zset, types.Entry{Value: zset, Type: types.ZSet}, nil
+	}
+	zset, err := db.zsetForRead(sh, key, op)
+	return zset, entry, err
+}
+
+func zaddLogArgs(key string, members []types.ZMember) []interface{} {
+	args := make([]interface{}, 0, 1+2*len(members))
+	args = append(args, key)
+	for _, m := range members {
+		args = append(args, m.Member, m.Score)
+	}
+	return args
+}
+
+func zremLogArgs(key string, members []string) []interface{} {
+	args := make([]interface{}, 0, 1+len(members))
+	args = append(args, key)
+	for _, m := range members {
+		args = append(args, m)
+	}
+	return args
+}
+
+// ZAdd adds members or updates their scores, subject to opts. It returns the
+// number of members added, or added plus updated when opts.CH is set.
+func (db *DB) ZAdd(ctx context.Context, key string, opts types.ZAddOptions, members ...types.ZMember) (int, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("ZAdd operation canceled", "key", key)
+		return 0, ErrContextCanceled
+	default:
+	}
+
+	if key == "" {
+		db.logger.Error("ZAdd failed: empty key")
+		return 0, ErrInvalidKey
+	}
+	if len(members) == 0 {
+		db.logger.Warn("ZAdd called with no members", "key", key)
+		return 0, ErrEmptyValues
+	}
+	if err := validateZAddOptions(opts); err != nil {
+		db.logger.Error("ZAdd failed: incompatible options", "key", key)
+		return 0, err
+	}
+	for _, m := range members {
+		if math.IsNaN(m.Score) {
+			db.logger.Error("ZAdd failed: score is NaN", "key", key, "member", m.Member)
+			return 0, ErrInvalidScore
+		}
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.lockShard(ctx, sh)()
+
+	zset, entry, err := db.zsetForWrite(sh, key, "ZAdd", true)
+	if err != nil {
+		return 0, err
+	}
+
+	added, updated := 0, 0
+	applied := make([]types.ZMember, 0, len(members))
+	for _, m := range members {
+		current, exists := zset.Score(m.Member)
+		if !zaddAllowed(opts, exists, current, m.Score) {
+			continue
+		}
+		if zset.Add(m.Member, m.Score) {
+			added++
+		} else if current != m.Score {
+			updated++
+		}
+		applied = append(applied, m)
+	}
+
+	if zset.Len() > 0 {
+		sh.data[key] = entry
+	}
+	if len(applied) > 0 {
+		db.afterWrite(opZAdd, zaddLogArgs(key, applied)...)
+		db.notify(ctx, types.Event{Op: types.EventZAdd, Key: key, Type: types.ZSet, NewValue: applied, TTL: ttlLeft(entry.Expiration)})
+	}
+
+	db.logger.Info("ZAdd operation successful", "key", key, "added", added, "updated", updated)
+	if opts.CH {
+		return added + updated, nil
+	}
+	return added, nil
+}
+
+// ZIncrBy adds increment to the score of member, creating it with that score if
+// needed, and returns the new score. opts behaves as for ZADD ... INCR: when a
+// condition prevents the update ErrConditionNotMet is returned.
+func (db *DB) ZIncrBy(ctx context.Context, key string, member string, increment float64, opts types.ZAddOptions) (float64, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("ZIncrBy operation canceled", "key", key)
+		return 0, ErrContextCanceled
+	default:
+	}
+
+	if key == "" {
+		db.logger.Error("ZIncrBy failed: empty key")
+		return 0, ErrInvalidKey
+	}
+	if err := validateZAddOptions(opts); err != nil {
+		db.logger.Error("ZIncrBy failed: incompatible options", "key", key)
+		return 0, err
+	}
+	if math.IsNaN(increment) {
+		return 0, ErrInvalidScore
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.lockShard(ctx, sh)()
+
+	zset, entry, err := db.zsetForWrite(sh, key, "ZIncrBy", true)
+	if err != nil {
+		return 0, err
+	}
+
+	current, exists := zset.Score(member)
+	score := current + increment
+	if math.IsNaN(score) {
+		db.logger.Error("ZIncrBy failed: resulting score is NaN", "key", key, "member", member)
+		return 0, ErrInvalidScore
+	}
+	if !zaddAllowed(opts, exists, current, score) {
+		db.logger.Info("ZIncrBy skipped: condition not met", "key", key, "member", member)
+		return 0, ErrConditionNotMet
+	}
+
+	zset.Add(member, score)
+	sh.data[key] = entry
+	db.afterWrite(opZAdd, key, member, score)
+	db.notify(ctx, types.Event{Op: types.EventZIncrBy, Key: key, Field: member, Type: types.ZSet, NewValue: score, TTL: ttlLeft(entry.Expiration)})
+	db.logger.Info("ZIncrBy operation successful", "key", key, "member", member, "score", score)
+	return score, nil
+}
+
+// ZRem removes members and returns how many were present.
+func (db *DB) ZRem(ctx context.Context, key string, members ...string) (int, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("ZRem operation canceled", "key", key)
+		return 0, ErrContextCanceled
+	default:
+	}
+
+	if len(members) == 0 {
+		db.logger.Warn("ZRem called with no members", "key", key)
+		return 0, ErrEmptyValues
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.lockShard(ctx, sh)()
+
+	zset, entry, err := db.zsetForWrite(sh, key, "ZRem", false)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := make([]string, 0, len(members))
+	for _, m := range members {
+		if zset.Remove(m) {
+			removed = append(removed, m)
+		}
+	}
+	if len(removed) > 0 {
+		db.afterWrite(opZRem, zremLogArgs(key, removed)...)
+		db.notify(ctx, types.Event{Op: types.EventZRem, Key: key, Type: types.ZSet, OldValue: removed, TTL: ttlLeft(entry.Expiration)})
+	}
+	if zset.Len() == 0 {
+		delete(sh.data, key)
+		db.notify(ctx, types.Event{Op: types.EventDel, Key: key, Type: types.ZSet})
+		db.logger.Info("ZRem removed key because sorted set is empty", "key", key)
+	}
+
+	db.logger.Info("ZRem operation successful", "key", key, "removedCount", len(removed))
+	return len(removed), nil
+}
+
+func (db *DB) ZScore(ctx context.Context, key string, member string) (float64, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("ZScore operation canceled", "key", key)
+		return 0, ErrContextCanceled
+	default:
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.rlockShard(ctx, sh)()
+
+	zset, err := db.zsetForRead(sh, key, "ZScore")
+	if err != nil {
+		return 0, err
+	}
+	score, ok := zset.Score(member)
+	if !ok {
+		db.logger.Warn("ZScore failed: member not found", "key", key, "member", member)
+		return 0, ErrKeyNotFound
+	}
+
+	db.logger.Info("ZScore operation successful", "key", key, "member", member, "score", score)
+	return score, nil
+}
+
+// ZRank returns the 0-based rank of member ordered from the lowest score.
+func (db *DB) ZRank(ctx context.Context, key string, member string) (int, error) {
+	return db.zrank(ctx, key, member, false)
+}
+
+// ZRevRank returns the 0-based rank of member ordered from the highest score.
+func (db *DB) ZRevRank(ctx context.Context, key string, member string) (int, error) {
+	return db.zrank(ctx, key, member, true)
+}
+
+func (db *DB) zrank(ctx context.Context, key string, member string, rev bool) (int, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("ZRank operation canceled", "key", key)
+		return 0, ErrContextCanceled
+	default:
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.rlockShard(ctx, sh)()
+
+	zset, err := db.zsetForRead(sh, key, "ZRank")
+	if err != nil {
+		return 0, err
+	}
+	rank, ok := zset.Rank(member)
+	if !ok {
+		db.logger.Warn("ZRank failed: member not found", "key", key, "member", member)
+		return 0, ErrKeyNotFound
+	}
+	if rev {
+		rank = zset.Len() - 1 - rank
+	}
+
+	db.logger.Info("ZRank operation successful", "key", key, "member", member, "rank", rank, "rev", rev)
+	return rank, nil
+}
+
+// ZRange returns members between the ranks start and stop, inclusive. Negative
+// ranks count from the end; with rev ranks count from the highest score.
+func (db *DB) ZRange(ctx context.Context, key string, start, stop int, rev bool) ([]types.ZMember, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("ZRange operation canceled", "key", key)
+		return nil, ErrContextCanceled
+	default:
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.rlockShard(ctx, sh)()
+
+	zset, err := db.zsetForRead(sh, key, "ZRange")
+	if err != nil {
+		return nil, err
+	}
+	result := zset.Range(start, stop, rev)
+	db.logger.Info("ZRange operation successful", "key", key, "start", start, "stop", stop, "count", len(result))
+	return result, nil
+}
+
+// ZRangeByScore returns members whose score lies between min and max. With
+// opts.Rev the result is ordered from max down to min.
+func (db *DB) ZRangeByScore(ctx context.Context, key string, min, max types.ScoreBound, opts types.ZRangeOptions) ([]types.ZMember, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("ZRangeByScore operation canceled", "key", key)
+		return nil, ErrContextCanceled
+	default:
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.rlockShard(ctx, sh)()
+
+	zset, err := db.zsetForRead(sh, key, "ZRangeByScore")
+	if err != nil {
+		return nil, err
+	}
+	result := zset.RangeByScore(min, max, opts)
+	db.logger.Info("ZRangeByScore operation successful", "key", key, "count", len(result))
+	return result, nil
+}
+
+// ZRangeByLex returns members between min and max in lexicographic order. Like
+// Redis it assumes all members share the same score.
+func (db *DB) ZRangeByLex(ctx context.Context, key string, min, max types.LexBound, opts types.ZRangeOptions) ([]types.ZMember, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("ZRangeByLex operation canceled", "key", key)
+		return nil, ErrContextCanceled
+	default:
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.rlockShard(ctx, sh)()
+
+	zset, err := db.zsetForRead(sh, key, "ZRangeByLex")
+	if err != nil {
+		return nil, err
+	}
+	result := zset.RangeByLex(min, max, opts)
+	db.logger.Info("ZRangeByLex operation successful", "key", key, "count", len(result))
+	return result, nil
+}
+
+// ZPopMin removes and returns up to count members with the lowest scores.
+func (db *DB) ZPopMin(ctx context.Context, key string, count int) ([]types.ZMember, error) {
+	return db.zpop(ctx, key, count, false)
+}
+
+// ZPopMax removes and returns up to count members with the highest scores.
+func (db *DB) ZPopMax(ctx context.Context, key string, count int) ([]types.ZMember, error) {
+	return db.zpop(ctx, key, count, true)
+}
+
+func (db *DB) zpop(ctx context.Context, key string, count int, max bool) ([]types.ZMember, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("ZPop operation canceled", "key", key)
+		return nil, ErrContextCanceled
+	default:
+	}
+
+	if count <= 0 {
+		db.logger.Warn("ZPop called with non-positive count", "key", key, "count", count)
+		return []types.ZMember{}, nil
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.lockShard(ctx, sh)()
+
+	zset, entry, err := db.zsetForWrite(sh, key, "ZPop", false)
+	if err != nil {
+		return nil, err
+	}
+
+	popped := zset.Range(0, count-1, max)
+	removed := make([]string, len(popped))
+	for i, m := range popped {
+		zset.Remove(m.Member)
+		removed[i] = m.Member
+	}
+	if len(removed) > 0 {
+		db.afterWrite(opZRem, zremLogArgs(key, removed)...)
+		op := types.EventZPopMin
+		if max {
+			op = types.EventZPopMax
+		}
+		db.notify(ctx, types.Event{Op: op, Key: key, Type: types.ZSet, OldValue: popped, TTL: ttlLeft(entry.Expiration)})
+	}
+	if zset.Len() == 0 {
+		delete(sh.data, key)
+		db.notify(ctx, types.Event{Op: types.EventDel, Key: key, Type: types.ZSet})
+		db.logger.Info("ZPop removed key because sorted set is empty", "key", key)
+	}
+
+	db.logger.Info("ZPop operation successful", "key", key, "count", len(popped), "max", max)
+	return popped, nil
+}
+
+func (db *DB) ZCard(ctx context.Context, key string) (int, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("ZCard operation canceled", "key", key)
+		return 0, ErrContextCanceled
+	default:
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.rlockShard(ctx, sh)()
+
+	zset, err := db.zsetForRead(sh, key, "ZCard")
+	if err != nil {
+		return 0, err
+	}
+	cardinality := zset.Len()
+	db.logger.Info("ZCard operation successful", "key", key, "cardinality", cardinality)
+	return cardinality, nil
+}
+
+// ZCount returns the number of members whose score lies between min and max.
+func (db *DB) ZCount(ctx context.Context, key string, min, max types.ScoreBound) (int, error) {
+	select {
+	case <-ctx.Done():
+		db.logger.Warn("ZCount operation canceled", "key", key)
+		return 0, ErrContextCanceled
+	default:
+	}
+
+	sh := db.shards[db.getShardIndex(key)]
+	defer db.rlockShard(ctx, sh)()
+
+	zset, err := db.zsetForRead(sh, key, "ZCount")
+	if err != nil {
+		return 0, err
+	}
+	count := zset.Count(min, max)
+	db.logger.Info("ZCount operation successful", "key", key, "count", count)
+	return count, nil
+}
@@ -0,0 +1,258 @@
+package hermes
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"testing"
+
+	"github.com/themedef/go-hermes/internal/types"
+)
+
+func zmembers(pairs ...interface{}) []types.ZMember {
+	out := make([]types.ZMember, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		out = append(out, types.ZMember{Member: pairs[i].(string), Score: pairs[i+1].(float64)})
+	}
+	return out
+}
+
+func zmemberNames(members []types.ZMember) []string {
+	names := make([]string, len(members))
+	for i, m := range members {
+		names[i] = m.Member
+	}
+	return names
+}
+
+func equalNames(got []types.ZMember, want ...string) bool {
+	names := zmemberNames(got)
+	if len(names) != len(want) {
+		return false
+	}
+	for i := range names {
+		if names[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestStoreZAdd checks ZAdd and its NX/XX/GT/LT/CH options.
+func TestStoreZAdd(t *testing.T) {
+	db := withTestStore(t)
+	ctx := context.Background()
+
+	// Scenario 1: Add new members
+	n, err := db.ZAdd(ctx, "board", ZAddOptions{}, zmembers("alice", 10.0, "bob", 20.0)...)
+	if err != nil || n != 2 {
+		t.Fatalf("Expected 2 added, got %d (err=%v)", n, err)
+	}
+
+	// Scenario 2: Updating an existing member is not counted unless CH is set
+	n, _ = db.ZAdd(ctx, "board", ZAddOptions{}, zmembers("alice", 15.0)...)
+	if n != 0 {
+		t.Errorf("Expected 0 added on update, got %d", n)
+	}
+	n, _ = db.ZAdd(ctx, "board", ZAddOptions{CH: true}, zmembers("alice", 16.0, "carol", 1.0)...)
+	if n != 2 {
+		t.Errorf("Expected 2 changed with CH, got %d", n)
+	}
+
+	// Scenario 3: NX and XX
+	db.ZAdd(ctx, "board", ZAddOptions{NX: true}, zmembers("alice", 100.0, "dave", 5.0)...)
+	db.ZAdd(ctx, "board", ZAddOptions{XX: true}, zmembers("bob", 25.0, "erin", 5.0)...)
+	if s, _ := db.ZScore(ctx, "board", "alice"); s != 16 {
+		t.Errorf("NX must not update alice, score=%v", s)
+	}
+	if s, _ := db.ZScore(ctx, "board", "bob"); s != 25 {
+		t.Errorf("XX must update bob, score=%v", s)
+	}
+	if _, err := db.ZScore(ctx, "board", "erin"); !IsKeyNotFound(err) {
+		t.Errorf("XX must not add erin, got %v", err)
+	}
+
+	// Scenario 4: GT and LT only move scores in one direction
+	db.ZAdd(ctx, "board", ZAddOptions{GT: true}, zmembers("bob", 1.0)...)
+	db.ZAdd(ctx, "board", ZAddOptions{LT: true}, zmembers("carol", 50.0)...)
+	if s, _ := db.ZScore(ctx, "board", "bob"); s != 25 {
+		t.Errorf("GT must not lower bob, score=%v", s)
+	}
+	if s, _ := db.ZScore(ctx, "board", "carol"); s != 1 {
+		t.Errorf("LT must not raise carol, score=%v", s)
+	}
+
+	// Scenario 5: Invalid input
+	if _, err := db.ZAdd(ctx, "board", ZAddOptions{NX: true, XX: true}, zmembers("x", 1.0)...); !IsInvalidOptions(err) {
+		t.Errorf("Expected ErrInvalidOptions, got %v", err)
+	}
+	if _, err := db.ZAdd(ctx, "board", ZAddOptions{}, zmembers("x", math.NaN())...); !IsInvalidScore(err) {
+		t.Errorf("Expected ErrInvalidScore, got %v", err)
+	}
+	db.Set(ctx, "str", "v", 0)
+	if _, err := db.ZAdd(ctx, "str", ZAddOptions{}, zmembers("x", 1.0)...); !IsInvalidType(err) {
+		t.Errorf("Expected ErrInvalidType, got %v", err)
+	}
+}
+
+// TestStoreZIncrBy checks increments and ZADD INCR conditions.
+func TestStoreZIncrBy(t *testing.T) {
+	db := withTestStore(t)
+	ctx := context.Background()
+
+	// Scenario 1: Increment creates the member
+	if s, err := db.ZIncrBy(ctx, "z", "a", 2.5, ZAddOptions{}); err != nil || s != 2.5 {
+		t.Fatalf("Expected 2.5, got %v (err=%v)", s, err)
+	}
+	if s, _ := db.ZIncrBy(ctx, "z", "a", -1, ZAddOptions{}); s != 1.5 {
+		t.Errorf("Expected 1.5, got %v", s)
+	}
+
+	// Scenario 2: Conditions that block the update
+	if _, err := db.ZIncrBy(ctx, "z", "a", 1, ZAddOptions{NX: true}); !IsConditionNotMet(err) {
+		t.Errorf("Expected ErrConditionNotMet for NX, got %v", err)
+	}
+	if _, err := db.ZIncrBy(ctx, "z", "a", -1, ZAddOptions{GT: true}); !IsConditionNotMet(err) {
+		t.Errorf("Expected ErrConditionNotMet for GT, got %v", err)
+	}
+
+	// Scenario 3: inf + -inf is not a number
+	db.ZAdd(ctx, "z", ZAddOptions{}, zmembers("inf", math.Inf(1))...)
+	if _, err := db.ZIncrBy(ctx, "z", "inf", math.Inf(-1), ZAddOptions{}); !IsInvalidScore(err) {
+		t.Errorf("Expected ErrInvalidScore, got %v", err)
+	}
+}
+
+// TestStoreZRemAndPop checks removals and that empty sorted sets are deleted.
+func TestStoreZRemAndPop(t *testing.T) {
+	db := withTestStore(t)
+	ctx := context.Background()
+	db.ZAdd(ctx, "z", ZAddOptions{}, zmembers("a", 1.0, "b", 2.0, "c", 3.0, "d", 4.0)...)
+
+	// Scenario 1: ZRem counts only present members
+	if n, err := db.ZRem(ctx, "z", "a", "missing"); err != nil || n != 1 {
+		t.Errorf("Expected 1 removed, got %d (err=%v)", n, err)
+	}
+
+	// Scenario 2: Pops return members in score order
+	min, _ := db.ZPopMin(ctx, "z", 1)
+	if !equalNames(min, "b") {
+		t.Errorf("ZPopMin = %v", zmemberNames(min))
+	}
+	max, _ := db.ZPopMax(ctx, "z", 5)
+	if !equalNames(max, "d", "c") {
+		t.Errorf("ZPopMax = %v", zmemberNames(max))
+	}
+
+	// Scenario 3: Key is removed once empty
+	if exists, _ := db.Exists(ctx, "z"); exists {
+		t.Error("Expected empty sorted set to be deleted")
+	}
+	if _, err := db.ZPopMin(ctx, "z", 1); !IsKeyNotFound(err) {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+// TestStoreZRankAndRange checks ranks and rank, score and lex ranges.
+func TestStoreZRankAndRange(t *testing.T) {
+	db := withTestStore(t)
+	ctx := context.Background()
+	db.ZAdd(ctx, "z", ZAddOptions{}, zmembers("a", 1.0, "b", 2.0, "c", 3.0, "d", 4.0, "e", 5.0)...)
+
+	// Scenario 1: Ranks
+	if r, _ := db.ZRank(ctx, "z", "c"); r != 2 {
+		t.Errorf("ZRank c = %d", r)
+	}
+	if r, _ := db.ZRevRank(ctx, "z", "c"); r != 2 {
+		t.Errorf("ZRevRank c = %d", r)
+	}
+	if r, _ := db.ZRevRank(ctx, "z", "e"); r != 0 {
+		t.Errorf("ZRevRank e = %d", r)
+	}
+
+	// Scenario 2: Index ranges
+	got, _ := db.ZRange(ctx, "z", 1, -2, false)
+	if !equalNames(got, "b", "c", "d") {
+		t.Errorf("ZRange 1 -2 = %v", zmemberNames(got))
+	}
+	got, _ = db.ZRange(ctx, "z", 0, 1, true)
+	if !equalNames(got, "e", "d") {
+		t.Errorf("ZRange 0 1 rev = %v", zmemberNames(got))
+	}
+
+	// Scenario 3: Score ranges with exclusive bounds and limits
+	min, _ := ParseScoreBound("(1")
+	max, _ := ParseScoreBound("+inf")
+	got, _ = db.ZRangeByScore(ctx, "z", min, max, ZRangeOptions{Offset: 1, Count: 2})
+	if !equalNames(got, "c", "d") {
+		t.Errorf("ZRangeByScore (1 +inf LIMIT 1 2 = %v", zmemberNames(got))
+	}
+	got, _ = db.ZRangeByScore(ctx, "z", min, max, ZRangeOptions{Rev: true, Count: 2})
+	if !equalNames(got, "e", "d") {
+		t.Errorf("ZRangeByScore rev = %v", zmemberNames(got))
+	}
+	if n, _ := db.ZCount(ctx, "z", min, max); n != 4 {
+		t.Errorf("ZCount (1 +inf = %d", n)
+	}
+	if n, _ := db.ZCard(ctx, "z"); n != 5 {
+		t.Errorf("ZCard = %d", n)
+	}
+
+	// Scenario 4: Lex ranges
+	db.ZAdd(ctx, "lex", ZAddOptions{}, zmembers("apple", 0.0, "banana", 0.0, "cherry", 0.0)...)
+	lo, _ := ParseLexBound("[b")
+	hi, _ := ParseLexBound("+")
+	got, _ = db.ZRangeByLex(ctx, "lex", lo, hi, ZRangeOptions{})
+	if !equalNames(got, "banana", "cherry") {
+		t.Errorf("ZRangeByLex [b + = %v", zmemberNames(got))
+	}
+
+	// Scenario 5: Missing member and wrong type
+	if _, err := db.ZRank(ctx, "z", "missing"); !IsKeyNotFound(err) {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+	db.LPush(ctx, "list", "x")
+	if _, err := db.ZRange(ctx, "list", 0, -1, false); !IsInvalidType(err) {
+		t.Errorf("Expected ErrInvalidType, got %v", err)
+	}
+}
+
+// TestSortedSetPersistence checks that sorted sets survive snapshots and log replay.
+func TestSortedSetPersistence(t *testing.T) {
+	ctx := context.Background()
+	db := withTestStore(t)
+	db.ZAdd(ctx, "z", ZAddOptions{}, zmembers("a", 1.0, "b", math.Inf(1), "c", -2.5)...)
+	db.ZIncrBy(ctx, "z", "a", 10, ZAddOptions{})
+	db.ZRem(ctx, "z", "c")
+
+	// Scenario 1: Snapshot round trip
+	var buf bytes.Buffer
+	if err := db.SaveSnapshot(ctx, &buf); err != nil {
+		t.Fatalf("SaveSnapshot failed: %v", err)
+	}
+	restored := withTestStore(t)
+	if err := restored.LoadSnapshot(ctx, &buf); err != nil {
+		t.Fatalf("LoadSnapshot failed: %v", err)
+	}
+	got, _ := restored.ZRange(ctx, "z", 0, -1, false)
+	if len(got) != 2 || got[0] != (ZMember{Member: "a", Score: 11}) || !math.IsInf(got[1].Score, 1) {
+		t.Errorf("Restored sorted set = %+v", got)
+	}
+
+	// Scenario 2: Append-only log replay
+	path := t.TempDir() + "/zset.aof"
+	logged := openAOFStore(t, path, FsyncAlways)
+	logged.ZAdd(ctx, "z", ZAddOptions{}, zmembers("a", 1.0, "b", 2.0, "c", 3.0)...)
+	logged.ZIncrBy(ctx, "z", "a", 5, ZAddOptions{})
+	logged.ZPopMax(ctx, "z", 1)
+	logged.ZRem(ctx, "z", "b")
+	if err := logged.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	replayed := openAOFStore(t, path, FsyncAlways)
+	got, _ = replayed.ZRange(ctx, "z", 0, -1, false)
+	if len(got) != 1 || got[0] != (ZMember{Member: "c", Score: 3}) {
+		t.Errorf("Replayed sorted set = %+v", got)
+	}
+}